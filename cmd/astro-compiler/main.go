@@ -0,0 +1,364 @@
+// Command astro-compiler compiles .astro files without a Node host, e.g. for
+// debugging or for build systems that drive the compiler directly.
+//
+//	astro-compiler compile path/to/File.astro --sourcemap external --project-root . --out dir/
+//
+// Pass `-` as the path to read the component from stdin.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/handler"
+	"github.com/withastro/compiler/internal/loc"
+	"github.com/withastro/compiler/internal/printer"
+	t "github.com/withastro/compiler/internal/t"
+	"github.com/withastro/compiler/internal/transform"
+)
+
+const usage = `Usage: astro-compiler compile <file.astro | -> [flags]
+
+Compiles a component to a JS module. Without --out, the module is written to stdout.
+
+Flags:
+`
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type compileOptions struct {
+	path        string
+	filename    string
+	sourcemap   string
+	projectRoot string
+	internalURL string
+	out         string
+	ast         bool
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "compile" {
+		fmt.Fprint(stderr, usage)
+		newFlagSet(&compileOptions{}, stderr).PrintDefaults()
+		return exitUsage
+	}
+	opts, err := parseArgs(args[1:], stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		fmt.Fprintf(stderr, "astro-compiler: %s\n", err)
+		return exitUsage
+	}
+	h, err := compile(opts, stdin, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "astro-compiler: %s\n", err)
+		return exitError
+	}
+	printDiagnostics(stderr, h.Diagnostics())
+	if h.HasErrors() {
+		return exitError
+	}
+	return exitOK
+}
+
+func newFlagSet(opts *compileOptions, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("compile", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprint(output, usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.sourcemap, "sourcemap", "", `"external", "inline" or "both"`)
+	fs.StringVar(&opts.projectRoot, "project-root", "", "root the scope of the component is derived from")
+	fs.StringVar(&opts.filename, "filename", "", "filename used in the output (defaults to the absolute path of the file)")
+	fs.StringVar(&opts.internalURL, "internal-url", "astro/runtime/server/index.js", "module the runtime helpers are imported from")
+	fs.StringVar(&opts.out, "out", "", "directory to write the module, sourcemap and metadata to")
+	fs.BoolVar(&opts.ast, "ast", false, "dump the parsed tree as JSON before it is transformed (without --out, instead of the module)")
+	return fs
+}
+
+// parseArgs parses the arguments of `compile`. Flags may come before or after the path.
+func parseArgs(args []string, output io.Writer) (compileOptions, error) {
+	var opts compileOptions
+	fs := newFlagSet(&opts, output)
+	var paths []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return opts, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		paths = append(paths, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(paths) != 1 {
+		return opts, fmt.Errorf("expected exactly one file to compile, got %d", len(paths))
+	}
+	opts.path = paths[0]
+	switch opts.sourcemap {
+	case "", "inline":
+	case "external", "both":
+		if opts.out == "" {
+			return opts, fmt.Errorf("--sourcemap %s requires --out", opts.sourcemap)
+		}
+	default:
+		return opts, fmt.Errorf(`unknown --sourcemap %q, expected "external", "inline" or "both"`, opts.sourcemap)
+	}
+	return opts, nil
+}
+
+func compile(opts compileOptions, stdin io.Reader, stdout io.Writer) (*handler.Handler, error) {
+	var input []byte
+	var err error
+	if opts.path == "-" {
+		input, err = io.ReadAll(stdin)
+	} else {
+		input, err = os.ReadFile(opts.path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	source := transform.NormalizeLineEndings(strings.TrimRightFunc(string(input), unicode.IsSpace))
+
+	filename := opts.filename
+	if filename == "" && opts.path != "-" {
+		if filename, err = filepath.Abs(opts.path); err != nil {
+			return nil, err
+		}
+	}
+	transformOptions := transform.TransformOptions{
+		Filename:                filename,
+		NormalizedFilename:      filename,
+		InternalURL:             opts.internalURL,
+		SourceMap:               opts.sourcemap,
+		ScopedStyleStrategy:     "where",
+		TransitionsAnimationURL: "astro/components/viewtransitions.css",
+	}
+	if opts.projectRoot != "" {
+		root, err := filepath.Abs(opts.projectRoot)
+		if err != nil {
+			return nil, err
+		}
+		transformOptions.ProjectRoot = root
+	}
+	transform.NormalizePaths(&transformOptions)
+	if transformOptions.ProjectRoot != "" {
+		transformOptions.Scope = transform.ScopeHash(transformOptions.Filename, transformOptions.ProjectRoot)
+	} else if filename != "" {
+		transformOptions.Scope = astro.HashString(transformOptions.Filename)
+	} else {
+		transformOptions.Scope = astro.HashString(source)
+	}
+
+	displayName := filename
+	if displayName == "" {
+		displayName = "<stdin>"
+	}
+	h := handler.NewHandler(source, displayName)
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		h.AppendError(err)
+		return h, nil
+	}
+
+	outputs := newOutputs(opts, stdout)
+	if opts.ast {
+		tree := printer.PrintToJSON(source, doc, t.ParseOptions{Filename: filename, Position: true})
+		if err := outputs.write(".ast.json", tree.Output); err != nil {
+			return nil, err
+		}
+	}
+
+	transform.ExtractStyles(doc, &transformOptions, h)
+	transformed := transform.TransformWithResult(doc, transformOptions, h)
+	// Without --out, stdout only holds the tree. It is still transformed to report diagnostics.
+	if opts.ast && opts.out == "" {
+		return h, nil
+	}
+	css := printer.PrintCSS(source, doc, transformOptions)
+	result := printer.PrintTransformResultToJS(source, transformed, len(css.Output), transformOptions, h)
+
+	code := string(result.Output)
+	sourcemap := ""
+	if opts.sourcemap != "" {
+		sourcemap = printer.SourceMapString(source, result, filename)
+	}
+	if opts.sourcemap == "inline" || opts.sourcemap == "both" {
+		code += "\n//# sourceMappingURL=data:application/json;charset=utf-8;base64," + base64.StdEncoding.EncodeToString([]byte(sourcemap))
+	}
+	if err := outputs.write(".mjs", []byte(code)); err != nil {
+		return nil, err
+	}
+	if opts.sourcemap == "external" || opts.sourcemap == "both" {
+		if err := outputs.write(".mjs.map", []byte(sourcemap)); err != nil {
+			return nil, err
+		}
+	}
+	if opts.out != "" {
+		metadata, err := json.MarshalIndent(newMetadata(transformOptions.Scope, css, transformed, h), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := outputs.write(".json", metadata); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// outputs writes the compiled files to the --out directory, named after the input
+// file, or to stdout when no directory was given.
+type outputs struct {
+	dir    string
+	name   string
+	stdout io.Writer
+}
+
+func newOutputs(opts compileOptions, stdout io.Writer) *outputs {
+	name := "stdin"
+	if opts.path != "-" {
+		name = strings.TrimSuffix(filepath.Base(opts.path), filepath.Ext(opts.path))
+	}
+	return &outputs{dir: opts.out, name: name, stdout: stdout}
+}
+
+func (o *outputs) write(ext string, content []byte) error {
+	if o.dir == "" {
+		_, err := o.stdout.Write(append(content, '\n'))
+		return err
+	}
+	if err := os.MkdirAll(o.dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(o.dir, o.name+ext), append(content, '\n'), 0o644)
+}
+
+type metadata struct {
+	Scope                string       `json:"scope"`
+	CSS                  []string     `json:"css"`
+	Scripts              []script     `json:"scripts"`
+	HydratedComponents   []component  `json:"hydratedComponents"`
+	ClientOnlyComponents []component  `json:"clientOnlyComponents"`
+	ServerComponents     []component  `json:"serverComponents"`
+	ContainsHead         bool         `json:"containsHead"`
+	Propagation          bool         `json:"propagation"`
+	Diagnostics          []diagnostic `json:"diagnostics"`
+	StyleImports         []string     `json:"styleImports"`
+}
+
+type script struct {
+	Type string `json:"type"`
+	Src  string `json:"src,omitempty"`
+	Code string `json:"code,omitempty"`
+}
+
+type component struct {
+	ExportName   string `json:"exportName"`
+	LocalName    string `json:"localName,omitempty"`
+	Specifier    string `json:"specifier"`
+	ResolvedPath string `json:"resolvedPath"`
+}
+
+type diagnostic struct {
+	Severity int    `json:"severity"`
+	Code     int    `json:"code"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Length   int    `json:"length,omitempty"`
+	Text     string `json:"text"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+func newMetadata(scope string, css printer.PrintCSSResult, result *transform.TransformResult, h *handler.Handler) metadata {
+	m := metadata{
+		Scope:                scope,
+		CSS:                  make([]string, 0, len(css.Output)),
+		Scripts:              make([]script, 0, len(result.Scripts)),
+		HydratedComponents:   components(result.HydratedComponents),
+		ClientOnlyComponents: components(result.ClientOnlyComponents),
+		ServerComponents:     components(result.ServerComponents),
+		ContainsHead:         result.ContainsHead,
+		Propagation:          result.Propagation,
+		Diagnostics:          make([]diagnostic, 0),
+		StyleImports:         result.StyleImports,
+	}
+	if m.StyleImports == nil {
+		m.StyleImports = []string{}
+	}
+	for _, c := range css.Output {
+		m.CSS = append(m.CSS, string(c))
+	}
+	for _, s := range result.Scripts {
+		m.Scripts = append(m.Scripts, script{Type: s.Type, Src: s.Src, Code: s.Code})
+	}
+	for _, d := range h.Diagnostics() {
+		m.Diagnostics = append(m.Diagnostics, newDiagnostic(d))
+	}
+	return m
+}
+
+func components(metadata []*astro.HydratedComponentMetadata) []component {
+	result := make([]component, 0, len(metadata))
+	for _, c := range metadata {
+		result = append(result, component{
+			ExportName:   c.ExportName,
+			LocalName:    c.LocalName,
+			Specifier:    c.Specifier,
+			ResolvedPath: c.ResolvedPath,
+		})
+	}
+	return result
+}
+
+func newDiagnostic(d loc.DiagnosticMessage) diagnostic {
+	result := diagnostic{Severity: d.Severity, Code: d.Code, Text: d.Text, Hint: d.Hint}
+	if d.Location != nil {
+		result.File = d.Location.File
+		result.Line = d.Location.Line
+		result.Column = d.Location.Column
+		result.Length = d.Location.Length
+	}
+	return result
+}
+
+var severities = map[int]string{
+	int(loc.ErrorType):       "error",
+	int(loc.WarningType):     "warning",
+	int(loc.InformationType): "info",
+	int(loc.HintType):        "hint",
+}
+
+// printDiagnostics prints diagnostics as `file:line:column: severity: text`.
+func printDiagnostics(w io.Writer, diagnostics []loc.DiagnosticMessage) {
+	for _, d := range diagnostics {
+		position := ""
+		if d.Location != nil {
+			position = fmt.Sprintf("%s:%d:%d: ", d.Location.File, d.Location.Line, d.Location.Column)
+		}
+		fmt.Fprintf(w, "%s%s: %s\n", position, severities[d.Severity], d.Text)
+		if d.Hint != "" {
+			fmt.Fprintf(w, "  hint: %s\n", d.Hint)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestCompile(t *testing.T) {
+	source, err := os.ReadFile("testdata/Card.astro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"compile", "testdata/Card.astro", "--filename", "/src/components/Card.astro", "--sourcemap", "external", "--ast", "--out", out}, nil, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	files := []struct {
+		name string
+		kind test_utils.OutputKind
+	}{
+		{"Card.mjs", test_utils.JsOutput},
+		{"Card.mjs.map", test_utils.JsonOutput},
+		{"Card.json", test_utils.JsonOutput},
+		{"Card.ast.json", test_utils.JsonOutput},
+	}
+	for _, file := range files {
+		t.Run(file.name, func(t *testing.T) {
+			output, err := os.ReadFile(filepath.Join(out, file.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test_utils.MakeSnapshot(&test_utils.SnapshotOptions{
+				Testing:      t,
+				TestCaseName: "compile " + file.name,
+				Input:        string(source),
+				Output:       string(output),
+				Kind:         file.kind,
+				FolderName:   "__compiler_cli__",
+			})
+		})
+	}
+}
+
+func TestCompileStdin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"compile", "-"}, strings.NewReader(`<h1>Hello</h1>`), &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "<h1>Hello</h1>") {
+		t.Errorf("expected the module on stdout, got %q", stdout.String())
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		code   int
+		stderr string
+	}{
+		{
+			name:   "error diagnostic",
+			args:   []string{"compile", "-"},
+			stdin:  `<Foo client:only="react" />`,
+			code:   exitError,
+			stderr: "<stdin>:1:2: error: Unable to find matching import statement for client:only component",
+		},
+		{
+			name:   "missing file",
+			args:   []string{"compile", "testdata/Missing.astro"},
+			code:   exitError,
+			stderr: "no such file or directory",
+		},
+		{
+			name:   "external sourcemap without out",
+			args:   []string{"compile", "-", "--sourcemap", "external"},
+			code:   exitUsage,
+			stderr: "--sourcemap external requires --out",
+		},
+		{
+			name:   "unknown command",
+			args:   []string{"build"},
+			code:   exitUsage,
+			stderr: "Usage: astro-compiler compile",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("expected exit code %d, got %d", tt.code, code)
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("expected stderr to contain %q, got %q", tt.stderr, stderr.String())
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -24,8 +26,10 @@ func main() {
 	js.Global().Set("@astrojs/compiler", js.ValueOf(make(map[string]interface{})))
 	module := js.Global().Get("@astrojs/compiler")
 	module.Set("transform", Transform())
+	module.Set("transformAll", TransformAll())
 	module.Set("parse", Parse())
 	module.Set("convertToTSX", ConvertToTSX())
+	module.Set("cancel", Cancel())
 
 	<-make(chan struct{})
 }
@@ -51,6 +55,30 @@ func jsBool(j js.Value) bool {
 	return j.Bool()
 }
 
+func jsStringMap(j js.Value) map[string]string {
+	values := make(map[string]string)
+	if j.Type() != js.TypeObject {
+		return values
+	}
+	keys := js.Global().Get("Object").Call("keys", j)
+	for i := 0; i < keys.Length(); i++ {
+		key := jsString(keys.Index(i))
+		values[key] = jsString(j.Get(key))
+	}
+	return values
+}
+
+func jsStringArray(j js.Value) []string {
+	values := make([]string, 0)
+	if j.Type() != js.TypeObject {
+		return values
+	}
+	for i := 0; i < j.Length(); i++ {
+		values = append(values, jsString(j.Index(i)))
+	}
+	return values
+}
+
 func makeParseOptions(options js.Value) t.ParseOptions {
 	position := true
 
@@ -65,7 +93,7 @@ func makeParseOptions(options js.Value) t.ParseOptions {
 	}
 
 	return t.ParseOptions{
-		Filename: filename,
+		Filename: transform.NormalizePath(filename),
 		Position: position,
 	}
 }
@@ -81,6 +109,11 @@ func makeTransformOptions(options js.Value) transform.TransformOptions {
 		normalizedFilename = filename
 	}
 
+	projectRoot := jsString(options.Get("projectRoot"))
+	site := jsString(options.Get("site"))
+	pathname := jsString(options.Get("pathname"))
+	base := jsString(options.Get("base"))
+
 	internalURL := jsString(options.Get("internalURL"))
 	if internalURL == "" {
 		internalURL = "astro/runtime/server/index.js"
@@ -98,6 +131,21 @@ func makeTransformOptions(options js.Value) transform.TransformOptions {
 		compact = true
 	}
 
+	minify := false
+	if jsBool(options.Get("minify")) {
+		minify = true
+	}
+
+	normalize := false
+	if jsBool(options.Get("normalize")) {
+		normalize = true
+	}
+
+	optimizedScopes := false
+	if jsBool(options.Get("optimizedScopes")) {
+		optimizedScopes = true
+	}
+
 	scopedSlot := false
 	if jsBool(options.Get("resultScopedSlot")) {
 		scopedSlot = true
@@ -133,6 +181,8 @@ func makeTransformOptions(options js.Value) transform.TransformOptions {
 		scopedStyleStrategy = "where"
 	}
 
+	format := jsString(options.Get("format"))
+
 	renderScript := false
 	if jsBool(options.Get("renderScript")) {
 		renderScript = true
@@ -143,21 +193,136 @@ func makeTransformOptions(options js.Value) transform.TransformOptions {
 		experimentalScriptOrder = true
 	}
 
-	return transform.TransformOptions{
-		Filename:                filename,
-		NormalizedFilename:      normalizedFilename,
-		InternalURL:             internalURL,
-		SourceMap:               sourcemap,
-		AstroGlobalArgs:         astroGlobalArgs,
-		Compact:                 compact,
-		ResolvePath:             resolvePathFn,
-		PreprocessStyle:         preprocessStyle,
-		ResultScopedSlot:        scopedSlot,
-		ScopedStyleStrategy:     scopedStyleStrategy,
-		TransitionsAnimationURL: transitionsAnimationURL,
-		AnnotateSourceFile:      annotateSourceFile,
-		RenderScript:            renderScript,
-		ExperimentalScriptOrder: experimentalScriptOrder,
+	removeStyleImports := false
+	if jsBool(options.Get("removeStyleImports")) {
+		removeStyleImports = true
+	}
+
+	hoistInlineStyles := false
+	if jsBool(options.Get("hoistInlineStyles")) {
+		hoistInlineStyles = true
+	}
+
+	strictDirectives := false
+	if jsBool(options.Get("strictDirectives")) {
+		strictDirectives = true
+	}
+
+	auditRawHTML := false
+	if jsBool(options.Get("auditRawHTML")) {
+		auditRawHTML = true
+	}
+
+	auditViewportMeta := false
+	if jsBool(options.Get("auditViewportMeta")) {
+		auditViewportMeta = true
+	}
+
+	normalizeBooleanAttributes := false
+	if jsBool(options.Get("normalizeBooleanAttributes")) {
+		normalizeBooleanAttributes = true
+	}
+
+	resolveRootURLs := false
+	if jsBool(options.Get("resolveRootURLs")) {
+		resolveRootURLs = true
+	}
+
+	keepStylesInPlace := false
+	if jsBool(options.Get("keepStylesInPlace")) {
+		keepStylesInPlace = true
+	}
+
+	mergeScopedStyles := false
+	if jsBool(options.Get("mergeScopedStyles")) {
+		mergeScopedStyles = true
+	}
+
+	extraComponentTags := jsStringArray(options.Get("extraComponentTags"))
+	componentAliases := jsStringMap(options.Get("componentAliases"))
+
+	maxDiagnostics := 0
+	if value := options.Get("maxDiagnostics"); value.Type() == js.TypeNumber {
+		maxDiagnostics = value.Int()
+	}
+
+	transformOptions := transform.TransformOptions{
+		Filename:                   filename,
+		NormalizedFilename:         normalizedFilename,
+		ProjectRoot:                projectRoot,
+		Site:                       site,
+		Pathname:                   pathname,
+		Base:                       base,
+		InternalURL:                internalURL,
+		SourceMap:                  sourcemap,
+		AstroGlobalArgs:            astroGlobalArgs,
+		Compact:                    compact,
+		Minify:                     minify,
+		Normalize:                  normalize,
+		OptimizedScopes:            optimizedScopes,
+		ResolvePath:                resolvePathFn,
+		PreprocessStyle:            preprocessStyle,
+		ResultScopedSlot:           scopedSlot,
+		ScopedStyleStrategy:        scopedStyleStrategy,
+		Format:                     format,
+		TransitionsAnimationURL:    transitionsAnimationURL,
+		AnnotateSourceFile:         annotateSourceFile,
+		RenderScript:               renderScript,
+		ExperimentalScriptOrder:    experimentalScriptOrder,
+		RemoveStyleImports:         removeStyleImports,
+		ExtraComponentTags:         extraComponentTags,
+		ComponentAliases:           componentAliases,
+		HoistInlineStyles:          hoistInlineStyles,
+		StrictDirectives:           strictDirectives,
+		AuditRawHTML:               auditRawHTML,
+		AuditViewportMeta:          auditViewportMeta,
+		NormalizeBooleanAttributes: normalizeBooleanAttributes,
+		Plugins:                    makePlugins(options),
+		ResolveRootURLs:            resolveRootURLs,
+		MergeScopedStyles:          mergeScopedStyles,
+		KeepStylesInPlace:          keepStylesInPlace,
+		MaxDiagnostics:             maxDiagnostics,
+	}
+	transform.NormalizePaths(&transformOptions)
+	return transformOptions
+}
+
+// makePlugins wraps the functions of the `plugins` option. Each of them is called with the
+// AST of the transformed document and may return a patched copy of it, see printer.ApplyJSON.
+// Unlike Go plugins, they have no access to the handler and can't append diagnostics.
+func makePlugins(options js.Value) []transform.Plugin {
+	plugins := make([]transform.Plugin, 0)
+	value := options.Get("plugins")
+	if value.Type() != js.TypeObject {
+		return plugins
+	}
+	for i := 0; i < value.Length(); i++ {
+		if fn := value.Index(i); fn.Type() == js.TypeFunction {
+			plugins = append(plugins, jsPlugin(fn))
+		}
+	}
+	return plugins
+}
+
+func jsPlugin(fn js.Value) transform.Plugin {
+	return func(doc *astro.Node, ctx *transform.PluginContext) error {
+		JSON := js.Global().Get("JSON")
+		tree := printer.PrintToJSON(ctx.Handler.Source(), doc, t.ParseOptions{Filename: ctx.Options.Filename, Position: true})
+		result, failure := wasm_utils.Await(js.Global().Get("Promise").Call("resolve", fn.Invoke(JSON.Call("parse", string(tree.Output)))))
+		if failure != nil {
+			return fmt.Errorf("plugin failed: %s", js.Global().Get("String").Invoke(failure[0]).String())
+		}
+		if len(result) == 0 || result[0].IsUndefined() || result[0].IsNull() {
+			return nil
+		}
+		var ast printer.ASTNode
+		if err := json.Unmarshal([]byte(JSON.Call("stringify", result[0]).String()), &ast); err != nil {
+			return fmt.Errorf("plugin returned an invalid AST: %w", err)
+		}
+		if err := printer.ApplyJSON(doc, ast); err != nil {
+			return fmt.Errorf("plugin returned an invalid AST: %w", err)
+		}
+		return nil
 	}
 }
 
@@ -171,15 +336,6 @@ func makeTSXOptions(options js.Value) printer.TSXOptions {
 	}
 }
 
-type RawSourceMap struct {
-	File           string   `js:"file"`
-	Mappings       string   `js:"mappings"`
-	Names          []string `js:"names"`
-	Sources        []string `js:"sources"`
-	SourcesContent []string `js:"sourcesContent"`
-	Version        int      `js:"version"`
-}
-
 type HoistedScript struct {
 	Code string `js:"code"`
 	Src  string `js:"src"`
@@ -194,6 +350,26 @@ type HydratedComponent struct {
 	ResolvedPath string `js:"resolvedPath"`
 }
 
+type ComponentUsage struct {
+	Name       string   `js:"name"`
+	Attrs      []string `js:"attrs"`
+	Directives []string `js:"directives"`
+	HasSpread  bool     `js:"hasSpread"`
+	Position   Point    `js:"position"`
+}
+
+type Point struct {
+	Line   int `js:"line"`
+	Column int `js:"column"`
+	Offset int `js:"offset"`
+}
+
+type CSSImport struct {
+	Specifier  string `js:"specifier"`
+	SideEffect bool   `js:"sideEffect"`
+	Start      int    `js:"start"`
+}
+
 type ParseResult struct {
 	AST         string                  `js:"ast"`
 	Diagnostics []loc.DiagnosticMessage `js:"diagnostics"`
@@ -207,18 +383,23 @@ type TSXResult struct {
 }
 
 type TransformResult struct {
-	Code                 string                  `js:"code"`
-	Diagnostics          []loc.DiagnosticMessage `js:"diagnostics"`
-	Map                  string                  `js:"map"`
-	Scope                string                  `js:"scope"`
-	CSS                  []string                `js:"css"`
-	Scripts              []HoistedScript         `js:"scripts"`
-	HydratedComponents   []HydratedComponent     `js:"hydratedComponents"`
-	ClientOnlyComponents []HydratedComponent     `js:"clientOnlyComponents"`
-	ServerComponents     []HydratedComponent     `js:"serverComponents"`
-	ContainsHead         bool                    `js:"containsHead"`
-	StyleError           []string                `js:"styleError"`
-	Propagation          bool                    `js:"propagation"`
+	Code                   string                  `js:"code"`
+	Diagnostics            []loc.DiagnosticMessage `js:"diagnostics"`
+	Map                    string                  `js:"map"`
+	Scope                  string                  `js:"scope"`
+	CSS                    []string                `js:"css"`
+	Scripts                []HoistedScript         `js:"scripts"`
+	HydratedComponents     []HydratedComponent     `js:"hydratedComponents"`
+	ClientOnlyComponents   []HydratedComponent     `js:"clientOnlyComponents"`
+	ServerComponents       []HydratedComponent     `js:"serverComponents"`
+	ComponentUsages        []ComponentUsage        `js:"componentUsages"`
+	CSSImports             []CSSImport             `js:"cssImports"`
+	StyleImports           []string                `js:"styleImports"`
+	ContainsHead           bool                    `js:"containsHead"`
+	StyleError             []string                `js:"styleError"`
+	Propagation            bool                    `js:"propagation"`
+	ContainsGetStaticPaths bool                    `js:"containsGetStaticPaths"`
+	Prerender              *bool                   `js:"prerender"`
 }
 
 // This is spawned as a goroutine to preprocess style nodes using an async function passed from JS
@@ -248,9 +429,75 @@ func preprocessStyle(i int, style *astro.Node, transformOptions transform.Transf
 	style.FirstChild.Data = str
 }
 
+// cancellation is the cancel function of a running transform started with an `id`
+type cancellation struct {
+	cancel context.CancelFunc
+}
+
+var (
+	cancellationsMu sync.Mutex
+	cancellations   = map[string]*cancellation{}
+)
+
+// registerCancellation returns a context that is cancelled when JS calls `cancel(id)`,
+// and a function to call once the transform with that id settles. Transforms
+// without an id can't be cancelled.
+func registerCancellation(id string) (context.Context, func()) {
+	if id == "" {
+		return context.Background(), func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &cancellation{cancel: cancel}
+	cancellationsMu.Lock()
+	cancellations[id] = c
+	cancellationsMu.Unlock()
+	return ctx, func() {
+		cancellationsMu.Lock()
+		// The id may have been reused by a newer transform in the meantime
+		if cancellations[id] == c {
+			delete(cancellations, id)
+		}
+		cancellationsMu.Unlock()
+		cancel()
+	}
+}
+
+// Cancel aborts the running transform with the given id, which then rejects
+// with an "AbortError". It returns whether such a transform was found.
+func Cancel() any {
+	return js.FuncOf(func(this js.Value, args []js.Value) any {
+		id := jsString(args[0])
+		cancellationsMu.Lock()
+		c, ok := cancellations[id]
+		delete(cancellations, id)
+		cancellationsMu.Unlock()
+		if ok {
+			c.cancel()
+		}
+		return ok
+	})
+}
+
+func cancellationError(id string) js.Value {
+	err := js.Global().Get("Error").New(fmt.Sprintf("Transform %q was cancelled", id))
+	err.Set("name", "AbortError")
+	return err
+}
+
+// yieldToEventLoop lets JS run queued tasks, e.g. a call to `cancel`,
+// before the calling goroutine continues.
+func yieldToEventLoop() {
+	executor := js.FuncOf(func(this js.Value, args []js.Value) any {
+		js.Global().Call("setTimeout", args[0], 0)
+		return nil
+	})
+	defer executor.Release()
+	wasm_utils.Await(js.Global().Get("Promise").New(executor))
+}
+
 func Parse() any {
 	return js.FuncOf(func(this js.Value, args []js.Value) any {
-		source := jsString(args[0])
+		source := transform.NormalizeLineEndings(jsString(args[0]))
 		parseOptions := makeParseOptions(js.Value(args[1]))
 		transformOptions := makeTransformOptions(js.Value(args[1]))
 		transformOptions.Scope = "xxxxxx"
@@ -275,7 +522,7 @@ func Parse() any {
 
 func ConvertToTSX() any {
 	return js.FuncOf(func(this js.Value, args []js.Value) any {
-		source := jsString(args[0])
+		source := transform.NormalizeLineEndings(jsString(args[0]))
 		transformOptions := makeTransformOptions(js.Value(args[1]))
 		transformOptions.Scope = "xxxxxx"
 		h := handler.NewHandler(source, transformOptions.Filename)
@@ -309,207 +556,358 @@ func ConvertToTSX() any {
 	})
 }
 
-func Transform() any {
-	return js.FuncOf(func(this js.Value, args []js.Value) any {
-		source := strings.TrimRightFunc(jsString(args[0]), unicode.IsSpace)
-
-		transformOptions := makeTransformOptions(js.Value(args[1]))
+// makeScopedTransformOptions reads the transform options of source and derives its scope.
+func makeScopedTransformOptions(source string, options js.Value) transform.TransformOptions {
+	transformOptions := makeTransformOptions(options)
+	if transformOptions.ProjectRoot != "" {
+		transformOptions.Scope = transform.ScopeHash(transformOptions.Filename, transformOptions.ProjectRoot)
+	} else {
 		scopeStr := transformOptions.NormalizedFilename
 		if scopeStr == "<stdin>" {
 			scopeStr = source
 		}
 		transformOptions.Scope = astro.HashString(scopeStr)
+	}
+	return transformOptions
+}
+
+func Transform() any {
+	return js.FuncOf(func(this js.Value, args []js.Value) any {
+		source := transform.NormalizeLineEndings(strings.TrimRightFunc(jsString(args[0]), unicode.IsSpace))
+		transformOptions := makeScopedTransformOptions(source, js.Value(args[1]))
 		h := handler.NewHandler(source, transformOptions.Filename)
+		// Cap the diagnostics of the parser too, which runs before the transform applies the option
+		h.SetMaxDiagnostics(transformOptions.MaxDiagnostics)
+		id := jsString(args[1].Get("id"))
+		ctx, settle := registerCancellation(id)
 
-		styleError := []string{}
 		promiseHandle := js.FuncOf(func(this js.Value, args []js.Value) any {
 			resolve := args[0]
 			reject := args[1]
 
 			go func() {
-				var doc *astro.Node
-				defer func() {
-					if err := recover(); err != nil {
-						reject.Invoke(wasm_utils.ErrorToJSError(h, err.(error)))
-						return
-					}
-				}()
-
-				doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
-				if err != nil {
-					reject.Invoke(wasm_utils.ErrorToJSError(h, err))
+				defer settle()
+				value, failure := transformFile(ctx, id, source, transformOptions, h, nil)
+				if !failure.IsUndefined() {
+					reject.Invoke(failure)
 					return
 				}
+				resolve.Invoke(value)
+			}()
 
-				// Hoist styles and scripts to the top-level
-				transform.ExtractStyles(doc, &transformOptions)
-
-				// Pre-process styles
-				// Important! These goroutines need to be spawned from this file or they don't work
-				var wg sync.WaitGroup
-				if len(doc.Styles) > 0 {
-					if transformOptions.PreprocessStyle.(js.Value).Type() == js.TypeFunction {
-						for i, style := range doc.Styles {
-							wg.Add(1)
-							i := i
-							go preprocessStyle(i, style, transformOptions, &styleError, wg.Done)
-						}
+			return nil
+		})
+		defer promiseHandle.Release()
+
+		// Create and return the Promise object
+		promiseConstructor := js.Global().Get("Promise")
+		return promiseConstructor.New(promiseHandle)
+	})
+}
+
+// TransformAll compiles a batch of files in one call, in order, so that the cost of crossing
+// into WASM is only paid once. Each file's `options` override the batch-level options. A file
+// that fails to compile doesn't stop the others: its result has empty code and carries the
+// error in its diagnostics.
+func TransformAll() any {
+	return js.FuncOf(func(this js.Value, args []js.Value) any {
+		files := args[0]
+		defaults := js.Global().Get("Object").New()
+		if len(args) > 1 && !args[1].IsUndefined() && !args[1].IsNull() {
+			defaults = args[1]
+		}
+		type batchFile struct {
+			source           string
+			transformOptions transform.TransformOptions
+		}
+		batch := make([]batchFile, files.Length())
+		for i := range batch {
+			file := files.Index(i)
+			options := js.Global().Get("Object").Call("assign", js.Global().Get("Object").New(), defaults, file.Get("options"))
+			if filename := jsString(file.Get("filename")); filename != "" {
+				options.Set("filename", filename)
+			}
+			source := transform.NormalizeLineEndings(strings.TrimRightFunc(jsString(file.Get("source")), unicode.IsSpace))
+			batch[i] = batchFile{source: source, transformOptions: makeScopedTransformOptions(source, options)}
+		}
+
+		promiseHandle := js.FuncOf(func(this js.Value, args []js.Value) any {
+			resolve := args[0]
+
+			go func() {
+				results := js.Global().Get("Array").New(len(batch))
+				// Every result is copied to JS before the next file is parsed, so the buffer can be reused
+				var buf bytes.Buffer
+				for i, file := range batch {
+					h := handler.NewHandler(file.source, file.transformOptions.Filename)
+					h.SetMaxDiagnostics(file.transformOptions.MaxDiagnostics)
+					value, failure := transformFile(context.Background(), "", file.source, file.transformOptions, h, &buf)
+					if !failure.IsUndefined() {
+						h.AppendError(&loc.ErrorWithRange{
+							Code: loc.ERROR,
+							Text: jsString(failure.Get("message")),
+						})
+						value = failedTransformResult(file.transformOptions, h)
 					}
+					results.SetIndex(i, value)
 				}
-				// Wait for all the style goroutines to finish
-				wg.Wait()
-
-				// Perform CSS and element scoping as needed
-				transform.Transform(doc, transformOptions, h)
-
-				css := []string{}
-				scripts := []HoistedScript{}
-				hydratedComponents := []HydratedComponent{}
-				clientOnlyComponents := []HydratedComponent{}
-				serverComponents := []HydratedComponent{}
-				css_result := printer.PrintCSS(source, doc, transformOptions)
-				for _, bytes := range css_result.Output {
-					css = append(css, string(bytes))
-				}
+				resolve.Invoke(results)
+			}()
 
-				// Append hoisted scripts
-				for _, node := range doc.Scripts {
-					src := astro.GetAttribute(node, "src")
-					script := HoistedScript{
-						Src:  "",
-						Code: "",
-						Type: "",
-						Map:  "",
-					}
+			return nil
+		})
+		defer promiseHandle.Release()
+
+		promiseConstructor := js.Global().Get("Promise")
+		return promiseConstructor.New(promiseHandle)
+	})
+}
 
-					if src != nil {
-						script.Type = "external"
-						script.Src = src.Val
-					} else if node.FirstChild != nil {
-						script.Type = "inline"
-
-						if transformOptions.SourceMap != "" {
-							isLine := func(r rune) bool { return r == '\r' || r == '\n' }
-							isNotLine := func(r rune) bool { return !(r == '\r' || r == '\n') }
-							output := make([]byte, 0)
-							builder := sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(source, len(strings.Split(source, "\n"))))
-							sourcesContent, _ := json.Marshal(source)
-							if len(node.FirstChild.Loc) > 0 {
-								i := node.FirstChild.Loc[0].Start
-								nonWS := strings.IndexFunc(node.FirstChild.Data, isNotLine)
-								i += nonWS
-								for _, ln := range strings.Split(strings.TrimFunc(node.FirstChild.Data, isLine), "\n") {
-									content := []byte(ln)
-									content = append(content, '\n')
-									for j, b := range content {
-										if j == 0 || !unicode.IsSpace(rune(b)) {
-											builder.AddSourceMapping(loc.Loc{Start: i}, output)
-										}
-										output = append(output, b)
-										i += 1
-									}
-								}
-								output = append(output, '\n')
-							} else {
-								output = append(output, []byte(strings.TrimSpace(node.FirstChild.Data))...)
+// failedTransformResult is the result of a file of a batch that could not be compiled.
+func failedTransformResult(transformOptions transform.TransformOptions, h *handler.Handler) js.Value {
+	value := vert.ValueOf(&TransformResult{
+		Scope:                transformOptions.Scope,
+		CSS:                  []string{},
+		Scripts:              []HoistedScript{},
+		HydratedComponents:   []HydratedComponent{},
+		ClientOnlyComponents: []HydratedComponent{},
+		ServerComponents:     []HydratedComponent{},
+		ComponentUsages:      []ComponentUsage{},
+		CSSImports:           []CSSImport{},
+		StyleImports:         []string{},
+		StyleError:           []string{},
+	})
+	value.Set("metadata", js.Null())
+	value.Set("diagnostics", vert.ValueOf(h.Diagnostics()).Value)
+	return value.Value
+}
+
+// transformFile compiles a single component. On failure, it returns the value the promise
+// of the transform should reject with instead of a result. When id is set, ctx is checked
+// for cancellation between phases.
+func transformFile(ctx context.Context, id string, source string, transformOptions transform.TransformOptions, h *handler.Handler, buf *bytes.Buffer) (output js.Value, failure js.Value) {
+	defer func() {
+		if err := recover(); err != nil {
+			output, failure = js.Undefined(), wasm_utils.ErrorToJSError(h, err.(error))
+		}
+	}()
+
+	// Checked between phases. Cancellable transforms give JS a chance to call
+	// `cancel` first, since it can't run while a goroutine is busy.
+	cancelled := func() bool {
+		if id != "" {
+			yieldToEventLoop()
+		}
+		return ctx.Err() != nil
+	}
+
+	styleError := []string{}
+	parseOptions := []astro.ParseOption{astro.ParseOptionWithHandler(h)}
+	if buf != nil {
+		parseOptions = append(parseOptions, astro.ParseOptionWithBuffer(buf))
+	}
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), parseOptions...)
+	if err != nil {
+		return js.Undefined(), wasm_utils.ErrorToJSError(h, err)
+	}
+	if cancelled() {
+		return js.Undefined(), cancellationError(id)
+	}
+
+	// Hoist styles and scripts to the top-level
+	transform.ExtractStyles(doc, &transformOptions, h)
+
+	// Pre-process styles
+	// Important! These goroutines need to be spawned from this file or they don't work
+	var wg sync.WaitGroup
+	if styles := transform.ComponentStyles(doc, &transformOptions); len(styles) > 0 {
+		if transformOptions.PreprocessStyle.(js.Value).Type() == js.TypeFunction {
+			for i, style := range styles {
+				wg.Add(1)
+				i := i
+				go preprocessStyle(i, style, transformOptions, &styleError, wg.Done)
+			}
+		}
+	}
+	// Wait for all the style goroutines to finish, unless the transform is cancelled first
+	preprocessed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(preprocessed)
+	}()
+	select {
+	case <-preprocessed:
+	case <-ctx.Done():
+		return js.Undefined(), cancellationError(id)
+	}
+
+	// Perform CSS and element scoping as needed
+	transform.Transform(doc, transformOptions, h)
+	if err := transform.RunPlugins(doc, transformOptions, h); err != nil {
+		return js.Undefined(), wasm_utils.ErrorToJSError(h, err)
+	}
+	transformed := transform.NewTransformResult(doc, h)
+	if cancelled() {
+		return js.Undefined(), cancellationError(id)
+	}
+
+	css := []string{}
+	scripts := []HoistedScript{}
+	hydratedComponents := []HydratedComponent{}
+	clientOnlyComponents := []HydratedComponent{}
+	serverComponents := []HydratedComponent{}
+	css_result := printer.PrintCSS(source, doc, transformOptions)
+	for _, bytes := range css_result.Output {
+		css = append(css, string(bytes))
+	}
+
+	// Append hoisted scripts
+	for _, hoisted := range transformed.Scripts {
+		node := hoisted.Node
+		script := HoistedScript{
+			Src:  "",
+			Code: "",
+			Type: "",
+			Map:  "",
+		}
+
+		if hoisted.Type == "external" {
+			script.Type = "external"
+			script.Src = hoisted.Src
+		} else if node.FirstChild != nil {
+			script.Type = "inline"
+
+			if transformOptions.SourceMap != "" {
+				isLine := func(r rune) bool { return r == '\r' || r == '\n' }
+				isNotLine := func(r rune) bool { return !(r == '\r' || r == '\n') }
+				output := make([]byte, 0)
+				builder := sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(source, strings.Count(source, "\n")+1))
+				sources, _ := json.Marshal(transformOptions.Filename)
+				sourcesContent, _ := json.Marshal(source)
+				if len(node.FirstChild.Loc) > 0 {
+					i := node.FirstChild.Loc[0].Start
+					nonWS := strings.IndexFunc(node.FirstChild.Data, isNotLine)
+					i += nonWS
+					for _, ln := range strings.Split(strings.TrimFunc(node.FirstChild.Data, isLine), "\n") {
+						content := []byte(ln)
+						content = append(content, '\n')
+						for j, b := range content {
+							if j == 0 || !unicode.IsSpace(rune(b)) {
+								builder.AddSourceMapping(loc.Loc{Start: i}, output)
 							}
-							sourcemap := fmt.Sprintf(
-								`{ "version": 3, "sources": ["%s"], "sourcesContent": [%s], "mappings": "%s", "names": [] }`,
-								transformOptions.Filename,
-								string(sourcesContent),
-								string(builder.GenerateChunk(output).Buffer),
-							)
-							script.Map = sourcemap
-							script.Code = string(output)
-						} else {
-							script.Code = node.FirstChild.Data
+							output = append(output, b)
+							i += 1
 						}
 					}
-
-					// sourcemapString := createSourceMapString(source, result, transformOptions)
-					// inlineSourcemap := `//# sourceMappingURL=data:application/json;charset=utf-8;base64,` + base64.StdEncoding.EncodeToString([]byte(sourcemapString))
-					scripts = append(scripts, script)
+					output = append(output, '\n')
+				} else {
+					output = append(output, []byte(strings.TrimSpace(node.FirstChild.Data))...)
 				}
+				sourcemap := fmt.Sprintf(
+					`{ "version": 3, "sources": [%s], "sourcesContent": [%s], "mappings": "%s", "names": [] }`,
+					string(sources),
+					string(sourcesContent),
+					string(builder.GenerateChunk(output).Buffer),
+				)
+				script.Map = sourcemap
+				script.Code = string(output)
+			} else {
+				script.Code = hoisted.Code
+			}
+		}
 
-				for _, c := range doc.HydratedComponents {
-					hydratedComponents = append(hydratedComponents, HydratedComponent{
-						ExportName:   c.ExportName,
-						Specifier:    c.Specifier,
-						ResolvedPath: c.ResolvedPath,
-					})
-				}
+		// sourcemapString := createSourceMapString(source, result, transformOptions)
+		// inlineSourcemap := `//# sourceMappingURL=data:application/json;charset=utf-8;base64,` + base64.StdEncoding.EncodeToString([]byte(sourcemapString))
+		scripts = append(scripts, script)
+	}
 
-				for _, c := range doc.ClientOnlyComponents {
-					clientOnlyComponents = append(clientOnlyComponents, HydratedComponent{
-						ExportName:   c.ExportName,
-						Specifier:    c.Specifier,
-						ResolvedPath: c.ResolvedPath,
-					})
-				}
+	for _, c := range transformed.HydratedComponents {
+		hydratedComponents = append(hydratedComponents, HydratedComponent{
+			ExportName:   c.ExportName,
+			Specifier:    c.Specifier,
+			ResolvedPath: c.ResolvedPath,
+		})
+	}
 
-				for _, c := range doc.ServerComponents {
-					serverComponents = append(serverComponents, HydratedComponent{
-						ExportName:   c.ExportName,
-						LocalName:    c.LocalName,
-						Specifier:    c.Specifier,
-						ResolvedPath: c.ResolvedPath,
-					})
-				}
+	for _, c := range transformed.ClientOnlyComponents {
+		clientOnlyComponents = append(clientOnlyComponents, HydratedComponent{
+			ExportName:   c.ExportName,
+			Specifier:    c.Specifier,
+			ResolvedPath: c.ResolvedPath,
+		})
+	}
 
-				var value vert.Value
-				result := printer.PrintToJS(source, doc, len(css), transformOptions, h)
-				transformResult := &TransformResult{
-					CSS:                  css,
-					Scope:                transformOptions.Scope,
-					Scripts:              scripts,
-					HydratedComponents:   hydratedComponents,
-					ClientOnlyComponents: clientOnlyComponents,
-					ServerComponents:     serverComponents,
-					ContainsHead:         doc.ContainsHead,
-					StyleError:           styleError,
-					Propagation:          doc.HeadPropagation,
-				}
-				switch transformOptions.SourceMap {
-				case "external":
-					value = createExternalSourceMap(source, transformResult, result, transformOptions)
-				case "both":
-					value = createBothSourceMap(source, transformResult, result, transformOptions)
-				case "inline":
-					value = createInlineSourceMap(source, transformResult, result, transformOptions)
-				default:
-					transformResult.Code = string(result.Output)
-					transformResult.Map = ""
-					value = vert.ValueOf(transformResult)
-				}
-				value.Set("diagnostics", vert.ValueOf(h.Diagnostics()).Value)
-				resolve.Invoke(value.Value)
-			}()
+	for _, c := range transformed.ServerComponents {
+		serverComponents = append(serverComponents, HydratedComponent{
+			ExportName:   c.ExportName,
+			LocalName:    c.LocalName,
+			Specifier:    c.Specifier,
+			ResolvedPath: c.ResolvedPath,
+		})
+	}
 
-			return nil
+	componentUsages := []ComponentUsage{}
+	for _, u := range transformed.ComponentUsages {
+		line, column := h.LineAndColumn(u.Pos)
+		componentUsages = append(componentUsages, ComponentUsage{
+			Name:       u.Name,
+			Attrs:      append([]string{}, u.Attrs...),
+			Directives: append([]string{}, u.Directives...),
+			HasSpread:  u.HasSpread,
+			Position:   Point{Line: line, Column: column, Offset: u.Pos.Start},
 		})
-		defer promiseHandle.Release()
+	}
 
-		// Create and return the Promise object
-		promiseConstructor := js.Global().Get("Promise")
-		return promiseConstructor.New(promiseHandle)
-	})
+	cssImports := []CSSImport{}
+	for _, i := range transformed.CSSImports {
+		cssImports = append(cssImports, CSSImport{
+			Specifier:  i.Specifier,
+			SideEffect: i.SideEffect,
+			Start:      i.Loc.Start,
+		})
+	}
+
+	var value vert.Value
+	result := printer.PrintTransformResultToJS(source, transformed, len(css), transformOptions, h)
+	if cancelled() {
+		return js.Undefined(), cancellationError(id)
+	}
+	transformResult := &TransformResult{
+		CSS:                    css,
+		Scope:                  doc.Scope,
+		Scripts:                scripts,
+		HydratedComponents:     hydratedComponents,
+		ClientOnlyComponents:   clientOnlyComponents,
+		ServerComponents:       serverComponents,
+		ComponentUsages:        componentUsages,
+		CSSImports:             cssImports,
+		StyleImports:           append([]string{}, transformed.StyleImports...),
+		ContainsHead:           transformed.ContainsHead,
+		StyleError:             styleError,
+		Propagation:            transformed.Propagation,
+		ContainsGetStaticPaths: transformed.ContainsGetStaticPaths,
+		Prerender:              transformed.Prerender,
+	}
+	switch transformOptions.SourceMap {
+	case "external":
+		value = createExternalSourceMap(source, transformResult, result, transformOptions)
+	case "both":
+		value = createBothSourceMap(source, transformResult, result, transformOptions)
+	case "inline":
+		value = createInlineSourceMap(source, transformResult, result, transformOptions)
+	default:
+		transformResult.Code = string(result.Output)
+		transformResult.Map = ""
+		value = vert.ValueOf(transformResult)
+	}
+	metadata, _ := json.Marshal(result.Metadata)
+	value.Set("metadata", js.Global().Get("JSON").Call("parse", string(metadata)))
+	value.Set("diagnostics", vert.ValueOf(h.Diagnostics()).Value)
+	return value.Value, js.Undefined()
 }
 
 func createSourceMapString(source string, result printer.PrintResult, transformOptions transform.TransformOptions) string {
-	sourcesContent, _ := json.Marshal(source)
-	sourcemap := RawSourceMap{
-		Version:        3,
-		Sources:        []string{transformOptions.Filename},
-		SourcesContent: []string{string(sourcesContent)},
-		Mappings:       string(result.SourceMapChunk.Buffer),
-	}
-	return fmt.Sprintf(`{
-  "version": 3,
-  "sources": ["%s"],
-  "sourcesContent": [%s],
-  "mappings": "%s",
-  "names": []
-}`, sourcemap.Sources[0], sourcemap.SourcesContent[0], sourcemap.Mappings)
+	return printer.SourceMapString(source, result, transformOptions.Filename)
 }
 
 func createExternalSourceMap(source string, transformResult *TransformResult, result printer.PrintResult, transformOptions transform.TransformOptions) vert.Value {
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/withastro/compiler/internal/loc"
@@ -16,13 +17,18 @@ type Handler struct {
 	warnings   []error
 	infos      []error
 	hints      []error
+	// Called with every diagnostic as it is appended
+	onDiagnostic func(loc.DiagnosticMessage)
+	// The number of warnings, infos and hints kept before the others are dropped, 0 keeps all of them
+	maxDiagnostics int
+	truncated      bool
 }
 
 func NewHandler(sourcetext string, filename string) *Handler {
 	return &Handler{
 		sourcetext: sourcetext,
 		filename:   filename,
-		builder:    sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(sourcetext, len(strings.Split(sourcetext, "\n")))),
+		builder:    sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(sourcetext, strings.Count(sourcetext, "\n")+1)),
 		errors:     make([]error, 0),
 		warnings:   make([]error, 0),
 		infos:      make([]error, 0),
@@ -30,24 +36,93 @@ func NewHandler(sourcetext string, filename string) *Handler {
 	}
 }
 
+// Fork returns a handler without any diagnostics for the same source and file.
+func (h *Handler) Fork() *Handler {
+	return NewHandler(h.sourcetext, h.filename)
+}
+
+// Source returns the source that diagnostics refer to.
+func (h *Handler) Source() string {
+	return h.sourcetext
+}
+
+// LineAndColumn returns the 1-based line and column of l in the source.
+func (h *Handler) LineAndColumn(l loc.Loc) (int, int) {
+	pos := h.builder.GetLineAndColumnForLocation(l)
+	return pos[0], pos[1]
+}
+
+// SetOnDiagnostic sets a function that is called with every diagnostic appended from now on,
+// in addition to collecting it. A nil fn only collects diagnostics.
+func (h *Handler) SetOnDiagnostic(fn func(loc.DiagnosticMessage)) {
+	h.onDiagnostic = fn
+}
+
+// SetMaxDiagnostics caps the number of warnings, infos and hints that are kept. Once max of
+// them have been appended, the others are dropped and a single warning records that the list
+// was truncated. Errors are always kept. A max of 0 keeps all of them.
+func (h *Handler) SetMaxDiagnostics(max int) {
+	h.maxDiagnostics = max
+}
+
+func (h *Handler) report(severity loc.DiagnosticSeverity, err error) {
+	if h.onDiagnostic != nil && err != nil {
+		h.onDiagnostic(ErrorToMessage(h, severity, err))
+	}
+}
+
+// accept reports whether another warning, info or hint can be kept, recording the truncation
+// marker the first time one is dropped.
+func (h *Handler) accept() bool {
+	if h.truncated {
+		return false
+	}
+	if h.maxDiagnostics <= 0 || len(h.warnings)+len(h.infos)+len(h.hints) < h.maxDiagnostics {
+		return true
+	}
+	h.truncated = true
+	marker := &loc.ErrorWithRange{
+		Code: loc.WARNING_DIAGNOSTICS_TRUNCATED,
+		Text: fmt.Sprintf("Only the first %d diagnostics are reported, the others were dropped.", h.maxDiagnostics),
+	}
+	h.warnings = append(h.warnings, marker)
+	h.report(loc.WarningType, marker)
+	return false
+}
+
 func (h *Handler) HasErrors() bool {
 	return len(h.errors) > 0
 }
 
+// AppendError collects err. Errors are never dropped by SetMaxDiagnostics, so that a
+// compile that fails is always reported as such.
 func (h *Handler) AppendError(err error) {
 	h.errors = append(h.errors, err)
+	h.report(loc.ErrorType, err)
 }
 
 func (h *Handler) AppendWarning(err error) {
+	if !h.accept() {
+		return
+	}
 	h.warnings = append(h.warnings, err)
+	h.report(loc.WarningType, err)
 }
 
 func (h *Handler) AppendInfo(err error) {
+	if !h.accept() {
+		return
+	}
 	h.infos = append(h.infos, err)
+	h.report(loc.InformationType, err)
 }
 
 func (h *Handler) AppendHint(err error) {
+	if !h.accept() {
+		return
+	}
 	h.hints = append(h.hints, err)
+	h.report(loc.HintType, err)
 }
 
 func (h *Handler) Errors() []loc.DiagnosticMessage {
@@ -239,20 +239,383 @@ func HoistImports(source []byte) HoistedScripts {
 }
 
 func HasGetStaticPaths(source []byte) bool {
-	ident := []byte("getStaticPaths")
-	if !bytes.Contains(source, ident) {
+	if !bytes.Contains(source, []byte("getStaticPaths")) {
 		return false
 	}
+	return ScanPageExports(source).ContainsGetStaticPaths
+}
+
+// PageExports describes the route-level exports of a page's frontmatter.
+type PageExports struct {
+	ContainsGetStaticPaths bool
+	// Only set when `prerender` is exported as a boolean literal
+	Prerender *bool
+}
+
+// ScanPageExports detects exported `getStaticPaths` and `prerender` bindings,
+// including functions, variable declarations and re-exports.
+func ScanPageExports(source []byte) PageExports {
+	result := PageExports{}
+	if !bytes.Contains(source, []byte("getStaticPaths")) && !bytes.Contains(source, []byte("prerender")) {
+		return result
+	}
+	for _, statement := range HoistExports(source).Hoisted {
+		tokens := significantTokens(statement)
+		if len(tokens) < 2 || tokens[0].token != js.ExportToken {
+			continue
+		}
+		for _, name := range exportedNames(tokens[1:]) {
+			if name == "getStaticPaths" {
+				result.ContainsGetStaticPaths = true
+			}
+		}
+		// export const prerender = true
+		if len(tokens) >= 5 && isDeclarationKeyword(tokens[1].value) && string(tokens[2].value) == "prerender" && tokens[3].token == js.EqToken {
+			switch tokens[4].token {
+			case js.TrueToken:
+				prerender := true
+				result.Prerender = &prerender
+			case js.FalseToken:
+				prerender := false
+				result.Prerender = &prerender
+			}
+		}
+	}
+	return result
+}
+
+// Declaration is a binding declared at the top level of a script.
+type Declaration struct {
+	Name string
+	// One of "const", "let", "var", "function" or "class"
+	Kind string
+	// Offset of the name in the scanned source
+	Start int
+}
 
-	exports := HoistExports(source)
-	for _, statement := range exports.Hoisted {
-		if bytes.Contains(statement, ident) {
-			return true
+// ScanTopLevelDeclarations returns the bindings declared by `const`, `let`, `var`, `function`
+// and `class` statements at the top level of source, in source order. This is a heuristic
+// rather than a parse: only the first binding of a declaration is returned, and destructuring
+// patterns are skipped.
+func ScanTopLevelDeclarations(source []byte) []Declaration {
+	declarations := make([]Declaration, 0)
+	tokens := significantTokens(source)
+	depth := 0
+	for i, t := range tokens {
+		switch t.token {
+		case js.OpenBraceToken, js.OpenParenToken, js.OpenBracketToken, js.TemplateStartToken:
+			depth++
+			continue
+		case js.CloseBraceToken, js.CloseParenToken, js.CloseBracketToken, js.TemplateEndToken:
+			depth--
+			continue
+		}
+		if depth != 0 || i+1 == len(tokens) {
+			continue
 		}
+		kind := string(t.value)
+		if !isDeclarationKeyword(t.value) && t.token != js.FunctionToken && t.token != js.ClassToken {
+			continue
+		}
+		// `async function`
+		first := i
+		if t.token == js.FunctionToken && i > 0 && tokens[i-1].token == js.AsyncToken {
+			first--
+		}
+		if !startsStatement(tokens, first) {
+			continue
+		}
+		name := tokens[i+1]
+		if name.token == js.MulToken && i+2 < len(tokens) {
+			name = tokens[i+2]
+		}
+		if js.IsIdentifier(name.token) {
+			declarations = append(declarations, Declaration{Name: string(name.value), Kind: kind, Start: name.start})
+		}
+	}
+	return declarations
+}
+
+// startsStatement reports whether tokens[i] is likely the first token of a statement,
+// optionally exported.
+func startsStatement(tokens []scannedToken, i int) bool {
+	if i > 0 && tokens[i-1].token == js.DefaultToken {
+		i--
+	}
+	if i > 0 && tokens[i-1].token == js.ExportToken {
+		i--
+	}
+	if i == 0 || tokens[i].newline {
+		return true
+	}
+	switch tokens[i-1].token {
+	case js.SemicolonToken, js.CloseBraceToken:
+		return true
 	}
 	return false
 }
 
+type scannedToken struct {
+	token js.TokenType
+	value []byte
+	// Offset of the token in the scanned source
+	start int
+	// Whether a line terminator precedes the token
+	newline bool
+}
+
+func (t scannedToken) end() int {
+	return t.start + len(t.value)
+}
+
+// significantTokens lexes source, dropping whitespace, line terminators and comments.
+func significantTokens(source []byte) []scannedToken {
+	tokens := make([]scannedToken, 0)
+	// The lexer writes a NULL terminator past the end of its input, so lex a copy
+	// to avoid clobbering the bytes that follow `source` in the underlying array
+	l := js.NewLexer(parse.NewInputBytes(append([]byte{}, source...)))
+	pos := 0
+	newline := false
+	for {
+		token, value := l.Next()
+		if token == js.ErrorToken {
+			return tokens
+		}
+		start := pos
+		pos += len(value)
+		if token == js.LineTerminatorToken || token == js.CommentLineTerminatorToken {
+			newline = true
+			continue
+		}
+		if token == js.WhitespaceToken || token == js.CommentToken {
+			continue
+		}
+		tokens = append(tokens, scannedToken{token: token, value: value, start: start, newline: newline})
+		newline = false
+	}
+}
+
+func isDeclarationKeyword(value []byte) bool {
+	switch string(value) {
+	case "const", "let", "var":
+		return true
+	}
+	return false
+}
+
+// exportedNames returns the names bound by the tokens following an `export` keyword.
+func exportedNames(tokens []scannedToken) []string {
+	names := make([]string, 0)
+	switch {
+	case tokens[0].token == js.OpenBraceToken:
+		// export { a, b as c } [from "..."]
+		var current []byte
+		for _, t := range tokens[1:] {
+			switch {
+			case t.token == js.CloseBraceToken || t.token == js.CommaToken:
+				if current != nil {
+					names = append(names, string(current))
+				}
+				current = nil
+				if t.token == js.CloseBraceToken {
+					return names
+				}
+			case string(t.value) == "as":
+				continue
+			case js.IsIdentifier(t.token) || t.token == js.StringToken:
+				current = t.value
+			}
+		}
+	case isDeclarationKeyword(tokens[0].value):
+		if len(tokens) > 1 {
+			names = append(names, string(tokens[1].value))
+		}
+	default:
+		// export [async] function[*] name
+		for i, t := range tokens {
+			if t.token == js.FunctionToken {
+				for _, next := range tokens[i+1:] {
+					if next.token == js.MulToken {
+						continue
+					}
+					names = append(names, string(next.value))
+					break
+				}
+				break
+			}
+			if string(t.value) != "async" {
+				break
+			}
+		}
+	}
+	return names
+}
+
+// PropsDeclaration describes where the `Props` type of a component is declared.
+type PropsDeclaration struct {
+	// One of "interface", "type" or "import"
+	Kind     string
+	Exported bool
+	Generic  bool
+	// The module `Props` is imported from, only set when Kind is "import"
+	Specifier string
+	// Offsets of the whole statement in the scanned source
+	Start int
+	End   int
+}
+
+// ScanPropsDeclaration finds the top-level `interface Props`, `type Props =` or
+// imported `Props` of the frontmatter source. It returns nil if there is none.
+func ScanPropsDeclaration(source []byte) *PropsDeclaration {
+	if !bytes.Contains(source, []byte("Props")) {
+		return nil
+	}
+	tokens := significantTokens(source)
+	depth := 0
+	for i, t := range tokens {
+		switch t.token {
+		case js.OpenBraceToken, js.OpenParenToken, js.OpenBracketToken:
+			depth++
+			continue
+		case js.CloseBraceToken, js.CloseParenToken, js.CloseBracketToken:
+			depth--
+			continue
+		}
+		if depth != 0 || i+2 >= len(tokens) || string(tokens[i+1].value) != "Props" {
+			continue
+		}
+		kind := string(t.value)
+		if kind != "interface" && kind != "type" {
+			continue
+		}
+		declaration := &PropsDeclaration{
+			Kind:    kind,
+			Generic: tokens[i+2].token == js.LtToken,
+			Start:   t.start,
+		}
+		if i > 0 && string(tokens[i-1].value) == "export" {
+			declaration.Exported = true
+			declaration.Start = tokens[i-1].start
+		}
+		var end int
+		var ok bool
+		if kind == "interface" {
+			end, ok = interfaceEnd(tokens, i+2)
+		} else {
+			end, ok = typeAliasEnd(tokens, i+2)
+		}
+		if !ok {
+			continue
+		}
+		declaration.End = end
+		return declaration
+	}
+
+	for i, statement := NextImportStatement(source, 0); i > -1; i, statement = NextImportStatement(source, i) {
+		for _, imported := range statement.Imports {
+			if imported.LocalName == "Props" {
+				return &PropsDeclaration{
+					Kind:      "import",
+					Specifier: statement.Specifier,
+					Start:     statement.Span.Start,
+					End:       statement.Span.End,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// angleDelta returns how much a token changes the nesting of `<` and `>`.
+func angleDelta(t scannedToken) int {
+	switch t.token {
+	case js.LtToken:
+		return 1
+	case js.GtToken:
+		return -1
+	case js.GtGtToken:
+		return -2
+	case js.GtGtGtToken:
+		return -3
+	}
+	return 0
+}
+
+// interfaceEnd returns the end offset of the interface body following the
+// type parameters and `extends` clause that start at tokens[i].
+func interfaceEnd(tokens []scannedToken, i int) (int, bool) {
+	angles := 0
+	depth := 0
+	for ; i < len(tokens); i++ {
+		t := tokens[i]
+		angles += angleDelta(t)
+		if angles > 0 {
+			continue
+		}
+		switch t.token {
+		case js.OpenBraceToken, js.OpenParenToken, js.OpenBracketToken:
+			depth++
+		case js.CloseBraceToken, js.CloseParenToken, js.CloseBracketToken:
+			depth--
+			if depth == 0 && t.token == js.CloseBraceToken {
+				return t.end(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// typeAliasEnd returns the end offset of the type alias whose type parameters
+// or `=` start at tokens[i]. Aliases end at a top-level `;`, or at a line break
+// that cannot continue the type.
+func typeAliasEnd(tokens []scannedToken, i int) (int, bool) {
+	angles := 0
+	depth := 0
+	foundEq := false
+	for ; i < len(tokens); i++ {
+		t := tokens[i]
+		if !foundEq {
+			angles += angleDelta(t)
+			if angles == 0 && t.token == js.EqToken {
+				foundEq = true
+			}
+			continue
+		}
+		if depth == 0 && angles == 0 {
+			if t.token == js.SemicolonToken {
+				return t.end(), true
+			}
+			if t.newline && !continuesType(tokens[i-1], t) {
+				return tokens[i-1].end(), true
+			}
+		}
+		angles += angleDelta(t)
+		switch t.token {
+		case js.OpenBraceToken, js.OpenParenToken, js.OpenBracketToken:
+			depth++
+		case js.CloseBraceToken, js.CloseParenToken, js.CloseBracketToken:
+			depth--
+		}
+	}
+	if !foundEq {
+		return 0, false
+	}
+	return tokens[len(tokens)-1].end(), true
+}
+
+// continuesType reports whether a type expression continues from prev onto the next line at t.
+func continuesType(prev scannedToken, t scannedToken) bool {
+	switch prev.token {
+	case js.EqToken, js.BitOrToken, js.BitAndToken, js.CommaToken, js.ColonToken, js.QuestionToken, js.ArrowToken, js.DotToken, js.LtToken:
+		return true
+	}
+	switch t.token {
+	case js.BitOrToken, js.BitAndToken, js.DotToken, js.QuestionToken, js.ColonToken, js.ArrowToken, js.GtToken:
+		return true
+	}
+	return string(prev.value) == "extends" || string(t.value) == "extends" || string(prev.value) == "keyof" || string(prev.value) == "typeof"
+}
+
 type Props struct {
 	Ident     string
 	Statement string
@@ -583,7 +946,8 @@ func NextImportStatement(source []byte, pos int) (int, ImportStatement) {
 				if next == js.DotToken {
 					isMeta := false
 					for {
-						next, _ := l.Next()
+						next, nextValue := l.Next()
+						i += len(nextValue)
 						if next == js.MetaToken {
 							isMeta = true
 						}
@@ -698,6 +1062,8 @@ func NextImportStatement(source []byte, pos int) (int, ImportStatement) {
 					break
 				}
 			}
+			// `i` already accounts for the `import` keyword
+			continue
 		}
 
 		i += len(value)
@@ -723,12 +1089,9 @@ func ExtractComponentExportName(data string, imported Import) (string, bool) {
 		case imported.ExportName == "*":
 			// matched a namespaced import
 			exportName = strings.Replace(data, namespacePrefix, "", 1)
-		case imported.ExportName == "default":
-			// matched a default import
-			exportName = strings.Replace(data, imported.LocalName, "default", 1)
 		default:
-			// matched a named import
-			exportName = data
+			// matched a member of a default or (possibly aliased) named import
+			exportName = strings.Replace(data, imported.LocalName, imported.ExportName, 1)
 		}
 		return exportName, true
 	}
@@ -203,6 +203,18 @@ import { c } from "c";`,
 import { c } from "c";
 `,
 		},
+		{
+			name: "dynamic import before static import",
+			source: `const theme = await import('./theme.css');
+import './card.css';`,
+			want: `import './card.css';`,
+		},
+		{
+			name: "import.meta before static import",
+			source: `const env = import.meta.env.MODE;
+import './card.css';`,
+			want: `import './card.css';`,
+		},
 	}
 }
 
@@ -665,3 +677,257 @@ func TestGetObjectKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestScanPageExports(t *testing.T) {
+	yes := true
+	no := false
+	tests := []struct {
+		name   string
+		source string
+		want   PageExports
+	}{
+		{
+			name:   "export function",
+			source: `export function getStaticPaths() { return [] }`,
+			want:   PageExports{ContainsGetStaticPaths: true},
+		},
+		{
+			name:   "export async function",
+			source: `export async function getStaticPaths() { return [] }`,
+			want:   PageExports{ContainsGetStaticPaths: true},
+		},
+		{
+			name: "export const arrow",
+			source: `export const getStaticPaths = async () => {
+	return []
+}`,
+			want: PageExports{ContainsGetStaticPaths: true},
+		},
+		{
+			name:   "re-export",
+			source: `export { getStaticPaths } from './shared';`,
+			want:   PageExports{ContainsGetStaticPaths: true},
+		},
+		{
+			name:   "aliased re-export",
+			source: `export { paths as getStaticPaths } from './shared';`,
+			want:   PageExports{ContainsGetStaticPaths: true},
+		},
+		{
+			name: "local export list",
+			source: `function getStaticPaths() { return [] }
+export { getStaticPaths };`,
+			want: PageExports{ContainsGetStaticPaths: true},
+		},
+		{
+			name:   "local function",
+			source: `function getStaticPaths() { return [] }`,
+			want:   PageExports{},
+		},
+		{
+			name:   "exported under another name",
+			source: `export { getStaticPaths as paths } from './shared';`,
+			want:   PageExports{},
+		},
+		{
+			name:   "mentioned in another export",
+			source: `export const name = "getStaticPaths";`,
+			want:   PageExports{},
+		},
+		{
+			name:   "prerender true",
+			source: `export const prerender = true;`,
+			want:   PageExports{Prerender: &yes},
+		},
+		{
+			name: "prerender false",
+			source: `import Layout from '../layouts/Layout.astro';
+export const prerender = false
+export function getStaticPaths() {}`,
+			want: PageExports{ContainsGetStaticPaths: true, Prerender: &no},
+		},
+		{
+			name:   "prerender expression",
+			source: `export const prerender = import.meta.env.PRERENDER;`,
+			want:   PageExports{},
+		},
+		{
+			name:   "local prerender",
+			source: `const prerender = true;`,
+			want:   PageExports{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ScanPageExports([]byte(tt.source))
+			if got.ContainsGetStaticPaths != tt.want.ContainsGetStaticPaths {
+				t.Errorf("ContainsGetStaticPaths = %v, want %v", got.ContainsGetStaticPaths, tt.want.ContainsGetStaticPaths)
+			}
+			if (got.Prerender == nil) != (tt.want.Prerender == nil) || (got.Prerender != nil && *got.Prerender != *tt.want.Prerender) {
+				t.Errorf("Prerender = %v, want %v", got.Prerender, tt.want.Prerender)
+			}
+		})
+	}
+}
+
+func TestScanTopLevelDeclarations(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "declarations",
+			source: "const a = 1;\nlet b;\nvar c = 3\nfunction d() {}\nclass E {}",
+			want:   []string{"const a", "let b", "var c", "function d", "class E"},
+		},
+		{
+			name:   "exported and async",
+			source: "export const a = 1;\nexport async function b() {}\nexport default function c() {}\nfunction* d() {}",
+			want:   []string{"const a", "function b", "function c", "function d"},
+		},
+		{
+			name:   "nested",
+			source: "function a() { const b = 1; }\nif (x) { let c; }\nfor (const d of e) {}\nconst f = () => { var g; };",
+			want:   []string{"function a", "const f"},
+		},
+		{
+			name:   "expressions",
+			source: "const a = function b() {}, c = class D {};\nx.function = 1;",
+			want:   []string{"const a"},
+		},
+		{
+			name:   "template literal",
+			source: "const a = `${ { b: 1 }.b }`;\nconst c = 2;",
+			want:   []string{"const a", "const c"},
+		},
+		{
+			name:   "destructuring",
+			source: "const { a } = b;\nconst [c] = d;",
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, d := range ScanTopLevelDeclarations([]byte(tt.source)) {
+				if name := tt.source[d.Start : d.Start+len(d.Name)]; name != d.Name {
+					t.Errorf("%s is at the offset of %q", d.Name, name)
+				}
+				got = append(got, d.Kind+" "+d.Name)
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestScanPropsDeclaration(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		kind      string
+		exported  bool
+		generic   bool
+		specifier string
+		statement string
+	}{
+		{
+			name: "interface",
+			source: `import Layout from '../layouts/Layout.astro';
+interface Props {
+	title: string;
+	nested: { a: number };
+}
+const { title } = Astro.props;`,
+			kind: "interface",
+			statement: `interface Props {
+	title: string;
+	nested: { a: number };
+}`,
+		},
+		{
+			name:      "exported interface",
+			source:    `export interface Props extends HTMLAttributes<'a'> { href: string }`,
+			kind:      "interface",
+			exported:  true,
+			statement: `export interface Props extends HTMLAttributes<'a'> { href: string }`,
+		},
+		{
+			name:      "generic interface",
+			source:    `interface Props<T extends { id: string }> { items: T[] }`,
+			kind:      "interface",
+			generic:   true,
+			statement: `interface Props<T extends { id: string }> { items: T[] }`,
+		},
+		{
+			name:      "type alias",
+			source:    "type Props = { title: string };\nconst { title } = Astro.props;",
+			kind:      "type",
+			statement: `type Props = { title: string };`,
+		},
+		{
+			name: "multiline type alias without semicolon",
+			source: `type Props =
+	| { href: string }
+	| { onClick: () => void }
+const props = Astro.props`,
+			kind: "type",
+			statement: `type Props =
+	| { href: string }
+	| { onClick: () => void }`,
+		},
+		{
+			name:      "exported generic type alias",
+			source:    `export type Props<T> = Record<string, T>`,
+			kind:      "type",
+			exported:  true,
+			generic:   true,
+			statement: `export type Props<T> = Record<string, T>`,
+		},
+		{
+			name:      "imported",
+			source:    "import type { Props } from './types';\nconst { title } = Astro.props;",
+			kind:      "import",
+			specifier: "./types",
+			statement: `import type { Props } from './types';`,
+		},
+		{
+			name:      "imported alias",
+			source:    `import { ButtonProps as Props } from '../Button.astro';`,
+			kind:      "import",
+			specifier: "../Button.astro",
+			statement: `import { ButtonProps as Props } from '../Button.astro';`,
+		},
+		{
+			name:   "nested interface",
+			source: `function inner() { interface Props {} }`,
+		},
+		{
+			name:   "no props",
+			source: `import type { ButtonProps } from './types';`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ScanPropsDeclaration([]byte(tt.source))
+			if tt.kind == "" {
+				if got != nil {
+					t.Errorf("\nFAIL: %s\n  want: nil\n  got:  %+v", tt.name, got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("\nFAIL: %s\n  want: %s\n  got:  nil", tt.name, tt.kind)
+			}
+			want := PropsDeclaration{Kind: tt.kind, Exported: tt.exported, Generic: tt.generic, Specifier: tt.specifier, Start: got.Start, End: got.End}
+			if *got != want {
+				t.Errorf("\nFAIL: %s\n  want: %+v\n  got:  %+v", tt.name, want, *got)
+			}
+			if statement := tt.source[got.Start:got.End]; statement != tt.statement {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.statement, statement)
+			}
+		})
+	}
+}
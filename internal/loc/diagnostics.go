@@ -9,6 +9,8 @@ const (
 	ERROR_UNMATCHED_IMPORT            DiagnosticCode = 1003
 	ERROR_UNSUPPORTED_SLOT_ATTRIBUTE  DiagnosticCode = 1004
 	ERROR_UNTERMINATED_STRING         DiagnosticCode = 1005
+	ERROR_UNKNOWN_DIRECTIVE           DiagnosticCode = 1006
+	ERROR_UNRESOLVED_CUSTOM_ELEMENT   DiagnosticCode = 1007
 	WARNING                           DiagnosticCode = 2000
 	WARNING_UNTERMINATED_HTML_COMMENT DiagnosticCode = 2001
 	WARNING_UNCLOSED_HTML_TAG         DiagnosticCode = 2002
@@ -20,6 +22,24 @@ const (
 	WARNING_INVALID_SPREAD            DiagnosticCode = 2008
 	WARNING_UNEXPECTED_CHARACTER      DiagnosticCode = 2009
 	WARNING_CANNOT_RERUN              DiagnosticCode = 2010
+	WARNING_DETACHED_NODE             DiagnosticCode = 2011
+	WARNING_UNSCOPED_SELECTOR         DiagnosticCode = 2012
+	WARNING_IMPLICITLY_CLOSED_ELEMENT DiagnosticCode = 2013
+	WARNING_MISSING_SCOPE             DiagnosticCode = 2014
+	WARNING_UNKNOWN_HYDRATED_ELEMENT  DiagnosticCode = 2015
+	WARNING_MIXED_CONTENT             DiagnosticCode = 2016
+	WARNING_HYDRATION_IN_NOSCRIPT     DiagnosticCode = 2017
+	WARNING_INVALID_SCOPE             DiagnosticCode = 2018
+	WARNING_UNKNOWN_DIRECTIVE         DiagnosticCode = 2019
+	WARNING_DUPLICATE_ATTRIBUTE       DiagnosticCode = 2020
+	WARNING_INLINE_SCRIPT_ATTRIBUTE   DiagnosticCode = 2021
+	WARNING_CONFLICTING_DECLARATION   DiagnosticCode = 2022
+	WARNING_ELEMENT_IN_EXPRESSION     DiagnosticCode = 2023
+	WARNING_DIAGNOSTICS_TRUNCATED     DiagnosticCode = 2024
+	WARNING_SELF_CLOSING_ELEMENT      DiagnosticCode = 2025
+	WARNING_INVALID_COMPONENT_ALIAS   DiagnosticCode = 2026
 	INFO                              DiagnosticCode = 3000
+	INFO_RAW_HTML                     DiagnosticCode = 3001
+	INFO_MISSING_VIEWPORT             DiagnosticCode = 3002
 	HINT                              DiagnosticCode = 4000
 )
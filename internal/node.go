@@ -5,6 +5,9 @@
 package astro
 
 import (
+	"slices"
+	"sort"
+
 	"github.com/withastro/compiler/internal/loc"
 	"golang.org/x/net/html/atom"
 )
@@ -70,6 +73,20 @@ type HydratedComponentMetadata struct {
 	ResolvedPath string
 }
 
+// ComponentUsage is a component or custom element used in the template, with the keys of the
+// attributes passed to it.
+type ComponentUsage struct {
+	Name string
+	// Keys of the attributes passed as props, in authored order. Spread attributes have no
+	// known keys, so they only set HasSpread.
+	Attrs []string
+	// Directives used on the component (e.g. `client:load`), which aren't passed as props
+	Directives []string
+	HasSpread  bool
+	// Position of the tag name
+	Pos loc.Loc
+}
+
 // A Node consists of a NodeType and some Data (tag name for element nodes,
 // content for text) and are part of a tree of Nodes. Element nodes may also
 // have a Namespace and contain a slice of Attributes. Data is unescaped, so
@@ -89,19 +106,44 @@ type Node struct {
 	// Whether this node is a script that should be rendered with the `renderScript` runtime,
 	// so that the runtime handles how this is bundled and referenced.
 	HandledScript bool
+	// Whether this element was closed by the parser without a matching end tag
+	ImplicitlyClosed bool
+	// Whether this element was written with a self-closing tag, e.g. `<circle />`
+	SelfClosing bool
 
 	Parent, FirstChild, LastChild, PrevSibling, NextSibling *Node
 
 	// These are only accessible from the document root Node
-	Styles, Scripts          []*Node
+	//
+	// Hoisted styles and scripts, in document order: the first style of the document
+	// is `Styles[0]`, whether it is in <head>, <body> or passed to a component. Styles
+	// added by a transform, e.g. with AppendStyle, come after those of the document.
+	Styles, Scripts []*Node
+	// Hoisted `<script src>` elements, one per resolved src, in document order
+	ExternalScripts []*Node
+	// Specifiers of the CSS `@import` rules found in extracted styles
+	StyleImports             []string
 	HydratedComponentNodes   []*Node
 	HydratedComponents       []*HydratedComponentMetadata
 	ClientOnlyComponentNodes []*Node
 	ClientOnlyComponents     []*HydratedComponentMetadata
-	HydrationDirectives      map[string]bool
+	HydrationDirectives      map[string]string
 	ServerComponents         []*HydratedComponentMetadata
+	ComponentUsages          []ComponentUsage
 	ContainsHead             bool
 	HeadPropagation          bool
+	// Whether the document renders anything inside of <head>, or any metadata elements
+	HasHeadContent bool
+	// Whether the document renders anything outside of <head> besides metadata elements
+	HasBodyContent bool
+	// Whether the document declares its encoding, with `<meta charset>` or the equivalent `http-equiv`
+	HasCharsetMeta bool
+	// Whether the document has a `<meta name="viewport">`
+	HasViewportMeta bool
+	// Elements that render unescaped HTML with `set:html`, in document order
+	RawHTMLNodes []*Node
+	// Scope used by Transform for the document's scoped styles, empty if there is none
+	Scope string
 
 	Type      NodeType
 	DataAtom  atom.Atom
@@ -216,6 +258,59 @@ func (n *Node) RemoveAttribute(key string) {
 	}
 }
 
+// SortedHydrationDirectives returns the hydration directives used in the
+// document, sorted by name so that generated output is deterministic.
+func (n *Node) SortedHydrationDirectives() []string {
+	directives := make([]string, 0, len(n.HydrationDirectives))
+	for directive := range n.HydrationDirectives {
+		directives = append(directives, directive)
+	}
+	sort.Strings(directives)
+	return directives
+}
+
+// NodesEqual reports whether a and b are equal trees: the same type, atom, data
+// and namespace, the same attributes in any order, and equal children in the
+// same order. Parent and sibling pointers, source locations and the metadata
+// collected on the document root are ignored, so tooling can use it to detect
+// whether a transform changed anything.
+func NodesEqual(a, b *Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || a.DataAtom != b.DataAtom || a.Data != b.Data || a.Namespace != b.Namespace || !attributesEqual(a.Attr, b.Attr) {
+		return false
+	}
+	ca, cb := a.FirstChild, b.FirstChild
+	for ca != nil && cb != nil {
+		if !NodesEqual(ca, cb) {
+			return false
+		}
+		ca, cb = ca.NextSibling, cb.NextSibling
+	}
+	return ca == nil && cb == nil
+}
+
+// attributesEqual compares attributes by namespace, key, value and type,
+// regardless of their order.
+func attributesEqual(a, b []Attribute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	matched := make([]bool, len(b))
+outer:
+	for _, x := range a {
+		for i, y := range b {
+			if !matched[i] && x.Namespace == y.Namespace && x.Key == y.Key && x.Val == y.Val && x.Type == y.Type {
+				matched[i] = true
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
 // reparentChildren reparents all of src's child nodes to dst.
 func reparentChildren(dst, src *Node) {
 	for {
@@ -245,6 +340,49 @@ func (n *Node) clone() *Node {
 	return m
 }
 
+// MarkComponent marks n as a component rather than a custom element, e.g. once its
+// tag name turns out to reference an import. An element is never both: the parser
+// treats tag names that start with an uppercase letter as components and other tag
+// names with a dash as custom elements.
+func (n *Node) MarkComponent() {
+	n.Component = true
+	n.CustomElement = false
+}
+
+// Clone returns a copy of n that shares no attributes or locations with it and keeps
+// its per-node flags, except HandledScript. If deep is true, the children of n are
+// cloned as well. The clone has no parent and no siblings, and it isn't part of the
+// styles, scripts or components collected on the document, so it is extracted like
+// any other node once it is added to the tree. A cloned document starts without any
+// of these.
+func (n *Node) Clone(deep bool) *Node {
+	m := &Node{
+		Type:             n.Type,
+		DataAtom:         n.DataAtom,
+		Data:             n.Data,
+		Namespace:        n.Namespace,
+		Attr:             slices.Clone(n.Attr),
+		Loc:              slices.Clone(n.Loc),
+		Fragment:         n.Fragment,
+		CustomElement:    n.CustomElement,
+		Component:        n.Component,
+		Expression:       n.Expression,
+		Transition:       n.Transition,
+		TransitionScope:  n.TransitionScope,
+		ImplicitlyClosed: n.ImplicitlyClosed,
+		SelfClosing:      n.SelfClosing,
+	}
+	if n.Type == DocumentNode {
+		m.HydrationDirectives = make(map[string]string)
+	}
+	if deep {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			m.AppendChild(c.Clone(true))
+		}
+	}
+	return m
+}
+
 // nodeStack is a stack of nodes.
 type nodeStack []*Node
 
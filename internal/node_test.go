@@ -0,0 +1,203 @@
+package astro
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/withastro/compiler/internal/loc"
+	"golang.org/x/net/html/atom"
+)
+
+func TestNodesEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want bool
+	}{
+		{
+			name: "equal trees",
+			a:    `<div class="a"><p>Hello {name}</p></div>`,
+			b:    `<div class="a"><p>Hello {name}</p></div>`,
+			want: true,
+		},
+		{
+			name: "attribute order",
+			a:    `<div class="a" id="b" {...props}></div>`,
+			b:    `<div {...props} id="b" class="a"></div>`,
+			want: true,
+		},
+		{
+			name: "source locations",
+			a:    `<div   class="a"><p>Hello</p></div>`,
+			b:    `<div class="a"><p>Hello</p></div>`,
+			want: true,
+		},
+		{
+			name: "text",
+			a:    `<div><p>Hello</p></div>`,
+			b:    `<div><p>Goodbye</p></div>`,
+			want: false,
+		},
+		{
+			name: "attribute value",
+			a:    `<div class="a"></div>`,
+			b:    `<div class="b"></div>`,
+			want: false,
+		},
+		{
+			name: "attribute type",
+			a:    `<div class="a"></div>`,
+			b:    `<div class={a}></div>`,
+			want: false,
+		},
+		{
+			name: "extra child",
+			a:    `<ul><li /></ul>`,
+			b:    `<ul><li /><li /></ul>`,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := Parse(strings.NewReader(tt.a))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := Parse(strings.NewReader(tt.b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := NodesEqual(a, b); got != tt.want {
+				t.Errorf("NodesEqual(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// checkLinks reports the first node below n whose parent or sibling pointers are inconsistent.
+func checkLinks(n *Node) *Node {
+	var prev *Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Parent != n || c.PrevSibling != prev {
+			return c
+		}
+		if bad := checkLinks(c); bad != nil {
+			return bad
+		}
+		prev = c
+	}
+	if n.LastChild != prev {
+		return n
+	}
+	return nil
+}
+
+func TestClone(t *testing.T) {
+	tests := []string{
+		`<nav class="a" {...props}><a href="/">Home</a>{items.map(item => <a href={item.href}>{item.name}</a>)}</nav>`,
+		`<header class="b"><Nav client:load><Fragment slot="a"><my-element /></Fragment></Nav></header>`,
+		`<svg viewBox="0 0 1 1"><path d="M0" /></svg>`,
+		`<div id="a"><style>div{color:red}</style><script>console.log(1)</script></div>`,
+	}
+	for _, source := range tests {
+		t.Run(source, func(t *testing.T) {
+			doc, err := Parse(strings.NewReader(source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := doc.LastChild.FirstChild.NextSibling
+			n := body.FirstChild
+			n.HandledScript = true
+
+			clone := n.Clone(true)
+			if !NodesEqual(n, clone) {
+				t.Errorf("\nFAIL: %s\n  expected the clone to equal the original", source)
+			}
+			if clone.Parent != nil || clone.PrevSibling != nil || clone.NextSibling != nil {
+				t.Errorf("\nFAIL: %s\n  expected the clone to be detached", source)
+			}
+			if clone.HandledScript {
+				t.Errorf("\nFAIL: %s\n  expected the clone not to be handled", source)
+			}
+			if bad := checkLinks(clone); bad != nil {
+				t.Errorf("\nFAIL: %s\n  inconsistent links at %s", source, bad.Data)
+			}
+			if n.FirstChild == nil || clone.FirstChild == n.FirstChild || clone.FirstChild.Type != n.FirstChild.Type {
+				t.Errorf("\nFAIL: %s\n  expected the children to be cloned", source)
+			}
+
+			// The clone can be added to the tree next to the original
+			body.AppendChild(clone)
+			if bad := checkLinks(doc); bad != nil {
+				t.Errorf("\nFAIL: %s\n  inconsistent links at %s after appending the clone", source, bad.Data)
+			}
+
+			// Attributes are not shared
+			key, val := n.Attr[0].Key, n.Attr[0].Val
+			clone.Attr[0].Key = "changed"
+			clone.Attr = append(clone.Attr[:0], Attribute{Key: "id", Val: "clone"})
+			if n.Attr[0].Key != key || n.Attr[0].Val != val {
+				t.Errorf("\nFAIL: %s\n  changing the clone changed the original: %v", source, n.Attr[0])
+			}
+
+			shallow := n.Clone(false)
+			if shallow.FirstChild != nil || shallow.LastChild != nil || shallow.Data != n.Data {
+				t.Errorf("\nFAIL: %s\n  expected a shallow clone without children", source)
+			}
+		})
+	}
+}
+
+func TestCloneFields(t *testing.T) {
+	// Fields that Clone leaves empty: the links of the tree and what is only
+	// collected on the document root
+	skipped := map[string]bool{
+		"Parent": true, "FirstChild": true, "LastChild": true, "PrevSibling": true, "NextSibling": true,
+		"Styles": true, "Scripts": true, "ExternalScripts": true, "StyleImports": true,
+		"HydratedComponentNodes": true, "HydratedComponents": true,
+		"ClientOnlyComponentNodes": true, "ClientOnlyComponents": true,
+		"HydrationDirectives": true, "ServerComponents": true, "ComponentUsages": true,
+		"ContainsHead": true, "HeadPropagation": true, "HasHeadContent": true, "HasBodyContent": true,
+		"HasCharsetMeta": true, "HasViewportMeta": true, "RawHTMLNodes": true, "Scope": true,
+		// A clone isn't handled until it is extracted
+		"HandledScript": true,
+	}
+	n := &Node{
+		Fragment:         true,
+		CustomElement:    true,
+		Component:        true,
+		Expression:       true,
+		Transition:       true,
+		TransitionScope:  "astro-abc",
+		HandledScript:    true,
+		ImplicitlyClosed: true,
+		SelfClosing:      true,
+		Type:             ElementNode,
+		DataAtom:         atom.Svg,
+		Data:             "svg",
+		Namespace:        "svg",
+		Attr:             []Attribute{{Key: "id", Val: "a"}},
+		Loc:              []loc.Loc{{Start: 1}},
+	}
+	clone := n.Clone(false)
+	if clone.HandledScript {
+		t.Errorf("\nFAIL: HandledScript\n  expected the clone not to be handled")
+	}
+
+	original, cloned := reflect.ValueOf(n).Elem(), reflect.ValueOf(clone).Elem()
+	for i := 0; i < original.NumField(); i++ {
+		name := original.Type().Field(i).Name
+		if skipped[name] {
+			continue
+		}
+		if original.Field(i).IsZero() {
+			t.Errorf("\nFAIL: %s\n  expected the test to set the field", name)
+			continue
+		}
+		if want, got := original.Field(i).Interface(), cloned.Field(i).Interface(); !reflect.DeepEqual(want, got) {
+			t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", name, want, got)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package astro
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -61,6 +62,8 @@ type parser struct {
 	// (section 12.4).
 	context *Node
 	handler *handler.Handler
+	// buffer, if set, is reused to hold the source while it is tokenized
+	buffer *bytes.Buffer
 }
 
 func (p *parser) top() *Node {
@@ -110,6 +113,7 @@ const (
 // ["html", "body", "font"]
 func (p *parser) popUntil(s scope, matchTags ...a.Atom) bool {
 	if i := p.indexOfElementInScope(s, matchTags...); i != -1 {
+		markImplicitlyClosed(p.oe[i+1:])
 		p.oe = p.oe[:i]
 		return true
 	}
@@ -404,15 +408,21 @@ func isSlot(data string) bool {
 	return data == "slot"
 }
 
+// isComponent reports whether a tag name refers to a component: it starts with an
+// uppercase letter, or it is a member expression (e.g. `ns.thing`), and it has no dash.
+// A dashed name such as `My-Comp` is not a valid identifier, so it stays a custom
+// element. Tags that reference an import are marked as components by the transform.
 func isComponent(data string) bool {
-	if strings.Contains(data, ".") {
-		return true
+	if isFragment(data) || strings.Contains(data, "-") {
+		return false
 	}
-	return !isFragment(data) && data[0] >= 'A' && data[0] <= 'Z'
+	return (data[0] >= 'A' && data[0] <= 'Z') || strings.Contains(data, ".")
 }
 
+// isCustomElement reports whether a tag name refers to a custom element, that is a
+// name with a dash that is not a component.
 func isCustomElement(data string) bool {
-	return strings.Contains(data, "-")
+	return !isComponent(data) && strings.Contains(data, "-")
 }
 
 func (p *parser) isInsideHead() bool {
@@ -437,6 +447,7 @@ func (p *parser) addElement() {
 		Component:     isComponent(p.tok.Data),
 		CustomElement: isCustomElement(p.tok.Data),
 		HandledScript: false,
+		SelfClosing:   p.hasSelfClosingToken,
 		Loc:           p.generateLoc(),
 	})
 }
@@ -1537,10 +1548,7 @@ func inBodyIM(p *parser) bool {
 			return false
 		}
 		for _, e := range p.oe {
-			switch e.DataAtom {
-			case a.Dd, a.Dt, a.Li, a.Optgroup, a.Option, a.P, a.Rb, a.Rp, a.Rt, a.Rtc, a.Tbody, a.Td, a.Tfoot, a.Th,
-				a.Thead, a.Tr, a.Body, a.Html:
-			default:
+			if !hasOptionalEndTag(e) {
 				return true
 			}
 		}
@@ -1551,6 +1559,37 @@ func inBodyIM(p *parser) bool {
 	return true
 }
 
+// hasOptionalEndTag reports whether the end tag of n may be omitted, in which case
+// the element is closed implicitly without being malformed.
+func hasOptionalEndTag(n *Node) bool {
+	switch n.DataAtom {
+	case a.Dd, a.Dt, a.Li, a.Optgroup, a.Option, a.P, a.Rb, a.Rp, a.Rt, a.Rtc, a.Tbody, a.Td, a.Tfoot, a.Th,
+		a.Thead, a.Tr, a.Body, a.Html:
+		return true
+	}
+	return false
+}
+
+// markImplicitlyClosed flags elements that are closed without a matching end tag.
+func markImplicitlyClosed(nodes []*Node) {
+	for _, n := range nodes {
+		if n.Type != ElementNode || n.Expression || hasOptionalEndTag(n) || isImpliedNode(n) {
+			continue
+		}
+		n.ImplicitlyClosed = true
+	}
+}
+
+// isImpliedNode reports whether n was created by the parser rather than the source.
+func isImpliedNode(n *Node) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == ImplicitNodeMarker {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *parser) inTemplateFragmentContext() bool {
 	return len(p.oe) == 1 && p.context != nil && p.context.DataAtom == a.Template
 }
@@ -1725,6 +1764,7 @@ func (p *parser) inBodyEndTagOther(tagAtom a.Atom, tagName string) {
 			p.addLoc()
 			// If we only have a single element, just ignore it
 			if len(p.oe) > 1 {
+				markImplicitlyClosed(p.oe[i+1:])
 				p.oe = p.oe[:i]
 			}
 			break
@@ -3032,7 +3072,16 @@ type ParseOption func(p *parser)
 func ParseOptionWithHandler(h *handler.Handler) ParseOption {
 	return func(p *parser) {
 		p.handler = h
-		p.tokenizer.handler = h
+	}
+}
+
+// ParseOptionWithBuffer reads the source into buf instead of a newly allocated buffer,
+// so that parsing many documents in a row can reuse its capacity. The previous content
+// of buf is discarded, and buf must not be used again until the returned nodes are no
+// longer needed.
+func ParseOptionWithBuffer(buf *bytes.Buffer) ParseOption {
+	return func(p *parser) {
+		p.buffer = buf
 	}
 }
 
@@ -3052,13 +3101,23 @@ func ParseOptionEnableLiteral(enable bool) ParseOption {
 	}
 }
 
+// newTokenizer creates the tokenizer once the options have been applied.
+func (p *parser) newTokenizer(r io.Reader, contextTag string) *Tokenizer {
+	buf := p.buffer
+	if buf == nil {
+		buf = new(bytes.Buffer)
+	}
+	z := newTokenizerWithBuffer(r, contextTag, buf)
+	z.handler = p.handler
+	return z
+}
+
 // ParseWithOptions is like Parse, with options.
 func ParseWithOptions(r io.Reader, opts ...ParseOption) (*Node, error) {
 	p := &parser{
-		tokenizer: NewTokenizer(r),
 		doc: &Node{
 			Type:                DocumentNode,
-			HydrationDirectives: make(map[string]bool),
+			HydrationDirectives: make(map[string]string),
 		},
 		framesetOK:       true,
 		im:               initialIM,
@@ -3069,10 +3128,13 @@ func ParseWithOptions(r io.Reader, opts ...ParseOption) (*Node, error) {
 	for _, f := range opts {
 		f(p)
 	}
+	p.tokenizer = p.newTokenizer(r, "")
 
 	if err := p.parse(); err != nil {
 		return nil, err
 	}
+	// Anything still open at the end of the document was never closed
+	markImplicitlyClosed(p.oe)
 	return p.doc, nil
 }
 
@@ -3103,15 +3165,14 @@ func ParseFragmentWithOptions(r io.Reader, context *Node, opts ...ParseOption) (
 		frontmatterState: FrontmatterInitial,
 		exitLiteralIM:    func() bool { return false },
 	}
-	if context != nil && context.Namespace != "" {
-		p.tokenizer = NewTokenizer(r)
-	} else {
-		p.tokenizer = NewTokenizerFragment(r, contextTag)
-	}
-
 	for _, f := range opts {
 		f(p)
 	}
+	if context != nil && context.Namespace != "" {
+		p.tokenizer = p.newTokenizer(r, "")
+	} else {
+		p.tokenizer = p.newTokenizer(r, contextTag)
+	}
 
 	if p.handler == nil {
 		return nil, errors.New("html: handler must be passed to ParseFragmentWithOptions")
@@ -1,10 +1,13 @@
 package astro
 
 import (
+	"bytes"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/withastro/compiler/internal/handler"
 	"github.com/withastro/compiler/internal/loc"
 	"github.com/withastro/compiler/internal/test_utils"
 )
@@ -126,3 +129,51 @@ func findTargetNode(doc *Node) *Node {
 	})
 	return target
 }
+
+func TestParseOptionWithBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	sources := []string{
+		`<div class="A">A</div>`,
+		`<SECTION id="b"><p>B</p></SECTION>`,
+		`<span>C</span>`,
+	}
+	for _, source := range sources {
+		h := handler.NewHandler(source, "/test.astro")
+		doc, err := ParseWithOptions(strings.NewReader(source), ParseOptionWithHandler(h), ParseOptionWithBuffer(&buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fresh, err := ParseWithOptions(strings.NewReader(source), ParseOptionWithHandler(handler.NewHandler(source, "/test.astro")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !NodesEqual(doc, fresh) {
+			t.Errorf("\nFAIL: %s\n  parsing with a reused buffer produced a different tree", source)
+		}
+	}
+	if buf.Cap() == 0 {
+		t.Error("expected the buffer to hold the last source")
+	}
+}
+
+func BenchmarkParseWithBuffer(b *testing.B) {
+	sources := make([]string, 1000)
+	for i := range sources {
+		sources[i] = fmt.Sprintf("---\nconst { title } = Astro.props;\n---\n<div class=\"card\"><h2>{title}</h2><p>Item %d</p></div>\n<style>.card { color: red; }</style>", i)
+	}
+	b.Run("new buffer", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, source := range sources {
+				ParseWithOptions(strings.NewReader(source), ParseOptionWithHandler(handler.NewHandler(source, "/test.astro")))
+			}
+		}
+	})
+	b.Run("reused buffer", func(b *testing.B) {
+		var buf bytes.Buffer
+		for i := 0; i < b.N; i++ {
+			for _, source := range sources {
+				ParseWithOptions(strings.NewReader(source), ParseOptionWithHandler(handler.NewHandler(source, "/test.astro")), ParseOptionWithBuffer(&buf))
+			}
+		}
+	})
+}
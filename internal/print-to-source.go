@@ -48,7 +48,8 @@ func PrintToSource(buf *strings.Builder, node *Node) {
 				case QuotedAttribute:
 					buf.WriteString(attr.Key)
 					buf.WriteString("=")
-					buf.WriteString(`"` + attr.Val + `"`)
+					quote := string(attr.QuoteChar())
+					buf.WriteString(quote + attr.Val + quote)
 				case EmptyAttribute:
 					buf.WriteString(attr.Key)
 				case ExpressionAttribute:
@@ -0,0 +1,96 @@
+package printer
+
+import (
+	"fmt"
+
+	. "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/transform"
+)
+
+// ApplyJSON updates the document n from ast, a tree printed by PrintToJSON for it and
+// then patched. Only the attributes of elements and the values of text, comment,
+// doctype and frontmatter nodes may change: the nodes of ast must match those of n
+// one for one, otherwise an error is returned. Nodes and attributes that are unchanged
+// keep their locations in the source.
+func ApplyJSON(n *Node, ast ASTNode) error {
+	if typ := astType(n); ast.Type != typ {
+		return fmt.Errorf("expected a %s node, got a %s node", typ, ast.Type)
+	}
+	switch {
+	case n.Type == ElementNode && !n.Expression:
+		if ast.Name != n.Data {
+			return fmt.Errorf("<%s> was renamed to <%s>, only attributes and text can be changed", n.Data, ast.Name)
+		}
+		attrs, err := applyAttributes(n.Attr, ast.Attributes)
+		if err != nil {
+			return fmt.Errorf("<%s>: %w", n.Data, err)
+		}
+		n.Attr = attrs
+	case n.Type == TextNode || n.Type == CommentNode || n.Type == DoctypeNode:
+		n.Data = ast.Value
+	case n.Type == FrontmatterNode:
+		if n.FirstChild != nil {
+			n.FirstChild.Data = ast.Value
+		}
+		return nil
+	}
+	children := astChildren(n, nil)
+	if len(children) != len(ast.Children) {
+		parent := "the " + ast.Type
+		if n.Type == ElementNode && !n.Expression {
+			parent = "<" + n.Data + ">"
+		}
+		return fmt.Errorf("children of %s were added or removed, only attributes and text can be changed", parent)
+	}
+	for i, c := range children {
+		if err := ApplyJSON(c, ast.Children[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// astChildren appends the nodes printed as the children of n by PrintToJSON, which
+// skips implicit elements in favor of their children.
+func astChildren(n *Node, children []*Node) []*Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if transform.IsImplicitNode(c) {
+			children = astChildren(c, children)
+		} else {
+			children = append(children, c)
+		}
+	}
+	return children
+}
+
+// applyAttributes returns the attributes described by nodes, reusing those of attrs that
+// did not change.
+func applyAttributes(attrs []Attribute, nodes []ASTNode) ([]Attribute, error) {
+	result := make([]Attribute, 0, len(nodes))
+	for i, node := range nodes {
+		if i < len(attrs) && attrName(attrs[i]) == node.Name && attrs[i].Type.String() == node.Kind && attrs[i].Val == node.Value {
+			result = append(result, attrs[i])
+			continue
+		}
+		attrType, ok := attributeTypes[node.Kind]
+		if !ok {
+			return nil, fmt.Errorf("unknown kind %q for the attribute %q", node.Kind, node.Name)
+		}
+		attr := Attribute{Key: node.Name, Val: node.Value, Type: attrType}
+		if attrType == QuotedAttribute && len(node.Raw) > 1 && node.Raw[0] == '\'' {
+			attr.Quote = '\''
+		}
+		result = append(result, attr)
+	}
+	return result, nil
+}
+
+// attributeTypes maps the kinds of attribute nodes back to their type.
+var attributeTypes = map[string]AttributeType{
+	QuotedAttribute.String():          QuotedAttribute,
+	EmptyAttribute.String():           EmptyAttribute,
+	ExpressionAttribute.String():      ExpressionAttribute,
+	SpreadAttribute.String():          SpreadAttribute,
+	ShorthandAttribute.String():       ShorthandAttribute,
+	TemplateLiteralAttribute.String(): TemplateLiteralAttribute,
+}
@@ -16,7 +16,7 @@ type PrintCSSResult struct {
 func PrintCSS(sourcetext string, doc *Node, opts transform.TransformOptions) PrintCSSResult {
 	p := &printer{
 		opts:    opts,
-		builder: sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(sourcetext, len(strings.Split(sourcetext, "\n")))),
+		builder: sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(sourcetext, strings.Count(sourcetext, "\n")+1)),
 	}
 
 	result := PrintCSSResult{
@@ -7,9 +7,9 @@ package printer
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"sort"
 	"strings"
-	"unicode"
 
 	. "github.com/withastro/compiler/internal"
 	"github.com/withastro/compiler/internal/handler"
@@ -46,13 +46,43 @@ import (
 // Another example is that the programmatic equivalent of "a<head>b</head>c"
 // becomes "<html><head><head/><body>abc</body></html>".
 func PrintToJS(sourcetext string, n *Node, cssLen int, opts transform.TransformOptions, h *handler.Handler) PrintResult {
+	return PrintTransformResultToJS(sourcetext, transform.NewTransformResult(n, nil), cssLen, opts, h)
+}
+
+// PrintTransformResultToJS prints the document of a TransformResult, reading the
+// component metadata from the result rather than from the tree.
+func PrintTransformResultToJS(sourcetext string, result *transform.TransformResult, cssLen int, opts transform.TransformOptions, h *handler.Handler) PrintResult {
+	var output bytes.Buffer
+	// Writing to a bytes.Buffer never fails
+	p, chunk := printTo(&output, sourcetext, result, cssLen, opts, h)
+	return PrintResult{
+		Output:         output.Bytes(),
+		SourceMapChunk: chunk,
+		Metadata:       p.metadata,
+	}
+}
+
+// PrintTo prints the document of a TransformResult like PrintTransformResultToJS,
+// but writes the output to w in chunks while walking the tree. It returns the
+// sourcemap chunk and the first error returned by w.
+func PrintTo(w io.Writer, sourcetext string, result *transform.TransformResult, cssLen int, opts transform.TransformOptions, h *handler.Handler) (sourcemap.Chunk, error) {
+	p, chunk := printTo(w, sourcetext, result, cssLen, opts, h)
+	return chunk, p.err
+}
+
+func printTo(w io.Writer, sourcetext string, result *transform.TransformResult, cssLen int, opts transform.TransformOptions, h *handler.Handler) (*printer, sourcemap.Chunk) {
 	p := &printer{
 		sourcetext: sourcetext,
 		opts:       opts,
-		builder:    sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(sourcetext, len(strings.Split(sourcetext, "\n")))),
+		builder:    sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(sourcetext, strings.Count(sourcetext, "\n")+1)),
 		handler:    h,
+		result:     result,
+		w:          w,
 	}
-	return printToJs(p, n, cssLen, opts)
+	printToJs(p, result.Doc, cssLen, opts)
+	chunk := p.builder.GenerateChunk(p.output)
+	p.flush()
+	return p, chunk
 }
 
 type RenderOptions struct {
@@ -70,7 +100,7 @@ type ExtractedStatement struct {
 	Loc     loc.Loc
 }
 
-func printToJs(p *printer, n *Node, cssLen int, opts transform.TransformOptions) PrintResult {
+func printToJs(p *printer, n *Node, cssLen int, opts transform.TransformOptions) {
 	printedMaybeHead := false
 	scriptCount := 0
 	render1(p, n, RenderOptions{
@@ -82,11 +112,6 @@ func printToJs(p *printer, n *Node, cssLen int, opts transform.TransformOptions)
 		printedMaybeHead: &printedMaybeHead,
 		scriptCount:      &scriptCount,
 	})
-
-	return PrintResult{
-		Output:         p.output,
-		SourceMapChunk: p.builder.GenerateChunk(p.output),
-	}
 }
 
 const whitespace = " \t\r\n\f"
@@ -117,6 +142,29 @@ func emptyTextNodeWithoutSiblings(n *Node) bool {
 	}
 }
 
+// printTemplatePrelude prints the component metadata and opens the render function
+// of a component without frontmatter, before its first template node.
+func printTemplatePrelude(p *printer, doc *Node, opts transform.TransformOptions, printAstroGlobal bool) {
+	p.printComponentMetadata(doc, opts, []byte{})
+	if printAstroGlobal {
+		p.printTopLevelAstro(opts)
+	}
+
+	p.printFuncPrelude(opts, printAstroGlobal)
+	// This just ensures a newline
+	p.println("")
+
+	// If we haven't printed the funcPrelude but we do have Styles/Scripts, we need to print them!
+	if len(doc.Styles) > 0 {
+		definedVars := transform.GetDefineVars(doc.Styles)
+		if len(definedVars) > 0 {
+			p.printf("const $$definedVars = %s([%s]);\n", DEFINE_STYLE_VARS, strings.Join(definedVars, ","))
+		}
+	}
+
+	p.printReturnOpen()
+}
+
 func render1(p *printer, n *Node, opts RenderOptions) {
 	depth := opts.depth
 
@@ -127,7 +175,7 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 	// Root of the document, print all children
 	if n.Type == DocumentNode {
 		p.printInternalImports(p.opts.InternalURL, &opts)
-		if n.FirstChild != nil && n.FirstChild.Type != FrontmatterNode {
+		if n.FirstChild == nil || n.FirstChild.Type != FrontmatterNode {
 			p.printCSSImports(opts.cssLen)
 		}
 
@@ -143,13 +191,23 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 			})
 		}
 
+		// A document without a template, e.g. an empty file or one that only has
+		// hoisted scripts and styles, still compiles to a component rendering nothing.
+		if !p.hasFuncPrelude {
+			printTemplatePrelude(p, n, opts.opts, strings.Contains(p.sourcetext, "Astro"))
+		}
+
 		p.printReturnClose()
 		p.printFuncSuffix(opts.opts, n)
 		return
 	}
 
 	// Decide whether to print code for `Astro` global variable. Use a loose check for now.
-	printAstroGlobal := strings.Contains(p.sourcetext, "Astro")
+	// Only needed until the function prelude is printed, so avoid scanning the source for every node.
+	printAstroGlobal := false
+	if n.Type == FrontmatterNode || !p.hasFuncPrelude {
+		printAstroGlobal = strings.Contains(p.sourcetext, "Astro")
+	}
 
 	// Render frontmatter (will be the first node, if it exists)
 	if n.Type == FrontmatterNode {
@@ -181,7 +239,7 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 
 				// 1. Component imports, if any exist.
 				p.addNilSourceMapping()
-				p.printComponentMetadata(n.Parent, opts.opts, []byte(p.sourcetext))
+				p.printComponentMetadata(n.Parent, opts.opts, []byte(c.Data))
 
 				// 2. Top-level Astro global.
 				if printAstroGlobal {
@@ -271,25 +329,8 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 		}
 		return
 	} else if !p.hasFuncPrelude {
-		p.printComponentMetadata(n.Parent, opts.opts, []byte{})
-		if printAstroGlobal {
-			p.printTopLevelAstro(opts.opts)
-		}
-
 		// Render func prelude. Will only run for the first non-frontmatter node
-		p.printFuncPrelude(opts.opts, printAstroGlobal)
-		// This just ensures a newline
-		p.println("")
-
-		// If we haven't printed the funcPrelude but we do have Styles/Scripts, we need to print them!
-		if len(n.Parent.Styles) > 0 {
-			definedVars := transform.GetDefineVars(n.Parent.Styles)
-			if len(definedVars) > 0 {
-				p.printf("const $$definedVars = %s([%s]);\n", DEFINE_STYLE_VARS, strings.Join(definedVars, ","))
-			}
-		}
-
-		p.printReturnOpen()
+		printTemplatePrelude(p, n.Parent, opts.opts, printAstroGlobal)
 	}
 	switch n.Type {
 	case TextNode:
@@ -298,7 +339,7 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 			p.print(n.Data)
 			return
 		}
-		text := escapeText(n.Data)
+		text := escapeTemplateLiteral(n.Data)
 		p.printTextWithSourcemap(text, n.Loc[0])
 		return
 	case ElementNode:
@@ -309,7 +350,7 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 		p.print("<!--")
 		start += 4
 		p.addSourceMapping(loc.Loc{Start: start})
-		p.printTextWithSourcemap(escapeText(n.Data), n.Loc[0])
+		p.printTextWithSourcemap(escapeTemplateLiteral(n.Data), n.Loc[0])
 		start += len(n.Data)
 		p.addSourceMapping(loc.Loc{Start: start})
 		p.print("-->")
@@ -434,6 +475,8 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 		p.print("null")
 	case !isSlot && n.CustomElement:
 		p.print(fmt.Sprintf("'%s'", n.Data))
+	case !isSlot && n.Component:
+		p.print(componentReference(n.Data))
 	case !isSlot && !isImplicit:
 		// Print the tag name
 		p.print(n.Data)
@@ -480,6 +523,10 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 			if transform.IsImplicitNodeMarker(a) || a.Key == "is:inline" {
 				continue
 			}
+			// `is:global` only changes how a style is scoped, e.g. one kept in place with `KeepStylesInPlace`
+			if n.DataAtom == atom.Style && a.Key == "is:global" {
+				continue
+			}
 
 			if a.Key == "slot" {
 				if n.Parent.Component || n.Parent.Expression {
@@ -488,36 +535,21 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 				// Note: if we encounter "slot" NOT inside a component, that's fine
 				// These should be preserved in the output
 				p.printAttribute(a, n)
-			} else if a.Key == "data-astro-source-file" {
-				p.printAttribute(a, n)
-				var l []int
-				if n.FirstChild != nil && len(n.FirstChild.Loc) > 0 {
-					start := n.FirstChild.Loc[0].Start
-					if n.FirstChild.Type == TextNode {
-						start += len(n.Data) - len(strings.TrimLeftFunc(n.Data, unicode.IsSpace))
-					}
-					l = p.builder.GetLineAndColumnForLocation(loc.Loc{Start: start})
-				} else if len(n.Loc) > 0 {
-					l = p.builder.GetLineAndColumnForLocation(n.Loc[0])
-				}
-				if len(l) > 0 {
-					p.printAttribute(Attribute{
-						Key:  "data-astro-source-loc",
-						Type: QuotedAttribute,
-						Val:  fmt.Sprintf("%d:%d", l[0], l[1]),
-					}, n)
-				}
-				p.addSourceMapping(n.Loc[0])
 			} else {
 				p.printAttribute(a, n)
 				p.addSourceMapping(n.Loc[0])
 			}
 		}
 		p.addSourceMapping(n.Loc[0])
+		// SVG and MathML elements may be self-closing, so they keep the authored syntax
+		if n.SelfClosing && n.Namespace != "" && n.FirstChild == nil {
+			p.print("/>")
+			return
+		}
 		p.print(">")
 	}
 
-	if voidElements[n.Data] {
+	if IsVoidElement(n.Data) {
 		if n.FirstChild != nil {
 			// return fmt.Errorf("html: void element <%s> has child nodes", n.Data)
 		}
@@ -541,7 +573,7 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 	case "iframe", "noembed", "noframes", "noscript", "plaintext", "script", "style", "xmp":
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
 			if c.Type == TextNode {
-				p.printTextWithSourcemap(escapeText(c.Data), c.Loc[0])
+				p.printTextWithSourcemap(escapeTemplateLiteral(c.Data), c.Loc[0])
 			} else {
 				render1(p, c, RenderOptions{
 					isRoot:           false,
@@ -815,24 +847,3 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 		p.print(`>`)
 	}
 }
-
-// Section 12.1.2, "Elements", gives this list of void elements. Void elements
-// are those that can't have any contents.
-// nolint
-var voidElements = map[string]bool{
-	"area":   true,
-	"base":   true,
-	"br":     true,
-	"col":    true,
-	"embed":  true,
-	"hr":     true,
-	"img":    true,
-	"input":  true,
-	"keygen": true, // "keygen" has been removed from the spec, but are kept here for backwards compatibility.
-	"link":   true,
-	"meta":   true,
-	"param":  true,
-	"source": true,
-	"track":  true,
-	"wbr":    true,
-}
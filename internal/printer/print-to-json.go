@@ -107,7 +107,7 @@ func (n ASTNode) String() string {
 
 func PrintToJSON(sourcetext string, n *Node, opts t.ParseOptions) PrintResult {
 	p := &printer{
-		builder:    sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(sourcetext, len(strings.Split(sourcetext, "\n")))),
+		builder:    sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(sourcetext, strings.Count(sourcetext, "\n")+1)),
 		sourcetext: sourcetext,
 	}
 	root := ASTNode{}
@@ -187,6 +187,55 @@ func attrPositionAt(p *printer, n *Attribute, opts t.ParseOptions) ASTPosition {
 	}
 }
 
+// attrRaw returns the value of a quoted or template literal attribute as written in the
+// source, including its quotes. Values that are not in the source, e.g. those of
+// attributes added by a transform, are quoted as they would be printed.
+func attrRaw(p *printer, attr Attribute) string {
+	if attr.Type != QuotedAttribute && attr.Type != TemplateLiteralAttribute {
+		return ""
+	}
+	start := attr.ValLoc.Start - 1
+	end := attr.ValLoc.Start + len(attr.Val)
+	if start < 0 || end >= len(p.sourcetext) || p.sourcetext[attr.ValLoc.Start:end] != attr.Val {
+		if attr.Type == TemplateLiteralAttribute {
+			return "`" + attr.Val + "`"
+		}
+		quote := string(attr.QuoteChar())
+		return quote + attr.Val + quote
+	}
+	if p.sourcetext[start] == '=' {
+		start += 1
+	} else {
+		end += 1
+	}
+	return strings.TrimSpace(p.sourcetext[start:end])
+}
+
+// astType returns the type of the ASTNode of n.
+func astType(n *Node) string {
+	switch {
+	case n.Type != ElementNode:
+		return n.Type.String()
+	case n.Expression:
+		return "expression"
+	case n.Component:
+		return "component"
+	case n.CustomElement:
+		return "custom-element"
+	case n.Fragment:
+		return "fragment"
+	}
+	return "element"
+}
+
+// attrName returns the name of the ASTNode of attr, including its namespace.
+func attrName(attr Attribute) string {
+	if attr.Namespace != "" {
+		return fmt.Sprintf("%s:%s", attr.Namespace, attr.Key)
+	}
+	return attr.Key
+}
+
 func renderNode(p *printer, parent *ASTNode, n *Node, opts t.ParseOptions) {
 	isImplicit := false
 	for _, a := range n.Attr {
@@ -207,57 +256,23 @@ func renderNode(p *printer, parent *ASTNode, n *Node, opts t.ParseOptions) {
 	var node ASTNode
 
 	node.Position = positionAt(p, n, opts)
+	node.Type = astType(n)
 
-	if n.Type == ElementNode {
-		if n.Expression {
-			node.Type = "expression"
-		} else {
-			node.Name = n.Data
-			if n.Component {
-				node.Type = "component"
-			} else if n.CustomElement {
-				node.Type = "custom-element"
-			} else if n.Fragment {
-				node.Type = "fragment"
-			} else {
-				node.Type = "element"
+	if n.Type == ElementNode && !n.Expression {
+		node.Name = n.Data
+		for _, attr := range n.Attr {
+			attrNode := ASTNode{
+				Type:     "attribute",
+				Kind:     attr.Type.String(),
+				Position: attrPositionAt(p, &attr, opts),
+				Name:     attrName(attr),
+				Value:    attr.Val,
+				Raw:      attrRaw(p, attr),
 			}
-
-			for _, attr := range n.Attr {
-				name := attr.Key
-				if attr.Namespace != "" {
-					name = fmt.Sprintf("%s:%s", attr.Namespace, attr.Key)
-				}
-				position := attrPositionAt(p, &attr, opts)
-				raw := ""
-				if attr.Type == QuotedAttribute || attr.Type == TemplateLiteralAttribute {
-					start := attr.ValLoc.Start - 1
-					end := attr.ValLoc.Start + len(attr.Val)
-
-					char := p.sourcetext[start]
-					if char == '=' {
-						start += 1
-					} else {
-						end += 1
-					}
-					raw = strings.TrimSpace(p.sourcetext[start:end])
-				}
-				attrNode := ASTNode{
-					Type:     "attribute",
-					Kind:     attr.Type.String(),
-					Position: position,
-					Name:     name,
-					Value:    attr.Val,
-					Raw:      raw,
-				}
-				node.Attributes = append(node.Attributes, attrNode)
-			}
-		}
-	} else {
-		node.Type = n.Type.String()
-		if n.Type == TextNode || n.Type == CommentNode || n.Type == DoctypeNode {
-			node.Value = n.Data
+			node.Attributes = append(node.Attributes, attrNode)
 		}
+	} else if n.Type == TextNode || n.Type == CommentNode || n.Type == DoctypeNode {
+		node.Value = n.Data
 	}
 	if n.Type == FrontmatterNode && hasChildren {
 		node.Value = n.FirstChild.Data
@@ -1,6 +1,7 @@
 package printer
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"unicode"
@@ -29,7 +30,7 @@ func PrintToTSX(sourcetext string, n *Node, opts TSXOptions, transformOpts trans
 	p := &printer{
 		sourcetext: sourcetext,
 		opts:       transformOpts,
-		builder:    sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(sourcetext, len(strings.Split(sourcetext, "\n")))),
+		builder:    sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(sourcetext, strings.Count(sourcetext, "\n")+1)),
 	}
 	p.print(getTSXPrefix())
 	renderTsx(p, n, &opts)
@@ -41,8 +42,36 @@ func PrintToTSX(sourcetext string, n *Node, opts TSXOptions, transformOpts trans
 	}
 }
 
+// ConvertToTSX parses the source and prints it as a TSX module for editor
+// tooling, returning the code together with its sourcemap. Style and script
+// contents are included as string children.
+func ConvertToTSX(source string, opts transform.TransformOptions) (code string, sourcemapString string) {
+	h := handler.NewHandler(source, opts.Filename)
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h), astro.ParseOptionEnableLiteral(true))
+	if err != nil {
+		h.AppendError(err)
+	}
+
+	result := PrintToTSX(source, doc, TSXOptions{IncludeScripts: true, IncludeStyles: true}, opts, h)
+	return string(result.Output), SourceMapString(source, result, opts.Filename)
+}
+
+// SourceMapString returns the JSON sourcemap mapping the printed result back to
+// the original source.
+func SourceMapString(source string, result PrintResult, filename string) string {
+	sources, _ := json.Marshal(filename)
+	sourcesContent, _ := json.Marshal(source)
+	return fmt.Sprintf(`{
+  "version": 3,
+  "sources": [%s],
+  "sourcesContent": [%s],
+  "mappings": "%s",
+  "names": []
+}`, string(sources), string(sourcesContent), string(result.SourceMapChunk.Buffer))
+}
+
 func finalizeRanges(content string, ranges TSXRanges) TSXRanges {
-	chunkBuilder := sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(content, len(strings.Split(content, "\n"))))
+	chunkBuilder := sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(content, strings.Count(content, "\n")+1))
 
 	return TSXRanges{
 		Frontmatter: loc.TSXRange{
@@ -53,9 +82,10 @@ func finalizeRanges(content string, ranges TSXRanges) TSXRanges {
 			Start: chunkBuilder.OffsetAt(loc.Loc{Start: ranges.Body.Start}),
 			End:   chunkBuilder.OffsetAt(loc.Loc{Start: ranges.Body.End}),
 		},
-		// Scripts and styles are already using the proper positions
+		// Scripts, styles and props are already converted to UTF-16 offsets with p.builder.OffsetAt
 		Scripts: ranges.Scripts,
 		Styles:  ranges.Styles,
+		Props:   ranges.Props,
 	}
 }
 
@@ -64,6 +94,20 @@ type TSXRanges struct {
 	Body        loc.TSXRange      `js:"body"`
 	Scripts     []TSXExtractedTag `js:"scripts"`
 	Styles      []TSXExtractedTag `js:"styles"`
+	// The declaration of the component's `Props`, nil if there is none
+	Props *TSXProps `js:"props"`
+}
+
+// TSXProps describes where the `Props` of a component are declared, so that
+// editor tooling can provide prop completions.
+type TSXProps struct {
+	// One of "interface", "type" or "import"
+	Kind     string `js:"kind"`
+	Exported bool   `js:"exported"`
+	Generic  bool   `js:"generic"`
+	// The module `Props` is imported from, only set when Kind is "import"
+	Specifier string       `js:"specifier"`
+	Range     loc.TSXRange `js:"range"`
 }
 
 var htmlEvents = map[string]bool{
@@ -334,6 +378,21 @@ func renderTsx(p *printer, n *Node, o *TSXOptions) {
 	if n.Type == DocumentNode {
 		source := []byte(p.sourcetext)
 		props := js_scanner.GetPropsType(source)
+		if n.FirstChild != nil && n.FirstChild.Type == FrontmatterNode && n.FirstChild.FirstChild != nil {
+			frontmatter := n.FirstChild.FirstChild
+			if declaration := js_scanner.ScanPropsDeclaration([]byte(frontmatter.Data)); declaration != nil {
+				p.ranges.Props = &TSXProps{
+					Kind:      declaration.Kind,
+					Exported:  declaration.Exported,
+					Generic:   declaration.Generic,
+					Specifier: declaration.Specifier,
+					Range: loc.TSXRange{
+						Start: p.builder.OffsetAt(loc.Loc{Start: frontmatter.Loc[0].Start + declaration.Start}),
+						End:   p.builder.OffsetAt(loc.Loc{Start: frontmatter.Loc[0].Start + declaration.End}),
+					},
+				}
+			}
+		}
 		hasGetStaticPaths := js_scanner.HasGetStaticPaths(source)
 		hasChildren := false
 		startLen := len(p.output)
@@ -740,7 +799,7 @@ declare const Astro: Readonly<import('astro').AstroGlobal<%s, typeof %s`, propsI
 		p.addSourceMapping(loc.Loc{Start: leadingSpaceLoc + 1})
 	}
 
-	if voidElements[n.Data] && n.FirstChild == nil {
+	if IsVoidElement(n.Data) && n.FirstChild == nil {
 		p.print("/>")
 		return
 	}
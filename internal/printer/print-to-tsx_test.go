@@ -1,8 +1,10 @@
 package printer
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
+	"unicode/utf16"
 
 	astro "github.com/withastro/compiler/internal"
 	handler "github.com/withastro/compiler/internal/handler"
@@ -36,3 +38,111 @@ Faucibus 🎈🐋🔄📇🐡💐 🎾🎩🔹🔣🎍🐸🌳 vestibulum, 🐢
 		}, h)
 	}
 }
+
+func TestConvertToTSX(t *testing.T) {
+	source := `---
+interface Props { name: string }
+const { name } = Astro.props;
+---
+<Component {...attrs} title={name} />
+<style>div { color: red; }</style>`
+
+	code, sourcemap := ConvertToTSX(source, transform.TransformOptions{Filename: "/src/pages/index.astro"})
+
+	for _, want := range []string{
+		"const { name } = Astro.props;",
+		"<Component {...attrs} title={name} />",
+		"{`div { color: red; }`}",
+		"declare const Astro: Readonly<import('astro').AstroGlobal<Props, typeof Index__AstroComponent_>>",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("\nFAIL: expected code to contain %q\n  got: %s", want, code)
+		}
+	}
+	for _, want := range []string{`"sources": ["/src/pages/index.astro"]`, `"mappings": "`} {
+		if !strings.Contains(sourcemap, want) {
+			t.Errorf("\nFAIL: expected sourcemap to contain %q\n  got: %s", want, sourcemap)
+		}
+	}
+}
+
+func TestPrintToTSXProps(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   *TSXProps
+		code   string
+	}{
+		{
+			name:   "exported interface",
+			source: "---\nexport interface Props<T> { items: T[] }\n---\n<div />",
+			want:   &TSXProps{Kind: "interface", Exported: true, Generic: true},
+			code:   "export interface Props<T> { items: T[] }",
+		},
+		{
+			name:   "imported",
+			source: "---\nimport type { Props } from './types';\n---\n<div />",
+			want:   &TSXProps{Kind: "import", Specifier: "./types"},
+			code:   "import type { Props } from './types';",
+		},
+		{
+			name:   "after multibyte characters",
+			source: "---\n// 🎈🎈🎈\ninterface Props { title: string }\n---\n<div />",
+			want:   &TSXProps{Kind: "interface"},
+			code:   "interface Props { title: string }",
+		},
+		{
+			name:   "none",
+			source: "---\nconst { title } = Astro.props;\n---\n<div />",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/src/components/Test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h), astro.ParseOptionEnableLiteral(true))
+			if err != nil {
+				t.Error(err)
+			}
+			result := PrintToTSX(tt.source, doc, TSXOptions{}, transform.TransformOptions{Filename: "/src/components/Test.astro"}, h)
+			got := result.TSXRanges.Props
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("\nFAIL: %s\n  want: nil\n  got:  %+v", tt.name, got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("\nFAIL: %s\n  want: %+v\n  got:  nil", tt.name, tt.want)
+			}
+			tt.want.Range = got.Range
+			if *got != *tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %+v\n  got:  %+v", tt.name, tt.want, got)
+			}
+			// Ranges are UTF-16 offsets, like the positions of JS strings
+			source := utf16.Encode([]rune(tt.source))
+			if code := string(utf16.Decode(source[got.Range.Start:got.Range.End])); code != tt.code {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.code, code)
+			}
+		})
+	}
+}
+
+func TestSourceMapStringFilename(t *testing.T) {
+	source := "<div />"
+	filename := `C:\Users\me\"quoted".astro`
+	h := handler.NewHandler(source, filename)
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := PrintToTSX(source, doc, TSXOptions{}, transform.TransformOptions{Filename: filename}, h)
+	var sourcemap struct {
+		Sources []string `json:"sources"`
+	}
+	if err := json.Unmarshal([]byte(SourceMapString(source, result, filename)), &sourcemap); err != nil {
+		t.Fatal(err)
+	}
+	if len(sourcemap.Sources) != 1 || sourcemap.Sources[0] != filename {
+		t.Errorf("\nFAIL: sources\n  want: %s\n  got:  %v", filename, sourcemap.Sources)
+	}
+}
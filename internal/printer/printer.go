@@ -2,7 +2,8 @@ package printer
 
 import (
 	"fmt"
-	"regexp"
+	"io"
+	"slices"
 	"strings"
 	"unicode/utf8"
 
@@ -21,6 +22,38 @@ type PrintResult struct {
 	SourceMapChunk sourcemap.Chunk
 	// Optional, used only for TSX output
 	TSXRanges TSXRanges
+	// The arguments of the printed `$$createMetadata` call, only set for JS output when
+	// `ResolvePath` is not set
+	Metadata *Metadata
+}
+
+// Metadata describes the `$$metadata` export of a component, as printed in the call to
+// `$$createMetadata`, so that it can be read without parsing the output.
+type Metadata struct {
+	Modules []MetadataModule `json:"modules"`
+	// The names of hydrated components and custom elements
+	HydratedComponents []string `json:"hydratedComponents"`
+	// The specifiers of the imports of `client:only` components, without duplicates
+	ClientOnlyComponents []string         `json:"clientOnlyComponents"`
+	Scripts              []MetadataScript `json:"scripts"`
+	HydrationDirectives  []string         `json:"hydrationDirectives"`
+}
+
+// MetadataModule is an import of the frontmatter, other than type and style imports.
+type MetadataModule struct {
+	Specifier string `json:"specifier"`
+	// The import assertions, without whitespace (e.g. `{type:'json'}`), or empty
+	Assert string `json:"assert"`
+}
+
+// MetadataScript is a hoisted script. Value is set for "inline" and "define:vars" scripts,
+// Src for "external" ones and Keys, the comma-separated names of the variables, for
+// "define:vars" ones.
+type MetadataScript struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+	Src   string `json:"src,omitempty"`
+	Keys  string `json:"keys,omitempty"`
 }
 
 type printer struct {
@@ -33,39 +66,64 @@ type printer struct {
 	hasInternalImports bool
 	hasCSSImports      bool
 	needsTransitionCSS bool
+	result             *transform.TransformResult
+
+	// When set, output is written to w as soon as the sourcemap builder has
+	// scanned it instead of being kept in memory
+	w   io.Writer
+	err error
 
 	// Optional, used only for TSX output
 	ranges TSXRanges
-}
 
-var TEMPLATE_TAG = "$$render"
-var CREATE_ASTRO = "$$createAstro"
-var CREATE_COMPONENT = "$$createComponent"
-var RENDER_COMPONENT = "$$renderComponent"
-var RENDER_HEAD = "$$renderHead"
-var MAYBE_RENDER_HEAD = "$$maybeRenderHead"
-var UNESCAPE_HTML = "$$unescapeHTML"
-var RENDER_SLOT = "$$renderSlot"
-var MERGE_SLOTS = "$$mergeSlots"
-var ADD_ATTRIBUTE = "$$addAttribute"
-var RENDER_TRANSITION = "$$renderTransition"
-var CREATE_TRANSITION_SCOPE = "$$createTransitionScope"
-var SPREAD_ATTRIBUTES = "$$spreadAttributes"
-var DEFINE_STYLE_VARS = "$$defineStyleVars"
-var DEFINE_SCRIPT_VARS = "$$defineScriptVars"
-var CREATE_METADATA = "$$createMetadata"
-var RENDER_SCRIPT = "$$renderScript"
-var METADATA = "$$metadata"
-var RESULT = "$$result"
-var SLOTS = "$$slots"
-var FRAGMENT = "Fragment"
-var BACKTICK = "`"
-var styleModuleSpecExp = regexp.MustCompile(`(\.css|\.pcss|\.postcss|\.sass|\.scss|\.styl|\.stylus|\.less)$`)
+	// Set once the component metadata has been printed
+	metadata *Metadata
+}
+
+// flushSize is how much scanned output is buffered before it is written to w.
+const flushSize = 32 * 1024
+
+// Names of the runtime helpers used by the generated code. These are constants
+// so that concurrent compiles never share mutable printer state.
+const (
+	TEMPLATE_TAG            = "$$render"
+	CREATE_ASTRO            = "$$createAstro"
+	CREATE_COMPONENT        = "$$createComponent"
+	RENDER_COMPONENT        = "$$renderComponent"
+	RENDER_HEAD             = "$$renderHead"
+	MAYBE_RENDER_HEAD       = "$$maybeRenderHead"
+	UNESCAPE_HTML           = "$$unescapeHTML"
+	RENDER_SLOT             = "$$renderSlot"
+	MERGE_SLOTS             = "$$mergeSlots"
+	ADD_ATTRIBUTE           = "$$addAttribute"
+	RENDER_TRANSITION       = "$$renderTransition"
+	CREATE_TRANSITION_SCOPE = "$$createTransitionScope"
+	SPREAD_ATTRIBUTES       = "$$spreadAttributes"
+	DEFINE_STYLE_VARS       = "$$defineStyleVars"
+	DEFINE_SCRIPT_VARS      = "$$defineScriptVars"
+	CREATE_METADATA         = "$$createMetadata"
+	RENDER_SCRIPT           = "$$renderScript"
+	METADATA                = "$$metadata"
+	RESULT                  = "$$result"
+	SLOTS                   = "$$slots"
+	FRAGMENT                = "Fragment"
+	BACKTICK                = "`"
+)
 
 func (p *printer) print(text string) {
 	p.output = append(p.output, []byte(text)...)
 }
 
+// printRune prints the rune c found at text[pos:pos+size], slicing text instead of
+// encoding the rune again. Invalid UTF-8 is still printed as U+FFFD.
+func (p *printer) printRune(text string, pos int, c rune, size int) {
+	if c == utf8.RuneError {
+		p.print(string(c))
+		return
+	}
+	p.output = append(p.output, text[pos:pos+size]...)
+}
+
 func (p *printer) printf(format string, a ...interface{}) {
 	p.print(fmt.Sprintf(format, a...))
 }
@@ -106,6 +164,9 @@ func (p *printer) addTSXStyle(start int, end int, content string, styleType stri
 }
 
 func (p *printer) printTextWithSourcemap(text string, l loc.Loc) {
+	if p.w == nil {
+		p.output = slices.Grow(p.output, len(text))
+	}
 	start := l.Start
 	skipNext := false
 	for pos, c := range text {
@@ -125,7 +186,7 @@ func (p *printer) printTextWithSourcemap(text string, l loc.Loc) {
 
 		_, nextCharByteSize := utf8.DecodeRuneInString(text[pos:])
 		p.addSourceMapping(loc.Loc{Start: start})
-		p.print(string(c))
+		p.printRune(text, pos, c, nextCharByteSize)
 		start += nextCharByteSize
 	}
 }
@@ -160,7 +221,7 @@ func (p *printer) printEscapedJSXTextWithSourcemap(text string, l loc.Loc) {
 
 		_, nextCharByteSize := utf8.DecodeRuneInString(text[pos:])
 		p.addSourceMapping(loc.Loc{Start: start})
-		p.print(string(c))
+		p.printRune(text, pos, c, nextCharByteSize)
 		start += nextCharByteSize
 	}
 }
@@ -359,8 +420,7 @@ func (p *printer) printFuncSuffix(opts transform.TransformOptions, n *astro.Node
 	filenameArg := "undefined"
 	propagationArg := "undefined"
 	if len(opts.Filename) > 0 {
-		escapedFilename := strings.ReplaceAll(opts.Filename, "'", "\\'")
-		filenameArg = fmt.Sprintf("'%s'", escapedFilename)
+		filenameArg = fmt.Sprintf("'%s'", escapeSingleQuote(opts.Filename))
 	}
 	if n.Transition {
 		propagationArg = "'self'"
@@ -379,6 +439,44 @@ var skippedAttributes = map[string]bool{
 	"transition:persist": true,
 }
 
+// attributeSpacing returns the whitespace to print before attr. Unless `Format` is
+// "normalized" or whitespace is collapsed, attributes written by the author keep the
+// whitespace that precedes them in the source. Attributes added by transforms are
+// preceded by a single space.
+func (p *printer) attributeSpacing(attr astro.Attribute) string {
+	if p.opts.Format == "normalized" || p.opts.Compact || p.opts.Minify {
+		return " "
+	}
+	end := attr.KeyLoc.Start
+	if end <= 0 || end > len(p.sourcetext) || !strings.HasPrefix(p.sourcetext[end:], attr.Key) {
+		return " "
+	}
+	start := end
+	for start > 0 && isHTMLSpace(p.sourcetext[start-1]) {
+		start--
+	}
+	if start == end {
+		return " "
+	}
+	return p.sourcetext[start:end]
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// isBooleanExpressionAttribute reports whether attr is an expression on a boolean attribute
+// of an HTML element, which should only be rendered when the expression is truthy.
+func isBooleanExpressionAttribute(attr astro.Attribute, n *astro.Node) bool {
+	if attr.Type != astro.ExpressionAttribute || attr.Namespace != "" || strings.TrimSpace(attr.Val) == "" {
+		return false
+	}
+	if n == nil || n.Component || n.CustomElement || n.Fragment || n.Namespace != "" {
+		return false
+	}
+	return transform.IsBooleanAttribute(attr.Key)
+}
+
 var skippedAttributesToObject = map[string]bool{
 	"set:text":           true,
 	"set:html":           true,
@@ -454,27 +552,40 @@ func (p *printer) printAttribute(attr astro.Attribute, n *astro.Node) {
 		return
 	}
 
-	if attr.Namespace != "" || attr.Type == astro.QuotedAttribute || attr.Type == astro.EmptyAttribute {
-		p.print(" ")
-	}
-
 	if attr.Namespace != "" {
 		attr.Key = fmt.Sprintf("%s:%s", attr.Namespace, attr.Key)
 	}
 
+	if attr.Namespace != "" || attr.Type == astro.QuotedAttribute || attr.Type == astro.EmptyAttribute {
+		p.print(p.attributeSpacing(attr))
+	}
+
 	switch attr.Type {
 	case astro.QuotedAttribute:
 		p.addSourceMapping(attr.KeyLoc)
 		p.print(attr.Key)
 		p.addNilSourceMapping()
-		p.print(`="`)
-		p.printTextWithSourcemap(encodeDoubleQuote(escapeInterpolation(escapeBackticks(attr.Val))), attr.ValLoc)
+		quote := string(attr.QuoteChar())
+		p.print(`=` + quote)
+		p.printTextWithSourcemap(encodeQuote(escapeTemplateLiteral(attr.Val), attr.QuoteChar()), attr.ValLoc)
 		p.addNilSourceMapping()
-		p.print(`"`)
+		p.print(quote)
 	case astro.EmptyAttribute:
 		p.addSourceMapping(attr.KeyLoc)
 		p.print(attr.Key)
 	case astro.ExpressionAttribute:
+		if isBooleanExpressionAttribute(attr, n) {
+			p.addNilSourceMapping()
+			p.print("${(")
+			p.printTextWithSourcemap(attr.Val, attr.ValLoc)
+			p.addNilSourceMapping()
+			p.print(`) ? " `)
+			p.addSourceMapping(attr.KeyLoc)
+			p.print(attr.Key)
+			p.addNilSourceMapping()
+			p.print(`" : ""}`)
+			return
+		}
 		p.addNilSourceMapping()
 		p.print(fmt.Sprintf("${%s(", ADD_ATTRIBUTE))
 		if strings.TrimSpace(attr.Val) == "" {
@@ -491,8 +602,9 @@ func (p *printer) printAttribute(attr astro.Attribute, n *astro.Node) {
 		p.print(`")}`)
 	case astro.SpreadAttribute:
 		injectClass := false
+		opts := p.opts
 		for p := n.Parent; p != nil; p = p.Parent {
-			if p.Parent == nil && len(p.Styles) != 0 {
+			if p.Parent == nil && len(transform.ComponentStyles(p, &opts)) != 0 {
 				injectClass = true
 				break
 			}
@@ -511,7 +623,8 @@ func (p *printer) printAttribute(attr astro.Attribute, n *astro.Node) {
 		if !injectClass {
 			p.print(`)}`)
 		} else {
-			p.printf(`,undefined,{"class":"astro-%s"})}`, p.opts.Scope)
+			scope, _ := transform.SanitizeScope(p.opts.Scope)
+			p.printf(`,undefined,{"class":"astro-%s"})}`, scope)
 		}
 	case astro.ShorthandAttribute:
 		withoutComments := helpers.RemoveComments(attr.Key)
@@ -538,6 +651,9 @@ func (p *printer) addSourceMapping(location loc.Loc) {
 	} else {
 		p.builder.AddSourceMapping(location, p.output)
 	}
+	if len(p.output) >= flushSize {
+		p.flush()
+	}
 }
 
 // Reset sourcemap by pointing to last possible index
@@ -545,6 +661,19 @@ func (p *printer) addNilSourceMapping() {
 	p.builder.AddSourceMapping(loc.Loc{Start: -1}, p.output)
 }
 
+// flush writes the output the sourcemap builder has already scanned to w. The
+// first write error is kept and later output is dropped.
+func (p *printer) flush() {
+	if p.w == nil {
+		return
+	}
+	scanned, rest := p.builder.Flush(p.output)
+	if p.err == nil && len(scanned) > 0 {
+		_, p.err = p.w.Write(scanned)
+	}
+	p.output = append(p.output[:0], rest...)
+}
+
 func (p *printer) printTopLevelAstro(opts transform.TransformOptions) {
 	p.println(fmt.Sprintf("const $$Astro = %s(%s);\nconst Astro = $$Astro;", CREATE_ASTRO, opts.AstroGlobalArgs))
 }
@@ -599,8 +728,13 @@ func maybeConvertTransition(n *astro.Node) {
 }
 
 func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.TransformOptions, source []byte) {
-	var specs []string
-	var asrts []string
+	metadata := &Metadata{
+		Modules:              make([]MetadataModule, 0),
+		HydratedComponents:   make([]string, 0, len(doc.HydratedComponentNodes)),
+		ClientOnlyComponents: make([]string, 0),
+		Scripts:              make([]MetadataScript, 0, len(p.result.Scripts)),
+		HydrationDirectives:  p.result.HydrationDirectives,
+	}
 	var conlyspecs []string
 	unfoundconly := make([]*astro.Node, len(doc.ClientOnlyComponentNodes))
 	copy(unfoundconly, doc.ClientOnlyComponentNodes)
@@ -651,14 +785,13 @@ func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.Transfo
 			}
 
 			isCSSImport := false
-			if len(statement.Imports) == 0 && styleModuleSpecExp.MatchString(statement.Specifier) {
+			if len(statement.Imports) == 0 && transform.IsStyleModuleSpecifier(statement.Specifier) {
 				isCSSImport = true
 			}
 
 			if !isCSSImport && !statement.IsType {
 				p.print(fmt.Sprintf("\nimport * as $$module%v from '%s'%s;", modCount, statement.Specifier, assertions))
-				specs = append(specs, statement.Specifier)
-				asrts = append(asrts, statement.Assertions)
+				metadata.Modules = append(metadata.Modules, MetadataModule{Specifier: statement.Specifier, Assert: statement.Assertions})
 				modCount++
 			}
 		}
@@ -684,27 +817,54 @@ func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.Transfo
 		return
 	}
 
+	for _, node := range doc.HydratedComponentNodes {
+		metadata.HydratedComponents = append(metadata.HydratedComponents, node.Data)
+	}
+	for _, spec := range conlyspecs {
+		if !slices.Contains(metadata.ClientOnlyComponents, spec) {
+			metadata.ClientOnlyComponents = append(metadata.ClientOnlyComponents, spec)
+		}
+	}
+	for _, script := range p.result.Scripts {
+		switch script.Type {
+		case "define:vars":
+			keys := js_scanner.GetObjectKeys([]byte(script.DefineVars))
+			params := make([]byte, 0)
+			for i, key := range keys {
+				params = append(params, key...)
+				if i < len(keys)-1 {
+					params = append(params, ',')
+				}
+			}
+			metadata.Scripts = append(metadata.Scripts, MetadataScript{Type: script.Type, Value: script.Code, Keys: string(params)})
+		case "external":
+			metadata.Scripts = append(metadata.Scripts, MetadataScript{Type: script.Type, Src: script.Src})
+		case "inline":
+			metadata.Scripts = append(metadata.Scripts, MetadataScript{Type: script.Type, Value: script.Code})
+		}
+	}
+	p.metadata = metadata
+
 	// Call createMetadata
 	patharg := opts.Filename
 	if patharg == "" {
 		patharg = "import.meta.url"
 	} else {
-		escapedPatharg := strings.ReplaceAll(patharg, "'", "\\'")
-		patharg = fmt.Sprintf("\"%s\"", escapedPatharg)
+		patharg = fmt.Sprintf("\"%s\"", escapeDoubleQuote(patharg))
 	}
 	p.print(fmt.Sprintf("\nexport const $$metadata = %s(%s, { ", CREATE_METADATA, patharg))
 
 	// Add modules
 	p.print("modules: [")
-	for i := 1; i < modCount; i++ {
-		if i > 1 {
+	for i, module := range metadata.Modules {
+		if i > 0 {
 			p.print(", ")
 		}
 		asrt := "{}"
-		if asrts[i-1] != "" {
-			asrt = asrts[i-1]
+		if module.Assert != "" {
+			asrt = module.Assert
 		}
-		p.print(fmt.Sprintf("{ module: $$module%v, specifier: '%s', assert: %s }", i, specs[i-1], asrt))
+		p.print(fmt.Sprintf("{ module: $$module%v, specifier: '%s', assert: %s }", i+1, module.Specifier, asrt))
 	}
 	p.print("]")
 
@@ -718,61 +878,38 @@ func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.Transfo
 		if node.CustomElement {
 			p.print(fmt.Sprintf("'%s'", node.Data))
 		} else {
-			p.print(node.Data)
+			p.print(componentReference(node.Data))
 		}
 	}
 	// Client-Only Components
 	p.print("], clientOnlyComponents: [")
-	uniquespecs := make([]string, 0)
-	i := 0
-conly_loop:
-	for _, spec := range conlyspecs {
-		for _, uniq := range uniquespecs {
-			if uniq == spec {
-				continue conly_loop
-			}
-		}
+	for i, spec := range metadata.ClientOnlyComponents {
 		if i > 0 {
 			p.print(", ")
 		}
 		p.print(fmt.Sprintf("'%s'", spec))
-		i++
-		uniquespecs = append(uniquespecs, spec)
 	}
 	p.print("], hydrationDirectives: new Set([")
-	j := 0
-	for directive := range doc.HydrationDirectives {
+	for j, directive := range metadata.HydrationDirectives {
 		if j > 0 {
 			p.print(", ")
 		}
 		p.print(fmt.Sprintf("'%s'", directive))
-		j++
 	}
 	// Hoisted scripts
 	p.print("]), hoisted: [")
-	for i, node := range doc.Scripts {
+	for i, script := range metadata.Scripts {
 		if i > 0 {
 			p.print(", ")
 		}
 
-		defineVars := astro.GetAttribute(node, "define:vars")
-		src := astro.GetAttribute(node, "src")
-
-		switch {
-		case defineVars != nil:
-			keys := js_scanner.GetObjectKeys([]byte(defineVars.Val))
-			params := make([]byte, 0)
-			for i, key := range keys {
-				params = append(params, key...)
-				if i < len(keys)-1 {
-					params = append(params, ',')
-				}
-			}
-			p.print(fmt.Sprintf("{ type: 'define:vars', value: `%s`, keys: '%s' }", escapeInterpolation(escapeBackticks(node.FirstChild.Data)), escapeSingleQuote(string(params))))
-		case src != nil:
-			p.print(fmt.Sprintf("{ type: 'external', src: '%s' }", escapeSingleQuote(src.Val)))
-		case node.FirstChild != nil:
-			p.print(fmt.Sprintf("{ type: 'inline', value: `%s` }", escapeInterpolation(escapeBackticks(node.FirstChild.Data))))
+		switch script.Type {
+		case "define:vars":
+			p.print(fmt.Sprintf("{ type: 'define:vars', value: `%s`, keys: '%s' }", escapeTemplateLiteral(script.Value), escapeSingleQuote(script.Keys)))
+		case "external":
+			p.print(fmt.Sprintf("{ type: 'external', src: '%s' }", escapeSingleQuote(script.Src)))
+		case "inline":
+			p.print(fmt.Sprintf("{ type: 'inline', value: `%s` }", escapeTemplateLiteral(script.Value)))
 		}
 	}
 
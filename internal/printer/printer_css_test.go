@@ -88,8 +88,9 @@ func TestPrinterCSS(t *testing.T) {
 
 			hash := astro.HashString(code)
 			opts := transform.TransformOptions{Scope: hash, ScopedStyleStrategy: scopedStyleStrategy, ExperimentalScriptOrder: true}
-			transform.ExtractStyles(doc, &opts)
-			transform.Transform(doc, opts, handler.NewHandler(code, "/test.astro")) // note: we want to test Transform in context here, but more advanced cases could be tested separately
+			h := handler.NewHandler(code, "/test.astro")
+			transform.ExtractStyles(doc, &opts, h)
+			transform.Transform(doc, opts, h) // note: we want to test Transform in context here, but more advanced cases could be tested separately
 			result := PrintCSS(code, doc, transform.TransformOptions{
 				Scope:       "astro-XXXX",
 				InternalURL: "http://localhost:3000/",
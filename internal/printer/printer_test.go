@@ -1,12 +1,22 @@
 package printer
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"sync"
 	"testing"
+	"unicode"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/js"
 
 	astro "github.com/withastro/compiler/internal"
 	"github.com/withastro/compiler/internal/handler"
+	"github.com/withastro/compiler/internal/sourcemap"
 	types "github.com/withastro/compiler/internal/t"
 	"github.com/withastro/compiler/internal/test_utils"
 	"github.com/withastro/compiler/internal/transform"
@@ -98,6 +108,26 @@ func TestPrinter(t *testing.T) {
 			name:   "text only",
 			source: `Foo`,
 		},
+		{
+			name:   "empty file",
+			source: ``,
+		},
+		{
+			name:   "whitespace only",
+			source: "\n  \n\t\n",
+		},
+		{
+			name:   "frontmatter only",
+			source: "---\nconst name = 'world';\nconsole.log(name);\n---\n",
+		},
+		{
+			name:   "frontmatter followed by whitespace",
+			source: "---\nconsole.log('hi');\n---\n\n  \n",
+		},
+		{
+			name:   "hoisted elements only",
+			source: `<style>h1 { color: red; }</style><script>console.log('hi')</script>`,
+		},
 		{
 			name:   "unusual line terminator I",
 			source: `Pre-set & Time-limited \u2028holiday campaigns`,
@@ -218,6 +248,22 @@ func TestPrinter(t *testing.T) {
 			name:   "attribute with template literal interpolation",
 			source: "<a :href=\"`/${url}`\">Home</a>",
 		},
+		{
+			name:   "boolean attribute expression",
+			source: `<button disabled={isDisabled}>Save</button><input type="checkbox" checked={items.length > 0} required={true}>`,
+		},
+		{
+			name:   "boolean attribute quoted",
+			source: `<button disabled="false">Save</button><details open="">Details</details>`,
+		},
+		{
+			name:   "boolean attribute aria",
+			source: `<button aria-disabled={isDisabled} aria-pressed="false" hidden={isHidden}>Save</button>`,
+		},
+		{
+			name:   "boolean attribute component",
+			source: `<Button disabled={isDisabled} /><my-button disabled={isDisabled}></my-button>`,
+		},
 		{
 			name: "basic (frontmatter)",
 			source: `---
@@ -307,6 +353,24 @@ import data from "test";
 ---
 
 <div>{data}</div>
+`,
+		},
+		{
+			name: "imports interleaved with body",
+			source: `---
+import First from "../components/First.astro";
+const data = await fetch("/api").then((res) => res.json());
+import './global.css';
+let count = 0;
+import config from "./config.json" assert { type: 'json' };
+import {
+	a,
+	b,
+} from './utils';
+count += a + b;
+---
+
+<First {data} {config} {count} />
 `,
 		},
 		{
@@ -436,6 +500,13 @@ import * as ns from '../components';
 	</noscript>
   </body>
 </html>`,
+		},
+		{
+			name: "noscript hydrated component",
+			source: `---
+import Counter from '../components/Counter.jsx';
+---
+<noscript><Counter client:load count={1} /></noscript>`,
 		},
 		{
 			name:   "noscript styles",
@@ -535,6 +606,35 @@ import Component from '../components';
   </body>
 </html>`,
 		},
+		{
+			name: "client:visible with options",
+			source: `---
+import Counter from '../components/Counter.jsx';
+---
+<Counter client:visible={{ rootMargin: '200px' }} />`,
+		},
+		{
+			name: "client:idle with options",
+			source: `---
+import Counter from '../components/Counter.jsx';
+---
+<Counter client:idle={{ timeout: 500 }} />`,
+		},
+		{
+			name: "client:media with query",
+			source: `---
+import Counter from '../components/Counter.jsx';
+---
+<Counter client:media="(max-width: 50em)" />`,
+		},
+		{
+			name:   "attribute quotes",
+			source: `<style>div { color: red }</style><div title='say "hi"' data-a="it's" data-b=plain />`,
+		},
+		{
+			name:   "duplicate attributes",
+			source: `<div class="a" class="b" data-id="1" data-ID={2}><Card title="a" title={b} /></div>`,
+		},
 		{
 			name:   "iframe",
 			source: `<iframe src="something" />`,
@@ -870,6 +970,25 @@ import Widget2 from '../components/Widget2.astro';
 			name:   "script before elements",
 			source: `<script>Here</script><div></div>`,
 		},
+		{
+			name:   "attribute spacing (preserve)",
+			source: "<div   class=\"x\"   id=\"y\"\n  hidden>text</div>",
+		},
+		{
+			name:   "attribute spacing (normalized)",
+			source: "<div   class=\"x\"   id=\"y\"\n  hidden>text</div>",
+			transformOptions: transform.TransformOptions{
+				Format: "normalized",
+			},
+		},
+		{
+			name:   "annotate source file",
+			source: "<main>\n  <h1 class=\"title\">Hello</h1>\n  <Component />\n</main>",
+			transformOptions: transform.TransformOptions{
+				AnnotateSourceFile: true,
+			},
+			filename: "/src/pages/index.astro",
+		},
 		{
 			name:   "script (renderScript: true)",
 			source: `<main><script>console.log("Hello");</script>`,
@@ -922,6 +1041,16 @@ import Widget2 from '../components/Widget2.astro';
 			source:   `<main>{false && <script>console.log("hello")</script>}`,
 			filename: "/src/pages/index.astro",
 		},
+		{
+			name:     "script hoist in expression (renderScript: false)",
+			source:   `<main>{items.map(() => <script hoist>console.log("hello")</script>)}</main>`,
+			filename: "/src/pages/index.astro",
+		},
+		{
+			name:     "style in expression",
+			source:   `<main>{show && <style>main { color: red; }</style>}{show ? <p>Hi</p> : <style is:inline>p { color: blue; }</style>}</main>`,
+			filename: "/src/pages/index.astro",
+		},
 		{
 			name:   "script inline (renderScript: true)",
 			source: `<main><script is:inline type="module">console.log("Hello");</script>`,
@@ -937,6 +1066,30 @@ import Widget2 from '../components/Widget2.astro';
 			},
 			filename: "/src/pages/index.astro",
 		},
+		{
+			name: "component alias",
+			source: `---
+import AstroImage from '../components/AstroImage.jsx';
+---
+<Img client:load src="a.png"><Img /></Img><img src="b.png" />`,
+			transformOptions: transform.TransformOptions{
+				ComponentAliases: map[string]string{"Img": "AstroImage", "img": "picture"},
+			},
+			filename: "/src/pages/index.astro",
+		},
+		{
+			name:     "mixed styles",
+			source:   `<html><head><style>h1 { color: red; }</style><style is:global>body { margin: 0; }</style></head><body><h1>Hello</h1><div {...props} /><style is:inline>p { color: blue; }</style>{show && <style>div { color: green; }</style>}</body></html>`,
+			filename: "/src/pages/index.astro",
+		},
+		{
+			name:   "mixed styles (keepStylesInPlace)",
+			source: `<html><head><style>h1 { color: red; }</style><style is:global>body { margin: 0; }</style></head><body><h1>Hello</h1><div {...props} /><style is:inline>p { color: blue; }</style>{show && <style>div { color: green; }</style>}</body></html>`,
+			transformOptions: transform.TransformOptions{
+				KeepStylesInPlace: true,
+			},
+			filename: "/src/pages/index.astro",
+		},
 		{
 			name:   "text after title expression",
 			source: `<title>a {expr} b</title>`,
@@ -978,6 +1131,35 @@ import 'test';
 ---
 <my-element></my-element>`,
 		},
+		{
+			name: "custom element with a dashed component file",
+			source: `---
+import MyComp from './my-comp.astro';
+import 'my-element';
+---
+<my-element client:load />
+<MyComp />`,
+		},
+		{
+			name: "hydrated custom element",
+			source: `---
+import '../components/my-counter.js';
+---
+<my-counter client:visible />
+<my-toggle client:idle client:component-path="../components/toggle.js" />`,
+		},
+		{
+			name: "imported namespace member with a dash",
+			source: `---
+import * as ns from './components';
+---
+<ns.thing-x client:load />
+<ns.thing-x />`,
+		},
+		{
+			name:   "dotted custom element",
+			source: `<ns.thing-x></ns.thing-x>`,
+		},
 		{
 			name: "gets all potential hydrated components",
 			source: `---
@@ -1331,6 +1513,17 @@ const value = 'test';
 const value = 'test';
 ---
 <textarea>{value}</textarea>`,
+		},
+		{
+			name:   "textarea with whitespace around an expression",
+			source: "<textarea>\n  {value}  text\n</textarea>",
+		},
+		{
+			name: "title with text around an expression",
+			source: `---
+const post = { title: 'Hello' };
+---
+<html><head><title>{post.title} | My Site</title></head><body><script is:inline>const o = {a: 1};</script><style is:inline>a{color:red}</style></body></html>`,
 		},
 		{
 			name:   "textarea inside expression",
@@ -1513,6 +1706,30 @@ const items = ["Dog", "Cat", "Platipus"];
 			name:   "caption only",
 			source: `<caption>Hello world!</caption>`,
 		},
+		{
+			name:   "void and self-closing elements",
+			source: `<area><base/><br><br/><col><embed/><hr><img src="a.png"/><input><keygen/><link rel="icon"><meta charset="utf-8"/><param><source/><track><wbr/><div/><p>after</p><span/>text<svg><circle r="1"/><g></g></svg><math><mi/></math>`,
+		},
+		{
+			name:   "sibling tr roots",
+			source: `<tr><td>a</td></tr>{rows}<tr><td>b</td></tr>`,
+		},
+		{
+			name:   "td roots with text",
+			source: `text<td>a</td>between<td>b</td>after`,
+		},
+		{
+			name:   "li roots with text",
+			source: `<li>a</li>text<li>b</li>`,
+		},
+		{
+			name:   "option roots",
+			source: `<option>a</option><optgroup><option>b</option></optgroup>`,
+		},
+		{
+			name:   "mixed table roots",
+			source: `<caption>c</caption><col><thead><tr><th>a</th></tr></thead><tr><td>b</td></tr><p>after</p>`,
+		},
 		{
 			name:   "anchor expressions",
 			source: `<a>{expr}</a>`,
@@ -2101,10 +2318,14 @@ const meta = { title: 'My App' };
 			// combine from tt.transformOptions
 			transformOptions := transform.TransformOptions{
 				Scope:                   hash,
+				NormalizedFilename:      tt.filename,
 				RenderScript:            tt.transformOptions.RenderScript,
+				AnnotateSourceFile:      tt.transformOptions.AnnotateSourceFile,
+				ComponentAliases:        tt.transformOptions.ComponentAliases,
+				KeepStylesInPlace:       tt.transformOptions.KeepStylesInPlace,
 				ExperimentalScriptOrder: true,
 			}
-			transform.ExtractStyles(doc, &transformOptions)
+			transform.ExtractStyles(doc, &transformOptions, h)
 			transform.Transform(doc, transformOptions, h) // note: we want to test Transform in context here, but more advanced cases could be tested separately
 
 			result := PrintToJS(code, doc, 0, transform.TransformOptions{
@@ -2113,6 +2334,8 @@ const meta = { title: 'My App' };
 				Filename:                tt.filename,
 				AstroGlobalArgs:         "'https://astro.build'",
 				TransitionsAnimationURL: "transitions.css",
+				Format:                  tt.transformOptions.Format,
+				KeepStylesInPlace:       tt.transformOptions.KeepStylesInPlace,
 			}, h)
 			output := string(result.Output)
 
@@ -2129,6 +2352,42 @@ const meta = { title: 'My App' };
 	}
 }
 
+func TestPrintToJSEscaping(t *testing.T) {
+	values := []string{"${value}", "\\", "\\`", "a\\", "\\u{zz}", "</script>", "</SCRIPT >", "line\nbreak", "\u2028"}
+	for c := byte('!'); c <= '~'; c++ {
+		if !unicode.IsPunct(rune(c)) && !unicode.IsSymbol(rune(c)) {
+			continue
+		}
+		values = append(values, fmt.Sprintf("a%cb", c))
+	}
+	for _, value := range values {
+		quote := `"`
+		if strings.Contains(value, `"`) {
+			quote = `'`
+		}
+		attr := quote + value + quote
+		source := fmt.Sprintf(`<div title=%s><Component title=%s><p slot=%s>text</p></Component></div><script define:vars={{ a: 1 }}>console.log(%q)</script>`, attr, attr, attr, value)
+		t.Run(value, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := handler.NewHandler(source, "/src/pages/index.astro")
+			transformOptions := transform.TransformOptions{Scope: "XXXX", Filename: `C:\src\pages\it's.astro`}
+			transform.ExtractStyles(doc, &transformOptions, h)
+			transform.Transform(doc, transformOptions, h)
+			result := PrintToJS(source, doc, 0, transformOptions, h)
+			output := string(result.Output)
+			if _, err := js.Parse(parse.NewInputString(output), js.Options{}); err != nil {
+				t.Errorf("generated module is not valid JavaScript: %v\n%s", err, output)
+			}
+			if value == "</script>" && !strings.Contains(output, `{"title":"<\/script>"}`) {
+				t.Errorf("expected </script> to be escaped in the generated module:\n%s", output)
+			}
+		})
+	}
+}
+
 func TestPrintToJSON(t *testing.T) {
 	tests := []jsonTestcase{
 		{
@@ -2159,6 +2418,10 @@ func TestPrintToJSON(t *testing.T) {
 			name:   "Comment preserves whitespace",
 			source: `<!-- hello -->`,
 		},
+		{
+			name:   "attribute kinds",
+			source: `<div quoted="a" empty expression={b} {...spread} {shorthand} literal=` + BACKTICK + `c` + BACKTICK + ` />`,
+		},
 		{
 			name:   "Fragment Shorthand",
 			source: `<>Hello</>`,
@@ -2265,3 +2528,462 @@ const c = '\''
 		})
 	}
 }
+
+// Large inline payloads (e.g. serialized data for a client-side store) should compile in roughly linear time.
+func BenchmarkPrintToJSLargeRawText(b *testing.B) {
+	var payload strings.Builder
+	payload.WriteString(`{"items":[`)
+	for i := 0; payload.Len() < 500*1024; i++ {
+		if i > 0 {
+			payload.WriteString(",")
+		}
+		fmt.Fprintf(&payload, `{"id":%d,"name":"Item <%d>","tags":["a","b"],"description":"Lorem ipsum dolor sit amet, consectetur adipiscing elit."}`, i, i)
+	}
+	payload.WriteString(`]}`)
+	source := fmt.Sprintf("---\nconst title = 'Store';\n---\n<html><head><title>{title}</title><style>body { margin: 0; }</style></head><body><main><h1>{title}</h1></main><script type=\"application/json\" id=\"data\">%s</script></body></html>", payload.String())
+	b.SetBytes(int64(len(source)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h := handler.NewHandler(source, "/src/pages/index.astro")
+		doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+		if err != nil {
+			b.Fatal(err)
+		}
+		transformOptions := transform.TransformOptions{Scope: "XXXX", Filename: "/src/pages/index.astro"}
+		transform.ExtractStyles(doc, &transformOptions, h)
+		transform.Transform(doc, transformOptions, h)
+		PrintToJS(source, doc, 0, transformOptions, h)
+	}
+}
+
+// Compiles are independent, so they must be safe to run in parallel (see `go test -race`).
+func TestConcurrentCompile(t *testing.T) {
+	fixtures := []string{
+		`---
+import Counter from '../components/Counter.jsx';
+import Only from '../components/Only.svelte';
+const { title } = Astro.props;
+---
+<html><head><title>{title}</title></head><body><Counter client:load /><Only client:only="svelte" /></body></html>`,
+		`<div class="a" transition:name="hero"><slot /></div><style>div { color: red; }</style><script>console.log("%d")</script>`,
+		`---
+export const prerender = true;
+---
+<ul>{[1, 2, 3].map((i) => <li set:html={i} />)}</ul><style define:vars={{ color: "blue" }}>li { color: var(--color); }</style>`,
+		`<mycomponent client:load /><img src="http://example.com/a.png" /><div   id="%d"   hidden={false} />`,
+	}
+	var wg sync.WaitGroup
+	errs := make(chan string, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			source := strings.ReplaceAll(fixtures[i%len(fixtures)], "%d", fmt.Sprint(i))
+			filename := fmt.Sprintf("/src/pages/page-%d.astro", i)
+			h := handler.NewHandler(source, filename)
+			doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				errs <- err.Error()
+				return
+			}
+			transformOptions := transform.TransformOptions{Filename: filename, Site: "https://astro.build", OptimizedScopes: i%2 == 0}
+			transform.ExtractStyles(doc, &transformOptions, h)
+			result := transform.TransformWithResult(doc, transformOptions, h)
+			js := PrintTransformResultToJS(source, result, 0, transformOptions, h)
+			PrintCSS(source, doc, transformOptions)
+			ConvertToTSX(source, transformOptions)
+			if !strings.Contains(string(js.Output), fmt.Sprintf(`"%s"`, filename)) {
+				errs <- fmt.Sprintf("output of %s does not reference its filename", filename)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestWindowsPaths(t *testing.T) {
+	source := `---
+import Counter from '../components/Counter.jsx';
+---
+<div><Counter client:load /></div><style>div { color: red; }</style><script>console.log(1)</script>`
+	compile := func(filename string, root string) (string, string) {
+		opts := transform.TransformOptions{Filename: filename, NormalizedFilename: filename, ProjectRoot: root, SourceMap: "both", AnnotateSourceFile: true}
+		transform.NormalizePaths(&opts)
+		opts.Scope = transform.ScopeHash(opts.Filename, opts.ProjectRoot)
+		h := handler.NewHandler(source, opts.Filename)
+		doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+		if err != nil {
+			t.Fatal(err)
+		}
+		transform.ExtractStyles(doc, &opts, h)
+		transform.Transform(doc, opts, h)
+		result := PrintToJS(source, doc, 0, opts, h)
+		return string(result.Output), SourceMapString(source, result, opts.Filename)
+	}
+	posix, _ := compile(`/Users/me/proj/src/pages/index.astro`, `/Users/me/proj`)
+	want, wantMap := compile(`c:/Users/me/proj/src/pages/index.astro`, `c:/Users/me/proj`)
+	// The drive letter is the only difference left with the posix path
+	if got := strings.ReplaceAll(want, "c:/", "/"); got != posix {
+		t.Errorf("\nFAIL: posix path\n  want: %s\n  got:  %s", posix, got)
+	}
+	if !strings.Contains(wantMap, `"sources": ["c:/Users/me/proj/src/pages/index.astro"]`) {
+		t.Errorf("\nFAIL: source map\n  got: %s", wantMap)
+	}
+	for _, filename := range []string{`C:\Users\me\proj\src\pages\index.astro`, `C:/Users\me\proj/src\pages/index.astro`} {
+		got, gotMap := compile(filename, `C:\Users\me\proj\`)
+		if got != want || gotMap != wantMap {
+			t.Errorf("\nFAIL: %s\n  want: %s\n%s\n  got:  %s\n%s", filename, want, wantMap, got, gotMap)
+		}
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	source := "---\nimport Card from '../components/Card.astro';\nconst title = `Hello\nworld`;\n---\n<Card title={title}>\n  <p class=\"intro\">\n    Hi\n  </p>\n</Card>\n<style>\np { color: red; }\n</style>\n"
+	compile := func(source string) (string, string) {
+		source = transform.NormalizeLineEndings(source)
+		opts := transform.TransformOptions{Filename: "/src/pages/index.astro", SourceMap: "external"}
+		h := handler.NewHandler(source, opts.Filename)
+		doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+		if err != nil {
+			t.Fatal(err)
+		}
+		transform.ExtractStyles(doc, &opts, h)
+		transform.Transform(doc, opts, h)
+		result := PrintToJS(source, doc, 0, opts, h)
+		var sourcemap struct {
+			Mappings string `json:"mappings"`
+		}
+		if err := json.Unmarshal([]byte(SourceMapString(source, result, opts.Filename)), &sourcemap); err != nil {
+			t.Fatal(err)
+		}
+		return string(result.Output), sourcemap.Mappings
+	}
+	crlf := strings.ReplaceAll(source, "\n", "\r\n")
+	want, lfMappings := compile(source)
+	// A byte order mark shifts the columns of the first line in the sourcemap, like in the file
+	_, bomMappings := compile("\ufeff" + source)
+
+	tests := []struct {
+		name     string
+		source   string
+		mappings string
+	}{
+		{name: "crlf", source: crlf, mappings: lfMappings},
+		{name: "lone cr", source: strings.ReplaceAll(source, "\n", "\r"), mappings: lfMappings},
+		{name: "byte order mark", source: "\ufeff" + source, mappings: bomMappings},
+		{name: "byte order mark and crlf", source: "\ufeff" + crlf, mappings: bomMappings},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, mappings := compile(tt.source)
+			if got != want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, want, got)
+			}
+			if mappings != tt.mappings {
+				t.Errorf("\nFAIL: %s mappings\n  want: %s\n  got:  %s", tt.name, tt.mappings, mappings)
+			}
+		})
+	}
+}
+
+func TestPrintEmptyDocument(t *testing.T) {
+	print := func(doc *astro.Node) string {
+		h := handler.NewHandler("", "/src/components/Empty.astro")
+		opts := transform.TransformOptions{Filename: "/src/components/Empty.astro"}
+		transform.Transform(doc, opts, h)
+		return string(PrintToJS("", doc, 0, opts, h).Output)
+	}
+	parsed, err := astro.Parse(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := print(parsed)
+	// A document the parser did not build has no frontmatter nor implicit elements
+	got := print(&astro.Node{Type: astro.DocumentNode, HydrationDirectives: make(map[string]string)})
+	if got != want {
+		t.Errorf("\nFAIL: document without children\n  want: %s\n  got:  %s", want, got)
+	}
+	if !strings.Contains(got, "return $$render``;") {
+		t.Errorf("expected an empty render, got %s", got)
+	}
+}
+
+func TestPrintMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "no metadata",
+			source: `<div></div>`,
+			want:   `{"modules":[],"hydratedComponents":[],"clientOnlyComponents":[],"scripts":[],"hydrationDirectives":[]}`,
+		},
+		{
+			name: "modules",
+			source: `---
+import type { Props } from '../types';
+import '../styles/global.css';
+import data from '../data.json' assert { type: 'json' };
+import Card from '../components/Card.astro';
+---
+<Card data={data} />`,
+			want: `{"modules":[{"specifier":"../data.json","assert":"{type:'json'}"},{"specifier":"../components/Card.astro","assert":""}],"hydratedComponents":[],"clientOnlyComponents":[],"scripts":[],"hydrationDirectives":[]}`,
+		},
+		{
+			name: "hydrated components",
+			source: `---
+import Counter from '../components/Counter.jsx';
+import * as ns from '../components/ns.js';
+import Only from '../components/Only.svelte';
+import '../components/my-element.js';
+---
+<Counter client:load /><ns.thing-x client:visible /><my-element client:idle /><Only client:only="svelte" /><Only client:only="svelte" />`,
+			want: `{"modules":[{"specifier":"../components/Counter.jsx","assert":""},{"specifier":"../components/ns.js","assert":""},{"specifier":"../components/my-element.js","assert":""}],"hydratedComponents":["my-element","ns.thing-x","Counter"],"clientOnlyComponents":["../components/Only.svelte"],"scripts":[],"hydrationDirectives":["idle","load","only","visible"]}`,
+		},
+		{
+			name:   "scripts",
+			source: "<script src=\"./a.js\"></script><script>console.log(`a`)</script>",
+			want:   "{\"modules\":[],\"hydratedComponents\":[],\"clientOnlyComponents\":[],\"scripts\":[{\"type\":\"external\",\"src\":\"./a.js\"},{\"type\":\"inline\",\"value\":\"console.log(`a`)\"}],\"hydrationDirectives\":[]}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/src/pages/index.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Fatal(err)
+			}
+			transformOptions := transform.TransformOptions{Filename: "/src/pages/index.astro", ExperimentalScriptOrder: true}
+			result := transform.TransformWithResult(doc, transformOptions, h)
+			printed := PrintTransformResultToJS(tt.source, result, 0, transformOptions, h)
+			got, err := json.Marshal(printed.Metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+
+			// Everything in the metadata is in the printed `$$createMetadata` call
+			_, call, _ := strings.Cut(string(printed.Output), "export const $$metadata = ")
+			call, _, _ = strings.Cut(call, "\n")
+			var fragments []string
+			for _, module := range printed.Metadata.Modules {
+				fragments = append(fragments, fmt.Sprintf("specifier: '%s', assert: ", module.Specifier))
+			}
+			for _, spec := range printed.Metadata.ClientOnlyComponents {
+				fragments = append(fragments, fmt.Sprintf("'%s'", spec))
+			}
+			for _, directive := range printed.Metadata.HydrationDirectives {
+				fragments = append(fragments, fmt.Sprintf("'%s'", directive))
+			}
+			for _, script := range printed.Metadata.Scripts {
+				fragments = append(fragments, fmt.Sprintf("type: '%s'", script.Type), script.Src, escapeTemplateLiteral(script.Value))
+			}
+			for _, fragment := range fragments {
+				if !strings.Contains(call, fragment) {
+					t.Errorf("\nFAIL: %s\n  %q is not in the printed metadata: %s", tt.name, fragment, call)
+				}
+			}
+			if n := strings.Count(call, "module: $$module"); n != len(printed.Metadata.Modules) {
+				t.Errorf("\nFAIL: %s\n  expected %d printed modules, got %d: %s", tt.name, len(printed.Metadata.Modules), n, call)
+			}
+		})
+	}
+}
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func largeComponent(size int) string {
+	var body strings.Builder
+	for i := 0; body.Len() < size; i++ {
+		fmt.Fprintf(&body, "<li class=\"item\" data-id={%d}>Item {items[%d]}\r\n</li>\n", i, i)
+	}
+	return fmt.Sprintf("---\nconst items = [];\n---\n<ul>%s</ul><script type=\"application/json\">%s</script>", body.String(), strings.Repeat(`{"a":"b"},`, size/10))
+}
+
+func TestPrintTo(t *testing.T) {
+	source := largeComponent(256 * 1024)
+	h := handler.NewHandler(source, "/src/pages/index.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transformOptions := transform.TransformOptions{Filename: "/src/pages/index.astro"}
+	result := transform.TransformWithResult(doc, transformOptions, h)
+
+	// Print without a writer, keeping the whole output in memory
+	p := &printer{
+		sourcetext: source,
+		opts:       transformOptions,
+		builder:    sourcemap.MakeChunkBuilder(nil, sourcemap.GenerateLineOffsetTables(source, strings.Count(source, "\n")+1)),
+		handler:    h,
+		result:     result,
+	}
+	printToJs(p, result.Doc, 0, transformOptions)
+	want := p.builder.GenerateChunk(p.output)
+
+	var w countingWriter
+	chunk, err := PrintTo(&w, source, result, 0, transformOptions, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.writes < 2 {
+		t.Errorf("expected output to be written in chunks, got %d write(s)", w.writes)
+	}
+	if !bytes.Equal(w.Bytes(), p.output) {
+		t.Error("streamed output differs from buffered output")
+	}
+	if !bytes.Equal(chunk.Buffer, want.Buffer) || chunk.EndState != want.EndState || chunk.FinalGeneratedColumn != want.FinalGeneratedColumn {
+		t.Error("streamed sourcemap differs from buffered sourcemap")
+	}
+
+	if _, err := PrintTo(failingWriter{}, source, result, 0, transformOptions, h); err == nil || err.Error() != "disk full" {
+		t.Errorf("expected the write error to be returned, got %v", err)
+	}
+}
+
+func BenchmarkPrintToLargeComponent(b *testing.B) {
+	source := largeComponent(1024 * 1024)
+	h := handler.NewHandler(source, "/src/pages/index.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		b.Fatal(err)
+	}
+	transformOptions := transform.TransformOptions{Filename: "/src/pages/index.astro"}
+	result := transform.TransformWithResult(doc, transformOptions, h)
+
+	b.Run("PrintTransformResultToJS", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			PrintTransformResultToJS(source, result, 0, transformOptions, h)
+		}
+	})
+	b.Run("PrintTo", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := PrintTo(io.Discard, source, result, 0, transformOptions, h); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestApplyJSON(t *testing.T) {
+	source := `---
+const alt = "A";
+---
+<style>div { color: red }</style>
+<div class="a"><img src="a.png" alt={alt}><p>Text</p></div>`
+	parse := func() (*astro.Node, ASTNode) {
+		doc, err := astro.Parse(strings.NewReader(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := handler.NewHandler(source, "/test.astro")
+		transformOptions := transform.TransformOptions{Scope: "xxxxxx"}
+		transform.ExtractStyles(doc, &transformOptions, h)
+		transform.Transform(doc, transformOptions, h)
+		var ast ASTNode
+		if err := json.Unmarshal(PrintToJSON(source, doc, types.ParseOptions{Position: true}).Output, &ast); err != nil {
+			t.Fatal(err)
+		}
+		return doc, ast
+	}
+	// find returns the first node of ast with the given name
+	var find func(ast *ASTNode, name string) *ASTNode
+	find = func(ast *ASTNode, name string) *ASTNode {
+		if ast.Name == name {
+			return ast
+		}
+		for i := range ast.Children {
+			if found := find(&ast.Children[i], name); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name  string
+		patch func(ast *ASTNode)
+		want  string
+		err   string
+	}{
+		{
+			name:  "unchanged",
+			patch: func(ast *ASTNode) {},
+			want:  `<div class="a astro-xxxxxx"><img src="a.png" alt={alt} class="astro-xxxxxx"></img><p class="astro-xxxxxx">Text</p></div>`,
+		},
+		{
+			name: "attributes and text",
+			patch: func(ast *ASTNode) {
+				img := find(ast, "img")
+				img.Attributes = append(img.Attributes, ASTNode{Type: "attribute", Kind: "quoted", Name: "loading", Value: "lazy", Raw: `'lazy'`})
+				find(ast, "p").Children[0].Value = "Patched"
+			},
+			want: `<div class="a astro-xxxxxx"><img src="a.png" alt={alt} class="astro-xxxxxx" loading='lazy'></img><p class="astro-xxxxxx">Patched</p></div>`,
+		},
+		{
+			name: "removed child",
+			patch: func(ast *ASTNode) {
+				div := find(ast, "div")
+				div.Children = div.Children[:1]
+			},
+			err: "children of <div> were added or removed, only attributes and text can be changed",
+		},
+		{
+			name: "renamed element",
+			patch: func(ast *ASTNode) {
+				find(ast, "p").Name = "span"
+			},
+			err: "<p> was renamed to <span>, only attributes and text can be changed",
+		},
+		{
+			name: "unknown attribute kind",
+			patch: func(ast *ASTNode) {
+				find(ast, "img").Attributes[0].Kind = "bogus"
+			},
+			err: `<img>: unknown kind "bogus" for the attribute "src"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, ast := parse()
+			tt.patch(&ast)
+			err := ApplyJSON(doc, ast)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %v", tt.name, tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b strings.Builder
+			astro.PrintToSource(&b, doc.LastChild)
+			if got := strings.TrimSpace(b.String()); got != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+		})
+	}
+}
@@ -65,6 +65,27 @@ func getComponentName(filename string) string {
 	return strings.Join([]string{"$$", basename}, "")
 }
 
+// componentReference returns the expression that references a component from its tag
+// name. Members that are not identifiers (e.g. `thing-x` in `ns.thing-x`) are accessed
+// with brackets, and a name that doesn't start with an identifier is quoted like the
+// name of a custom element.
+func componentReference(name string) string {
+	parts := strings.Split(name, ".")
+	if !js_scanner.IsIdentifier([]byte(parts[0])) {
+		return fmt.Sprintf("'%s'", name)
+	}
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for _, member := range parts[1:] {
+		if js_scanner.IsIdentifier([]byte(member)) {
+			b.WriteString("." + member)
+		} else {
+			b.WriteString(`["` + escapeDoubleQuote(member) + `"]`)
+		}
+	}
+	return b.String()
+}
+
 func escapeExistingEscapes(src string) string {
 	return strings.Replace(src, "\\", "\\\\", -1)
 }
@@ -87,17 +108,66 @@ func escapeBackticks(src string) string {
 }
 
 func escapeSingleQuote(str string) string {
-	return strings.Replace(str, "'", "\\'", -1)
+	return escapeStringLiteral(str, '\'')
 }
 
 func escapeDoubleQuote(str string) string {
-	return strings.Replace(str, `"`, "\\\"", -1)
+	return escapeStringLiteral(str, '"')
+}
+
+// escapeStringLiteral escapes str so that it can be printed between two `quote`
+// characters as a JavaScript string literal, whatever its contents.
+func escapeStringLiteral(str string, quote byte) string {
+	var b strings.Builder
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '\\':
+			b.WriteString(`\\`)
+		case quote:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	// Line and paragraph separators are line terminators in older engines
+	escaped := strings.NewReplacer("\u2028", `\u2028`, "\u2029", `\u2029`).Replace(b.String())
+	return escapeScriptClose(escaped)
+}
+
+// escapeTemplateLiteral escapes src so that it can be printed inside of a JavaScript
+// template literal, whatever its contents.
+func escapeTemplateLiteral(src string) string {
+	return escapeScriptClose(escapeText(src))
+}
+
+var scriptCloseExp = regexp.MustCompile(`(?i)</script`)
+
+// escapeScriptClose escapes `</script` sequences, which would otherwise end the generated
+// module early if it is ever inlined into a <script> element. `\/` evaluates to `/` in
+// both string and template literals, so the value is unchanged.
+func escapeScriptClose(src string) string {
+	return scriptCloseExp.ReplaceAllStringFunc(src, func(m string) string {
+		return `<\/` + m[2:]
+	})
 }
 
 func encodeDoubleQuote(str string) string {
 	return strings.Replace(str, `"`, "&quot;", -1)
 }
 
+// encodeQuote encodes the quote that an attribute value is printed with.
+func encodeQuote(str string, quote byte) string {
+	if quote == '\'' {
+		return strings.Replace(str, `'`, "&#39;", -1)
+	}
+	return encodeDoubleQuote(str)
+}
+
 func convertAttributeValue(n *astro.Node, attrName string) string {
 	expr := `""`
 	if transform.HasAttr(n, attrName) {
@@ -573,14 +573,20 @@ type Chunk struct {
 }
 
 type ChunkBuilder struct {
-	inputSourceMap      *SourceMap
-	sourceMap           []byte
-	prevLoc             loc.Loc
-	prevState           SourceMapState
-	lastGeneratedUpdate int
-	generatedColumn     int
-	hasPrevState        bool
-	lineOffsetTables    []LineOffsetTable
+	inputSourceMap   *SourceMap
+	sourceMap        []byte
+	prevLoc          loc.Loc
+	prevState        SourceMapState
+	generatedColumn  int
+	hasPrevState     bool
+	lineOffsetTables []LineOffsetTable
+
+	// Byte offsets into the generated output. Everything before
+	// generatedScanned has been scanned for line and column changes, and
+	// everything before generatedFlushed has been handed back by Flush and is
+	// no longer part of the output passed in.
+	generatedScanned int
+	generatedFlushed int
 
 	// This is a workaround for a bug in the popular "source-map" library:
 	// https://github.com/mozilla/source-map/issues/261. The library will
@@ -749,15 +755,25 @@ func (b *ChunkBuilder) GenerateChunk(output []byte) Chunk {
 	}
 }
 
+// Flush splits output into the part that has already been scanned, which can
+// be written out, and the rest. Later calls must pass the rest followed by any
+// newly printed text, so the whole output never has to be held in memory.
+func (b *ChunkBuilder) Flush(output []byte) (scanned []byte, rest []byte) {
+	n := b.generatedScanned - b.generatedFlushed
+	b.generatedFlushed = b.generatedScanned
+	return output[:n], output[n:]
+}
+
 // Scan over the printed text since the last source mapping and update the
 // generated line and column numbers
 func (b *ChunkBuilder) updateGeneratedLineAndColumn(output []byte) {
-	for i, c := range string(output[b.lastGeneratedUpdate:]) {
+	start := b.generatedScanned - b.generatedFlushed
+	for i, c := range string(output[start:]) {
 		switch c {
 		case '\r', '\n', '\u2028', '\u2029':
 			// Handle Windows-specific "\r\n" newlines
 			if c == '\r' {
-				newlineCheck := b.lastGeneratedUpdate + i + 1
+				newlineCheck := start + i + 1
 				if newlineCheck < len(output) && output[newlineCheck] == '\n' {
 					continue
 				}
@@ -793,7 +809,7 @@ func (b *ChunkBuilder) updateGeneratedLineAndColumn(output []byte) {
 		}
 	}
 
-	b.lastGeneratedUpdate = len(output)
+	b.generatedScanned = b.generatedFlushed + len(output)
 }
 
 func (b *ChunkBuilder) appendMapping(currentState SourceMapState) {
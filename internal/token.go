@@ -142,6 +142,17 @@ type Attribute struct {
 	ValLoc    loc.Loc
 	Tokenizer *Tokenizer
 	Type      AttributeType
+	// The quote around the value of a quoted attribute as authored: '"' or '\''. It is 0 for
+	// unquoted and generated values, which are printed with double quotes.
+	Quote byte
+}
+
+// QuoteChar returns the quote to print the value of a with.
+func (a Attribute) QuoteChar() byte {
+	if a.Quote == 0 {
+		return '"'
+	}
+	return a.Quote
 }
 
 type Expression struct {
@@ -2104,6 +2115,7 @@ func (z *Tokenizer) Raw() []byte {
 var (
 	nul         = []byte("\x00")
 	replacement = []byte("\ufffd")
+	utf8BOM     = []byte("\ufeff")
 )
 
 // Text returns the unescaped text of a text, comment or doctype token. The
@@ -2171,6 +2183,18 @@ func (z *Tokenizer) TagAttr() (key []byte, keyLoc loc.Loc, val []byte, valLoc lo
 	return nil, loc.Loc{Start: 0}, nil, loc.Loc{Start: 0}, QuotedAttribute, false
 }
 
+// attrQuote returns the quote that the value of an attribute starting at valLoc was
+// authored with, or 0 if it was unquoted.
+func (z *Tokenizer) attrQuote(attrType AttributeType, valLoc loc.Loc) byte {
+	if attrType != QuotedAttribute || valLoc.Start == 0 || valLoc.Start > len(z.buf) {
+		return 0
+	}
+	if c := z.buf[valLoc.Start-1]; c == '"' || c == '\'' {
+		return c
+	}
+	return 0
+}
+
 // Token returns the current Token. The result's Data and Attr values remain
 // valid after subsequent Next calls.
 func (z *Tokenizer) Token() Token {
@@ -2191,7 +2215,7 @@ func (z *Tokenizer) Token() Token {
 			var attrType AttributeType
 			var attrTokenizer *Tokenizer = nil
 			key, keyLoc, val, valLoc, attrType, moreAttr = z.TagAttr()
-			t.Attr = append(t.Attr, Attribute{"", atom.String(key), keyLoc, string(val), valLoc, attrTokenizer, attrType})
+			t.Attr = append(t.Attr, Attribute{"", atom.String(key), keyLoc, string(val), valLoc, attrTokenizer, attrType, z.attrQuote(attrType, valLoc)})
 		}
 		if isFragment(string(name)) || isComponent(string(name)) {
 			t.DataAtom, t.Data = 0, string(name)
@@ -2219,7 +2243,13 @@ func NewTokenizer(r io.Reader) *Tokenizer {
 //
 // The input is assumed to be UTF-8 encoded.
 func NewTokenizerFragment(r io.Reader, contextTag string) *Tokenizer {
-	buf := new(bytes.Buffer)
+	return newTokenizerWithBuffer(r, contextTag, new(bytes.Buffer))
+}
+
+// newTokenizerWithBuffer is like NewTokenizerFragment, but reads r into buf, which is
+// reset first. The tokenizer rewrites parts of buf in place (e.g. to lowercase tag names).
+func newTokenizerWithBuffer(r io.Reader, contextTag string, buf *bytes.Buffer) *Tokenizer {
+	buf.Reset()
 	buf.ReadFrom(r)
 	z := &Tokenizer{
 		r:                          r,
@@ -2227,6 +2257,11 @@ func NewTokenizerFragment(r io.Reader, contextTag string) *Tokenizer {
 		fm:                         FrontmatterInitial,
 		openBraceIsExpressionStart: true,
 	}
+	// A leading byte order mark is not part of the document: start reading after it,
+	// so that locations remain offsets into the original source.
+	if bytes.HasPrefix(z.buf, utf8BOM) {
+		z.raw.End = len(utf8BOM)
+	}
 	if contextTag != "" {
 		switch s := strings.ToLower(contextTag); s {
 		case "iframe", "noembed", "noframes", "plaintext", "script", "style", "title", "textarea", "xmp":
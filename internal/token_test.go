@@ -512,6 +512,16 @@ func TestFrontmatter(t *testing.T) {
 			`---`,
 			[]TokenType{FrontmatterFenceToken},
 		},
+		{
+			"byte order mark",
+			"\ufeff---\nconst a = 0;\n---\n<div></div>",
+			[]TokenType{FrontmatterFenceToken, TextToken, FrontmatterFenceToken, StartTagToken, EndTagToken},
+		},
+		{
+			"byte order mark without frontmatter",
+			"\ufeff<div></div>",
+			[]TokenType{StartTagToken, EndTagToken},
+		},
 		{
 			"basic case",
 			`
@@ -808,6 +818,26 @@ func TestExpressions(t *testing.T) {
 			"<title>test {expr} test</title>",
 			[]TokenType{StartTagToken, TextToken, StartExpressionToken, TextToken, EndExpressionToken, TextToken, EndTagToken},
 		},
+		{
+			"title with markup",
+			"<title>{post.title} <b>|</b> My Site</title>",
+			[]TokenType{StartTagToken, StartExpressionToken, TextToken, EndExpressionToken, TextToken, EndTagToken},
+		},
+		{
+			"textarea with whitespace around an expression",
+			"<textarea>\n  {value}\n</textarea>",
+			[]TokenType{StartTagToken, TextToken, StartExpressionToken, TextToken, EndExpressionToken, TextToken, EndTagToken},
+		},
+		{
+			"script does not parse expressions",
+			"<script>const o = {a: 1}</script>",
+			[]TokenType{StartTagToken, TextToken, EndTagToken},
+		},
+		{
+			"style does not parse expressions",
+			"<style>a{color:red}</style>",
+			[]TokenType{StartTagToken, TextToken, EndTagToken},
+		},
 		{
 			"String interpolation inside an expression within a title",
 			"<title>{content.title && `${title} 🚀 ${title}`}</title>",
@@ -995,6 +1025,16 @@ doesNotExist
 			`<div></div>`,
 			[]int{0, 2, 8},
 		},
+		{
+			"byte order mark",
+			"\ufeff<div></div>",
+			[]int{0, 5, 11},
+		},
+		{
+			"frontmatter after a byte order mark",
+			"\ufeff---\ndoesNotExist\n---\n",
+			[]int{0, 4, 7},
+		},
 	}
 
 	runTokenLocTest(t, Locs)
@@ -0,0 +1,35 @@
+package transform
+
+import (
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/handler"
+)
+
+// A Plugin is a pass supplied by the user, which runs on the document once it has
+// been transformed and before it is printed. Returning an error aborts the compile.
+type Plugin func(doc *astro.Node, ctx *PluginContext) error
+
+// PluginContext is passed to every plugin along with the document.
+type PluginContext struct {
+	// The options the document was transformed with, with the scope resolved
+	Options TransformOptions
+	// The handler of the compile, used by plugins to append their diagnostics
+	Handler *handler.Handler
+}
+
+// RunPlugins runs the plugins of opts in order on a document that has been transformed
+// by Transform. It stops at the first plugin that fails and returns its error.
+func RunPlugins(doc *astro.Node, opts TransformOptions, h *handler.Handler) error {
+	if len(opts.Plugins) == 0 {
+		return nil
+	}
+	// Transform already reported any problem with the scope
+	resolveScope(doc, &opts, h.Fork())
+	ctx := &PluginContext{Options: opts, Handler: h}
+	for _, plugin := range opts.Plugins {
+		if err := plugin(doc, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
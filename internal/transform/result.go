@@ -0,0 +1,163 @@
+package transform
+
+import (
+	"slices"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/handler"
+	"github.com/withastro/compiler/internal/js_scanner"
+	"github.com/withastro/compiler/internal/loc"
+)
+
+// TransformResult is the transformed document together with the metadata
+// collected while transforming it.
+type TransformResult struct {
+	Doc *astro.Node
+	// Hydration directives used in the document (e.g. "load", "visible"), sorted
+	HydrationDirectives  []string
+	HydratedComponents   []*astro.HydratedComponentMetadata
+	ClientOnlyComponents []*astro.HydratedComponentMetadata
+	ServerComponents     []*astro.HydratedComponentMetadata
+	Styles               []ExtractedStyle
+	Scripts              []HoistedScript
+	CSSImports           []CSSImport
+	// Every component and custom element used in the template, in document order
+	ComponentUsages []astro.ComponentUsage
+	// Elements that render unescaped HTML with `set:html`, in document order
+	RawHTMLNodes []*astro.Node
+	// Specifiers of the CSS `@import` rules found in extracted styles
+	StyleImports   []string
+	ContainsHead   bool
+	HasHeadContent bool
+	HasBodyContent bool
+	// Whether the document has a `<meta charset>` and a `<meta name="viewport">`
+	HasCharsetMeta  bool
+	HasViewportMeta bool
+	Propagation     bool
+	// Whether the frontmatter exports `getStaticPaths`
+	ContainsGetStaticPaths bool
+	// Set when the frontmatter exports `prerender` as a boolean literal
+	Prerender   *bool
+	Diagnostics []loc.DiagnosticMessage
+}
+
+// ExtractedStyle is a `<style>` element hoisted out of the template.
+type ExtractedStyle struct {
+	Node *astro.Node
+	Code string
+}
+
+// HoistedScript is a `<script>` element hoisted out of the template.
+type HoistedScript struct {
+	Node *astro.Node
+	// One of "external", "inline" or "define:vars"
+	Type string
+	Src  string
+	Code string
+	// The raw `define:vars` expression, only set when Type is "define:vars"
+	DefineVars string
+}
+
+// TransformWithResult transforms the document like Transform and returns the
+// collected metadata alongside it. Plugins are not run, see RunPlugins.
+func TransformWithResult(doc *astro.Node, opts TransformOptions, h *handler.Handler) *TransformResult {
+	Transform(doc, opts, h)
+	return NewTransformResult(doc, h)
+}
+
+// NewTransformResult collects the metadata of an already transformed document.
+func NewTransformResult(doc *astro.Node, h *handler.Handler) *TransformResult {
+	result := &TransformResult{
+		Doc:                  doc,
+		HydrationDirectives:  doc.SortedHydrationDirectives(),
+		HydratedComponents:   doc.HydratedComponents,
+		ClientOnlyComponents: doc.ClientOnlyComponents,
+		ServerComponents:     doc.ServerComponents,
+		ComponentUsages:      doc.ComponentUsages,
+		RawHTMLNodes:         doc.RawHTMLNodes,
+		Styles:               make([]ExtractedStyle, 0, len(doc.Styles)),
+		Scripts:              make([]HoistedScript, 0, len(doc.Scripts)+len(doc.ExternalScripts)),
+		CSSImports:           ExtractCSSImports(doc),
+		StyleImports:         doc.StyleImports,
+		ContainsHead:         doc.ContainsHead,
+		HasHeadContent:       doc.HasHeadContent,
+		HasBodyContent:       doc.HasBodyContent,
+		HasCharsetMeta:       doc.HasCharsetMeta,
+		HasViewportMeta:      doc.HasViewportMeta,
+		Propagation:          doc.HeadPropagation,
+	}
+	if doc.FirstChild != nil && doc.FirstChild.Type == astro.FrontmatterNode && doc.FirstChild.FirstChild != nil {
+		exports := js_scanner.ScanPageExports([]byte(doc.FirstChild.FirstChild.Data))
+		result.ContainsGetStaticPaths = exports.ContainsGetStaticPaths
+		result.Prerender = exports.Prerender
+	}
+	for _, n := range doc.Styles {
+		style := ExtractedStyle{Node: n}
+		if n.FirstChild != nil {
+			style.Code = n.FirstChild.Data
+		}
+		result.Styles = append(result.Styles, style)
+	}
+	// External scripts come first, so that inline scripts can rely on what they define
+	for _, n := range append(slices.Clip(doc.ExternalScripts), doc.Scripts...) {
+		script := HoistedScript{Node: n}
+		if n.FirstChild != nil {
+			script.Code = n.FirstChild.Data
+		}
+		if defineVars := astro.GetAttribute(n, "define:vars"); defineVars != nil {
+			script.Type = "define:vars"
+			script.DefineVars = defineVars.Val
+		} else if src := astro.GetAttribute(n, "src"); src != nil {
+			script.Type = "external"
+			script.Src = src.Val
+		} else if n.FirstChild != nil {
+			script.Type = "inline"
+		}
+		result.Scripts = append(result.Scripts, script)
+	}
+	if h != nil {
+		result.Diagnostics = h.Diagnostics()
+	}
+	return result
+}
+
+// TransformReport describes what Transform would do to a document.
+type TransformReport struct {
+	// The scope added to elements, once derived from the filename if needed and sanitized
+	Scope string
+	// The number of styles, inline scripts and external scripts that would be hoisted
+	Styles          int
+	Scripts         int
+	ExternalScripts int
+	// Hydration directives used in the document, sorted
+	HydrationDirectives  []string
+	HydratedComponents   []*astro.HydratedComponentMetadata
+	ClientOnlyComponents []*astro.HydratedComponentMetadata
+	ServerComponents     []*astro.HydratedComponentMetadata
+	// Diagnostics reported by the transform, not including those already in the handler
+	Diagnostics []loc.DiagnosticMessage
+}
+
+// AnalyzeTransform extracts the styles of a parsed document and transforms it like
+// ExtractStyles and Transform, but on a clone of the document. Neither doc nor h are
+// modified.
+func AnalyzeTransform(doc *astro.Node, opts TransformOptions, h *handler.Handler) TransformReport {
+	clone := doc.Clone(true)
+	h = h.Fork()
+	// Nothing is reported while analyzing, diagnostics are only returned
+	opts.OnDiagnostic = nil
+	ExtractStyles(clone, &opts, h)
+	resolveScope(clone, &opts, h)
+	Transform(clone, opts, h)
+	return TransformReport{
+		Scope:                opts.Scope,
+		Styles:               len(clone.Styles),
+		Scripts:              len(clone.Scripts),
+		ExternalScripts:      len(clone.ExternalScripts),
+		HydrationDirectives:  clone.SortedHydrationDirectives(),
+		HydratedComponents:   clone.HydratedComponents,
+		ClientOnlyComponents: clone.ClientOnlyComponents,
+		ServerComponents:     clone.ServerComponents,
+		Diagnostics:          h.Diagnostics(),
+	}
+}
@@ -4,19 +4,45 @@ import (
 
 	// "strings"
 
+	"cmp"
 	"fmt"
+	"math"
+	"slices"
 	"strings"
 
 	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/handler"
+	"github.com/withastro/compiler/internal/loc"
+	"github.com/withastro/compiler/lib/esbuild/css_ast"
 	"github.com/withastro/compiler/lib/esbuild/css_parser"
 	"github.com/withastro/compiler/lib/esbuild/css_printer"
 	"github.com/withastro/compiler/lib/esbuild/logger"
 	a "golang.org/x/net/html/atom"
 )
 
-// Take a slice of DOM nodes, and scope CSS within every <style> tag
-func ScopeStyle(styles []*astro.Node, opts TransformOptions) bool {
+// Take a slice of DOM nodes, and scope CSS within every <style> tag. Rules nested in another
+// style rule (e.g. `& .title` in `.card`) are left as authored.
+func ScopeStyle(styles []*astro.Node, opts TransformOptions, h *handler.Handler) bool {
+	didScope, _ := scopeStyles(styles, opts, h)
+	return didScope
+}
+
+// isScopedStyle reports whether the <style> element n will be scoped.
+func isScopedStyle(n *astro.Node) bool {
+	if n.DataAtom != a.Style || HasInlineDirective(n) || hasTruthyAttr(n, "global") || hasTruthyAttr(n, "is:global") {
+		return false
+	}
+	if n.FirstChild == nil || strings.TrimSpace(n.FirstChild.Data) == "" {
+		return HasAttr(n, "define:vars")
+	}
+	return true
+}
+
+// scopeStyles scopes every <style> tag like ScopeStyle. When `OptimizedScopes` is enabled,
+// it also collects the elements that the scoped selectors could match.
+func scopeStyles(styles []*astro.Node, opts TransformOptions, h *handler.Handler) (bool, *scopeTargets) {
 	didScope := false
+	targets := newScopeTargets()
 	for _, n := range styles {
 		if n.DataAtom != a.Style {
 			continue
@@ -25,14 +51,9 @@ func ScopeStyle(styles []*astro.Node, opts TransformOptions) bool {
 			fmt.Printf("Found `<style global>` in %s! Please migrate to the `is:global` directive.\n", opts.Filename)
 			continue
 		}
-		if hasTruthyAttr(n, "is:global") {
+		if !isScopedStyle(n) {
 			continue
 		}
-		if n.FirstChild == nil || strings.TrimSpace(n.FirstChild.Data) == "" {
-			if !HasAttr(n, "define:vars") {
-				continue
-			}
-		}
 		didScope = true
 		n.Attr = append(n.Attr, astro.Attribute{
 			Key: "data-astro-id",
@@ -53,9 +74,179 @@ func ScopeStyle(styles []*astro.Node, opts TransformOptions) bool {
 		// esbuild's internal `css_printer` has been modified to emit Astro scoped styles
 		result := css_printer.Print(tree, css_printer.Options{MinifyWhitespace: true, Scope: opts.Scope, ScopeStrategy: scopeStrategy})
 		n.FirstChild.Data = string(result.CSS)
+		if opts.OptimizedScopes {
+			targets.collectRules(tree.Rules)
+		}
+
+		for _, sel := range result.UnscopedSelectors {
+			var r loc.Range
+			if len(n.FirstChild.Loc) > 0 {
+				r = loc.Range{Loc: loc.Loc{Start: n.FirstChild.Loc[0].Start + int(sel.Loc.Start)}, Len: len(sel.Selector)}
+			}
+			h.AppendWarning(&loc.ErrorWithRange{
+				Code:  loc.WARNING_UNSCOPED_SELECTOR,
+				Text:  fmt.Sprintf("The `%s` selector cannot be scoped and will apply globally.", sel.Selector),
+				Hint:  fmt.Sprintf("Use `:global(%s)` to make this explicit and silence this warning.", sel.Selector),
+				Range: r,
+			})
+		}
 	}
 
-	return didScope
+	return didScope, targets
+}
+
+// mergeScopedStyles concatenates the CSS of each run of scoped styles of doc that were authored
+// next to each other into the first of them, and removes the others from `doc.Styles`. Global
+// styles, styles with `define:vars` and styles with `@charset` or `@import` rules, which must
+// come first in a stylesheet, are kept separate and end a run, so that the cascade order is
+// kept. Generated styles, like the one added by hoistInlineStyles, come after authored ones.
+func mergeScopedStyles(doc *astro.Node) {
+	position := func(n *astro.Node) int {
+		if len(n.Loc) == 0 || n.Loc[0].Start == 0 {
+			return math.MaxInt
+		}
+		return n.Loc[0].Start
+	}
+	authored := slices.Clone(doc.Styles)
+	slices.SortStableFunc(authored, func(a, b *astro.Node) int { return cmp.Compare(position(a), position(b)) })
+	var runs [][]*astro.Node
+	var run []*astro.Node
+	for _, n := range authored {
+		if n.FirstChild == nil {
+			continue
+		}
+		if !isScopedStyle(n) || HasAttr(n, "define:vars") || hasLeadingAtRule(n.FirstChild.Data) {
+			runs = append(runs, run)
+			run = nil
+			continue
+		}
+		run = append(run, n)
+	}
+	runs = append(runs, run)
+	for _, run := range runs {
+		if len(run) < 2 {
+			continue
+		}
+		var css strings.Builder
+		for _, n := range run {
+			css.WriteString(n.FirstChild.Data)
+		}
+		merged := doc.Styles[slices.IndexFunc(doc.Styles, func(n *astro.Node) bool { return slices.Contains(run, n) })]
+		merged.FirstChild.Data = css.String()
+		doc.Styles = slices.DeleteFunc(doc.Styles, func(n *astro.Node) bool {
+			return n != merged && slices.Contains(run, n)
+		})
+	}
+}
+
+// hasLeadingAtRule reports whether css has a `@charset` or `@import` rule, which is ignored
+// unless it comes before the other rules of a stylesheet.
+func hasLeadingAtRule(css string) bool {
+	return strings.Contains(css, "@charset") || strings.Contains(css, "@import")
+}
+
+// scopedStyleTargets reports whether any of styles, which were already scoped by scopeStyles,
+// is scoped. When `OptimizedScopes` is enabled, it also collects the elements that their
+// selectors could match.
+func scopedStyleTargets(styles []*astro.Node, opts TransformOptions) (bool, *scopeTargets) {
+	didScope := false
+	targets := newScopeTargets()
+	for _, n := range styles {
+		if !isScopedStyle(n) {
+			continue
+		}
+		didScope = true
+		if !opts.OptimizedScopes || n.FirstChild == nil || strings.TrimSpace(n.FirstChild.Data) == "" {
+			continue
+		}
+		tree := css_parser.Parse(logger.Log{AddMsg: func(msg logger.Msg) {}}, logger.Source{Contents: n.FirstChild.Data}, css_parser.Options{MinifySyntax: false, MinifyWhitespace: true})
+		targets.collectRules(tree.Rules)
+	}
+	return didScope, targets
+}
+
+// scopeTargets is the set of type selectors, classes and ids referenced by scoped selectors.
+// When a selector can't be narrowed down, it falls back to scoping every element.
+type scopeTargets struct {
+	all      bool
+	elements map[string]bool
+	classes  map[string]bool
+	ids      map[string]bool
+}
+
+func newScopeTargets() *scopeTargets {
+	return &scopeTargets{
+		elements: make(map[string]bool),
+		classes:  make(map[string]bool),
+		ids:      make(map[string]bool),
+	}
+}
+
+func (t *scopeTargets) collectRules(rules []css_ast.Rule) {
+	for _, rule := range rules {
+		switch r := rule.Data.(type) {
+		case *css_ast.RSelector:
+			t.collectComplexSelectors(r.Selectors)
+			t.collectRules(r.Rules)
+		case *css_ast.RKnownAt:
+			t.collectRules(r.Rules)
+		case *css_ast.RAtLayer:
+			t.collectRules(r.Rules)
+		case *css_ast.RQualified, *css_ast.RUnknownAt:
+			// The selectors of these rules are unknown, so anything could match
+			t.all = true
+		}
+	}
+}
+
+func (t *scopeTargets) collectComplexSelectors(selectors []css_ast.ComplexSelector) {
+	for _, complex := range selectors {
+		for _, compound := range complex.Selectors {
+			t.collectCompoundSelector(compound)
+		}
+	}
+}
+
+func (t *scopeTargets) collectCompoundSelector(sel css_ast.CompoundSelector) {
+	// "&" refers to the parent rule, which is already collected
+	if sel.NestingSelector != css_ast.NestingSelectorNone {
+		return
+	}
+	narrowed := false
+	if sel.TypeSelector != nil && sel.TypeSelector.Name.Text != "*" {
+		switch name := strings.ToLower(sel.TypeSelector.Name.Text); name {
+		case "html", "body":
+			// Never scoped
+			return
+		default:
+			t.elements[name] = true
+			narrowed = true
+		}
+	}
+	for _, sub := range sel.SubclassSelectors {
+		switch s := sub.(type) {
+		case *css_ast.SSClass:
+			t.classes[s.Name] = true
+			narrowed = true
+		case *css_ast.SSHash:
+			t.ids[s.Name] = true
+			narrowed = true
+		case *css_ast.SSPseudoClass:
+			if s.Name == "global" || s.Name == "root" {
+				// Never scoped
+				return
+			}
+		case *css_ast.SSPseudoClassWithSelectorList:
+			// Every selector inside of `:is()` and `:where()` is scoped on its own
+			if s.Kind == css_ast.PseudoClassIs || s.Kind == css_ast.PseudoClassWhere {
+				t.collectComplexSelectors(s.Selectors)
+				narrowed = true
+			}
+		}
+	}
+	if !narrowed {
+		t.all = true
+	}
 }
 
 func GetDefineVars(styles []*astro.Node) []string {
@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/handler"
+	"github.com/withastro/compiler/internal/loc"
 	"github.com/withastro/compiler/internal/test_utils"
 )
 
@@ -49,12 +51,62 @@ func TestScopeStyle(t *testing.T) {
 		{
 			name:   "attr",
 			source: "a[aria-current=page]{}",
-			want:   "a:where(.astro-xxxxxx)[aria-current=page]{}",
+			want:   "a[aria-current=page]:where(.astro-xxxxxx){}",
+		},
+		{
+			name:   "attr presence",
+			source: "a[href]{}",
+			want:   "a[href]:where(.astro-xxxxxx){}",
+		},
+		{
+			name:   "attr before class",
+			source: "[data-foo].b{}",
+			want:   "[data-foo]:where(.astro-xxxxxx).b{}",
 		},
 		{
 			name:   "attr universal implied",
 			source: "[aria-visible],[aria-hidden]{}",
-			want:   ":where(.astro-xxxxxx)[aria-visible],:where(.astro-xxxxxx)[aria-hidden]{}",
+			want:   "[aria-visible]:where(.astro-xxxxxx),[aria-hidden]:where(.astro-xxxxxx){}",
+		},
+		{
+			name:   "attr quoted",
+			source: `input[type="checkbox"]{}`,
+			want:   `input[type="checkbox"]:where(.astro-xxxxxx){}`,
+		},
+		{
+			name:   "attr prefix match",
+			source: `a[href^="https://"]{}`,
+			want:   `a[href^="https://"]:where(.astro-xxxxxx){}`,
+		},
+		{
+			name:   "attr quoted comma",
+			source: `[data-label="a,b"]{}`,
+			want:   `[data-label="a,b"]:where(.astro-xxxxxx){}`,
+		},
+		{
+			name:   "attr quoted comma in selector list",
+			source: `[data-label="a,b"], .c, [data-x='y,z'] span{}`,
+			want:   `[data-label="a,b"]:where(.astro-xxxxxx),.c:where(.astro-xxxxxx),[data-x='y,z']:where(.astro-xxxxxx) span:where(.astro-xxxxxx){}`,
+		},
+		{
+			name:   "attr quoted bracket",
+			source: `a[title="x]y"] span{}`,
+			want:   `a[title="x]y"]:where(.astro-xxxxxx) span:where(.astro-xxxxxx){}`,
+		},
+		{
+			name:   "attr escaped quote",
+			source: `[data-x="a\"b"]{}`,
+			want:   `[data-x="a\"b"]:where(.astro-xxxxxx){}`,
+		},
+		{
+			name:   "attr unquoted",
+			source: `[type=text]{}`,
+			want:   `[type=text]:where(.astro-xxxxxx){}`,
+		},
+		{
+			name:   "attr modifier",
+			source: `[lang|="en" i]{}`,
+			want:   `[lang|="en" i]:where(.astro-xxxxxx){}`,
 		},
 		{
 			name:   "universal pseudo state",
@@ -86,6 +138,16 @@ func TestScopeStyle(t *testing.T) {
 			source: "button:focus::before{}",
 			want:   "button:where(.astro-xxxxxx):focus::before{}",
 		},
+		{
+			name:   "global",
+			source: ":global(.foo){}",
+			want:   ".foo{}",
+		},
+		{
+			name:   "global descendant",
+			source: ".card :global(.child){}",
+			want:   ".card:where(.astro-xxxxxx) .child{}",
+		},
 		{
 			name:   "global children",
 			source: ".class :global(ul li){}",
@@ -131,6 +193,51 @@ func TestScopeStyle(t *testing.T) {
 			source: ".class:not(.is-active):not(.is-disabled){}",
 			want:   ".class:where(.astro-xxxxxx):not(.is-active):not(.is-disabled){}",
 		},
+		{
+			name:   ":not() descendant",
+			source: ":not(.active) li{}",
+			want:   ":where(.astro-xxxxxx):not(.active) li:where(.astro-xxxxxx){}",
+		},
+		{
+			name:   "unscopable :not()",
+			source: "html:not(.dark){}",
+			want:   "html:not(.dark){}",
+		},
+		{
+			name:   ":is() list",
+			source: ":is(header, footer){}",
+			want:   ":where(.astro-xxxxxx):is(header:where(.astro-xxxxxx),footer:where(.astro-xxxxxx)){}",
+		},
+		{
+			name:   ":where() list",
+			source: ":where(header, footer) a{}",
+			want:   ":where(.astro-xxxxxx):where(header:where(.astro-xxxxxx),footer:where(.astro-xxxxxx)) a:where(.astro-xxxxxx){}",
+		},
+		{
+			name:   ":has() relative",
+			source: "figure:has(> img){}",
+			want:   "figure:where(.astro-xxxxxx):has(>img:where(.astro-xxxxxx)){}",
+		},
+		{
+			name:   "nested :not(:is())",
+			source: ":not(:is(a, b)){}",
+			want:   ":where(.astro-xxxxxx):not(:is(a,b)){}",
+		},
+		{
+			name:   ":is() trailing comma",
+			source: ":is(a, b,){}",
+			want:   ":where(.astro-xxxxxx):is(a:where(.astro-xxxxxx),b:where(.astro-xxxxxx)){}",
+		},
+		{
+			name:   ":is() compound",
+			source: ".card :is(h1, h2):hover, li:is(.a, .b){}",
+			want:   ".card:where(.astro-xxxxxx) :where(.astro-xxxxxx):is(h1:where(.astro-xxxxxx),h2:where(.astro-xxxxxx)):hover,li:where(.astro-xxxxxx):is(.a:where(.astro-xxxxxx),.b:where(.astro-xxxxxx)){}",
+		},
+		{
+			name:   ":is() with :global()",
+			source: ":is(:global(.foo), .bar) a{}",
+			want:   ":is(.foo,.bar:where(.astro-xxxxxx)) a:where(.astro-xxxxxx){}",
+		},
 		{
 			name:   "weird chaining",
 			source: ":hover.a:focus{}", // yes this is valid. yes I’m just upset as you are :(
@@ -180,27 +287,42 @@ func TestScopeStyle(t *testing.T) {
 		{
 			name:   "keyframes",
 			source: "@keyframes shuffle{from{transform:rotate(0deg);}to{transform:rotate(360deg);}}",
-			want:   "@keyframes shuffle{from{transform:rotate(0deg)}to{transform:rotate(360deg)}}",
+			want:   "@keyframes shuffle-astro-xxxxxx{from{transform:rotate(0deg)}to{transform:rotate(360deg)}}",
 		},
 		{
 			name:   "keyframes 2",
 			source: "@keyframes shuffle{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}}",
-			want:   "@keyframes shuffle{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}}",
+			want:   "@keyframes shuffle-astro-xxxxxx{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}}",
 		},
 		{
 			name:   "keyframes start",
 			source: "@keyframes shuffle{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}} h1{} h2{}",
-			want:   "@keyframes shuffle{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}}h1:where(.astro-xxxxxx){}h2:where(.astro-xxxxxx){}",
+			want:   "@keyframes shuffle-astro-xxxxxx{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}}h1:where(.astro-xxxxxx){}h2:where(.astro-xxxxxx){}",
 		},
 		{
 			name:   "keyframes middle",
 			source: "h1{} @keyframes shuffle{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}} h2{}",
-			want:   "h1:where(.astro-xxxxxx){}@keyframes shuffle{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}}h2:where(.astro-xxxxxx){}",
+			want:   "h1:where(.astro-xxxxxx){}@keyframes shuffle-astro-xxxxxx{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}}h2:where(.astro-xxxxxx){}",
 		},
 		{
 			name:   "keyframes end",
 			source: "h1{} h2{} @keyframes shuffle{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}}",
-			want:   "h1:where(.astro-xxxxxx){}h2:where(.astro-xxxxxx){}@keyframes shuffle{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}}",
+			want:   "h1:where(.astro-xxxxxx){}h2:where(.astro-xxxxxx){}@keyframes shuffle-astro-xxxxxx{0%{transform:rotate(0deg);color:blue}100%{transform:rotate(360deg)}}",
+		},
+		{
+			name:   "keyframes animation reference",
+			source: "@keyframes spin{to{transform:rotate(360deg)}} .a{animation:spin 1s linear infinite} .b{animation-name:spin,fade}",
+			want:   "@keyframes spin-astro-xxxxxx{to{transform:rotate(360deg)}}.a:where(.astro-xxxxxx){animation:spin-astro-xxxxxx 1s linear infinite}.b:where(.astro-xxxxxx){animation-name:spin-astro-xxxxxx,fade}",
+		},
+		{
+			name:   "keyframes external animation",
+			source: "@keyframes spin{to{transform:rotate(360deg)}} .a{animation:bounce 1s}",
+			want:   "@keyframes spin-astro-xxxxxx{to{transform:rotate(360deg)}}.a:where(.astro-xxxxxx){animation:bounce 1s}",
+		},
+		{
+			name:   "keyframes nested in @media",
+			source: "@media (prefers-reduced-motion: no-preference){@keyframes spin{to{opacity:0}}} .a{-webkit-animation:spin 1s}",
+			want:   "@media (prefers-reduced-motion: no-preference){@keyframes spin-astro-xxxxxx{to{opacity:0}}}.a:where(.astro-xxxxxx){-webkit-animation:spin-astro-xxxxxx 1s}",
 		},
 		{
 			name:   "calc",
@@ -248,7 +370,48 @@ func TestScopeStyle(t *testing.T) {
 		{
 			name:   "nesting combinator",
 			source: "div { & span { color: blue } }",
-			want:   "div:where(.astro-xxxxxx){& span:where(.astro-xxxxxx){color:blue}}",
+			want:   "div:where(.astro-xxxxxx){& span{color:blue}}",
+		},
+		{
+			// Nested rules aren't scoped, see ScopeStyle
+			name:   "nesting descendant",
+			source: ".card { & .title { color: red } }",
+			want:   ".card:where(.astro-xxxxxx){& .title{color:red}}",
+		},
+		{
+			name:   "nesting implicit descendant",
+			source: ".card { .title { color: red } > p { color: blue } }",
+			want:   ".card:where(.astro-xxxxxx){.title{color:red}>p{color:blue}}",
+		},
+		{
+			name:   "nesting type selector",
+			source: ".card { a { color: red } div.major { color: blue } color: green }",
+			want:   ".card:where(.astro-xxxxxx){a{color:red}div.major{color:blue}color:green}",
+		},
+		{
+			name:   "nesting selector suffix",
+			source: ".card { .dark & { color: white } }",
+			want:   ".card:where(.astro-xxxxxx){.dark &{color:white}}",
+		},
+		{
+			name:   "nesting pseudo-class",
+			source: ".card { &:hover { color: red } }",
+			want:   ".card:where(.astro-xxxxxx){&:hover{color:red}}",
+		},
+		{
+			name:   "nesting inside @media",
+			source: ".card { @media (min-width: 640px) { color: red; .title { color: blue } } }",
+			want:   ".card:where(.astro-xxxxxx){@media (min-width: 640px){color:red;.title{color:blue}}}",
+		},
+		{
+			name:   "nesting inside :global",
+			source: ":global(.dark) { .card { color: white } }",
+			want:   ".dark{.card:where(.astro-xxxxxx){color:white}}",
+		},
+		{
+			name:   "nesting inside partially global list",
+			source: ".a, :global(.b) { .c { color: red } }",
+			want:   ".a:where(.astro-xxxxxx),.b{.c:where(.astro-xxxxxx){color:red}}",
 		},
 		{
 			name:   "nesting modifier",
@@ -269,6 +432,36 @@ func TestScopeStyle(t *testing.T) {
 			source: "@layer theme, layout, utilities; @layer special { .item { color: rebeccapurple; }}",
 			want:   "@layer theme,layout,utilities;@layer special{.item:where(.astro-xxxxxx){color:rebeccapurple}}",
 		},
+		{
+			name:   "@supports nested in @media",
+			source: "@media (min-width: 600px) { @supports (display: grid) { .grid { display: grid } :global(.x) a { color: red } } }",
+			want:   "@media (min-width: 600px){@supports (display: grid){.grid:where(.astro-xxxxxx){display:grid}.x a:where(.astro-xxxxxx){color:red}}}",
+		},
+		{
+			name:   "@container with name",
+			source: "@container card (inline-size > 300px) { h2 { color: red } }",
+			want:   "@container card (inline-size > 300px){h2:where(.astro-xxxxxx){color:red}}",
+		},
+		{
+			name:   "nested @layer",
+			source: "@layer base { p { margin: 0 } @layer inner { a {} } }",
+			want:   "@layer base{p:where(.astro-xxxxxx){margin:0}@layer inner{a:where(.astro-xxxxxx){}}}",
+		},
+		{
+			name:   "@font-face",
+			source: "@font-face { font-family: \"X\"; src: url(x.woff) }",
+			want:   "@font-face{font-family:\"X\";src:url(x.woff)}",
+		},
+		{
+			name:   "@property",
+			source: "@property --x { syntax: \"<length>\"; inherits: false; initial-value: 0px }",
+			want:   "@property --x{syntax:\"<length>\";inherits:false;initial-value:0px}",
+		},
+		{
+			name:   "@page",
+			source: "@page :first { margin: 1in }",
+			want:   "@page :first{margin:1in}",
+		},
 		{
 			name:   "@starting-style",
 			source: "@starting-style{.class{}}",
@@ -285,7 +478,7 @@ func TestScopeStyle(t *testing.T) {
 			}
 			styleEl := doc.LastChild.FirstChild.FirstChild // note: root is <html>, and we need to get <style> which lives in head
 			styles := []*astro.Node{styleEl}
-			ScopeStyle(styles, TransformOptions{Scope: "xxxxxx"})
+			ScopeStyle(styles, TransformOptions{Scope: "xxxxxx"}, handler.NewHandler(code, "/test.astro"))
 			got := styles[0].FirstChild.Data
 			if tt.want != got {
 				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
@@ -293,3 +486,311 @@ func TestScopeStyle(t *testing.T) {
 		})
 	}
 }
+
+func TestScopeStyleUnscopableNot(t *testing.T) {
+	code := "<style>html:not(.dark) { color: white }</style>"
+	doc, err := astro.Parse(strings.NewReader(code))
+	if err != nil {
+		t.Error(err)
+	}
+	h := handler.NewHandler(code, "/test.astro")
+	styles := []*astro.Node{doc.LastChild.FirstChild.FirstChild}
+	ScopeStyle(styles, TransformOptions{Scope: "xxxxxx"}, h)
+	if got, want := styles[0].FirstChild.Data, "html:not(.dark){color:white}"; got != want {
+		t.Errorf("\nFAIL: html:not(.dark)\n  want: %s\n  got:  %s", want, got)
+	}
+	diagnostics := h.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Code != int(loc.WARNING_UNSCOPED_SELECTOR) {
+		t.Errorf("\nFAIL: expected the unscopable selector to be reported\n  got:  %v", diagnostics)
+	}
+}
+
+func TestScopeStyleKeyframesOfOtherStyles(t *testing.T) {
+	source := `<div class="a">x</div>
+<style is:global>@keyframes fade { to { opacity: 0 } }</style>
+<style>.a { animation: fade 1s; }</style>
+<style>@keyframes spin { to { rotate: 1turn } } .b { animation: spin 1s, fade 2s, bounce 3s; }</style>`
+	h := handler.NewHandler(source, "/test.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Error(err)
+	}
+	opts := TransformOptions{Scope: "xxxxxx"}
+	ExtractStyles(doc, &opts, h)
+	Transform(doc, opts, h)
+	got := make([]string, 0, len(doc.Styles))
+	for _, style := range doc.Styles {
+		got = append(got, style.FirstChild.Data)
+	}
+	// Only the keyframes declared in the same style are renamed: `fade` is global, and `bounce`
+	// may be declared by another component
+	want := []string{
+		"@keyframes fade { to { opacity: 0 } }",
+		".a:where(.astro-xxxxxx){animation:fade 1s}",
+		"@keyframes spin-astro-xxxxxx{to{rotate:1turn}}.b:where(.astro-xxxxxx){animation:spin-astro-xxxxxx 1s,fade 2s,bounce 3s}",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("\nFAIL: keyframes of other styles\n  want: %q\n  got:  %q", want, got)
+	}
+}
+
+func TestScopeStylePseudoElements(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		where  string
+		class  string
+	}{
+		{
+			name:   "element ::before",
+			source: "a::before{}",
+			where:  "a:where(.astro-xxxxxx)::before{}",
+			class:  "a.astro-xxxxxx::before{}",
+		},
+		{
+			name:   "pseudo-class then pseudo-element",
+			source: "li:hover::after{}",
+			where:  "li:where(.astro-xxxxxx):hover::after{}",
+			class:  "li.astro-xxxxxx:hover::after{}",
+		},
+		{
+			name:   "bare pseudo-class then pseudo-element",
+			source: ":hover::after{}",
+			where:  ":where(.astro-xxxxxx):hover::after{}",
+			class:  ".astro-xxxxxx:hover::after{}",
+		},
+		{
+			name:   "::placeholder",
+			source: "input::placeholder{}",
+			where:  "input:where(.astro-xxxxxx)::placeholder{}",
+			class:  "input.astro-xxxxxx::placeholder{}",
+		},
+		{
+			name:   "bare vendor-prefixed pseudo-element",
+			source: "::-webkit-scrollbar-thumb{}",
+			where:  ":where(.astro-xxxxxx)::-webkit-scrollbar-thumb{}",
+			class:  ".astro-xxxxxx::-webkit-scrollbar-thumb{}",
+		},
+		{
+			name:   "legacy single-colon",
+			source: "a:before{}",
+			where:  "a:where(.astro-xxxxxx):before{}",
+			class:  "a.astro-xxxxxx:before{}",
+		},
+		{
+			name:   "bare legacy single-colon",
+			source: ":after{}",
+			where:  ":where(.astro-xxxxxx):after{}",
+			class:  ".astro-xxxxxx:after{}",
+		},
+		{
+			name:   "child combinator",
+			source: ".a > li::marker{}",
+			where:  ".a:where(.astro-xxxxxx)>li:where(.astro-xxxxxx)::marker{}",
+			class:  ".a.astro-xxxxxx>li.astro-xxxxxx::marker{}",
+		},
+		{
+			name:   "::part",
+			source: ".host::part(label){}",
+			where:  ".host:where(.astro-xxxxxx)::part(label){}",
+			class:  ".host.astro-xxxxxx::part(label){}",
+		},
+		{
+			name:   "::part with pseudo-classes",
+			source: ".host:hover::part(label):focus{}",
+			where:  ".host:where(.astro-xxxxxx):hover::part(label):focus{}",
+			class:  ".host.astro-xxxxxx:hover::part(label):focus{}",
+		},
+		{
+			name:   "bare ::part",
+			source: "::part(label){}",
+			where:  ":where(.astro-xxxxxx)::part(label){}",
+			class:  ".astro-xxxxxx::part(label){}",
+		},
+		{
+			name:   "attribute ::part",
+			source: "my-el[open]::part(label thumb){}",
+			where:  "my-el[open]:where(.astro-xxxxxx)::part(label thumb){}",
+			class:  "my-el[open].astro-xxxxxx::part(label thumb){}",
+		},
+		{
+			name:   "child combinator bare legacy",
+			source: ".a>:first-letter{}",
+			where:  ".a:where(.astro-xxxxxx)>:where(.astro-xxxxxx):first-letter{}",
+			class:  ".a.astro-xxxxxx>.astro-xxxxxx:first-letter{}",
+		},
+	}
+	for _, tt := range tests {
+		for _, strategy := range []string{"where", "class"} {
+			t.Run(tt.name+" ("+strategy+")", func(t *testing.T) {
+				code := test_utils.Dedent("<style>\n" + tt.source + " \n</style>")
+				doc, err := astro.Parse(strings.NewReader(code))
+				if err != nil {
+					t.Error(err)
+				}
+				styleEl := doc.LastChild.FirstChild.FirstChild
+				styles := []*astro.Node{styleEl}
+				ScopeStyle(styles, TransformOptions{Scope: "xxxxxx", ScopedStyleStrategy: strategy}, handler.NewHandler(code, "/test.astro"))
+				want := tt.where
+				if strategy == "class" {
+					want = tt.class
+				}
+				got := styles[0].FirstChild.Data
+				if want != got {
+					t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, want, got)
+				}
+			})
+		}
+	}
+}
+
+func TestScopeStyleStrategies(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		where     string
+		class     string
+		attribute string
+		warnings  int
+	}{
+		{
+			name:      "universal",
+			source:    "*{box-sizing:border-box}",
+			where:     ":where(.astro-xxxxxx){box-sizing:border-box}",
+			class:     ".astro-xxxxxx{box-sizing:border-box}",
+			attribute: "[data-astro-cid-xxxxxx]{box-sizing:border-box}",
+		},
+		{
+			name:      "bare pseudo-class",
+			source:    ":hover{}",
+			where:     ":where(.astro-xxxxxx):hover{}",
+			class:     ".astro-xxxxxx:hover{}",
+			attribute: "[data-astro-cid-xxxxxx]:hover{}",
+		},
+		{
+			name:      "bare pseudo-class descendant",
+			source:    ".a :focus-visible{}",
+			where:     ".a:where(.astro-xxxxxx) :where(.astro-xxxxxx):focus-visible{}",
+			class:     ".a.astro-xxxxxx .astro-xxxxxx:focus-visible{}",
+			attribute: ".a[data-astro-cid-xxxxxx] [data-astro-cid-xxxxxx]:focus-visible{}",
+		},
+		{
+			name:      ":root",
+			source:    ":root{--x:1}",
+			where:     ":root{--x:1}",
+			class:     ":root{--x:1}",
+			attribute: ":root{--x:1}",
+			warnings:  1,
+		},
+		{
+			name:      "html and body",
+			source:    "html,body{margin:0}",
+			where:     "html,body{margin:0}",
+			class:     "html,body{margin:0}",
+			attribute: "html,body{margin:0}",
+			warnings:  2,
+		},
+		{
+			name:      "body descendant",
+			source:    "body h1{}",
+			where:     "body h1:where(.astro-xxxxxx){}",
+			class:     "body h1.astro-xxxxxx{}",
+			attribute: "body h1[data-astro-cid-xxxxxx]{}",
+		},
+		{
+			name:      "global html",
+			source:    ":global(html){}",
+			where:     "html{}",
+			class:     "html{}",
+			attribute: "html{}",
+		},
+	}
+	for _, tt := range tests {
+		for _, strategy := range []string{"where", "class", "attribute"} {
+			t.Run(tt.name+" ("+strategy+")", func(t *testing.T) {
+				code := test_utils.Dedent("<style>\n" + tt.source + " \n</style>")
+				doc, err := astro.Parse(strings.NewReader(code))
+				if err != nil {
+					t.Error(err)
+				}
+				styleEl := doc.LastChild.FirstChild.FirstChild
+				styles := []*astro.Node{styleEl}
+				h := handler.NewHandler(code, "/test.astro")
+				ScopeStyle(styles, TransformOptions{Scope: "xxxxxx", ScopedStyleStrategy: strategy}, h)
+				want := tt.where
+				switch strategy {
+				case "class":
+					want = tt.class
+				case "attribute":
+					want = tt.attribute
+				}
+				got := styles[0].FirstChild.Data
+				if want != got {
+					t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, want, got)
+				}
+				if len(h.Warnings()) != tt.warnings {
+					t.Errorf("expected %d warnings, got %d", tt.warnings, len(h.Warnings()))
+				}
+			})
+		}
+	}
+}
+
+func TestMergeScopedStyles(t *testing.T) {
+	source := `<div class="a"><p>b</p><span>c</span></div>
+<style>.a { color: red; }</style>
+<style is:global>body { margin: 0; }</style>
+<style>p { color: blue; }</style>
+<style is:inline>span { color: green; }</style>
+<style>span { color: white; }</style>`
+	for _, experimentalScriptOrder := range []bool{false, true} {
+		h := handler.NewHandler(source, "/test.astro")
+		doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+		if err != nil {
+			t.Error(err)
+		}
+		opts := TransformOptions{Scope: "xxxxxx", MergeScopedStyles: true, ExperimentalScriptOrder: experimentalScriptOrder}
+		ExtractStyles(doc, &opts, h)
+		Transform(doc, opts, h)
+		got := make([]string, 0, len(doc.Styles))
+		for _, style := range doc.Styles {
+			got = append(got, style.FirstChild.Data)
+		}
+		want := []string{
+			".a:where(.astro-xxxxxx){color:red}",
+			"body { margin: 0; }",
+			"p:where(.astro-xxxxxx){color:blue}span:where(.astro-xxxxxx){color:white}",
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("\nFAIL: ExperimentalScriptOrder: %v\n  want: %q\n  got:  %q", experimentalScriptOrder, want, got)
+		}
+	}
+}
+
+func TestMergeScopedStylesLeadingAtRules(t *testing.T) {
+	source := `<div class="a"><p>b</p></div>
+<style>.a { color: red; }</style>
+<style>@import "./theme.css"; p { color: blue; }</style>
+<style>p { color: white; }</style>
+<style>div { color: black; }</style>`
+	h := handler.NewHandler(source, "/test.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Error(err)
+	}
+	opts := TransformOptions{Scope: "xxxxxx", MergeScopedStyles: true}
+	ExtractStyles(doc, &opts, h)
+	Transform(doc, opts, h)
+	got := make([]string, 0, len(doc.Styles))
+	for _, style := range doc.Styles {
+		got = append(got, style.FirstChild.Data)
+	}
+	want := []string{
+		".a:where(.astro-xxxxxx){color:red}",
+		`@import"./theme.css";p:where(.astro-xxxxxx){color:blue}`,
+		"p:where(.astro-xxxxxx){color:white}div:where(.astro-xxxxxx){color:black}",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("\nFAIL: leading at-rules\n  want: %q\n  got:  %q", want, got)
+	}
+}
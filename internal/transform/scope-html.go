@@ -2,20 +2,131 @@ package transform
 
 import (
 	"fmt"
+	"slices"
 	"strings"
+	"unicode"
 
 	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/handler"
+	"github.com/withastro/compiler/internal/loc"
 	"golang.org/x/net/html/atom"
 )
 
 func ScopeElement(n *astro.Node, opts TransformOptions) {
 	if n.Type == astro.ElementNode {
-		if _, noScope := NeverScopedElements[n.Data]; !noScope {
+		// `data-astro-noscope="subtree"` opts this element and all of its children out of scoping.
+		// The marker is kept until the whole document is scoped, so children can still find it.
+		if n.Closest(isNoScopeSubtree) != nil {
+			return
+		}
+		// `data-astro-noscope` opts this element (but not its children) out of scoping
+		if HasAttr(n, DATA_ASTRO_NOSCOPE) {
+			n.RemoveAttribute(DATA_ASTRO_NOSCOPE)
+			return
+		}
+		if !isNeverScoped(n) {
 			injectScopedClass(n, opts)
 		}
 	}
 }
 
+// isArrayLiteral reports whether expr is a single array literal, e.g. `["a", { b: true }]`.
+// Expressions with template literals or comments are too complex to check and are never treated as one.
+func isArrayLiteral(expr string) bool {
+	if len(expr) < 2 || expr[0] != '[' || strings.ContainsAny(expr, "`") || strings.Contains(expr, "//") || strings.Contains(expr, "/*") {
+		return false
+	}
+	depth := 0
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '[', '(', '{':
+			depth++
+		case ']', ')', '}':
+			depth--
+			// The opening bracket must only be closed at the very end
+			if depth == 0 {
+				return i == len(expr)-1
+			}
+		}
+	}
+	return false
+}
+
+func isNoScopeSubtree(n *astro.Node) bool {
+	attr := GetAttr(n, DATA_ASTRO_NOSCOPE)
+	return attr != nil && attr.Type == astro.QuotedAttribute && attr.Val == "subtree"
+}
+
+// isInsideNoscript reports whether n is a descendant of a <noscript>, whose content is kept as authored.
+func isInsideNoscript(n *astro.Node) bool {
+	return n.Parent != nil && n.Parent.Closest(func(p *astro.Node) bool { return p.DataAtom == atom.Noscript }) != nil
+}
+
+// isNeverScoped reports whether n never needs the scope, either because it is a fragment
+// (named or shorthand) that renders nothing itself, because it is one of the NeverScopedElements,
+// because it is inside of a <noscript> or because it is metadata inside of an explicit <head>.
+func isNeverScoped(n *astro.Node) bool {
+	if n.Fragment {
+		return true
+	}
+	// SVG shares some element names with HTML metadata (e.g. <title>, <font>),
+	// so inside of an <svg> only the raw text elements are skipped
+	if n.Namespace == "svg" {
+		return n.DataAtom == atom.Style || n.DataAtom == atom.Script
+	}
+	if _, noScope := NeverScopedElements[n.Data]; noScope || n.DataAtom == atom.Html || isInsideNoscript(n) {
+		return true
+	}
+	return n.Closest(func(p *astro.Node) bool { return p.DataAtom == atom.Head && !IsImplicitNode(p) }) != nil
+}
+
+// matches reports whether n could be targeted by the collected scoped selectors.
+// Dynamic attributes can't be known ahead of time, so they are assumed to match.
+func (t *scopeTargets) matches(n *astro.Node) bool {
+	if t.all || t.elements[strings.ToLower(n.Data)] {
+		return true
+	}
+	for _, attr := range n.Attr {
+		if attr.Type == astro.SpreadAttribute {
+			return true
+		}
+		switch attr.Key {
+		case "id":
+			if len(t.ids) == 0 {
+				continue
+			}
+			if attr.Type != astro.QuotedAttribute || t.ids[attr.Val] {
+				return true
+			}
+		case "class", "class:list", "className":
+			if len(t.classes) == 0 {
+				continue
+			}
+			if attr.Type != astro.QuotedAttribute && attr.Type != astro.EmptyAttribute {
+				return true
+			}
+			for _, name := range strings.Fields(attr.Val) {
+				if t.classes[name] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func AddDefineVars(n *astro.Node, values []string) bool {
 	if n.Type == astro.ElementNode && !n.Component {
 		if _, noScope := NeverScopedElements[n.Data]; !noScope {
@@ -28,12 +139,38 @@ func AddDefineVars(n *astro.Node, values []string) bool {
 	return false
 }
 
-func AnnotateElement(n *astro.Node, opts TransformOptions) {
-	if n.Type == astro.ElementNode && !n.Component && !n.Fragment {
-		if _, noScope := NeverScopedElements[n.Data]; !noScope {
-			annotateElement(n, opts)
-		}
+// AnnotateElement adds the `data-astro-source-file` and `data-astro-source-loc` attributes,
+// which map an element back to the file and the line and column it was authored at, so
+// that dev tools can find it. Components, metadata, foreign (SVG/MathML) elements and
+// elements inside of `is:raw` are left alone.
+func AnnotateElement(n *astro.Node, opts TransformOptions, h *handler.Handler) {
+	if n.Type != astro.ElementNode || n.Component || n.Fragment || n.Expression || n.Namespace != "" {
+		return
+	}
+	// Elements without a location were not authored in this file
+	if len(n.Loc) == 0 || n.Loc[0].Start == 0 {
+		return
+	}
+	if IsImplicitNode(n) || isNeverScoped(n) {
+		return
+	}
+	if n.Parent != nil && n.Parent.Closest(func(p *astro.Node) bool { return HasAttr(p, "is:raw") }) != nil {
+		return
+	}
+	filename := opts.NormalizedFilename
+	if filename == "" {
+		filename = opts.Filename
 	}
+	line, column := h.LineAndColumn(loc.Loc{Start: n.Loc[0].Start - 1})
+	n.Attr = append(slices.Grow(n.Attr, 2), astro.Attribute{
+		Key:  "data-astro-source-file",
+		Type: astro.QuotedAttribute,
+		Val:  filename,
+	}, astro.Attribute{
+		Key:  "data-astro-source-loc",
+		Type: astro.QuotedAttribute,
+		Val:  fmt.Sprintf("%d:%d", line, column),
+	})
 }
 
 var NeverScopedElements map[string]bool = map[string]bool{
@@ -57,17 +194,6 @@ var NeverScopedSelectors map[string]bool = map[string]bool{
 	":root": true,
 }
 
-func annotateElement(n *astro.Node, opts TransformOptions) {
-	if n.DataAtom == atom.Html {
-		return
-	}
-	n.Attr = append(n.Attr, astro.Attribute{
-		Key:  "data-astro-source-file",
-		Type: astro.QuotedAttribute,
-		Val:  opts.Filename,
-	})
-}
-
 func injectDefineVars(n *astro.Node, values []string) {
 	definedVars := "$$definedVars"
 	for i, attr := range n.Attr {
@@ -167,8 +293,17 @@ func injectScopedClass(n *astro.Node, opts TransformOptions) {
 					n.Attr[i] = attr
 					return
 				case astro.ExpressionAttribute:
-					// as an expression
-					attr.Val = fmt.Sprintf(`[(%s), "%s"]`, attr.Val, scopedClass)
+					// as an expression, appending to array literals directly
+					if trimmed := strings.TrimSpace(attr.Val); isArrayLiteral(trimmed) {
+						items := strings.TrimRightFunc(strings.TrimSpace(trimmed[1:len(trimmed)-1]), func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+						if items == "" {
+							attr.Val = fmt.Sprintf(`["%s"]`, scopedClass)
+						} else {
+							attr.Val = fmt.Sprintf(`[%s, "%s"]`, items, scopedClass)
+						}
+					} else {
+						attr.Val = fmt.Sprintf(`[(%s), "%s"]`, attr.Val, scopedClass)
+					}
 					n.Attr[i] = attr
 					return
 				}
@@ -181,11 +316,7 @@ func injectScopedClass(n *astro.Node, opts TransformOptions) {
 			return
 		}
 		// If we didn't find an existing class attribute, let's add one
-		n.Attr = append(n.Attr, astro.Attribute{
-			Key:  "class",
-			Type: astro.QuotedAttribute,
-			Val:  scopedClass,
-		})
+		AddClass(n, scopedClass)
 	} else {
 		n.Attr = append(n.Attr, astro.Attribute{
 			Key:  fmt.Sprintf(`data-astro-cid-%s`, opts.Scope),
@@ -60,6 +60,11 @@ func tests() []struct {
 			source: "<div class=`${value}` />",
 			want:   "<div class=`${value} astro-xxxxxx`></div>",
 		},
+		{
+			name:   "shorthand",
+			source: "<div {class} />",
+			want:   `<div class={((Astro.props.class) ?? "") + " astro-xxxxxx"}></div>`,
+		},
 		{
 			name:   "component className not scoped",
 			source: `<Component className="test" />`,
@@ -80,6 +85,31 @@ func tests() []struct {
 			source: "<div class:list={{ a: true }} />",
 			want:   `<div class:list={[({ a: true }), "astro-xxxxxx"]}></div>`,
 		},
+		{
+			name:   "element class:list array",
+			source: `<div class:list={["a", { b: isB }, [c]]} />`,
+			want:   `<div class:list={["a", { b: isB }, [c], "astro-xxxxxx"]}></div>`,
+		},
+		{
+			name:   "element class:list array trailing comma",
+			source: `<div class:list={["a", b,]} />`,
+			want:   `<div class:list={["a", b, "astro-xxxxxx"]}></div>`,
+		},
+		{
+			name:   "element class:list empty array",
+			source: `<div class:list={[]} />`,
+			want:   `<div class:list={["astro-xxxxxx"]}></div>`,
+		},
+		{
+			name:   "element class:list array with brackets in strings",
+			source: `<div class:list={["a]", b]} />`,
+			want:   `<div class:list={["a]", b, "astro-xxxxxx"]}></div>`,
+		},
+		{
+			name:   "element class:list indexed array",
+			source: `<div class:list={[a, b][0]} />`,
+			want:   `<div class:list={[([a, b][0]), "astro-xxxxxx"]}></div>`,
+		},
 		{
 			name:   "element class:list string",
 			source: "<div class:list=\"weird but ok\" />",
@@ -2,7 +2,11 @@ package transform
 
 import (
 	"fmt"
+	"net/url"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"unicode"
 
@@ -10,6 +14,8 @@ import (
 	"github.com/withastro/compiler/internal/handler"
 	"github.com/withastro/compiler/internal/js_scanner"
 	"github.com/withastro/compiler/internal/loc"
+	"github.com/withastro/compiler/lib/esbuild/css_lexer"
+	"github.com/withastro/compiler/lib/esbuild/logger"
 	a "golang.org/x/net/html/atom"
 )
 
@@ -18,40 +24,267 @@ const TRANSITION_NAME = "transition:name"
 const TRANSITION_PERSIST = "transition:persist"
 const DATA_ASTRO_RELOAD = "data-astro-reload"
 const TRANSITION_PERSIST_PROPS = "transition:persist-props"
+const DATA_ASTRO_NOSCOPE = "data-astro-noscope"
 
 type TransformOptions struct {
 	Scope                   string
 	Filename                string
 	NormalizedFilename      string
+	ProjectRoot             string
+	Site                    string
+	Pathname                string
+	Base                    string
 	InternalURL             string
 	SourceMap               string
 	AstroGlobalArgs         string
 	ScopedStyleStrategy     string
 	Compact                 bool
+	Minify                  bool
+	Normalize               bool
 	ResultScopedSlot        bool
 	TransitionsAnimationURL string
 	ResolvePath             func(string) string
-	PreprocessStyle         interface{}
-	AnnotateSourceFile      bool
-	RenderScript            bool
+	// Applied to the value of every expression attribute written by the user, before any value is
+	// generated for the hydration or scoping of the component
+	TransformExpression func(string) string
+	// Keys of expression attributes that TransformExpression is not applied to,
+	// e.g. attributes that are already rewritten by a dedicated pass
+	ExpressionAttrDenylist []string
+	PreprocessStyle        interface{}
+	AnnotateSourceFile     bool
+	RenderScript           bool
+	// Deprecated: styles and scripts are always hoisted in document order
 	ExperimentalScriptOrder bool
+	// Remove the `@import` rules collected in `doc.StyleImports` from extracted styles,
+	// leaving them to the bundler
+	RemoveStyleImports bool
+	// How attributes are spaced when re-serialized: "preserve" (default) keeps the
+	// author's whitespace, "normalized" separates them with a single space
+	Format string
+	// Only add the scope to elements that can be matched by the component's scoped styles
+	OptimizedScopes bool
+	// Additional tag names (matched exactly against `n.Data`) whose hydration directives
+	// are handled like those of components, even if the parser did not flag them. Only
+	// hydration props and metadata are added: the printer still renders them as elements.
+	ExtraComponentTags []string
+	// Tag names of components that are rendered as another component, e.g. `{"Img": "AstroImage"}`.
+	// The aliased name is also the one resolved against the imports of the document. Aliases that
+	// are not an identifier or a member expression are reported and ignored.
+	ComponentAliases map[string]string
+	// Replace quoted `style` attributes repeated verbatim on several elements with
+	// a shared scoped class. Unlike inline styles, the generated rule does not win
+	// over more specific selectors.
+	HoistInlineStyles bool
+	// Report `client:` directives that aren't built into Astro as errors instead of warnings
+	StrictDirectives bool
+	// Report every `set:html` directive with an informational diagnostic
+	AuditRawHTML bool
+	// Report documents with head content but no `<meta name="viewport">` with an informational diagnostic
+	AuditViewportMeta bool
+	// Called with every diagnostic as soon as a pass reports it, in addition to collecting it
+	// in the handler
+	OnDiagnostic func(loc.DiagnosticMessage)
+	// The number of warnings, infos and hints collected in the handler before the others are
+	// dropped, or 0 to collect all of them. Errors are always collected. Protects memory when
+	// compiling very broken input.
+	MaxDiagnostics int
+	// Passes supplied by the user. Only RunPlugins runs them, in order: Transform and
+	// TransformWithResult ignore them.
+	Plugins []Plugin
+	// Print quoted boolean attributes of HTML elements (e.g. `disabled="disabled"`) in their
+	// bare form, and remove those whose value is "false"
+	NormalizeBooleanAttributes bool
+	// Resolve quoted `href` and `src` attributes that start with `/` against `Site` and `Base`,
+	// for sites that are deployed under a sub-path
+	ResolveRootURLs bool
+	// Render the `<style>` elements of the component where they are authored, with their CSS scoped,
+	// instead of extracting them to `doc.Styles`. Styles with `define:vars` are still extracted, since
+	// their variables are set on the elements of the component.
+	KeepStylesInPlace bool
+	// Combine the scoped styles of a component that are authored next to each other into a single
+	// style once they are scoped. Global and inline styles, styles with `define:vars` and styles with
+	// `@charset` or `@import` rules are kept separate.
+	MergeScopedStyles bool
 }
 
 func Transform(doc *astro.Node, opts TransformOptions, h *handler.Handler) *astro.Node {
-	shouldScope := len(doc.Styles) > 0 && ScopeStyle(doc.Styles, opts)
+	configureHandler(h, &opts)
+	resolveScope(doc, &opts, h)
+	transformTree(doc, doc, opts, h)
+	return doc
+}
+
+// TransformSubtree runs the passes of Transform over the subtree of root only, e.g. after an editor
+// replaced it in a document that was already transformed. Hoisted scripts, hydrated components and
+// the other metadata found below root are still collected on doc, which root must belong to.
+//
+// Scoping depends on the styles of the whole document: doc must have been transformed before, and
+// its styles are not scoped again. root must not have been transformed yet. What was collected for
+// nodes that are no longer part of doc, like those of the subtree that root replaced, is dropped
+// first. Inline styles are not hoisted, and the document-level fixes of Transform, like trimming
+// trailing whitespace, are skipped.
+func TransformSubtree(doc *astro.Node, root *astro.Node, opts TransformOptions, h *handler.Handler) {
+	configureHandler(h, &opts)
+	// Transform already reported any problem with the scope
+	resolveScope(doc, &opts, h.Fork())
+	forgetDetachedNodes(doc, root, &opts)
+	transformTree(doc, root, opts, h)
+}
+
+// forgetDetachedNodes drops the metadata collected on doc for nodes that are no longer part of it,
+// or that are below root, which is collected again. Scripts hoisted out of the tree can't be told
+// apart from one another, so they are all kept.
+func forgetDetachedNodes(doc *astro.Node, root *astro.Node, opts *TransformOptions) {
+	kept := func(n *astro.Node) bool {
+		return n.Closest(func(p *astro.Node) bool { return p == root }) == nil && n.Closest(func(p *astro.Node) bool { return p == doc }) != nil
+	}
+	detachedScript := func(n *astro.Node) bool {
+		return n.Parent != nil && !kept(n)
+	}
+	doc.Scripts = slices.DeleteFunc(doc.Scripts, detachedScript)
+	doc.ExternalScripts = slices.DeleteFunc(doc.ExternalScripts, detachedScript)
+	doc.RawHTMLNodes = slices.DeleteFunc(doc.RawHTMLNodes, func(n *astro.Node) bool { return !kept(n) })
+
+	doc.HydratedComponents = keepMetadata(doc.HydratedComponentNodes, doc.HydratedComponents, kept, func(n *astro.Node) bool {
+		return HasAttr(n, "client:component-path")
+	})
+	doc.ClientOnlyComponents = keepMetadata(doc.ClientOnlyComponentNodes, doc.ClientOnlyComponents, kept, func(n *astro.Node) bool {
+		return matchNodeToImportStatement(doc, n) != nil
+	})
+	doc.HydratedComponentNodes = slices.DeleteFunc(doc.HydratedComponentNodes, func(n *astro.Node) bool { return !kept(n) })
+	doc.ClientOnlyComponentNodes = slices.DeleteFunc(doc.ClientOnlyComponentNodes, func(n *astro.Node) bool { return !kept(n) })
+
+	// The other metadata doesn't keep its nodes, so it is collected again from those that are left
+	clear(doc.HydrationDirectives)
+	for _, nodes := range [][]*astro.Node{doc.HydratedComponentNodes, doc.ClientOnlyComponentNodes} {
+		for j := len(nodes) - 1; j >= 0; j-- {
+			n := nodes[j]
+			i := slices.IndexFunc(n.Attr, func(attr astro.Attribute) bool {
+				return strings.HasPrefix(attr.Key, "client:") && !strings.HasPrefix(attr.Key, "client:component-")
+			})
+			if i < 0 {
+				continue
+			}
+			directive := strings.TrimPrefix(n.Attr[i].Key, "client:")
+			if value, ok := doc.HydrationDirectives[directive]; !ok || value == "" {
+				doc.HydrationDirectives[directive] = directiveValue(n.Attr[i])
+			}
+		}
+	}
+	type usageKey struct {
+		name string
+		pos  loc.Loc
+	}
+	usages := make(map[usageKey]int)
+	doc.ServerComponents = nil
+	astro.Walk(doc, astro.VisitorFuncs{OnEnter: func(n *astro.Node) astro.WalkAction {
+		if n == root {
+			return astro.WalkSkipChildren
+		}
+		if n.Type != astro.ElementNode || len(n.Loc) == 0 {
+			return astro.WalkContinue
+		}
+		usages[usageKey{n.Data, n.Loc[0]}]++
+		if !HasAttr(n, "server:component-path") {
+			return astro.WalkContinue
+		}
+		if match := matchNodeToImportStatement(doc, n); match != nil {
+			doc.ServerComponents = append(doc.ServerComponents, &astro.HydratedComponentMetadata{
+				ExportName:   match.ExportName,
+				LocalName:    n.Data,
+				Specifier:    match.Specifier,
+				ResolvedPath: ResolveIdForMatch(match.Specifier, opts),
+			})
+		}
+		return astro.WalkContinue
+	}})
+	doc.ComponentUsages = slices.DeleteFunc(doc.ComponentUsages, func(usage astro.ComponentUsage) bool {
+		key := usageKey{usage.Name, usage.Pos}
+		if usages[key] == 0 {
+			return true
+		}
+		usages[key]--
+		return false
+	})
+}
+
+// keepMetadata drops the entries of metadata that belong to nodes for which kept reports false.
+// Only the nodes for which hasMetadata reports true have an entry, and entries are appended in the
+// order their nodes were collected, while the nodes are prepended.
+func keepMetadata(nodes []*astro.Node, metadata []*astro.HydratedComponentMetadata, kept func(*astro.Node) bool, hasMetadata func(*astro.Node) bool) []*astro.HydratedComponentMetadata {
+	var result []*astro.HydratedComponentMetadata
+	i := 0
+	for j := len(nodes) - 1; j >= 0 && i < len(metadata); j-- {
+		n := nodes[j]
+		if !hasMetadata(n) {
+			continue
+		}
+		if kept(n) {
+			result = append(result, metadata[i])
+		}
+		i++
+	}
+	return append(result, metadata[i:]...)
+}
+
+// configureHandler applies the options that change how h collects diagnostics.
+func configureHandler(h *handler.Handler, opts *TransformOptions) {
+	if opts.OnDiagnostic != nil {
+		h.SetOnDiagnostic(opts.OnDiagnostic)
+	}
+	if opts.MaxDiagnostics > 0 {
+		h.SetMaxDiagnostics(opts.MaxDiagnostics)
+	}
+}
+
+// transformTree transforms the subtree of root, collecting metadata on doc. When root is doc,
+// the document-level passes are run too.
+func transformTree(doc *astro.Node, root *astro.Node, opts TransformOptions, h *handler.Handler) {
+	whole := root == doc
+	markImportedComponents(doc, root)
+	if opts.Normalize {
+		normalizeNames(root)
+	}
+	if len(opts.ComponentAliases) > 0 {
+		aliasComponents(root, opts.ComponentAliases, h)
+	}
+	removeDuplicateAttributes(root, h)
+	// Expressions are transformed and usages collected as authored, before the passes below add
+	// attributes or wrap the values of existing ones
+	walk(root, func(n *astro.Node) {
+		if opts.TransformExpression != nil {
+			transformExpressionAttributes(n, opts.TransformExpression, opts.ExpressionAttrDenylist)
+		}
+		collectComponentUsage(doc, n, &opts)
+	})
+	if opts.HoistInlineStyles && whole {
+		hoistInlineStyles(doc, &opts)
+	}
+	features := scanFeatures(root)
+	if features.directives {
+		hydrationPass(doc, root, opts, h)
+	}
+	if len(ComponentStyles(doc, &opts)) > 0 || features.noScope {
+		scopeStylesPass(doc, root, opts, h)
+	}
+	if opts.MergeScopedStyles && whole {
+		mergeScopedStyles(doc)
+	}
 	definedVars := GetDefineVars(doc.Styles)
+	if len(definedVars) > 1 && whole {
+		InfoAboutMultipleDefineVars(doc.Styles, h)
+	}
 	didAddDefinedVars := false
-	i := 0
-	walk(doc, func(n *astro.Node) {
+	// Transition scopes are derived from the position of elements in the document
+	i := walkIndex(doc, root)
+	walk(root, func(n *astro.Node) {
 		i++
 		WarnAboutRerunOnExternalESMs(n, h)
 		WarnAboutMisplacedReload(n, h)
+		WarnAboutImplicitlyClosedElement(n, h)
+		WarnAboutSelfClosingElement(n, h)
+		WarnAboutUnknownHydratedElement(n, &opts, h)
 		HintAboutImplicitInlineDirective(n, h)
-		ExtractScript(doc, n, &opts, h)
-		AddComponentProps(doc, n, &opts)
-		if shouldScope {
-			ScopeElement(n, opts)
-		}
 		if HasAttr(n, TRANSITION_ANIMATE) || HasAttr(n, TRANSITION_NAME) || HasAttr(n, TRANSITION_PERSIST) {
 			doc.Transition = true
 			doc.HeadPropagation = true
@@ -63,83 +296,691 @@ func Transform(doc *astro.Node, opts TransformOptions, h *handler.Handler) *astr
 				didAddDefinedVars = didAdd
 			}
 		}
+		collectRawHTML(doc, n, &opts, h)
 		mergeClassList(doc, n, &opts)
+		if opts.NormalizeBooleanAttributes {
+			normalizeBooleanAttributes(n)
+		}
 		if n.DataAtom == a.Head && !IsImplicitNode(n) {
 			doc.ContainsHead = true
 		}
+		detectContent(doc, n)
+		collectMetaTags(doc, n)
+		if opts.Site != "" {
+			// Root-relative URLs are resolved first, since only they take `Base` into account
+			if opts.ResolveRootURLs {
+				ResolveRootURLs(n, opts)
+			}
+			AbsolutizeMetadataURL(n, opts)
+			WarnAboutMixedContent(n, opts, h)
+		}
 		if opts.AnnotateSourceFile {
-			AnnotateElement(n, opts)
+			AnnotateElement(n, opts, h)
 		}
 	})
-	if len(definedVars) > 0 && !didAddDefinedVars {
+	if len(definedVars) > 0 && !didAddDefinedVars && whole {
 		for _, style := range doc.Styles {
-			for _, a := range style.Attr {
-				if a.Key == "define:vars" {
-					h.AppendWarning(&loc.ErrorWithRange{
-						Code:  loc.WARNING_CANNOT_DEFINE_VARS,
-						Text:  "Unable to inject `define:vars` declaration",
-						Range: loc.Range{Loc: a.KeyLoc, Len: len("define:vars")},
-						Hint:  "Try wrapping this component in an element so that Astro can inject a \"style\" attribute.",
-					})
-				}
+			if attr := GetAttr(style, "define:vars"); attr != nil {
+				h.AppendWarning(&loc.ErrorWithRange{
+					Code:  loc.WARNING_CANNOT_DEFINE_VARS,
+					Text:  "Unable to inject `define:vars` declaration",
+					Range: loc.Range{Loc: attr.KeyLoc, Len: len("define:vars")},
+					Hint:  "Try wrapping this component in an element so that Astro can inject a \"style\" attribute.",
+				})
 			}
 		}
 	}
-	NormalizeSetDirectives(doc, h)
+	if whole && opts.AuditViewportMeta {
+		InfoAboutMissingViewport(doc, h)
+	}
+	if features.scripts {
+		scriptExtractionPass(doc, root, opts, h)
+		if whole && !opts.RenderScript {
+			WarnAboutConflictingDeclarations(doc.Scripts, h)
+		}
+	}
+	NormalizeSetDirectives(root, h)
 
-	// Important! Remove scripts from original location *after* walking the doc
-	if !opts.RenderScript {
-		for _, script := range doc.Scripts {
-			script.Parent.RemoveChild(script)
+	if whole {
+		TrimTrailingSpace(doc)
+	}
+
+	if opts.Compact {
+		collapseWhitespace(root)
+	}
+
+	if opts.Minify {
+		minifyWhitespace(root)
+	}
+}
+
+// InfoAboutMultipleDefineVars reports when several styles use `define:vars`. Their variables are
+// merged into a single `style` attribute, so a variable defined twice silently takes the last value.
+func InfoAboutMultipleDefineVars(styles []*astro.Node, h *handler.Handler) {
+	attrs := make([]astro.Attribute, 0, len(styles))
+	for _, n := range styles {
+		if attr := GetAttr(n, "define:vars"); attr != nil && n.DataAtom == a.Style {
+			attrs = append(attrs, *attr)
 		}
 	}
+	if len(attrs) < 2 {
+		return
+	}
+	// Styles aren't necessarily collected in source order
+	slices.SortFunc(attrs, func(a, b astro.Attribute) int { return a.KeyLoc.Start - b.KeyLoc.Start })
+	values := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		switch attr.Type {
+		case astro.ExpressionAttribute:
+			values = append(values, fmt.Sprintf("{%s}", attr.Val))
+		default:
+			values = append(values, fmt.Sprintf("%q", attr.Val))
+		}
+	}
+	h.AppendInfo(&loc.ErrorWithRange{
+		Code:  loc.INFO,
+		Text:  fmt.Sprintf("%d <style> blocks use `define:vars` (%s). Their variables are merged, so a variable defined more than once takes the value of the last block.", len(attrs), strings.Join(values, ", ")),
+		Hint:  "Define all variables in a single `define:vars` to make the result explicit.",
+		Range: loc.Range{Loc: attrs[1].KeyLoc, Len: len("define:vars")},
+	})
+}
 
-	// If we've emptied out all the nodes, this was a Fragment that only contained hoisted elements
-	// Add an empty FrontmatterNode to allow the empty component to be printed
-	if doc.FirstChild == nil {
-		empty := &astro.Node{
-			Type: astro.FrontmatterNode,
+// resolveScope sets `Scope` to the scope that is added to elements. It falls back to a scope
+// derived from the filename, and replaces invalid characters.
+func resolveScope(doc *astro.Node, opts *TransformOptions, h *handler.Handler) {
+	if opts.Scope == "" {
+		resolveFallbackScope(doc, opts, h)
+	} else if scope, changed := SanitizeScope(opts.Scope); changed {
+		h.AppendWarning(&loc.ErrorWithRange{
+			Code: loc.WARNING_INVALID_SCOPE,
+			Text: fmt.Sprintf("The scope %q contains characters that are not valid in a CSS identifier, so `%s` is used instead.", opts.Scope, scope),
+			Hint: "Scopes may only contain letters, digits, `-` and `_`.",
+		})
+		opts.Scope = scope
+	}
+	doc.Scope = opts.Scope
+}
+
+// resolveFallbackScope derives the scope from `Filename` when no `Scope` was provided.
+// Without either, scoped styles can't be scoped, so a warning is emitted instead.
+func resolveFallbackScope(doc *astro.Node, opts *TransformOptions, h *handler.Handler) {
+	var style *astro.Node
+	for _, n := range ComponentStyles(doc, opts) {
+		if isScopedStyle(n) {
+			style = n
+			break
+		}
+	}
+	if opts.Filename != "" {
+		opts.Scope = ScopeHash(opts.Filename, opts.ProjectRoot)
+		if style != nil {
+			h.AppendInfo(&loc.ErrorWithRange{
+				Code:  loc.INFO,
+				Text:  fmt.Sprintf("No scope was provided, so the scope `%s` was derived from the filename.", opts.Scope),
+				Range: styleRange(style),
+			})
 		}
-		empty.AppendChild(&astro.Node{
-			Type: astro.TextNode,
-			Data: "",
+		return
+	}
+	if style != nil {
+		h.AppendWarning(&loc.ErrorWithRange{
+			Code:  loc.WARNING_MISSING_SCOPE,
+			Text:  "Styles could not be scoped because neither a scope nor a filename was provided.",
+			Hint:  "Pass a `filename` so that a scope can be derived from it.",
+			Range: styleRange(style),
 		})
-		doc.AppendChild(empty)
 	}
+}
 
-	TrimTrailingSpace(doc)
+func styleRange(n *astro.Node) loc.Range {
+	if len(n.Loc) == 0 {
+		return loc.Range{}
+	}
+	return loc.Range{Loc: n.Loc[0], Len: len(n.Data)}
+}
 
-	if opts.Compact {
-		collapseWhitespace(doc)
+// transformExpressionAttributes applies fn to the expression attributes of n. It runs before
+// the hydration and scoping passes, so fn only sees authored values.
+func transformExpressionAttributes(n *astro.Node, fn func(string) string, denylist []string) {
+	for i, attr := range n.Attr {
+		if attr.Type != astro.ExpressionAttribute {
+			continue
+		}
+		if slices.Contains(denylist, attr.Key) {
+			continue
+		}
+		n.Attr[i].Val = fn(attr.Val)
+	}
+}
+
+// Elements that only contribute document metadata, even outside of <head>
+var metadataElements = map[a.Atom]bool{
+	a.Base:  true,
+	a.Link:  true,
+	a.Meta:  true,
+	a.Title: true,
+}
+
+// detectContent records whether n renders content inside or outside of <head>.
+func detectContent(doc *astro.Node, n *astro.Node) {
+	switch n.Type {
+	case astro.ElementNode:
+		if n.DataAtom == a.Html || n.DataAtom == a.Head || n.DataAtom == a.Body {
+			return
+		}
+	case astro.TextNode:
+		if strings.TrimSpace(n.Data) == "" {
+			return
+		}
+	default:
+		return
+	}
+	if n.Parent == nil || n.Closest(func(p *astro.Node) bool { return p.Type == astro.FrontmatterNode }) != nil {
+		return
+	}
+	metadata := n.Parent.Closest(func(p *astro.Node) bool { return metadataElements[p.DataAtom] && !p.Component }) != nil
+	if metadata {
+		// Content of a metadata element is already accounted for
+		return
 	}
+	if (metadataElements[n.DataAtom] && !n.Component) || n.Closest(func(p *astro.Node) bool { return p.DataAtom == a.Head }) != nil {
+		doc.HasHeadContent = true
+	} else {
+		doc.HasBodyContent = true
+	}
+}
 
-	return doc
+// collectMetaTags records whether n is a `<meta>` that declares the encoding or the viewport.
+func collectMetaTags(doc *astro.Node, n *astro.Node) {
+	if n.Type != astro.ElementNode || n.DataAtom != a.Meta || n.Component {
+		return
+	}
+	if HasAttr(n, "charset") || strings.EqualFold(GetQuotedAttr(n, "http-equiv"), "content-type") {
+		doc.HasCharsetMeta = true
+	}
+	if strings.EqualFold(GetQuotedAttr(n, "name"), "viewport") {
+		doc.HasViewportMeta = true
+	}
 }
 
-func ExtractStyles(doc *astro.Node, opts *TransformOptions) {
+// InfoAboutMissingViewport reports a document that renders head content without a
+// `<meta name="viewport">`, which mobile browsers need to lay out the page at the device width.
+func InfoAboutMissingViewport(doc *astro.Node, h *handler.Handler) {
+	if !doc.HasHeadContent || doc.HasViewportMeta {
+		return
+	}
+	rng := loc.Range{}
+	if head := findExplicitHead(doc); head != nil && len(head.Loc) > 0 {
+		rng = loc.Range{Loc: head.Loc[0], Len: len(head.Data)}
+	}
+	h.AppendInfo(&loc.ErrorWithRange{
+		Code:  loc.INFO_MISSING_VIEWPORT,
+		Text:  "The document has head content, but no `<meta name=\"viewport\">`.",
+		Hint:  "Add `<meta name=\"viewport\" content=\"width=device-width\">` so that the page is laid out at the width of the device.",
+		Range: rng,
+	})
+}
+
+// findExplicitHead returns the first <head> written in the document, if any.
+func findExplicitHead(doc *astro.Node) *astro.Node {
+	var head *astro.Node
+	astro.Walk(doc, astro.VisitorFuncs{OnEnter: func(n *astro.Node) astro.WalkAction {
+		if n.DataAtom == a.Head && !IsImplicitNode(n) {
+			head = n
+			return astro.WalkStop
+		}
+		return astro.WalkContinue
+	}})
+	return head
+}
+
+// ScopeStylesPass scopes the hoisted styles of the document and adds the scope to every element they apply to.
+func ScopeStylesPass(doc *astro.Node, opts TransformOptions, h *handler.Handler) {
+	scopeStylesPass(doc, doc, opts, h)
+}
+
+// scopeStylesPass scopes the elements below root. The styles of doc are only scoped when root
+// is doc, otherwise they were already scoped when doc was transformed.
+func scopeStylesPass(doc *astro.Node, root *astro.Node, opts TransformOptions, h *handler.Handler) {
+	shouldScope := false
+	var targets *scopeTargets
+	if styles := ComponentStyles(doc, &opts); len(styles) > 0 && opts.Scope != "" {
+		if root == doc {
+			shouldScope, targets = scopeStyles(styles, opts, h)
+		} else {
+			shouldScope, targets = scopedStyleTargets(styles, opts)
+		}
+	}
+	walk(root, func(n *astro.Node) {
+		if shouldScope && (!opts.OptimizedScopes || targets.matches(n)) {
+			ScopeElement(n, opts)
+		}
+	})
+	// The opt-out marker should never reach the output
+	walk(root, func(n *astro.Node) {
+		n.RemoveAttribute(DATA_ASTRO_NOSCOPE)
+	})
+}
+
+// documentFeatures records which of the optional passes have anything to do.
+// Plain static markup has none of these and skips those passes entirely.
+type documentFeatures struct {
+	// Any `client:` or `server:` directive, handled by HydrationPass
+	directives bool
+	// Any `<script>` element, handled by ScriptExtractionPass
+	scripts bool
+	// Any `data-astro-noscope` marker, removed by ScopeStylesPass
+	noScope bool
+}
+
+func scanFeatures(doc *astro.Node) documentFeatures {
+	var features documentFeatures
 	walk(doc, func(n *astro.Node) {
-		if n.Type == astro.ElementNode && n.DataAtom == a.Style {
-			if HasSetDirective(n) || HasInlineDirective(n) {
-				return
+		if n.Type != astro.ElementNode {
+			return
+		}
+		if n.DataAtom == a.Script {
+			features.scripts = true
+		}
+		for _, attr := range n.Attr {
+			if strings.HasPrefix(attr.Key, "client:") || strings.HasPrefix(attr.Key, "server:") {
+				features.directives = true
+			} else if attr.Key == DATA_ASTRO_NOSCOPE {
+				features.noScope = true
 			}
-			// Ignore styles in svg/noscript/etc
-			if !IsHoistable(n, false) {
-				return
+		}
+	})
+	return features
+}
+
+// HydrationPass adds the attributes needed to hydrate components with `client:` and `server:` directives
+// and collects them on the document.
+func HydrationPass(doc *astro.Node, opts TransformOptions, h *handler.Handler) {
+	hydrationPass(doc, doc, opts, h)
+}
+
+func hydrationPass(doc *astro.Node, root *astro.Node, opts TransformOptions, h *handler.Handler) {
+	walk(root, func(n *astro.Node) {
+		// Components inside of a <noscript> are only rendered when scripts are disabled, so they never hydrate
+		if isInsideNoscript(n) {
+			WarnAboutHydrationInNoscript(n, &opts, h)
+			removeHydrationDirectives(n, &opts)
+			return
+		}
+		WarnAboutUnknownDirective(n, &opts, h)
+		WarnAboutHydratedFragment(n, h)
+		AddComponentProps(doc, n, &opts)
+		ErrorAboutUnresolvedCustomElement(n, h)
+	})
+}
+
+// ScriptExtractionPass hoists processed scripts to `doc.Scripts`, or to `doc.ExternalScripts`
+// for hoisted scripts with a `src`. Unless `RenderScript` is enabled,
+// they are also removed from their original location.
+func ScriptExtractionPass(doc *astro.Node, opts TransformOptions, h *handler.Handler) {
+	scriptExtractionPass(doc, doc, opts, h)
+}
+
+// scriptExtractionPass hoists the scripts below root. Only those are removed, scripts hoisted
+// from elsewhere in doc were already removed.
+func scriptExtractionPass(doc *astro.Node, root *astro.Node, opts TransformOptions, h *handler.Handler) {
+	walk(root, func(n *astro.Node) {
+		ExtractScript(doc, n, &opts, h)
+	})
+
+	// Important! Remove scripts from original location *after* walking the doc
+	if !opts.RenderScript {
+		inRoot := func(n *astro.Node) bool {
+			return root == doc || n.Closest(func(p *astro.Node) bool { return p == root }) != nil
+		}
+		for _, script := range doc.Scripts {
+			if inRoot(script) {
+				removeHoistedNode(script, h)
 			}
-			// append node to maintain authored order
-			if opts.ExperimentalScriptOrder {
-				doc.Styles = append(doc.Styles, n)
-			} else {
-				doc.Styles = append([]*astro.Node{n}, doc.Styles...)
+		}
+		for _, script := range doc.ExternalScripts {
+			if inRoot(script) {
+				removeHoistedNode(script, h)
 			}
 		}
-	})
+	}
+}
+
+// WarnAboutConflictingDeclarations reports top-level bindings declared by more than one of the
+// hoisted scripts. Each script is bundled as its own module, but if the scripts are concatenated,
+// such a binding would be declared twice. Only `var` may be declared again, so those are not
+// reported.
+func WarnAboutConflictingDeclarations(scripts []*astro.Node, h *handler.Handler) {
+	bodies := make([]*astro.Node, 0, len(scripts))
+	for _, n := range scripts {
+		if n.FirstChild != nil && n.FirstChild.Type == astro.TextNode && !HasAttr(n, "src") {
+			bodies = append(bodies, n.FirstChild)
+		}
+	}
+	if len(bodies) < 2 {
+		return
+	}
+	start := func(n *astro.Node) int {
+		if len(n.Loc) == 0 {
+			return 0
+		}
+		return n.Loc[0].Start
+	}
+	// Scripts aren't necessarily collected in source order
+	slices.SortFunc(bodies, func(a, b *astro.Node) int { return start(a) - start(b) })
+	declared := make(map[string]js_scanner.Declaration)
+	for _, body := range bodies {
+		// A binding declared twice in the same script is an error of its own
+		local := make(map[string]bool)
+		for _, d := range js_scanner.ScanTopLevelDeclarations([]byte(body.Data)) {
+			if local[d.Name] {
+				continue
+			}
+			local[d.Name] = true
+			first, ok := declared[d.Name]
+			if !ok {
+				declared[d.Name] = d
+				continue
+			}
+			if first.Kind == "var" && d.Kind == "var" {
+				continue
+			}
+			h.AppendWarning(&loc.ErrorWithRange{
+				Code:  loc.WARNING_CONFLICTING_DECLARATION,
+				Text:  fmt.Sprintf("`%s` is declared at the top level of several hoisted scripts, which may conflict if the scripts are concatenated.", d.Name),
+				Hint:  "Rename one of the declarations, or wrap the script in a block to keep its bindings local.",
+				Range: loc.Range{Loc: loc.Loc{Start: start(body) + d.Start}, Len: len(d.Name)},
+			})
+		}
+	}
+}
+
+func ExtractStyles(doc *astro.Node, opts *TransformOptions, h *handler.Handler) {
+	configureHandler(h, opts)
+	astro.Walk(doc, astro.VisitorFuncs{OnEnter: func(n *astro.Node) astro.WalkAction {
+		if n.Type != astro.ElementNode || n.DataAtom != a.Style {
+			return astro.WalkContinue
+		}
+		// Ignore directives and styles in svg/noscript/etc
+		if isExtractableStyle(n) {
+			if opts.KeepStylesInPlace && !HasAttr(n, "define:vars") {
+				return astro.WalkSkipChildren
+			}
+			if InExpression(n) {
+				h.AppendWarning(&loc.ErrorWithRange{
+					Code:  loc.WARNING_ELEMENT_IN_EXPRESSION,
+					Text:  "This <style> is inside an expression, but styles are always hoisted: it applies whether or not the expression renders it.",
+					Hint:  "Move the <style> out of the expression, or add the `is:inline` directive to render it in place.",
+					Range: loc.Range{Loc: n.Loc[0], Len: len(n.Data)},
+				})
+			}
+			doc.StyleImports = append(doc.StyleImports, extractStyleImports(n, opts.RemoveStyleImports)...)
+			// append node to maintain authored order
+			doc.Styles = append(doc.Styles, n)
+		}
+		// The contents of a style are only text
+		return astro.WalkSkipChildren
+	}})
 	// Important! Remove styles from original location *after* walking the doc
 	for _, style := range doc.Styles {
-		style.Parent.RemoveChild(style)
+		removeHoistedNode(style, h)
 	}
 }
 
+// isExtractableStyle reports whether n is a `<style>` of the component, as opposed to one that
+// is rendered as authored, like `is:inline` styles or those inside of an <svg>.
+func isExtractableStyle(n *astro.Node) bool {
+	return n.Type == astro.ElementNode && n.DataAtom == a.Style && !HasSetDirective(n) && !HasInlineDirective(n) && IsHoistable(n)
+}
+
+// ComponentStyles returns the styles that apply to the elements of doc: those extracted to
+// `doc.Styles` and, when `KeepStylesInPlace` is enabled, those left in the template, in
+// document order.
+func ComponentStyles(doc *astro.Node, opts *TransformOptions) []*astro.Node {
+	if !opts.KeepStylesInPlace {
+		return doc.Styles
+	}
+	var styles []*astro.Node
+	astro.Walk(doc, astro.VisitorFuncs{OnEnter: func(n *astro.Node) astro.WalkAction {
+		if n.Type != astro.ElementNode || n.DataAtom != a.Style {
+			return astro.WalkContinue
+		}
+		if isExtractableStyle(n) {
+			styles = append(styles, n)
+		}
+		return astro.WalkSkipChildren
+	}})
+	return append(styles, doc.Styles...)
+}
+
+// AppendStyle adds a `<style>` with the given CSS to `doc.Styles`, as if it had been
+// extracted from the template. It is added after the styles already in `doc.Styles`, so
+// call it after ExtractStyles. Unless scoped, it is marked `is:global`.
+func AppendStyle(doc *astro.Node, css string, scoped bool) *astro.Node {
+	style := &astro.Node{
+		Type:     astro.ElementNode,
+		DataAtom: a.Style,
+		Data:     "style",
+		Loc:      []loc.Loc{{Start: 0}},
+	}
+	if !scoped {
+		style.Attr = append(style.Attr, astro.Attribute{Key: "is:global", Type: astro.EmptyAttribute})
+	}
+	style.AppendChild(&astro.Node{
+		Type: astro.TextNode,
+		Data: css,
+		Loc:  []loc.Loc{{Start: 0}},
+	})
+	doc.Styles = append(doc.Styles, style)
+	return style
+}
+
+// hoistInlineStyles replaces quoted `style` attributes that appear verbatim on more
+// than one element with a generated class. The rules for these classes are added to
+// `doc.Styles` as a single style, so they are scoped like any other style.
+func hoistInlineStyles(doc *astro.Node, opts *TransformOptions) {
+	groups := make(map[string][]*astro.Node)
+	var values []string
+	walk(doc, func(n *astro.Node) {
+		if !canHoistInlineStyle(n, opts) {
+			return
+		}
+		style := GetAttr(n, "style").Val
+		if _, ok := groups[style]; !ok {
+			values = append(values, style)
+		}
+		groups[style] = append(groups[style], n)
+	})
+
+	var rules strings.Builder
+	var start loc.Loc
+	for _, style := range values {
+		nodes := groups[style]
+		if len(nodes) < 2 {
+			continue
+		}
+		if rules.Len() == 0 {
+			start = GetAttr(nodes[0], "style").KeyLoc
+		}
+		class := "astro-style-" + astro.HashString(style)
+		for _, n := range nodes {
+			replaceStyleWithClass(n, class)
+		}
+		fmt.Fprintf(&rules, ".%s{%s}", class, style)
+	}
+	if rules.Len() == 0 {
+		return
+	}
+	style := &astro.Node{
+		Type:     astro.ElementNode,
+		DataAtom: a.Style,
+		Data:     "style",
+		Loc:      []loc.Loc{start},
+	}
+	style.AppendChild(&astro.Node{
+		Type: astro.TextNode,
+		Data: rules.String(),
+		Loc:  []loc.Loc{start},
+	})
+	doc.Styles = append(doc.Styles, style)
+}
+
+// canHoistInlineStyle reports whether the `style` of n can be moved to a class. The
+// element must receive the scope, and its class must be known statically.
+func canHoistInlineStyle(n *astro.Node, opts *TransformOptions) bool {
+	if n.Type != astro.ElementNode || n.Component || n.Fragment || isExtraComponentTag(n, opts) {
+		return false
+	}
+	if isNeverScoped(n) || HasAttr(n, DATA_ASTRO_NOSCOPE) || n.Closest(isNoScopeSubtree) != nil {
+		return false
+	}
+	hasStyle := false
+	for _, attr := range n.Attr {
+		switch {
+		case attr.Type == astro.SpreadAttribute || attr.Key == "class:list":
+			return false
+		case attr.Key == "class":
+			if attr.Type != astro.QuotedAttribute && attr.Type != astro.EmptyAttribute {
+				return false
+			}
+		case attr.Key == "style":
+			// Anything that could break out of the generated rule, like braces, comments and
+			// escapes, is left alone
+			if attr.Type != astro.QuotedAttribute || strings.TrimSpace(attr.Val) == "" || strings.ContainsAny(attr.Val, "{}<\\") || strings.Contains(attr.Val, "/*") {
+				return false
+			}
+			hasStyle = true
+		}
+	}
+	return hasStyle
+}
+
+// replaceStyleWithClass adds class to the class list of n and drops its `style`.
+// Without a class attribute, the style attribute is reused so it keeps its position.
+func replaceStyleWithClass(n *astro.Node, class string) {
+	i := AttrIndex(n, "class")
+	if i == -1 {
+		if j := AttrIndex(n, "style"); j != -1 {
+			style := n.Attr[j]
+			n.Attr[j] = astro.Attribute{Key: "class", KeyLoc: style.KeyLoc, Val: class, ValLoc: style.ValLoc, Type: astro.QuotedAttribute}
+		}
+		return
+	}
+	attr := n.Attr[i]
+	if attr.Type == astro.EmptyAttribute || strings.TrimSpace(attr.Val) == "" {
+		attr.Val = class
+	} else {
+		attr.Val += " " + class
+	}
+	attr.Type = astro.QuotedAttribute
+	n.Attr[i] = attr
+	RemoveAttr(n, "style")
+}
+
+// extractStyleImports returns the specifiers of the top-level `@import` rules of a
+// <style>, in both the `@import "x"` and `@import url("x")` forms. Imports with media,
+// layer or supports conditions are left alone, as are styles written in another
+// language (e.g. `lang="scss"`) whose preprocessor handles `@import` itself. When
+// remove is true, the collected rules are removed from the style.
+func extractStyleImports(n *astro.Node, remove bool) []string {
+	if n.FirstChild == nil || !strings.Contains(n.FirstChild.Data, "@import") {
+		return nil
+	}
+	if lang := GetAttr(n, "lang"); lang != nil && lang.Val != "css" {
+		return nil
+	}
+	css := n.FirstChild.Data
+	tokens := css_lexer.Tokenize(logger.Log{AddMsg: func(msg logger.Msg) {}}, logger.Source{Contents: css}).Tokens
+	next := func(i int) int {
+		for i < len(tokens) && tokens[i].Kind == css_lexer.TWhitespace {
+			i++
+		}
+		return i
+	}
+	specifiers := make([]string, 0)
+	removed := make([]logger.Range, 0)
+	depth := 0
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i].Kind {
+		case css_lexer.TOpenBrace:
+			depth++
+			continue
+		case css_lexer.TCloseBrace:
+			depth--
+			continue
+		}
+		if depth != 0 || tokens[i].Kind != css_lexer.TAtKeyword || !strings.EqualFold(tokens[i].DecodedText(css), "import") {
+			continue
+		}
+		j := next(i + 1)
+		if j >= len(tokens) {
+			break
+		}
+		specifier := ""
+		switch {
+		case tokens[j].Kind == css_lexer.TString || tokens[j].Kind == css_lexer.TURL:
+			specifier = tokens[j].DecodedText(css)
+		case tokens[j].Kind == css_lexer.TFunction && strings.EqualFold(tokens[j].DecodedText(css), "url"):
+			j = next(j + 1)
+			if j >= len(tokens) || tokens[j].Kind != css_lexer.TString {
+				continue
+			}
+			specifier = tokens[j].DecodedText(css)
+			j = next(j + 1)
+			if j >= len(tokens) || tokens[j].Kind != css_lexer.TCloseParen {
+				continue
+			}
+		default:
+			continue
+		}
+		end := next(j + 1)
+		if end < len(tokens) && tokens[end].Kind != css_lexer.TSemicolon {
+			continue
+		}
+		specifiers = append(specifiers, specifier)
+		r := logger.Range{Loc: tokens[i].Range.Loc, Len: int32(len(css)) - tokens[i].Range.Loc.Start}
+		if end < len(tokens) {
+			r.Len = tokens[end].Range.End() - r.Loc.Start
+		}
+		removed = append(removed, r)
+		i = end
+	}
+	if remove && len(removed) > 0 {
+		var b strings.Builder
+		last := 0
+		for _, r := range removed {
+			b.WriteString(css[last:r.Loc.Start])
+			last = int(r.End())
+		}
+		b.WriteString(css[last:])
+		n.FirstChild.Data = b.String()
+	}
+	return specifiers
+}
+
+// removeHoistedNode removes a hoisted <style> or <script> from its original location.
+// Malformed trees can contain nodes without a parent, which are skipped with a warning.
+func removeHoistedNode(n *astro.Node, h *handler.Handler) {
+	if n.Parent == nil {
+		var r loc.Range
+		if len(n.Loc) > 0 {
+			r = loc.Range{Loc: n.Loc[0], Len: len(n.Data)}
+		}
+		h.AppendWarning(&loc.ErrorWithRange{
+			Code:  loc.WARNING_DETACHED_NODE,
+			Text:  fmt.Sprintf("Unable to remove <%s> from its original location because it has no parent element.", n.Data),
+			Range: r,
+		})
+		return
+	}
+	// An element rendered by an expression is a value of it, e.g. `{show && <style>...</style>}`,
+	// so it is replaced with a value that renders nothing to keep the expression valid
+	if n.Parent.Expression {
+		n.Parent.InsertBefore(&astro.Node{Type: astro.TextNode, Data: "null", Loc: n.Loc}, n)
+	}
+	n.Parent.RemoveChild(n)
+}
+
 func NormalizeSetDirectives(doc *astro.Node, h *handler.Handler) {
 	var nodes []*astro.Node
 	var directives []*astro.Attribute
@@ -272,13 +1113,11 @@ func TrimTrailingSpace(doc *astro.Node) {
 }
 
 func isRawElement(n *astro.Node) bool {
-	if n.Type == astro.FrontmatterNode {
+	if n.Type == astro.FrontmatterNode || n.DataAtom == a.Noscript {
 		return true
 	}
-	for _, attr := range n.Attr {
-		if attr.Key == "is:raw" {
-			return true
-		}
+	if HasAttr(n, "is:raw") {
+		return true
 	}
 	rawTags := []string{"pre", "listing", "iframe", "noembed", "noframes", "math", "plaintext", "script", "style", "textarea", "title", "xmp"}
 	for _, tag := range rawTags {
@@ -294,110 +1133,411 @@ func isWhitespaceInsensitiveElement(n *astro.Node) bool {
 }
 
 func collapseWhitespace(doc *astro.Node) {
-	walk(doc, func(n *astro.Node) {
-		if n.Type == astro.TextNode {
-			// Don't trim any whitespace if the node or any of its ancestors is raw
-			if n.Closest(isRawElement) != nil {
-				return
-			}
+	astro.Walk(doc, astro.VisitorFuncs{OnEnter: func(n *astro.Node) astro.WalkAction {
+		switch {
+		case n.Type == astro.TextNode:
+			collapseTextWhitespace(n)
+		case isRawElement(n):
+			// Don't trim any whitespace inside of raw elements
+			return astro.WalkSkipChildren
+		}
+		return astro.WalkContinue
+	}})
+}
 
-			// Trim the whitespace on each end of top-level expressions
-			if n.Parent != nil && n.Parent.Expression {
-				// Trim left whitespace in the first child
-				if n.PrevSibling == nil {
-					n.Data = strings.TrimLeftFunc(n.Data, unicode.IsSpace)
-				}
-				// Trim right whitespace in the last child
-				if n.NextSibling == nil {
-					n.Data = strings.TrimRightFunc(n.Data, unicode.IsSpace)
-				}
-				// Don't trim any more!
-				return
+func collapseTextWhitespace(n *astro.Node) {
+	// Trim the whitespace on each end of top-level expressions
+	if n.Parent != nil && n.Parent.Expression {
+		// Trim left whitespace in the first child
+		if n.PrevSibling == nil {
+			n.Data = strings.TrimLeftFunc(n.Data, unicode.IsSpace)
+		}
+		// Trim right whitespace in the last child
+		if n.NextSibling == nil {
+			n.Data = strings.TrimRightFunc(n.Data, unicode.IsSpace)
+		}
+		// Don't trim any more!
+		return
+	}
+
+	// If the node is only whitespace, clear it
+	if len(strings.TrimFunc(n.Data, unicode.IsSpace)) == 0 {
+		// If it's a lone text node, or if it's within a whitespace-insensitive element, clear completely
+		if (n.PrevSibling == nil && n.NextSibling == nil) || n.Closest(isWhitespaceInsensitiveElement) != nil {
+			n.Data = ""
+		} else {
+			n.Data = " "
+		}
+		return
+	}
+
+	// Collapse left whitespace into a single space
+	originalLen := len(n.Data)
+	hasNewline := false
+	n.Data = strings.TrimLeftFunc(n.Data, func(r rune) bool {
+		if r == '\n' {
+			hasNewline = true
+		}
+		return unicode.IsSpace(r)
+	})
+	if originalLen != len(n.Data) {
+		if hasNewline {
+			n.Data = "\n" + n.Data
+		} else {
+			n.Data = " " + n.Data
+		}
+	}
+	// Collapse right whitespace into a single space
+	originalLen = len(n.Data)
+	hasNewline = false
+	n.Data = strings.TrimRightFunc(n.Data, func(r rune) bool {
+		if r == '\n' {
+			hasNewline = true
+		}
+		return unicode.IsSpace(r)
+	})
+	if originalLen != len(n.Data) {
+		if hasNewline {
+			n.Data = n.Data + "\n"
+		} else {
+			n.Data = n.Data + " "
+		}
+	}
+}
+
+var whitespaceRunExp = regexp.MustCompile(`\s+`)
+
+func isExpressionNode(n *astro.Node) bool {
+	return n.Expression
+}
+
+// minifyWhitespace collapses runs of whitespace in text nodes to a single space,
+// and trims whitespace entirely at the start and end of an element's children.
+// Raw elements (e.g. <pre>, <textarea>, <script>, <style>) and expressions are left alone.
+func minifyWhitespace(doc *astro.Node) {
+	astro.Walk(doc, astro.VisitorFuncs{OnEnter: func(n *astro.Node) astro.WalkAction {
+		if n.Type != astro.TextNode {
+			if isRawElement(n) || isExpressionNode(n) {
+				return astro.WalkSkipChildren
 			}
+			return astro.WalkContinue
+		}
+		data := whitespaceRunExp.ReplaceAllString(n.Data, " ")
+		if n.PrevSibling == nil {
+			data = strings.TrimLeftFunc(data, unicode.IsSpace)
+		}
+		if n.NextSibling == nil {
+			data = strings.TrimRightFunc(data, unicode.IsSpace)
+		}
+		n.Data = data
+		return astro.WalkContinue
+	}})
+}
 
-			// If the node is only whitespace, clear it
-			if len(strings.TrimFunc(n.Data, unicode.IsSpace)) == 0 {
-				// If it's a lone text node, or if it's within a whitespace-insensitive element, clear completely
-				if (n.PrevSibling == nil && n.NextSibling == nil) || n.Closest(isWhitespaceInsensitiveElement) != nil {
-					n.Data = ""
-				} else {
-					n.Data = " "
-				}
+// normalizeNames lowercases the tag names and attribute keys of HTML elements.
+// Components, custom elements and foreign (SVG/MathML) elements are case-sensitive and left alone,
+// as are directives (e.g. `client:load`) and expression attributes.
+func normalizeNames(doc *astro.Node) {
+	walk(doc, func(n *astro.Node) {
+		if n.Type != astro.ElementNode || n.CustomElement || n.Fragment || n.Expression || n.Namespace != "" {
+			return
+		}
+		if strings.Contains(n.Data, "-") {
+			return
+		}
+		if name := strings.ToLower(n.Data); name != n.Data {
+			// The parser treats capitalized tags as components, but an uppercase
+			// HTML tag name (e.g. `<DIV>`) is still an element
+			atom := a.Lookup([]byte(name))
+			if atom == 0 || n.Data != strings.ToUpper(n.Data) {
 				return
 			}
+			n.Data = name
+			n.DataAtom = atom
+			n.Component = false
+		} else if n.Component {
+			return
+		}
+		for i, attr := range n.Attr {
+			if attr.Type != astro.QuotedAttribute && attr.Type != astro.EmptyAttribute {
+				continue
+			}
+			if strings.Contains(attr.Key, ":") {
+				continue
+			}
+			n.Attr[i].Key = strings.ToLower(attr.Key)
+		}
+	})
+}
 
-			// Collapse left whitespace into a single space
-			originalLen := len(n.Data)
-			hasNewline := false
-			n.Data = strings.TrimLeftFunc(n.Data, func(r rune) bool {
-				if r == '\n' {
-					hasNewline = true
-				}
-				return unicode.IsSpace(r)
+func WarnAboutMisplacedReload(n *astro.Node, h *handler.Handler) {
+	if HasAttr(n, DATA_ASTRO_RELOAD) {
+		attr := &n.Attr[AttrIndex(n, DATA_ASTRO_RELOAD)]
+
+		/*
+		 * When set on <a>, <form> or <area>,
+		 * the data-astro-reload attribute replaces view transitions between pages with a full page loads.
+		 */
+
+		if n.Type != astro.ElementNode || n.Data != "a" && n.Data != "area" && n.Data != "form" {
+			h.AppendWarning(&loc.ErrorWithRange{
+				Code:  loc.WARNING,
+				Text:  "The data-astro-reload attribute is only supported on <a>, <form> and <area> elements.",
+				Range: loc.Range{Loc: attr.KeyLoc, Len: len(attr.Key)},
+			})
+		}
+	}
+}
+
+func WarnAboutImplicitlyClosedElement(n *astro.Node, h *handler.Handler) {
+	if n.Type == astro.ElementNode && n.ImplicitlyClosed && len(n.Loc) > 0 {
+		h.AppendWarning(&loc.ErrorWithRange{
+			Code:  loc.WARNING_IMPLICITLY_CLOSED_ELEMENT,
+			Text:  fmt.Sprintf("<%s> is never closed and was closed automatically.", n.Data),
+			Hint:  fmt.Sprintf("Add a closing </%s> tag where this element is meant to end.", n.Data),
+			Range: loc.Range{Loc: n.Loc[0], Len: len(n.Data)},
+		})
+	}
+}
+
+// WarnAboutSelfClosingElement warns about HTML elements that aren't void, but are written with a
+// self-closing tag. Browsers ignore the slash and would put the following siblings inside of the
+// element, while Astro closes it immediately. Slots and elements whose content is set with a
+// directive are commonly written this way and aren't reported.
+func WarnAboutSelfClosingElement(n *astro.Node, h *handler.Handler) {
+	if n.Type != astro.ElementNode || !n.SelfClosing || n.Namespace != "" || len(n.Loc) == 0 {
+		return
+	}
+	if n.Component || n.CustomElement || n.Fragment || n.Expression || astro.IsVoidElement(n.Data) {
+		return
+	}
+	if n.DataAtom == a.Slot || HasSetDirective(n) {
+		return
+	}
+	h.AppendWarning(&loc.ErrorWithRange{
+		Code:  loc.WARNING_SELF_CLOSING_ELEMENT,
+		Text:  fmt.Sprintf("<%s /> is not a void element, so it is closed immediately, unlike in HTML.", n.Data),
+		Hint:  fmt.Sprintf("Write <%s></%s> to make the end of the element explicit.", n.Data, n.Data),
+		Range: loc.Range{Loc: n.Loc[0], Len: len(n.Data)},
+	})
+}
+
+func WarnAboutRerunOnExternalESMs(n *astro.Node, h *handler.Handler) {
+	if n.Data == "script" && HasAttr(n, "src") && HasAttr(n, "type") && HasAttr(n, "data-astro-rerun") {
+
+		/*
+		 * The browser caches external ECMAScript Modules. Even if such a script is included several times on a page,
+		 * it will only run once. This means that the data-astro-rerun attribute will not have any effect.
+		 */
+		src := &n.Attr[AttrIndex(n, "src")]
+		typ := &n.Attr[AttrIndex(n, "type")]
+		rerun := &n.Attr[AttrIndex(n, "data-astro-rerun")]
+		if typ.Val == "module" && src.Val != "" {
+			h.AppendWarning(&loc.ErrorWithRange{
+				Code:  loc.WARNING_CANNOT_RERUN,
+				Text:  "The data-astro-rerun attribute is not supported on an external module <script>",
+				Hint:  "Two out of three is OK: type=\"module\", src=\"...\", or data-astro-rerun",
+				Range: loc.Range{Loc: rerun.KeyLoc, Len: len(rerun.Key)},
+			})
+		}
+	}
+}
+
+// WarnAboutHydrationInNoscript warns when a component inside of a <noscript> uses a
+// `client:` directive, since its content is only rendered when scripts are disabled.
+func WarnAboutHydrationInNoscript(n *astro.Node, opts *TransformOptions, h *handler.Handler) {
+	if n.Type != astro.ElementNode || !(n.Component || n.CustomElement || isExtraComponentTag(n, opts)) {
+		return
+	}
+	for _, attr := range n.Attr {
+		if strings.HasPrefix(attr.Key, "client:") {
+			h.AppendWarning(&loc.ErrorWithRange{
+				Code:  loc.WARNING_HYDRATION_IN_NOSCRIPT,
+				Text:  fmt.Sprintf("<%s> is inside of a <noscript>, so the %s directive will be ignored.", n.Data, attr.Key),
+				Hint:  "The content of a <noscript> is only rendered when scripts are disabled, so it can't be hydrated.",
+				Range: loc.Range{Loc: attr.KeyLoc, Len: len(attr.Key)},
+			})
+			return
+		}
+	}
+}
+
+// removeHydrationDirectives drops the `client:` directives of a component that is never hydrated.
+// Left in place, they would be printed without the hydration props the runtime needs to render them.
+func removeHydrationDirectives(n *astro.Node, opts *TransformOptions) {
+	if n.Type != astro.ElementNode || !(n.Component || n.CustomElement || isExtraComponentTag(n, opts)) {
+		return
+	}
+	n.Attr = slices.DeleteFunc(n.Attr, func(attr astro.Attribute) bool {
+		return strings.HasPrefix(attr.Key, "client:")
+	})
+}
+
+// WarnAboutHydratedFragment warns when a fragment uses a `client:` directive. A fragment only
+// renders its children, so there is no component to hydrate and the directive is ignored.
+func WarnAboutHydratedFragment(n *astro.Node, h *handler.Handler) {
+	if n.Type != astro.ElementNode || !n.Fragment {
+		return
+	}
+	for _, attr := range n.Attr {
+		if strings.HasPrefix(attr.Key, "client:") {
+			h.AppendWarning(&loc.ErrorWithRange{
+				Code:  loc.WARNING_IGNORED_DIRECTIVE,
+				Text:  fmt.Sprintf("<Fragment> can't be hydrated, so the %s directive will be ignored.", attr.Key),
+				Hint:  "Add the directive to the components inside of the fragment instead.",
+				Range: loc.Range{Loc: attr.KeyLoc, Len: len(attr.Key)},
 			})
-			if originalLen != len(n.Data) {
-				if hasNewline {
-					n.Data = "\n" + n.Data
-				} else {
-					n.Data = " " + n.Data
-				}
+		}
+	}
+}
+
+// removeDuplicateAttributes warns about attributes that are set more than once on the same
+// element and removes all but the last one, which wins like in JSX. It runs before the other
+// passes, so that they only ever see the value that is rendered.
+func removeDuplicateAttributes(doc *astro.Node, h *handler.Handler) {
+	walk(doc, func(n *astro.Node) {
+		if n.Type != astro.ElementNode || len(n.Attr) < 2 {
+			return
+		}
+		html := !n.Component && !n.CustomElement && !n.Fragment
+		last := make(map[string]int, len(n.Attr))
+		for i, attr := range n.Attr {
+			if attr.Type != astro.SpreadAttribute {
+				last[duplicateAttributeKey(attr.Key, html)] = i
 			}
-			// Collapse right whitespace into a single space
-			originalLen = len(n.Data)
-			hasNewline = false
-			n.Data = strings.TrimRightFunc(n.Data, func(r rune) bool {
-				if r == '\n' {
-					hasNewline = true
-				}
-				return unicode.IsSpace(r)
-			})
-			if originalLen != len(n.Data) {
-				if hasNewline {
-					n.Data = n.Data + "\n"
-				} else {
-					n.Data = n.Data + " "
+		}
+		if len(last) == len(n.Attr) {
+			return
+		}
+		attrs := n.Attr[:0]
+		for i, attr := range n.Attr {
+			if attr.Type != astro.SpreadAttribute {
+				if j := last[duplicateAttributeKey(attr.Key, html)]; j != i {
+					line, column := h.LineAndColumn(n.Attr[j].KeyLoc)
+					h.AppendWarning(&loc.ErrorWithRange{
+						Code:  loc.WARNING_DUPLICATE_ATTRIBUTE,
+						Text:  fmt.Sprintf("Duplicate attribute `%s`. It is overridden by `%s` at %d:%d.", attr.Key, n.Attr[j].Key, line, column),
+						Hint:  "Only the last value of an attribute is rendered.",
+						Range: loc.Range{Loc: attr.KeyLoc, Len: len(attr.Key)},
+					})
+					continue
 				}
 			}
+			attrs = append(attrs, attr)
 		}
+		n.Attr = attrs
 	})
 }
 
-func WarnAboutMisplacedReload(n *astro.Node, h *handler.Handler) {
-	if HasAttr(n, DATA_ASTRO_RELOAD) {
-		attr := &n.Attr[AttrIndex(n, DATA_ASTRO_RELOAD)]
+// duplicateAttributeKey returns the key that attributes are compared by when looking for
+// duplicates. HTML lowercases `data-*` attributes, so their case doesn't matter on HTML elements.
+func duplicateAttributeKey(key string, html bool) string {
+	if html && len(key) > 5 && strings.EqualFold(key[:5], "data-") {
+		return strings.ToLower(key)
+	}
+	return key
+}
 
-		/*
-		 * When set on <a>, <form> or <area>,
-		 * the data-astro-reload attribute replaces view transitions between pages with a full page loads.
-		 */
+// Attribute key prefixes of directives, which aren't passed to components as props
+var directivePrefixes = []string{"client:", "server:", "set:", "is:", "transition:"}
 
-		if n.Type != astro.ElementNode || n.Data != "a" && n.Data != "area" && n.Data != "form" {
-			h.AppendWarning(&loc.ErrorWithRange{
-				Code:  loc.WARNING,
-				Text:  "The data-astro-reload attribute is only supported on <a>, <form> and <area> elements.",
-				Range: loc.Range{Loc: attr.KeyLoc, Len: len(attr.Key)},
-			})
+// collectComponentUsage records the attribute keys passed to a component or custom element
+// in `doc.ComponentUsages`, as authored: it runs before the hydration and scoping passes.
+func collectComponentUsage(doc *astro.Node, n *astro.Node, opts *TransformOptions) {
+	if n.Type != astro.ElementNode || !(n.Component || n.CustomElement || isExtraComponentTag(n, opts)) {
+		return
+	}
+	usage := astro.ComponentUsage{Name: n.Data, Attrs: []string{}, Directives: []string{}}
+	if len(n.Loc) > 0 {
+		usage.Pos = n.Loc[0]
+	}
+	for _, attr := range n.Attr {
+		switch {
+		case attr.Type == astro.SpreadAttribute:
+			usage.HasSpread = true
+		case slices.ContainsFunc(directivePrefixes, func(prefix string) bool { return strings.HasPrefix(attr.Key, prefix) }):
+			usage.Directives = append(usage.Directives, attr.Key)
+		default:
+			usage.Attrs = append(usage.Attrs, attr.Key)
 		}
 	}
+	doc.ComponentUsages = append(doc.ComponentUsages, usage)
 }
 
-func WarnAboutRerunOnExternalESMs(n *astro.Node, h *handler.Handler) {
-	if n.Data == "script" && HasAttr(n, "src") && HasAttr(n, "type") && HasAttr(n, "data-astro-rerun") {
+// Hydration directives built into Astro. Any other `client:` directive is still passed through,
+// since integrations can register their own.
+var knownClientDirectives = []string{"load", "idle", "visible", "media", "only"}
 
-		/*
-		 * The browser caches external ECMAScript Modules. Even if such a script is included several times on a page,
-		 * it will only run once. This means that the data-astro-rerun attribute will not have any effect.
-		 */
-		src := &n.Attr[AttrIndex(n, "src")]
-		typ := &n.Attr[AttrIndex(n, "type")]
-		rerun := &n.Attr[AttrIndex(n, "data-astro-rerun")]
-		if typ.Val == "module" && src.Val != "" {
+// WarnAboutUnknownDirective warns when a component uses a `client:` directive that isn't built
+// into Astro, suggesting the closest known one for likely typos (e.g. `client:visable`).
+// With `StrictDirectives`, an error is reported instead.
+func WarnAboutUnknownDirective(n *astro.Node, opts *TransformOptions, h *handler.Handler) {
+	if n.Type != astro.ElementNode || !(n.Component || n.CustomElement || isExtraComponentTag(n, opts)) {
+		return
+	}
+	for _, attr := range n.Attr {
+		directive, ok := strings.CutPrefix(attr.Key, "client:")
+		if !ok || slices.Contains(knownClientDirectives, directive) || isCustomElementPath(n, attr) {
+			continue
+		}
+		err := &loc.ErrorWithRange{
+			Code:  loc.WARNING_UNKNOWN_DIRECTIVE,
+			Text:  fmt.Sprintf("Unknown directive `%s`.", attr.Key),
+			Hint:  "If this is a custom client directive, make sure that it is registered by an integration.",
+			Range: loc.Range{Loc: attr.KeyLoc, Len: len(attr.Key)},
+		}
+		if suggestion := closestMatch(directive, knownClientDirectives); suggestion != "" {
+			err.Text = fmt.Sprintf("Unknown directive `%s`. Did you mean `client:%s`?", attr.Key, suggestion)
+		}
+		if opts.StrictDirectives {
+			err.Code = loc.ERROR_UNKNOWN_DIRECTIVE
+			h.AppendError(err)
+		} else {
+			h.AppendWarning(err)
+		}
+	}
+}
+
+// ErrorAboutUnresolvedCustomElement reports a hydrated custom element whose defining module
+// could not be found by AddComponentProps, since it would fail to hydrate at runtime.
+func ErrorAboutUnresolvedCustomElement(n *astro.Node, h *handler.Handler) {
+	if n.Type != astro.ElementNode || !n.CustomElement || HasAttr(n, "client:only") {
+		return
+	}
+	if !HasAttr(n, "client:component-hydration") || HasAttr(n, "client:component-path") {
+		return
+	}
+	var r loc.Range
+	if len(n.Loc) > 0 {
+		r = loc.Range{Loc: n.Loc[0], Len: len(n.Data)}
+	}
+	h.AppendError(&loc.ErrorWithRange{
+		Code:  loc.ERROR_UNRESOLVED_CUSTOM_ELEMENT,
+		Text:  fmt.Sprintf("Unable to determine the module that defines <%s>, so it cannot be hydrated.", n.Data),
+		Hint:  fmt.Sprintf("Import a module named after the element in the frontmatter (e.g. `import './%s.js'`), or set `client:component-path` to the module that defines it.", n.Data),
+		Range: r,
+	})
+}
+
+// WarnAboutUnknownHydratedElement warns when a `client:` directive is used on a tag
+// that is neither a component nor a known HTML element, which is most likely a
+// component written in lowercase (e.g. `<mycomponent client:load>`).
+func WarnAboutUnknownHydratedElement(n *astro.Node, opts *TransformOptions, h *handler.Handler) {
+	if n.Type != astro.ElementNode || n.DataAtom != 0 || n.Namespace != "" {
+		return
+	}
+	if n.Component || n.CustomElement || n.Fragment || isExtraComponentTag(n, opts) {
+		return
+	}
+	for _, attr := range n.Attr {
+		if strings.HasPrefix(attr.Key, "client:") {
 			h.AppendWarning(&loc.ErrorWithRange{
-				Code:  loc.WARNING_CANNOT_RERUN,
-				Text:  "The data-astro-rerun attribute is not supported on an external module <script>",
-				Hint:  "Two out of three is OK: type=\"module\", src=\"...\", or data-astro-rerun",
-				Range: loc.Range{Loc: rerun.KeyLoc, Len: len(rerun.Key)},
+				Code:  loc.WARNING_UNKNOWN_HYDRATED_ELEMENT,
+				Text:  fmt.Sprintf("<%s> is not a component, so the %s directive will be ignored.", n.Data, attr.Key),
+				Hint:  "Component names must start with an uppercase letter and match an import in the frontmatter.",
+				Range: loc.Range{Loc: attr.KeyLoc, Len: len(attr.Key)},
 			})
+			return
 		}
 	}
 }
@@ -408,24 +1548,54 @@ func ExtractScript(doc *astro.Node, n *astro.Node, opts *TransformOptions, h *ha
 			return
 		}
 		// Ignore scripts in svg/noscript/etc
-		// In expressions ignore scripts, unless `RenderScript` is true
-		if !IsHoistable(n, opts.RenderScript) {
+		if !IsHoistable(n) {
 			return
 		}
 
 		// if <script>, hoist to the document root
 		// If also using define:vars, that overrides the hoist tag.
-		if (hasTruthyAttr(n, "hoist")) ||
+		hoist, _ := GetTruthyAttrValue(n, "hoist")
+		if hoist ||
 			len(n.Attr) == 0 || (len(n.Attr) == 1 && n.Attr[0].Key == "src") {
+			// `RenderScript` renders scripts in place, so those in expressions stay conditional.
+			// Otherwise, only scripts explicitly marked with `hoist` are hoisted from expressions.
+			inExpression := !opts.RenderScript && InExpression(n)
+			if inExpression && !hoist {
+				h.AppendWarning(&loc.ErrorWithRange{
+					Code:  loc.WARNING_ELEMENT_IN_EXPRESSION,
+					Text:  "This <script> is inside an expression, so it is rendered in place as is, without being processed.",
+					Hint:  "Move the <script> out of the expression to have it processed and bundled, or add the `is:inline` directive to silence this warning.",
+					Range: loc.Range{Loc: n.Loc[0], Len: len(n.Data)},
+				})
+				return
+			}
+			if inExpression {
+				h.AppendWarning(&loc.ErrorWithRange{
+					Code:  loc.WARNING_ELEMENT_IN_EXPRESSION,
+					Text:  "This <script hoist> is inside an expression, but hoisted scripts always run: it runs whether or not the expression renders it.",
+					Hint:  "Move the <script> out of the expression, or remove the `hoist` attribute to render it in place.",
+					Range: loc.Range{Loc: n.Loc[0], Len: len(n.Data)},
+				})
+			}
 			shouldAdd := true
 			for _, attr := range n.Attr {
-				if attr.Key == "hoist" {
+				// `hoist` is what hoists a script from an expression
+				if attr.Key == "hoist" && !inExpression {
 					h.AppendWarning(&loc.ErrorWithRange{
 						Code:  loc.WARNING_DEPRECATED_DIRECTIVE,
 						Text:  "<script hoist> is no longer needed. You may remove the `hoist` attribute.",
 						Range: loc.Range{Loc: n.Loc[0], Len: len(n.Data)},
 					})
 				}
+				// Browsers ignore `async` and `defer` on inline scripts
+				if (attr.Key == "async" || attr.Key == "defer") && !HasAttr(n, "src") {
+					h.AppendWarning(&loc.ErrorWithRange{
+						Code:  loc.WARNING_INLINE_SCRIPT_ATTRIBUTE,
+						Text:  fmt.Sprintf("`%s` has no effect on an inline <script>.", attr.Key),
+						Hint:  fmt.Sprintf("Remove the `%s` attribute, or move the script to a file and reference it with `src`.", attr.Key),
+						Range: loc.Range{Loc: attr.KeyLoc, Len: len(attr.Key)},
+					})
+				}
 				if attr.Key == "src" {
 					if attr.Type == astro.ExpressionAttribute {
 						shouldAdd = false
@@ -442,11 +1612,18 @@ func ExtractScript(doc *astro.Node, n *astro.Node, opts *TransformOptions, h *ha
 
 			// append node to maintain authored order
 			if shouldAdd {
-				if opts.ExperimentalScriptOrder {
-					doc.Scripts = append(doc.Scripts, n)
-				} else {
-					doc.Scripts = append([]*astro.Node{n}, doc.Scripts...)
+				scripts := &doc.Scripts
+				// Hoisted external scripts are bundled separately, once per src.
+				// Scripts rendered in place keep a single list, since they are referenced by index.
+				if src := GetQuotedAttr(n, "src"); src != "" && !opts.RenderScript {
+					if hasExternalScript(doc, src, opts) {
+						// Walk allows the current node to be removed
+						removeHoistedNode(n, h)
+						return
+					}
+					scripts = &doc.ExternalScripts
 				}
+				*scripts = append(*scripts, n)
 				n.HandledScript = true
 			}
 		} else {
@@ -463,6 +1640,27 @@ func ExtractScript(doc *astro.Node, n *astro.Node, opts *TransformOptions, h *ha
 	}
 }
 
+// collectRawHTML records the elements that use `set:html`, whose value is rendered without
+// escaping, and reports them when `AuditRawHTML` is enabled.
+func collectRawHTML(doc *astro.Node, n *astro.Node, opts *TransformOptions, h *handler.Handler) {
+	if n.Type != astro.ElementNode {
+		return
+	}
+	attr := GetAttr(n, "set:html")
+	if attr == nil {
+		return
+	}
+	doc.RawHTMLNodes = append(doc.RawHTMLNodes, n)
+	if opts.AuditRawHTML {
+		h.AppendInfo(&loc.ErrorWithRange{
+			Code:  loc.INFO_RAW_HTML,
+			Text:  fmt.Sprintf("<%s> renders unescaped HTML with `set:html`.", n.Data),
+			Hint:  "Make sure the value is trusted or sanitized, since it is not escaped.",
+			Range: loc.Range{Loc: attr.KeyLoc, Len: len(attr.Key)},
+		})
+	}
+}
+
 func HintAboutImplicitInlineDirective(n *astro.Node, h *handler.Handler) {
 	if n.Type == astro.ElementNode && n.DataAtom == a.Script && len(n.Attr) > 0 && !HasInlineDirective(n) {
 		if len(n.Attr) == 1 && n.Attr[0].Key == "src" {
@@ -476,21 +1674,212 @@ func HintAboutImplicitInlineDirective(n *astro.Node, h *handler.Handler) {
 	}
 }
 
+// Open Graph and Twitter `<meta>` properties whose content is a URL. The content of the
+// others (e.g. `og:title`, `twitter:card`) is text and is never resolved.
+var urlMetadataProperties = map[string]bool{
+	"og:url":              true,
+	"og:image":            true,
+	"og:image:url":        true,
+	"og:image:secure_url": true,
+	"og:video":            true,
+	"og:video:url":        true,
+	"og:video:secure_url": true,
+	"og:audio":            true,
+	"og:audio:url":        true,
+	"og:audio:secure_url": true,
+	"twitter:image":       true,
+	"twitter:player":      true,
+}
+
+// AbsolutizeMetadataURL resolves relative URLs of `<link rel="canonical">` and of the
+// URL-valued Open Graph / Twitter `<meta>` tags against `Site` and `Pathname`.
+func AbsolutizeMetadataURL(n *astro.Node, opts TransformOptions) {
+	if n.Type != astro.ElementNode || n.Component {
+		return
+	}
+	key := ""
+	switch n.DataAtom {
+	case a.Link:
+		if rel := GetAttr(n, "rel"); rel != nil && rel.Type == astro.QuotedAttribute && strings.EqualFold(rel.Val, "canonical") {
+			key = "href"
+		}
+	case a.Meta:
+		if urlMetadataProperties[GetQuotedAttr(n, "property")] || urlMetadataProperties[GetQuotedAttr(n, "name")] {
+			key = "content"
+		}
+	}
+	if key == "" {
+		return
+	}
+	if val, ok := LookupQuotedAttr(n, key); ok {
+		if resolved, ok := resolveSiteURL(val, opts); ok {
+			n.Attr[AttrIndex(n, key)].Val = resolved
+		}
+	}
+}
+
+func resolveSiteURL(value string, opts TransformOptions) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.HasPrefix(value, "//") {
+		return "", false
+	}
+	ref, err := url.Parse(value)
+	if err != nil || ref.IsAbs() {
+		return "", false
+	}
+	base, err := url.Parse(opts.Site)
+	if err != nil || !base.IsAbs() {
+		return "", false
+	}
+	if opts.Pathname != "" {
+		pathname, err := url.Parse(opts.Pathname)
+		if err != nil {
+			return "", false
+		}
+		base = base.ResolveReference(pathname)
+	}
+	return base.ResolveReference(ref).String(), true
+}
+
+// ResolveRootURLs resolves the quoted `href` and `src` attributes of n that start with `/`
+// against `Site` and `Base`. Other URLs, like absolute URLs, fragments or `data:` and
+// `mailto:` URLs, are left untouched, and so are expressions, which are resolved at runtime.
+// Scripts that are hoisted are skipped, since their `src` is resolved by the bundler.
+func ResolveRootURLs(n *astro.Node, opts TransformOptions) {
+	if n.Type != astro.ElementNode || n.Component || n.Expression || isHoistedScript(n, opts) {
+		return
+	}
+	for i, attr := range n.Attr {
+		if attr.Type != astro.QuotedAttribute || (attr.Key != "href" && attr.Key != "src") {
+			continue
+		}
+		if resolved, ok := resolveRootURL(attr.Val, opts); ok {
+			n.Attr[i].Val = resolved
+		}
+	}
+}
+
+// isHoistedScript reports whether n is a script that ExtractScript hoists, leaving scripts with
+// other attributes (e.g. `<script src defer>`) or with directives in the HTML.
+func isHoistedScript(n *astro.Node, opts TransformOptions) bool {
+	if n.DataAtom != a.Script || HasSetDirective(n) || HasInlineDirective(n) || !IsHoistable(n) {
+		return false
+	}
+	hoist, _ := GetTruthyAttrValue(n, "hoist")
+	if !hoist && !(len(n.Attr) == 0 || (len(n.Attr) == 1 && n.Attr[0].Key == "src")) {
+		return false
+	}
+	return hoist || opts.RenderScript || !InExpression(n)
+}
+
+func resolveRootURL(value string, opts TransformOptions) (string, bool) {
+	if !strings.HasPrefix(value, "/") || strings.HasPrefix(value, "//") {
+		return "", false
+	}
+	site, err := url.Parse(opts.Site)
+	if err != nil || !site.IsAbs() {
+		return "", false
+	}
+	root := strings.TrimSuffix(site.String(), "/")
+	if base := strings.Trim(opts.Base, "/"); base != "" {
+		root += "/" + base
+	}
+	return root + value, true
+}
+
+// subresourceAttributes are the attributes that make the browser load a subresource
+var subresourceAttributes = map[a.Atom]string{
+	a.Img:    "src",
+	a.Script: "src",
+	a.Link:   "href",
+	a.Iframe: "src",
+	a.Audio:  "src",
+	a.Video:  "src",
+	a.Source: "src",
+	a.Track:  "src",
+	a.Embed:  "src",
+}
+
+// WarnAboutMixedContent warns when a subresource is loaded over `http://` while the
+// site is served over `https://`, which browsers block as mixed content.
+// Links to other pages, like `<a href>` or `<link rel="canonical">`, are not loaded.
+func WarnAboutMixedContent(n *astro.Node, opts TransformOptions, h *handler.Handler) {
+	if n.Type != astro.ElementNode || n.Component || !strings.HasPrefix(strings.ToLower(opts.Site), "https://") {
+		return
+	}
+	key, ok := subresourceAttributes[n.DataAtom]
+	if !ok {
+		return
+	}
+	if n.DataAtom == a.Link && !isSubresourceLink(n) {
+		return
+	}
+	attr := GetAttr(n, key)
+	if attr == nil || attr.Type != astro.QuotedAttribute || !strings.HasPrefix(strings.ToLower(strings.TrimSpace(attr.Val)), "http://") {
+		return
+	}
+	h.AppendWarning(&loc.ErrorWithRange{
+		Code:  loc.WARNING_MIXED_CONTENT,
+		Text:  fmt.Sprintf("<%s> loads %s over http:// on a site served over https://, which browsers block as mixed content.", n.Data, attr.Val),
+		Hint:  "Use an https:// URL instead.",
+		Range: loc.Range{Loc: attr.ValLoc, Len: len(attr.Val)},
+	})
+}
+
+// isSubresourceLink reports whether the browser loads the target of a <link>, based on its `rel`.
+func isSubresourceLink(n *astro.Node) bool {
+	rel := GetAttr(n, "rel")
+	if rel == nil || rel.Type != astro.QuotedAttribute {
+		return false
+	}
+	for _, value := range strings.Fields(strings.ToLower(rel.Val)) {
+		switch value {
+		case "stylesheet", "icon", "apple-touch-icon", "manifest", "preload", "modulepreload", "prefetch":
+			return true
+		}
+	}
+	return false
+}
+
+// directiveValue returns the raw value of a directive, or "" if it has none.
+func directiveValue(attr astro.Attribute) string {
+	switch attr.Type {
+	case astro.QuotedAttribute, astro.ExpressionAttribute, astro.TemplateLiteralAttribute:
+		return attr.Val
+	}
+	return ""
+}
+
 func AddComponentProps(doc *astro.Node, n *astro.Node, opts *TransformOptions) {
-	if n.Type == astro.ElementNode && (n.Component || n.CustomElement) {
+	if n.Type == astro.ElementNode && !n.Fragment && (n.Component || n.CustomElement || isExtraComponentTag(n, opts)) {
+		// Whether the path set by the author of a custom element is replaced by the resolved one,
+		// which is done once its attributes are no longer iterated
+		replacePath := false
 		for _, attr := range n.Attr {
+			if isCustomElementPath(n, attr) {
+				continue
+			}
 			if strings.HasPrefix(attr.Key, "client:") {
 				parts := strings.Split(attr.Key, ":")
 				directive := parts[1]
 
-				// Add the hydration directive so it can be extracted statically.
-				doc.HydrationDirectives[directive] = true
+				// Add the hydration directive so it can be extracted statically, along with the
+				// raw value of the first usage that passes one
+				if value, ok := doc.HydrationDirectives[directive]; !ok || value == "" {
+					doc.HydrationDirectives[directive] = directiveValue(attr)
+				}
+
+				// Make room for the hydration, path and export attributes at once
+				n.Attr = slices.Grow(n.Attr, 3)
 
 				hydrationAttr := astro.Attribute{
 					Key: "client:component-hydration",
 					Val: directive,
 				}
 				n.Attr = append(n.Attr, hydrationAttr)
+				// Options of the directive (e.g. `client:visible={{ rootMargin: '200px' }}`) are left
+				// on the directive itself, so that the runtime reads them from there and an expression
+				// is only evaluated once
 
 				if attr.Key == "client:only" {
 					doc.ClientOnlyComponentNodes = append([]*astro.Node{n}, doc.ClientOnlyComponentNodes...)
@@ -510,16 +1899,21 @@ func AddComponentProps(doc *astro.Node, n *astro.Node, opts *TransformOptions) {
 				doc.HydratedComponentNodes = append([]*astro.Node{n}, doc.HydratedComponentNodes...)
 
 				match := matchNodeToImportStatement(doc, n)
+				if match == nil && n.CustomElement {
+					match = matchCustomElementDefinition(doc, n)
+					_, replacePath = LookupQuotedAttr(n, "client:component-path")
+				}
 				if match != nil {
+					resolvedPath := ResolveIdForMatch(match.Specifier, opts)
 					doc.HydratedComponents = append(doc.HydratedComponents, &astro.HydratedComponentMetadata{
 						ExportName:   match.ExportName,
 						Specifier:    match.Specifier,
-						ResolvedPath: ResolveIdForMatch(match.Specifier, opts),
+						ResolvedPath: resolvedPath,
 					})
 
 					pathAttr := astro.Attribute{
 						Key:  "client:component-path",
-						Val:  fmt.Sprintf(`"%s"`, ResolveIdForMatch(match.Specifier, opts)),
+						Val:  fmt.Sprintf(`"%s"`, resolvedPath),
 						Type: astro.ExpressionAttribute,
 					}
 					n.Attr = append(n.Attr, pathAttr)
@@ -541,20 +1935,21 @@ func AddComponentProps(doc *astro.Node, n *astro.Node, opts *TransformOptions) {
 					Key: "server:component-directive",
 					Val: directive,
 				}
-				n.Attr = append(n.Attr, hydrationAttr)
+				n.Attr = append(slices.Grow(n.Attr, 3), hydrationAttr)
 
 				match := matchNodeToImportStatement(doc, n)
 				if match != nil {
+					resolvedPath := ResolveIdForMatch(match.Specifier, opts)
 					doc.ServerComponents = append(doc.ServerComponents, &astro.HydratedComponentMetadata{
 						ExportName:   match.ExportName,
 						LocalName:    n.Data,
 						Specifier:    match.Specifier,
-						ResolvedPath: ResolveIdForMatch(match.Specifier, opts),
+						ResolvedPath: resolvedPath,
 					})
 
 					pathAttr := astro.Attribute{
 						Key:  "server:component-path",
-						Val:  fmt.Sprintf(`"%s"`, ResolveIdForMatch(match.Specifier, opts)),
+						Val:  fmt.Sprintf(`"%s"`, resolvedPath),
 						Type: astro.ExpressionAttribute,
 					}
 					n.Attr = append(n.Attr, pathAttr)
@@ -568,7 +1963,19 @@ func AddComponentProps(doc *astro.Node, n *astro.Node, opts *TransformOptions) {
 				}
 			}
 		}
+		if replacePath {
+			n.Attr = slices.DeleteFunc(n.Attr, func(attr astro.Attribute) bool { return isCustomElementPath(n, attr) })
+		}
+	}
+}
+
+func isExtraComponentTag(n *astro.Node, opts *TransformOptions) bool {
+	for _, tag := range opts.ExtraComponentTags {
+		if n.Data == tag {
+			return true
+		}
 	}
+	return false
 }
 
 type ImportMatch struct {
@@ -576,6 +1983,35 @@ type ImportMatch struct {
 	Specifier  string
 }
 
+// isCustomElementPath reports whether attr is the module that defines the custom element n,
+// set by the author as `client:component-path="./my-counter.js"`.
+func isCustomElementPath(n *astro.Node, attr astro.Attribute) bool {
+	return n.CustomElement && attr.Key == "client:component-path" && attr.Type == astro.QuotedAttribute
+}
+
+// matchCustomElementDefinition finds the module that defines the custom element n: the
+// `client:component-path` set by the author, or else a side-effect
+// import of the frontmatter whose file is named after the element (e.g. `import
+// './my-counter.js'` for `<my-counter>`). Such modules register the element rather than
+// export it, so the default export is used.
+func matchCustomElementDefinition(doc *astro.Node, n *astro.Node) *ImportMatch {
+	if specifier, ok := LookupQuotedAttr(n, "client:component-path"); ok {
+		return &ImportMatch{ExportName: "default", Specifier: specifier}
+	}
+	var match *ImportMatch
+	eachImportStatement(doc, func(stmt js_scanner.ImportStatement) bool {
+		if stmt.IsType || len(stmt.Imports) > 0 {
+			return true
+		}
+		if name, _, _ := strings.Cut(path.Base(stmt.Specifier), "."); name == n.Data {
+			match = &ImportMatch{ExportName: "default", Specifier: stmt.Specifier}
+			return false
+		}
+		return true
+	})
+	return match
+}
+
 func matchNodeToImportStatement(doc *astro.Node, n *astro.Node) *ImportMatch {
 	var match *ImportMatch
 
@@ -596,6 +2032,17 @@ func matchNodeToImportStatement(doc *astro.Node, n *astro.Node) *ImportMatch {
 	return match
 }
 
+// hasExternalScript reports whether an external script with the same resolved src has already been hoisted.
+func hasExternalScript(doc *astro.Node, src string, opts *TransformOptions) bool {
+	resolved := ResolveIdForMatch(src, opts)
+	for _, script := range doc.ExternalScripts {
+		if ResolveIdForMatch(GetQuotedAttr(script, "src"), opts) == resolved {
+			return true
+		}
+	}
+	return false
+}
+
 func ResolveIdForMatch(id string, opts *TransformOptions) string {
 	// Try custom resolvePath if provided
 	if opts.ResolvePath != nil {
@@ -607,8 +2054,57 @@ func ResolveIdForMatch(id string, opts *TransformOptions) string {
 	}
 }
 
+// ExtractImports returns the specifier of every import statement in the
+// frontmatter, in source order, including side-effect imports.
+func ExtractImports(doc *astro.Node) []string {
+	specifiers := make([]string, 0)
+	if doc.FirstChild == nil {
+		return specifiers
+	}
+	eachImportStatement(doc, func(stmt js_scanner.ImportStatement) bool {
+		if stmt.Specifier != "" {
+			specifiers = append(specifiers, stmt.Specifier)
+		}
+		return true
+	})
+	return specifiers
+}
+
+// CSSImport is a stylesheet imported from the frontmatter.
+type CSSImport struct {
+	Specifier string
+	// Whether this is a bare `import "./file.css"` without any bindings
+	SideEffect bool
+	// Position of the import statement in the source
+	Loc loc.Loc
+}
+
+// ExtractCSSImports returns every stylesheet imported from the frontmatter, in source order.
+// Dynamic `import()` calls and type imports are ignored.
+func ExtractCSSImports(doc *astro.Node) []CSSImport {
+	imports := make([]CSSImport, 0)
+	if doc.FirstChild == nil {
+		return imports
+	}
+	start := 0
+	if text := doc.FirstChild.FirstChild; text != nil && len(text.Loc) > 0 {
+		start = text.Loc[0].Start
+	}
+	eachImportStatement(doc, func(stmt js_scanner.ImportStatement) bool {
+		if !stmt.IsType && IsStyleModuleSpecifier(stmt.Specifier) {
+			imports = append(imports, CSSImport{
+				Specifier:  stmt.Specifier,
+				SideEffect: len(stmt.Imports) == 0,
+				Loc:        loc.Loc{Start: start + stmt.Span.Start},
+			})
+		}
+		return true
+	})
+	return imports
+}
+
 func eachImportStatement(doc *astro.Node, cb func(stmt js_scanner.ImportStatement) bool) {
-	if doc.FirstChild.Type == astro.FrontmatterNode && doc.FirstChild.FirstChild != nil {
+	if doc.FirstChild != nil && doc.FirstChild.Type == astro.FrontmatterNode && doc.FirstChild.FirstChild != nil {
 		source := []byte(doc.FirstChild.FirstChild.Data)
 		loc, statement := js_scanner.NextImportStatement(source, 0)
 		for loc != -1 {
@@ -620,40 +2116,128 @@ func eachImportStatement(doc *astro.Node, cb func(stmt js_scanner.ImportStatemen
 	}
 }
 
-func walk(doc *astro.Node, cb func(*astro.Node)) {
-	var f func(*astro.Node)
-	f = func(n *astro.Node) {
-		cb(n)
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
+// normalizeBooleanAttributes rewrites the quoted boolean attributes of an HTML element
+// whose value is empty, "true" or the name of the attribute to their bare form, and
+// removes those whose value is "false". Other values and expressions are left alone.
+func normalizeBooleanAttributes(n *astro.Node) {
+	if n.Type != astro.ElementNode || n.Component || n.CustomElement || n.Fragment || n.Expression || n.Namespace != "" {
+		return
+	}
+	n.Attr = slices.DeleteFunc(n.Attr, func(attr astro.Attribute) bool {
+		return attr.Type == astro.QuotedAttribute && IsBooleanAttribute(attr.Key) && strings.EqualFold(strings.TrimSpace(attr.Val), "false")
+	})
+	for i, attr := range n.Attr {
+		if attr.Type != astro.QuotedAttribute || !IsBooleanAttribute(attr.Key) {
+			continue
+		}
+		switch val := strings.TrimSpace(attr.Val); {
+		case val == "", strings.EqualFold(val, "true"), strings.EqualFold(val, attr.Key):
+			n.Attr[i].Type = astro.EmptyAttribute
+			n.Attr[i].Val = ""
+			n.Attr[i].Quote = 0
 		}
 	}
-	f(doc)
 }
 
-// This function merges the values of `class=""` and `class:list=""` in `class:list`
-func mergeClassList(doc *astro.Node, n *astro.Node, opts *TransformOptions) {
-	var classListAttrValue string
-	var classListAttrIndex int = -1
-
-	var classAttrType astro.AttributeType
-	var classAttrValue string
-	var classAttrIndex int = -1
+// markImportedComponents marks elements below root whose tag name references a value imported
+// in the frontmatter of doc as components, e.g. `<ns.thing-x>` with `import * as ns`. Known HTML
+// elements are left alone, even if an import shadows their name.
+func markImportedComponents(doc *astro.Node, root *astro.Node) {
+	imported := make(map[string]bool)
+	eachImportStatement(doc, func(stmt js_scanner.ImportStatement) bool {
+		for _, imp := range stmt.Imports {
+			if !stmt.IsType && !imp.IsType {
+				imported[imp.LocalName] = true
+			}
+		}
+		return true
+	})
+	if len(imported) == 0 {
+		return
+	}
+	walk(root, func(n *astro.Node) {
+		if n.Type != astro.ElementNode || n.Component || n.Fragment || n.Expression || n.DataAtom != 0 {
+			return
+		}
+		if name, _, _ := strings.Cut(n.Data, "."); imported[name] {
+			n.MarkComponent()
+		}
+	})
+}
 
-	for i, attr := range n.Attr {
-		if attr.Key == "class:list" {
-			classListAttrValue = attr.Val
-			classListAttrIndex = i
+// aliasComponents renames the components below root whose tag name is a key of aliases,
+// before any other pass, so that hydration and rendering refer to the aliased component.
+// Aliases that are neither an identifier nor a member expression (e.g. `my-img`) can't be
+// rendered as a component, so they are reported and the tag name is kept.
+func aliasComponents(root *astro.Node, aliases map[string]string, h *handler.Handler) {
+	walk(root, func(n *astro.Node) {
+		if n.Type != astro.ElementNode || !n.Component {
+			return
+		}
+		alias := aliases[n.Data]
+		if alias == "" {
+			return
+		}
+		if !isComponentReference(alias) {
+			var r loc.Range
+			if len(n.Loc) > 0 {
+				r = loc.Range{Loc: n.Loc[0], Len: len(n.Data)}
+			}
+			h.AppendWarning(&loc.ErrorWithRange{
+				Code:  loc.WARNING_INVALID_COMPONENT_ALIAS,
+				Text:  fmt.Sprintf("The alias %q of <%s> is not a valid component name, so it is ignored.", alias, n.Data),
+				Hint:  "Aliases must be identifiers (e.g. `AstroImage`) or member expressions (e.g. `ui.Image`).",
+				Range: r,
+			})
+			return
 		}
-		if attr.Key == "class" {
-			classAttrType = attr.Type
-			classAttrValue = attr.Val
-			classAttrIndex = i
+		n.Data = alias
+	})
+}
+
+// isComponentReference reports whether name is an identifier or a member expression of
+// identifiers, which a component can be rendered from.
+func isComponentReference(name string) bool {
+	for _, part := range strings.Split(name, ".") {
+		if part == "" || !js_scanner.IsIdentifier([]byte(part)) {
+			return false
 		}
 	}
+	return true
+}
+
+// walkIndex returns the number of nodes that walk visits in doc before root.
+func walkIndex(doc *astro.Node, root *astro.Node) int {
+	i := 0
+	astro.Walk(doc, astro.VisitorFuncs{OnEnter: func(n *astro.Node) astro.WalkAction {
+		if n == root {
+			return astro.WalkStop
+		}
+		i++
+		return astro.WalkContinue
+	}})
+	return i
+}
+
+// walk calls cb for doc and every node below it, in document order. See astro.Walk
+// for how modifications of the tree made by cb are handled.
+func walk(doc *astro.Node, cb func(*astro.Node)) {
+	astro.Walk(doc, astro.VisitorFuncs{OnEnter: func(n *astro.Node) astro.WalkAction {
+		cb(n)
+		return astro.WalkContinue
+	}})
+}
+
+// This function merges the values of `class=""` and `class:list=""` in `class:list`
+func mergeClassList(doc *astro.Node, n *astro.Node, opts *TransformOptions) {
+	classListAttrIndex := AttrIndex(n, "class:list")
+	classAttrIndex := AttrIndex(n, "class")
 
 	// Check if both `class:list` and `class` attributes are present
 	if classListAttrIndex >= 0 && classAttrIndex >= 0 {
+		classListAttrValue := n.Attr[classListAttrIndex].Val
+		classAttrType := n.Attr[classAttrIndex].Type
+		classAttrValue := n.Attr[classAttrIndex].Val
 		// Merge the `class` attribute value into `class:list`
 		if classAttrType == astro.ExpressionAttribute {
 			// If the `class` attribute is an expression, include it directly without surrounding quotes.
@@ -1,13 +1,18 @@
 package transform
 
 import (
+	"fmt"
+	"reflect"
 	"regexp"
+	"slices"
 	"strings"
 	"testing"
 	"unicode/utf8"
 
 	astro "github.com/withastro/compiler/internal"
 	"github.com/withastro/compiler/internal/handler"
+	"github.com/withastro/compiler/internal/loc"
+	"golang.org/x/net/html/atom"
 )
 
 func transformScopingFixtures() []struct {
@@ -30,6 +35,22 @@ func transformScopingFixtures() []struct {
 			`,
 			want: `<div class="astro-xxxxxx"></div>`,
 		},
+		{
+			name: "single quotes",
+			source: `
+				<style>div { color: red }</style>
+				<div title='say "hi"' class='a' />
+			`,
+			want: `<div title='say "hi"' class='a astro-xxxxxx'></div>`,
+		},
+		{
+			name: "unrelated truthy attribute",
+			source: `
+				<style data-theme="true">div { color: red }</style>
+				<div />
+			`,
+			want: `<div class="astro-xxxxxx"></div>`,
+		},
 
 		{
 			name: "global empty",
@@ -133,6 +154,40 @@ func transformScopingFixtures() []struct {
 			`,
 			want: `<div></div>`,
 		},
+		{
+			name: "noscope",
+			source: `
+				<style>div { color: red }</style>
+				<section><div data-astro-noscope><span /></div><p /></section>
+			`,
+			want: `<section class="astro-xxxxxx"><div><span class="astro-xxxxxx"></span></div><p class="astro-xxxxxx"></p></section>`,
+		},
+		{
+			name: "noscope (attribute)",
+			source: `
+				<style>div { color: red }</style>
+				<section><div data-astro-noscope><span /></div><p /></section>
+			`,
+			want:       `<section data-astro-cid-xxxxxx><div><span data-astro-cid-xxxxxx></span></div><p data-astro-cid-xxxxxx></p></section>`,
+			scopeStyle: "attribute",
+		},
+		{
+			name: "noscope subtree",
+			source: `
+				<style>div { color: red }</style>
+				<section><div data-astro-noscope="subtree"><span><b /></span></div><p /></section>
+			`,
+			want: `<section class="astro-xxxxxx"><div><span><b></b></span></div><p class="astro-xxxxxx"></p></section>`,
+		},
+		{
+			name: "noscope subtree (attribute)",
+			source: `
+				<style>div { color: red }</style>
+				<section><div data-astro-noscope="subtree"><span><b /></span></div><p /></section>
+			`,
+			want:       `<section data-astro-cid-xxxxxx><div><span><b></b></span></div><p data-astro-cid-xxxxxx></p></section>`,
+			scopeStyle: "attribute",
+		},
 		{
 			name: "attribute -> creates a new data attribute",
 			source: `
@@ -191,8 +246,9 @@ func TestTransformScoping(t *testing.T) {
 				scopeStyle = "where"
 			}
 			transformOptions := TransformOptions{Scope: "xxxxxx", ScopedStyleStrategy: scopeStyle}
-			ExtractStyles(doc, &transformOptions)
-			Transform(doc, transformOptions, handler.NewHandler(tt.source, "/test.astro"))
+			h := handler.NewHandler(tt.source, "/test.astro")
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
 			astro.PrintToSource(&b, doc.LastChild.FirstChild.NextSibling.FirstChild)
 			got := b.String()
 			if tt.want != got {
@@ -202,6 +258,39 @@ func TestTransformScoping(t *testing.T) {
 	}
 }
 
+func TestScopedStyleStrategyPairs(t *testing.T) {
+	tests := []struct {
+		strategy string
+		selector string
+		element  string
+	}{
+		{strategy: "where", selector: ".a:where(.astro-xxxxxx)", element: `<div class="a astro-xxxxxx"></div>`},
+		{strategy: "class", selector: ".a.astro-xxxxxx", element: `<div class="a astro-xxxxxx"></div>`},
+		{strategy: "attribute", selector: ".a[data-astro-cid-xxxxxx]", element: `<div class="a" data-astro-cid-xxxxxx></div>`},
+	}
+	source := `<div class="a"></div><style>.a { color: red; }</style>`
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Error(err)
+			}
+			transformOptions := TransformOptions{Scope: "xxxxxx", ScopedStyleStrategy: tt.strategy}
+			h := handler.NewHandler(source, "/test.astro")
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+			if got, want := doc.Styles[0].FirstChild.Data, tt.selector+"{color:red}"; got != want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.strategy, want, got)
+			}
+			var b strings.Builder
+			astro.PrintToSource(&b, doc.LastChild.FirstChild.NextSibling.FirstChild)
+			if got := b.String(); got != tt.element {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.strategy, tt.element, got)
+			}
+		})
+	}
+}
+
 func FuzzTransformScoping(f *testing.F) {
 	tests := transformScopingFixtures()
 	for _, tt := range tests {
@@ -213,8 +302,9 @@ func FuzzTransformScoping(f *testing.F) {
 			t.Skip("Invalid parse, skipping rest of fuzz test")
 		}
 		transformOptions := TransformOptions{Scope: "xxxxxx"}
-		ExtractStyles(doc, &transformOptions)
-		Transform(doc, transformOptions, handler.NewHandler(source, "/test.astro"))
+		h := handler.NewHandler(source, "/test.astro")
+		ExtractStyles(doc, &transformOptions, h)
+		Transform(doc, transformOptions, h)
 		var b strings.Builder
 		astro.PrintToSource(&b, doc.LastChild.FirstChild.NextSibling.FirstChild)
 		got := b.String()
@@ -300,10 +390,11 @@ func TestFullTransform(t *testing.T) {
 				t.Error(err)
 			}
 			transformOptions := TransformOptions{}
-			ExtractStyles(doc, &transformOptions)
+			h := handler.NewHandler(tt.source, "/test.astro")
+			ExtractStyles(doc, &transformOptions, h)
 			// Clear doc.Styles to avoid scoping behavior, we're not testing that here
 			doc.Styles = make([]*astro.Node, 0)
-			Transform(doc, transformOptions, handler.NewHandler(tt.source, "/test.astro"))
+			Transform(doc, transformOptions, h)
 			astro.PrintToSource(&b, doc)
 			got := strings.TrimSpace(b.String())
 			if tt.want != got {
@@ -349,10 +440,11 @@ func TestTransformTrailingSpace(t *testing.T) {
 				t.Error(err)
 			}
 			transformOptions := TransformOptions{}
-			ExtractStyles(doc, &transformOptions)
+			h := handler.NewHandler(tt.source, "/test.astro")
+			ExtractStyles(doc, &transformOptions, h)
 			// Clear doc.Styles to avoid scoping behavior, we're not testing that here
 			doc.Styles = make([]*astro.Node, 0)
-			Transform(doc, transformOptions, handler.NewHandler(tt.source, "/test.astro"))
+			Transform(doc, transformOptions, h)
 			astro.PrintToSource(&b, doc)
 			got := b.String()
 			if tt.want != got {
@@ -362,6 +454,69 @@ func TestTransformTrailingSpace(t *testing.T) {
 	}
 }
 
+func TestTransformEmptyDocument(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []astro.NodeType
+	}{
+		{
+			name:   "empty file",
+			source: "",
+			want:   []astro.NodeType{},
+		},
+		{
+			name:   "whitespace only",
+			source: "\n  \n\t\n",
+			want:   []astro.NodeType{},
+		},
+		{
+			name:   "hoisted elements only",
+			source: "<style>h1 { color: red; }</style><script>console.log('hi')</script>",
+			want:   []astro.NodeType{},
+		},
+		{
+			name:   "frontmatter only",
+			source: "---\nconsole.log('hi');\n---\n\n  \n",
+			want:   []astro.NodeType{astro.FrontmatterNode, astro.TextNode},
+		},
+	}
+	var explicitNodes func(n *astro.Node, types []astro.NodeType) []astro.NodeType
+	explicitNodes = func(n *astro.Node, types []astro.NodeType) []astro.NodeType {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if !IsImplicitNode(c) {
+				types = append(types, c.Type)
+			}
+			types = explicitNodes(c, types)
+		}
+		return types
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			transformOptions := TransformOptions{}
+			h := handler.NewHandler(tt.source, "/test.astro")
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+			got := explicitNodes(doc, make([]astro.NodeType, 0))
+			if !slices.Equal(tt.want, got) {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+
+	t.Run("document without children", func(t *testing.T) {
+		doc := &astro.Node{Type: astro.DocumentNode, HydrationDirectives: make(map[string]string)}
+		Transform(doc, TransformOptions{}, handler.NewHandler("", "/test.astro"))
+		if doc.FirstChild != nil {
+			t.Errorf("expected the document to stay empty, got a %v node", doc.FirstChild.Type)
+		}
+	})
+}
+
 func TestCompactTransform(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -470,10 +625,61 @@ func TestCompactTransform(t *testing.T) {
 			transformOptions := TransformOptions{
 				Compact: true,
 			}
-			ExtractStyles(doc, &transformOptions)
+			h := &handler.Handler{}
+			ExtractStyles(doc, &transformOptions, h)
 			// Clear doc.Styles to avoid scoping behavior, we're not testing that here
 			doc.Styles = make([]*astro.Node, 0)
-			Transform(doc, transformOptions, &handler.Handler{})
+			Transform(doc, transformOptions, h)
+			astro.PrintToSource(&b, doc)
+			got := strings.TrimSpace(b.String())
+			if tt.want != got {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMinifyTransform(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "collapses whitespace in a paragraph",
+			source: "<p>\n\t  Hello   \n  world  \n</p>",
+			want:   "<p>Hello world</p>",
+		},
+		{
+			name:   "keeps single spaces between inline elements",
+			source: "<p>Click   <a>here</a>   <span> now </span></p>",
+			want:   "<p>Click <a>here</a> <span>now</span></p>",
+		},
+		{
+			name:   "pre",
+			source: "<pre>  a\n    b  </pre>",
+			want:   "<pre>  a\n    b  </pre>",
+		},
+		{
+			name:   "textarea",
+			source: "<textarea>  a   b  </textarea>",
+			want:   "<textarea>  a   b  </textarea>",
+		},
+		{
+			name:   "expression",
+			source: "<div>{ cond && <span>  a   b  </span> }</div>",
+			want:   "<div>{ cond && <span>  a   b  </span> }</div>",
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			Transform(doc, TransformOptions{Minify: true}, handler.NewHandler(tt.source, "/test.astro"))
 			astro.PrintToSource(&b, doc)
 			got := strings.TrimSpace(b.String())
 			if tt.want != got {
@@ -492,7 +698,7 @@ func TestAnnotation(t *testing.T) {
 		{
 			name:   "basic",
 			source: `<div>Hello world!</div>`,
-			want:   `<div data-astro-source-file="/src/pages/index.astro">Hello world!</div>`,
+			want:   `<div data-astro-source-file="/src/pages/index.astro" data-astro-source-loc="1:1">Hello world!</div>`,
 		},
 		{
 			name:   "no components",
@@ -504,6 +710,25 @@ func TestAnnotation(t *testing.T) {
 			source: `<html></html>`,
 			want:   `<html></html>`,
 		},
+		{
+			name: "line and column",
+			source: `<main>
+	<my-element>{items.map(item => <li>{item}</li>)}</my-element>
+</main>`,
+			want: `<main data-astro-source-file="/src/pages/index.astro" data-astro-source-loc="1:1">
+	<my-element data-astro-source-file="/src/pages/index.astro" data-astro-source-loc="2:2">{items.map(item => <li data-astro-source-file="/src/pages/index.astro" data-astro-source-loc="2:33">{item}</li>)}</my-element>
+</main>`,
+		},
+		{
+			name:   "head metadata",
+			source: `<html><head><title>Hi</title><meta charset="utf-8"></head><body><p>Hi</p></body></html>`,
+			want:   `<html><head><title>Hi</title><meta charset="utf-8"></meta></head><body data-astro-source-file="/src/pages/index.astro" data-astro-source-loc="1:59"><p data-astro-source-file="/src/pages/index.astro" data-astro-source-loc="1:65">Hi</p></body></html>`,
+		},
+		{
+			name:   "svg and is:raw",
+			source: `<svg><path d="M0" /></svg><div is:raw><span>{x}</span></div>`,
+			want:   `<svg><path d="M0"></path></svg><div is:raw data-astro-source-file="/src/pages/index.astro" data-astro-source-loc="1:27"><span>{x}</span></div>`,
+		},
 	}
 	var b strings.Builder
 	for _, tt := range tests {
@@ -594,3 +819,3176 @@ func TestClassAndClassListMerging(t *testing.T) {
 		})
 	}
 }
+
+func TestHoistedNodeWithoutParent(t *testing.T) {
+	tests := []struct {
+		name string
+		node *astro.Node
+	}{
+		{
+			name: "style",
+			node: &astro.Node{Type: astro.ElementNode, DataAtom: atom.Style, Data: "style"},
+		},
+		{
+			name: "script",
+			node: &astro.Node{Type: astro.ElementNode, DataAtom: atom.Script, Data: "script"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A malformed tree: the document points at the node, but the node has no Parent
+			doc := &astro.Node{Type: astro.DocumentNode, HydrationDirectives: make(map[string]string)}
+			doc.FirstChild = tt.node
+			doc.LastChild = tt.node
+			h := handler.NewHandler("", "/test.astro")
+			transformOptions := TransformOptions{}
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+			if len(h.Warnings()) != 1 {
+				t.Errorf("expected 1 warning, got %d", len(h.Warnings()))
+			}
+		})
+	}
+}
+
+func TestExtraComponentTags(t *testing.T) {
+	source := `<widget client:load /><other client:load />`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	h := handler.NewHandler(source, "/test.astro")
+	Transform(doc, TransformOptions{ExtraComponentTags: []string{"widget"}}, h)
+
+	widget := doc.LastChild.FirstChild.NextSibling.FirstChild
+	if widget.Component {
+		t.Fatal("expected <widget> not to be flagged as a component by the parser")
+	}
+	if attr := GetAttr(widget, "client:component-hydration"); attr == nil || attr.Val != "load" {
+		t.Errorf("expected <widget> to receive hydration attributes, got %v", widget.Attr)
+	}
+	if _, ok := doc.HydrationDirectives["load"]; !ok {
+		t.Error("expected the load directive to be collected")
+	}
+	if len(doc.HydratedComponentNodes) != 1 || doc.HydratedComponentNodes[0] != widget {
+		t.Errorf("expected only <widget> to be collected as a hydrated component, got %d", len(doc.HydratedComponentNodes))
+	}
+
+	other := widget.NextSibling
+	if HasAttr(other, "client:component-hydration") {
+		t.Error("expected <other> not to receive hydration attributes")
+	}
+}
+
+func TestHydrationPass(t *testing.T) {
+	source := `---
+import Counter from "../components/Counter.jsx";
+---
+<Counter client:load /><script>console.log("hello")</script>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	h := handler.NewHandler(source, "/test.astro")
+	HydrationPass(doc, TransformOptions{}, h)
+
+	var counter, script *astro.Node
+	walk(doc, func(n *astro.Node) {
+		switch n.Data {
+		case "Counter":
+			counter = n
+		case "script":
+			script = n
+		}
+	})
+	if counter == nil {
+		t.Fatal("expected to find <Counter>")
+	}
+	if attr := GetAttr(counter, "client:component-hydration"); attr == nil || attr.Val != "load" {
+		t.Errorf("expected <Counter> to receive hydration attributes, got %v", counter.Attr)
+	}
+	if len(doc.HydratedComponents) != 1 {
+		t.Errorf("expected 1 hydrated component, got %d", len(doc.HydratedComponents))
+	}
+	if len(doc.Scripts) != 0 {
+		t.Errorf("expected no scripts to be extracted, got %d", len(doc.Scripts))
+	}
+	if script == nil || script.Parent == nil {
+		t.Error("expected <script> to remain in place")
+	}
+}
+
+func TestImplicitlyClosedElements(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "unclosed div",
+			source: `<div><p>Hello</p>`,
+			want:   []string{"div 1:2"},
+		},
+		{
+			name:   "mismatched end tag",
+			source: `<div><span>Hello</div>`,
+			want:   []string{"span 1:7"},
+		},
+		{
+			name:   "closed elements",
+			source: `<div><span>Hello</span></div>`,
+			want:   []string{},
+		},
+		{
+			name:   "optional end tags",
+			source: `<ul><li>One<li>Two</ul><p>Text`,
+			want:   []string{},
+		},
+		{
+			name:   "self-closing component",
+			source: `<Component /><div />`,
+			want:   []string{},
+		},
+		{
+			name:   "document without closing html and body",
+			source: `<html><head><title>Test</title></head><body><main>Hello</main>`,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			Transform(doc, TransformOptions{}, h)
+			got := []string{}
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_IMPLICITLY_CLOSED_ELEMENT) {
+					got = append(got, fmt.Sprintf("%s %d:%d", tt.source[w.Location.Column-1:w.Location.Column-1+w.Location.Length], w.Location.Line, w.Location.Column))
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSelfClosingElements(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "self-closing div",
+			source: `<div /><p>after</p>`,
+			want:   []string{"div 1:2"},
+		},
+		{
+			name:   "nested self-closing span",
+			source: `<p><span/>text</p>`,
+			want:   []string{"span 1:5"},
+		},
+		{
+			name:   "void elements",
+			source: `<br/><img src="a.png" /><input>`,
+			want:   []string{},
+		},
+		{
+			name:   "foreign elements",
+			source: `<svg><circle r="1" /></svg><math><mi /></math>`,
+			want:   []string{},
+		},
+		{
+			name:   "components, slots and directives",
+			source: `<Component /><Fragment /><my-element /><slot /><div set:html={html} />`,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			Transform(doc, TransformOptions{}, h)
+			got := []string{}
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_SELF_CLOSING_ELEMENT) {
+					got = append(got, fmt.Sprintf("%s %d:%d", tt.source[w.Location.Column-1:w.Location.Column-1+w.Location.Length], w.Location.Line, w.Location.Column))
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTransformInvalidScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		scope    string
+		want     string
+		css      string
+		warnings int
+	}{
+		{
+			name:  "valid",
+			scope: "abc_DEF-123",
+			want:  `<div class="astro-abc_DEF-123"></div>`,
+			css:   `div:where(.astro-abc_DEF-123){color:red}`,
+		},
+		{
+			name:     "space",
+			scope:    "foo bar",
+			want:     `<div class="astro-foo-bar"></div>`,
+			css:      `div:where(.astro-foo-bar){color:red}`,
+			warnings: 1,
+		},
+		{
+			name:     "quotes",
+			scope:    `x"y'z`,
+			want:     `<div class="astro-x-y-z"></div>`,
+			css:      `div:where(.astro-x-y-z){color:red}`,
+			warnings: 1,
+		},
+	}
+	source := `<div></div><style>div { color: red; }</style>`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Error(err)
+			}
+			opts := TransformOptions{Scope: tt.scope}
+			h := handler.NewHandler(source, "/test.astro")
+			ExtractStyles(doc, &opts, h)
+			Transform(doc, opts, h)
+			var b strings.Builder
+			astro.PrintToSource(&b, doc.LastChild.FirstChild.NextSibling.FirstChild)
+			if got := b.String(); got != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+			if got := doc.Styles[0].FirstChild.Data; got != tt.css {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.css, got)
+			}
+			warnings := 0
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_INVALID_SCOPE) {
+					warnings++
+				}
+			}
+			if warnings != tt.warnings {
+				t.Errorf("\nFAIL: %s\n  expected %d invalid scope warnings, got %d", tt.name, tt.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestScopeHash(t *testing.T) {
+	tests := []struct {
+		name string
+		a    [2]string
+		b    [2]string
+		same bool
+	}{
+		{
+			name: "windows and posix paths",
+			a:    [2]string{`C:\proj\src\Foo.astro`, `C:\proj`},
+			b:    [2]string{`/proj/src/Foo.astro`, `/proj`},
+			same: true,
+		},
+		{
+			name: "windows drive letter casing",
+			a:    [2]string{`c:\proj\src\Foo.astro`, `C:\Proj\`},
+			b:    [2]string{`/proj/src/Foo.astro`, `/proj/`},
+			same: true,
+		},
+		{
+			name: "different project roots",
+			a:    [2]string{`/home/a/proj/src/Foo.astro`, `/home/a/proj`},
+			b:    [2]string{`/ci/build/src/Foo.astro`, `/ci/build`},
+			same: true,
+		},
+		{
+			name: "different files",
+			a:    [2]string{`/proj/src/Foo.astro`, `/proj`},
+			b:    [2]string{`/proj/src/Bar.astro`, `/proj`},
+			same: false,
+		},
+		{
+			name: "posix paths are case-sensitive",
+			a:    [2]string{`/proj/src/Foo.astro`, `/Proj`},
+			b:    [2]string{`/proj/src/Foo.astro`, `/proj`},
+			same: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := ScopeHash(tt.a[0], tt.a[1])
+			b := ScopeHash(tt.b[0], tt.b[1])
+			if len(a) != 8 || len(b) != 8 {
+				t.Errorf("expected 8 character hashes, got %q and %q", a, b)
+			}
+			if (a == b) != tt.same {
+				t.Errorf("\nFAIL: %s\n  a: %s\n  b: %s", tt.name, a, b)
+			}
+		})
+	}
+}
+
+func TestTransformGeneratesScope(t *testing.T) {
+	source := `<div></div><style>div { color: red; }</style>`
+	want := fmt.Sprintf(`<div class="astro-%s"></div>`, ScopeHash(`/proj/src/Foo.astro`, `/proj`))
+	for _, filename := range []string{`C:\proj\src\Foo.astro`, `/proj/src/Foo.astro`} {
+		doc, err := astro.Parse(strings.NewReader(source))
+		if err != nil {
+			t.Error(err)
+		}
+		root := filename[:len(filename)-len(`\src\Foo.astro`)]
+		opts := TransformOptions{Filename: filename, ProjectRoot: root}
+		h := handler.NewHandler(source, filename)
+		ExtractStyles(doc, &opts, h)
+		Transform(doc, opts, h)
+		var b strings.Builder
+		astro.PrintToSource(&b, doc.LastChild.FirstChild.NextSibling.FirstChild)
+		if got := b.String(); got != want {
+			t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", filename, want, got)
+		}
+	}
+}
+
+func TestTransformDocScope(t *testing.T) {
+	tests := []struct {
+		name string
+		opts TransformOptions
+		want string
+	}{
+		{
+			name: "provided scope",
+			opts: TransformOptions{Scope: "abc123", Filename: "/src/Foo.astro"},
+			want: "abc123",
+		},
+		{
+			name: "sanitized scope",
+			opts: TransformOptions{Scope: "abc.123"},
+			want: "abc-123",
+		},
+		{
+			name: "scope derived from the filename",
+			opts: TransformOptions{Filename: "/proj/src/Foo.astro", ProjectRoot: "/proj"},
+			want: ScopeHash("/proj/src/Foo.astro", "/proj"),
+		},
+		{
+			name: "no scope",
+			opts: TransformOptions{},
+			want: "",
+		},
+	}
+	source := `<div></div><style>div { color: red; }</style>`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Error(err)
+			}
+			h := handler.NewHandler(source, tt.opts.Filename)
+			ExtractStyles(doc, &tt.opts, h)
+			Transform(doc, tt.opts, h)
+			if doc.Scope != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, doc.Scope)
+			}
+			if tt.want == "" {
+				return
+			}
+			var b strings.Builder
+			astro.PrintToSource(&b, doc.LastChild.FirstChild.NextSibling.FirstChild)
+			if want := fmt.Sprintf(`<div class="astro-%s"></div>`, doc.Scope); b.String() != want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, want, b.String())
+			}
+		})
+	}
+}
+
+func TestNormalizePaths(t *testing.T) {
+	tests := []struct {
+		name string
+		opts TransformOptions
+		want TransformOptions
+	}{
+		{
+			name: "windows path",
+			opts: TransformOptions{Filename: `C:\Users\me\proj\src\pages\index.astro`, NormalizedFilename: `C:\Users\me\proj\src\pages\index.astro`},
+			want: TransformOptions{Filename: `c:/Users/me/proj/src/pages/index.astro`, NormalizedFilename: `c:/Users/me/proj/src/pages/index.astro`},
+		},
+		{
+			name: "windows path inside the project root",
+			opts: TransformOptions{Filename: `C:\Users\me\proj\src\pages\index.astro`, NormalizedFilename: `C:\Users\me\proj\src\pages\index.astro`, ProjectRoot: `c:\users\me\proj\`},
+			want: TransformOptions{Filename: `c:/Users/me/proj/src/pages/index.astro`, NormalizedFilename: `src/pages/index.astro`, ProjectRoot: `c:/users/me/proj/`},
+		},
+		{
+			name: "mixed separators",
+			opts: TransformOptions{Filename: `D:/proj\src/Card.astro`},
+			want: TransformOptions{Filename: `d:/proj/src/Card.astro`},
+		},
+		{
+			name: "posix path inside the project root",
+			opts: TransformOptions{Filename: `/home/me/proj/src/pages/index.astro`, NormalizedFilename: `/home/me/proj/src/pages/index.astro`, ProjectRoot: `/home/me/proj`},
+			want: TransformOptions{Filename: `/home/me/proj/src/pages/index.astro`, NormalizedFilename: `src/pages/index.astro`, ProjectRoot: `/home/me/proj`},
+		},
+		{
+			name: "outside the project root",
+			opts: TransformOptions{Filename: `C:\other\index.astro`, NormalizedFilename: `C:\other\index.astro`, ProjectRoot: `C:\proj`},
+			want: TransformOptions{Filename: `c:/other/index.astro`, NormalizedFilename: `c:/other/index.astro`, ProjectRoot: `c:/proj`},
+		},
+		{
+			name: "stdin",
+			opts: TransformOptions{Filename: "<stdin>", NormalizedFilename: "<stdin>", ProjectRoot: `C:\proj`},
+			want: TransformOptions{Filename: "<stdin>", NormalizedFilename: "<stdin>", ProjectRoot: `c:/proj`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			NormalizePaths(&opts)
+			got := [3]string{opts.Filename, opts.NormalizedFilename, opts.ProjectRoot}
+			want := [3]string{tt.want.Filename, tt.want.NormalizedFilename, tt.want.ProjectRoot}
+			if got != want {
+				t.Errorf("\nFAIL: %s\n  want: %q\n  got:  %q", tt.name, want, got)
+			}
+		})
+	}
+}
+
+func TestOptimizedScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "class selector",
+			source: `<div><button class="btn">A</button><span class="label">B</span><p class={cls}>C</p></div><style>.btn { color: red; }</style>`,
+			want:   `<div><button class="btn astro-xxxxxx">A</button><span class="label">B</span><p class={((cls) ?? "") + " astro-xxxxxx"}>C</p></div>`,
+		},
+		{
+			name:   "class:list",
+			source: `<div class:list={["card", active]}></div><div class:list="btn"></div><div></div><style>.btn { color: red; }</style>`,
+			want:   `<div class:list={["card", active, "astro-xxxxxx"]}></div><div class:list="btn astro-xxxxxx"></div><div></div>`,
+		},
+		{
+			name:   "type selector",
+			source: `<div><p>A</p><span>B</span></div><style>p { color: red; }</style>`,
+			want:   `<div><p class="astro-xxxxxx">A</p><span>B</span></div>`,
+		},
+		{
+			name:   "id selector",
+			source: `<div id="main"></div><div id="other"></div><div id={id}></div><style>#main { color: red; }</style>`,
+			want:   `<div id="main" class="astro-xxxxxx"></div><div id="other"></div><div id={id} class="astro-xxxxxx"></div>`,
+		},
+		{
+			name:   "descendant selector",
+			source: `<div class="card"><h2>Title</h2><p>Text</p></div><style>.card h2 { color: red; }</style>`,
+			want:   `<div class="card astro-xxxxxx"><h2 class="astro-xxxxxx">Title</h2><p>Text</p></div>`,
+		},
+		{
+			name:   "global selector",
+			source: `<div class="dark"><p class="text">Text</p></div><style>:global(.dark) .text { color: red; }</style>`,
+			want:   `<div class="dark"><p class="text astro-xxxxxx">Text</p></div>`,
+		},
+		{
+			name:   "universal selector falls back",
+			source: `<div><p>A</p></div><style>.btn { color: red; } * { margin: 0; }</style>`,
+			want:   `<div class="astro-xxxxxx"><p class="astro-xxxxxx">A</p></div>`,
+		},
+		{
+			name:   "attribute selector falls back",
+			source: `<div><p>A</p></div><style>[data-active] { color: red; }</style>`,
+			want:   `<div class="astro-xxxxxx"><p class="astro-xxxxxx">A</p></div>`,
+		},
+		{
+			name:   ":is()",
+			source: `<div><h1>A</h1><h2>B</h2><h3>C</h3></div><style>:is(h1, h2) { color: red; }</style>`,
+			want:   `<div><h1 class="astro-xxxxxx">A</h1><h2 class="astro-xxxxxx">B</h2><h3>C</h3></div>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			transformOptions := TransformOptions{Scope: "xxxxxx", OptimizedScopes: true}
+			h := handler.NewHandler(tt.source, "/test.astro")
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+			for n := doc.LastChild.FirstChild.NextSibling.FirstChild; n != nil; n = n.NextSibling {
+				astro.PrintToSource(&b, n)
+			}
+			got := b.String()
+			if tt.want != got {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNormalizeTransform(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "element and attribute names",
+			source: `<DIV CLASS="x" Hidden>Hello</DIV>`,
+			want:   `<div class="x" hidden>Hello</div>`,
+		},
+		{
+			name:   "expression attributes",
+			source: `<DIV Data-Value={value} {...Props}></DIV>`,
+			want:   `<div Data-Value={value} {...}></div>`,
+		},
+		{
+			name:   "component",
+			source: `<MyComponent Foo="bar" client:Load />`,
+			want:   `<MyComponent Foo="bar" client:Load client:component-hydration="Load"></MyComponent>`,
+		},
+		{
+			name:   "directives",
+			source: `<P set:HTML={html} />`,
+			want:   `<p set:HTML={html}></p>`,
+		},
+		{
+			name:   "mixed case tag is a component",
+			source: `<Button Type="submit" />`,
+			want:   `<Button Type="submit"></Button>`,
+		},
+		{
+			name:   "custom element",
+			source: `<my-Element FooBar="x"></my-Element>`,
+			want:   `<my-Element FooBar="x"></my-Element>`,
+		},
+		{
+			name:   "svg",
+			source: `<svg viewBox="0 0 10 10"><foreignObject></foreignObject></svg>`,
+			want:   `<svg viewBox="0 0 10 10"><foreignObject></foreignObject></svg>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			h := handler.NewHandler(tt.source, "/test.astro")
+			Transform(doc, TransformOptions{Normalize: true}, h)
+			walk(doc, func(n *astro.Node) {
+				if b.Len() == 0 && n.Type == astro.ElementNode && !IsImplicitNode(n) {
+					astro.PrintToSource(&b, n)
+				}
+			})
+			got := b.String()
+			if tt.want != got {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTransformScopingLayout(t *testing.T) {
+	source := `<html lang="en"><head><meta charset="utf-8"><title>Layout</title><link rel="icon" href="/favicon.svg"><base href="/"><noscript><img src="/pixel.gif"></noscript></head><body><main><h1>Hello</h1></main></body></html><style>h1 { color: red; }</style>`
+	tests := []struct {
+		strategy string
+		want     string
+	}{
+		{
+			strategy: "where",
+			want:     `<html lang="en"><head><meta charset="utf-8"></meta><title>Layout</title><link rel="icon" href="/favicon.svg"></link><base href="/"></base><noscript><img src="/pixel.gif"></img></noscript></head><body class="astro-xxxxxx"><main class="astro-xxxxxx"><h1 class="astro-xxxxxx">Hello</h1></main></body></html>`,
+		},
+		{
+			strategy: "attribute",
+			want:     `<html lang="en"><head><meta charset="utf-8"></meta><title>Layout</title><link rel="icon" href="/favicon.svg"></link><base href="/"></base><noscript><img src="/pixel.gif"></img></noscript></head><body data-astro-cid-xxxxxx><main data-astro-cid-xxxxxx><h1 data-astro-cid-xxxxxx>Hello</h1></main></body></html>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			b.Reset()
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Error(err)
+			}
+			transformOptions := TransformOptions{Scope: "xxxxxx", ScopedStyleStrategy: tt.strategy}
+			h := handler.NewHandler(source, "/test.astro")
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+			astro.PrintToSource(&b, doc)
+			got := b.String()
+			if tt.want != got {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.strategy, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTransformNoscript(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     string
+		warnings []string
+	}{
+		{
+			name:   "markup is not scoped",
+			source: `<noscript><div class="x"></div></noscript><div class="x"></div><style>.x { color: red; }</style>`,
+			want:   `<noscript><div class="x"></div></noscript><div class="x astro-xxxxxx"></div>`,
+		},
+		{
+			name:   "nested",
+			source: `<main><noscript><p>Please enable <strong>JavaScript</strong></p></noscript></main><style>p { color: red; }</style>`,
+			want:   `<main class="astro-xxxxxx"><noscript><p>Please enable <strong>JavaScript</strong></p></noscript></main>`,
+		},
+		{
+			name:     "hydrated component",
+			source:   `<noscript><Counter client:load /></noscript>`,
+			want:     `<noscript><Counter></Counter></noscript>`,
+			warnings: []string{"client:load 1:20"},
+		},
+		{
+			name:   "static component",
+			source: `<noscript><Counter /></noscript>`,
+			want:   `<noscript><Counter></Counter></noscript>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			transformOptions := TransformOptions{Scope: "xxxxxx"}
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+			astro.PrintToSource(&b, doc)
+			got := b.String()
+			if tt.want != got {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+			warnings := []string{}
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_HYDRATION_IN_NOSCRIPT) {
+					warnings = append(warnings, fmt.Sprintf("%s %d:%d", tt.source[w.Location.Column-1:w.Location.Column-1+w.Location.Length], w.Location.Line, w.Location.Column))
+				}
+			}
+			if strings.Join(warnings, ",") != strings.Join(tt.warnings, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.warnings, warnings)
+			}
+			if len(doc.HydratedComponents) != 0 {
+				t.Errorf("\nFAIL: %s\n  expected no hydrated components, got %d", tt.name, len(doc.HydratedComponents))
+			}
+		})
+	}
+}
+
+func TestTransformFragment(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     string
+		warnings []string
+	}{
+		{
+			name:     "hydrated fragment",
+			source:   `<Fragment client:load><div>a</div></Fragment><style>div { color: red; }</style>`,
+			want:     `<Fragment client:load><div class="astro-xxxxxx">a</div></Fragment>`,
+			warnings: []string{"client:load 1:11"},
+		},
+		{
+			name:   "named fragment",
+			source: `<Fragment><div>a</div></Fragment><style>div { color: red; }</style>`,
+			want:   `<Fragment><div class="astro-xxxxxx">a</div></Fragment>`,
+		},
+		{
+			name:   "shorthand fragment",
+			source: `<><div>a</div></><style>div { color: red; }</style>`,
+			want:   `<><div class="astro-xxxxxx">a</div></>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			transformOptions := TransformOptions{Scope: "xxxxxx"}
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+			astro.PrintToSource(&b, doc)
+			got := b.String()
+			if tt.want != got {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+			warnings := []string{}
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_IGNORED_DIRECTIVE) {
+					warnings = append(warnings, fmt.Sprintf("%s %d:%d", tt.source[w.Location.Column-1:w.Location.Column-1+w.Location.Length], w.Location.Line, w.Location.Column))
+				}
+			}
+			if strings.Join(warnings, ",") != strings.Join(tt.warnings, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.warnings, warnings)
+			}
+			if len(doc.HydratedComponentNodes) != 0 {
+				t.Errorf("\nFAIL: %s\n  expected no hydrated components, got %d", tt.name, len(doc.HydratedComponentNodes))
+			}
+		})
+	}
+}
+
+func TestExtractImports(t *testing.T) {
+	source := `---
+import Counter from "../components/Counter.jsx";
+import {
+	a,
+	b,
+} from '../utils';
+import "../styles/global.css";
+const value = 1;
+---
+<Counter />`
+	want := []string{"../components/Counter.jsx", "../utils", "../styles/global.css"}
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	got := ExtractImports(doc)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("\nFAIL: ExtractImports\n  want: %v\n  got:  %v", want, got)
+	}
+}
+
+func TestTransformWithResult(t *testing.T) {
+	source := `---
+import Counter from "../components/Counter.jsx";
+import Chart from "../components/Chart.jsx";
+---
+<html>
+<head><title>Result</title></head>
+<body>
+	<Counter client:visible />
+	<Chart client:only="react" />
+	<Counter client:load />
+	<script src="/external.js"></script>
+	<script>console.log("inline")</script>
+	<style>h1 { color: red; }</style>
+</body>
+</html>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	transformOptions := TransformOptions{Scope: "xxxxxx", ExperimentalScriptOrder: true}
+	h := handler.NewHandler(source, "/test.astro")
+	ExtractStyles(doc, &transformOptions, h)
+	result := TransformWithResult(doc, transformOptions, h)
+
+	if result.Doc != doc {
+		t.Error("expected result to reference the transformed document")
+	}
+	if got := strings.Join(result.HydrationDirectives, ","); got != "load,only,visible" {
+		t.Errorf("\nFAIL: HydrationDirectives\n  want: %s\n  got:  %s", "load,only,visible", got)
+	}
+	if len(result.HydratedComponents) != 2 || result.HydratedComponents[0].Specifier != "../components/Counter.jsx" {
+		t.Errorf("unexpected hydrated components: %v", result.HydratedComponents)
+	}
+	if len(result.ClientOnlyComponents) != 1 || result.ClientOnlyComponents[0].ExportName != "default" {
+		t.Errorf("unexpected client-only components: %v", result.ClientOnlyComponents)
+	}
+	if len(result.Scripts) != 2 || result.Scripts[0].Type != "external" || result.Scripts[0].Src != "/external.js" || result.Scripts[1].Type != "inline" || result.Scripts[1].Code != `console.log("inline")` {
+		t.Errorf("unexpected scripts: %v", result.Scripts)
+	}
+	if len(result.Styles) != 1 || !strings.Contains(result.Styles[0].Code, "astro-xxxxxx") {
+		t.Errorf("unexpected styles: %v", result.Styles)
+	}
+	if !result.ContainsHead {
+		t.Error("expected ContainsHead to be true")
+	}
+}
+
+func TestExternalScripts(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		opts     TransformOptions
+		want     string
+		scripts  []string
+		external []string
+	}{
+		{
+			name:     "deduplicated by src",
+			source:   `<script src="/a.js"></script><script>console.log("inline")</script><div /><script src="/a.js"></script>`,
+			want:     `<div></div>`,
+			scripts:  []string{`console.log("inline")`},
+			external: []string{"/a.js"},
+		},
+		{
+			name:     "deduplicated by resolved src",
+			source:   `<script src="./a.js"></script><script src="../pages/a.js"></script><script src="./b.js"></script>`,
+			opts:     TransformOptions{ExperimentalScriptOrder: true},
+			want:     ``,
+			external: []string{"./a.js", "./b.js"},
+		},
+		{
+			name:     "authored order",
+			source:   `<script src="/a.js"></script><script>a()</script><script src="/b.js"></script><script>b()</script>`,
+			opts:     TransformOptions{ExperimentalScriptOrder: true},
+			want:     ``,
+			scripts:  []string{`a()`, `b()`},
+			external: []string{"/a.js", "/b.js"},
+		},
+		{
+			name:    "rendered in place",
+			source:  `<script src="/a.js"></script><script src="/a.js"></script>`,
+			opts:    TransformOptions{RenderScript: true},
+			want:    `<script src="/a.js"></script><script src="/a.js"></script>`,
+			scripts: []string{`/a.js`, `/a.js`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			tt.opts.Filename = "/src/pages/index.astro"
+			h := handler.NewHandler(tt.source, "/src/pages/index.astro")
+			Transform(doc, tt.opts, h)
+			var b strings.Builder
+			astro.PrintToSource(&b, doc)
+			if got := b.String(); got != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+			scripts := []string{}
+			for _, n := range doc.Scripts {
+				if src := GetQuotedAttr(n, "src"); src != "" {
+					scripts = append(scripts, src)
+				} else {
+					scripts = append(scripts, n.FirstChild.Data)
+				}
+			}
+			if strings.Join(scripts, ",") != strings.Join(tt.scripts, ",") {
+				t.Errorf("\nFAIL: %s scripts\n  want: %v\n  got:  %v", tt.name, tt.scripts, scripts)
+			}
+			external := []string{}
+			for _, n := range doc.ExternalScripts {
+				external = append(external, GetQuotedAttr(n, "src"))
+			}
+			if strings.Join(external, ",") != strings.Join(tt.external, ",") {
+				t.Errorf("\nFAIL: %s external scripts\n  want: %v\n  got:  %v", tt.name, tt.external, external)
+			}
+		})
+	}
+}
+
+func TestAnalyzeTransform(t *testing.T) {
+	source := `---
+import Counter from "../components/Counter.jsx";
+---
+<html>
+<head><title>Report</title></head>
+<body>
+	<Counter client:visible />
+	<script src="/external.js"></script>
+	<script>console.log("inline")</script>
+	<style>h1 { color: red; }</style>
+	<h1>Hello</h1>
+</body>
+</html>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	var before strings.Builder
+	astro.PrintToSource(&before, doc)
+	h := handler.NewHandler(source, "/src/pages/index.astro")
+	report := AnalyzeTransform(doc, TransformOptions{Filename: "/src/pages/index.astro"}, h)
+
+	var after strings.Builder
+	astro.PrintToSource(&after, doc)
+	if before.String() != after.String() {
+		t.Errorf("\nFAIL: expected doc to be unchanged\n  want: %s\n  got:  %s", before.String(), after.String())
+	}
+	if len(doc.Styles) != 0 || len(doc.Scripts) != 0 || len(doc.HydratedComponents) != 0 || len(doc.HydrationDirectives) != 0 {
+		t.Errorf("\nFAIL: expected nothing to be collected on doc\n  got:  %v %v %v", doc.Styles, doc.Scripts, doc.HydratedComponents)
+	}
+	if len(h.Diagnostics()) != 0 {
+		t.Errorf("\nFAIL: expected the handler to be unchanged\n  got:  %v", h.Diagnostics())
+	}
+
+	if want := ScopeHash("/src/pages/index.astro", ""); report.Scope != want {
+		t.Errorf("\nFAIL: Scope\n  want: %s\n  got:  %s", want, report.Scope)
+	}
+	if report.Styles != 1 || report.Scripts != 1 || report.ExternalScripts != 1 {
+		t.Errorf("\nFAIL: expected 1 style, 1 script and 1 external script\n  got:  %d %d %d", report.Styles, report.Scripts, report.ExternalScripts)
+	}
+	if len(report.HydratedComponents) != 1 || report.HydratedComponents[0].Specifier != "../components/Counter.jsx" || strings.Join(report.HydrationDirectives, ",") != "visible" {
+		t.Errorf("\nFAIL: unexpected hydrated components\n  got:  %v %v", report.HydratedComponents, report.HydrationDirectives)
+	}
+	if len(report.Diagnostics) != 1 || !strings.Contains(report.Diagnostics[0].Text, "derived from the filename") {
+		t.Errorf("\nFAIL: expected the derived scope to be reported\n  got:  %v", report.Diagnostics)
+	}
+}
+
+func TestAnalyzeTransformDiagnostics(t *testing.T) {
+	source := `<html>
+<head><title>Report</title></head>
+<body>
+	<ul>
+		<li>One
+		<li>Two
+	</ul>
+	<section transition:name="hero" transition:animate="slide">
+		<span>Unclosed
+	</section>
+	<div id="a" transition:persist></div>
+	<div id="a"></div>
+	<style>h1 { color: red; }</style>
+</body>
+</html>`
+	opts := TransformOptions{Filename: "/src/pages/index.astro"}
+
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	report := AnalyzeTransform(doc, opts, handler.NewHandler(source, opts.Filename))
+
+	doc, err = astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	h := handler.NewHandler(source, opts.Filename)
+	ExtractStyles(doc, &opts, h)
+	Transform(doc, opts, h)
+	want := h.Diagnostics()
+
+	if len(want) == 0 {
+		t.Fatal("\nFAIL: expected the fixture to report diagnostics")
+	}
+	if !reflect.DeepEqual(report.Diagnostics, want) {
+		t.Errorf("\nFAIL: expected AnalyzeTransform to report the diagnostics of Transform\n  want: %v\n  got:  %v", want, report.Diagnostics)
+	}
+}
+
+func TestScriptHoistTruthiness(t *testing.T) {
+	tests := []struct {
+		source  string
+		hoisted bool
+	}{
+		{source: `<script hoist>a()</script>`, hoisted: true},
+		{source: `<script hoist={true}>a()</script>`, hoisted: true},
+		{source: `<script hoist="false">a()</script>`},
+		{source: `<script hoist={enabled}>a()</script>`},
+		{source: `<script data-enabled={true}>a()</script>`},
+		{source: `<script data-enabled="">a()</script>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			h := handler.NewHandler(tt.source, "/test.astro")
+			Transform(doc, TransformOptions{}, h)
+			if hoisted := len(doc.Scripts) == 1; hoisted != tt.hoisted {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.source, tt.hoisted, hoisted)
+			}
+		})
+	}
+}
+
+func TestInlineScriptAttributes(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{source: `<script hoist defer>a()</script>`, want: []string{"defer 1:15 `defer` has no effect on an inline <script>."}},
+		{source: `<script hoist async defer>a()</script>`, want: []string{
+			"async 1:15 `async` has no effect on an inline <script>.",
+			"defer 1:21 `defer` has no effect on an inline <script>.",
+		}},
+		{source: `<script hoist src="x" defer></script>`},
+		{source: `<script hoist>a()</script>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			Transform(doc, TransformOptions{}, h)
+			if hoisted := len(doc.Scripts) + len(doc.ExternalScripts); hoisted != 1 {
+				t.Errorf("\nFAIL: %s\n  expected the script to be hoisted, got %d hoisted scripts", tt.source, hoisted)
+			}
+			got := []string{}
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_INLINE_SCRIPT_ATTRIBUTE) {
+					got = append(got, fmt.Sprintf("%s %d:%d %s", tt.source[w.Location.Column-1:w.Location.Column-1+w.Location.Length], w.Location.Line, w.Location.Column, w.Text))
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.source, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConflictingDeclarations(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "const in two scripts",
+			source: "<script>const x = 1;</script>\n<script>\nconst x = 2;\n</script>",
+			want:   []string{"x 3:7"},
+		},
+		{
+			name:   "function and class",
+			source: "<script>function f() {}</script><div></div><script>class f {}</script>",
+			want:   []string{"f 1:58"},
+		},
+		{
+			name:   "var in two scripts",
+			source: "<script>var x = 1;</script><script>var x = 2;</script>",
+		},
+		{
+			name:   "nested declarations",
+			source: "<script>const x = 1;</script><script>{ const x = 2; }</script>",
+		},
+		{
+			name:   "inline script",
+			source: "<script>const x = 1;</script><script is:inline>const x = 2;</script>",
+		},
+		{
+			name:   "same script",
+			source: "<script>let x = 1; let x = 2;</script>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			Transform(doc, TransformOptions{}, h)
+			got := []string{}
+			lines := strings.Split(tt.source, "\n")
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_CONFLICTING_DECLARATION) {
+					line := lines[w.Location.Line-1]
+					got = append(got, fmt.Sprintf("%s %d:%d", line[w.Location.Column-1:w.Location.Column-1+w.Location.Length], w.Location.Line, w.Location.Column))
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestElementsInExpressions(t *testing.T) {
+	tests := []struct {
+		name         string
+		source       string
+		renderScript bool
+		styles       int
+		scripts      int
+		want         []string
+	}{
+		{
+			name:   "conditional style",
+			source: "{show && <style>p { color: red; }</style>}",
+			styles: 1,
+			want:   []string{"2023 1:11 This <style> is inside an expression, but styles are always hoisted: it applies whether or not the expression renders it."},
+		},
+		{
+			name:   "nested style",
+			source: "{show && <div><style>p { color: red; }</style></div>}",
+			styles: 1,
+			want:   []string{"2023 1:16 This <style> is inside an expression, but styles are always hoisted: it applies whether or not the expression renders it."},
+		},
+		{
+			name:   "inline style",
+			source: "{show && <style is:inline>p { color: red; }</style>}",
+		},
+		{
+			name:   "script in a loop",
+			source: "{items.map(() => <script>console.log(1)</script>)}",
+			want:   []string{"2023 1:19 This <script> is inside an expression, so it is rendered in place as is, without being processed."},
+		},
+		{
+			name:    "hoisted script",
+			source:  "{show && <script hoist>console.log(1)</script>}",
+			scripts: 1,
+			want:    []string{"2023 1:11 This <script hoist> is inside an expression, but hoisted scripts always run: it runs whether or not the expression renders it."},
+		},
+		{
+			name:         "rendered script",
+			source:       "{show && <script>console.log(1)</script>}",
+			renderScript: true,
+			scripts:      1,
+		},
+		{
+			name:    "top-level script",
+			source:  "<script>console.log(1)</script>",
+			scripts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			opts := TransformOptions{RenderScript: tt.renderScript}
+			ExtractStyles(doc, &opts, h)
+			Transform(doc, opts, h)
+			if len(doc.Styles) != tt.styles || len(doc.Scripts) != tt.scripts {
+				t.Errorf("\nFAIL: %s\n  expected %d styles and %d scripts to be hoisted, got %d and %d", tt.name, tt.styles, tt.scripts, len(doc.Styles), len(doc.Scripts))
+			}
+			got := []string{}
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_ELEMENT_IN_EXPRESSION) {
+					got = append(got, fmt.Sprintf("%d %d:%d %s", w.Code, w.Location.Line, w.Location.Column, w.Text))
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSortedHydrationDirectives(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{
+			name:   "visible then load",
+			source: `<Counter client:visible /><Counter client:load />`,
+		},
+		{
+			name:   "load then visible",
+			source: `<Counter client:load /><Counter client:visible /><Counter client:load />`,
+		},
+	}
+	want := []string{"load", "visible"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "---\nimport Counter from '../components/Counter.jsx';\n---\n" + tt.source
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Error(err)
+			}
+			h := handler.NewHandler(source, "/test.astro")
+			Transform(doc, TransformOptions{}, h)
+			got := doc.SortedHydrationDirectives()
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, want, got)
+			}
+		})
+	}
+}
+
+func TestHydrationDirectiveValues(t *testing.T) {
+	source := `---
+import Counter from '../components/Counter.jsx';
+---
+<Counter client:load />
+<Counter client:visible />
+<Counter client:visible={{ rootMargin: '200px' }} />
+<Counter client:idle={{ timeout: 500 }} />
+<Counter client:media="(max-width: 50em)" />
+<Counter client:only="react" />`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	h := handler.NewHandler(source, "/test.astro")
+	Transform(doc, TransformOptions{}, h)
+
+	want := map[string]string{
+		"load":    "",
+		"visible": "{ rootMargin: '200px' }",
+		"idle":    "{ timeout: 500 }",
+		"media":   "(max-width: 50em)",
+		"only":    "react",
+	}
+	if fmt.Sprint(doc.HydrationDirectives) != fmt.Sprint(want) {
+		t.Errorf("\nFAIL: HydrationDirectives\n  want: %v\n  got:  %v", want, doc.HydrationDirectives)
+	}
+
+	// Values stay on the directives, verbatim and with their kind, and aren't copied to
+	// another prop that would evaluate them again
+	got := make([]string, 0)
+	for _, n := range doc.HydratedComponentNodes {
+		for _, attr := range n.Attr {
+			if strings.HasPrefix(attr.Key, "client:component-hydration-") {
+				t.Errorf("\nFAIL: expected the value not to be copied\n  got:  %s", attr.Key)
+			}
+			if directiveValue(attr) != "" && !strings.HasPrefix(attr.Key, "client:component-") {
+				got = append(got, fmt.Sprintf("%s=%s:%d", attr.Key, attr.Val, attr.Type))
+			}
+		}
+	}
+	values := []string{
+		fmt.Sprintf("client:media=(max-width: 50em):%d", astro.QuotedAttribute),
+		fmt.Sprintf("client:idle={ timeout: 500 }:%d", astro.ExpressionAttribute),
+		fmt.Sprintf("client:visible={ rootMargin: '200px' }:%d", astro.ExpressionAttribute),
+	}
+	if strings.Join(got, ",") != strings.Join(values, ",") {
+		t.Errorf("\nFAIL: directive values\n  want: %v\n  got:  %v", values, got)
+	}
+}
+
+func TestHydratedComponentResolution(t *testing.T) {
+	tests := []struct {
+		name       string
+		imports    string
+		component  string
+		specifier  string
+		exportName string
+	}{
+		{
+			name:       "default import",
+			imports:    `import Counter from "./Counter.jsx";`,
+			component:  "Counter",
+			specifier:  "./Counter.jsx",
+			exportName: "default",
+		},
+		{
+			name:       "named import",
+			imports:    `import { Counter } from "./components.js";`,
+			component:  "Counter",
+			specifier:  "./components.js",
+			exportName: "Counter",
+		},
+		{
+			name:       "aliased import",
+			imports:    `import { Counter as Clicker } from "./components.js";`,
+			component:  "Clicker",
+			specifier:  "./components.js",
+			exportName: "Counter",
+		},
+		{
+			name:       "namespace import",
+			imports:    `import * as NS from "./components.js";`,
+			component:  "NS.Counter",
+			specifier:  "./components.js",
+			exportName: "Counter",
+		},
+		{
+			name:       "default import member",
+			imports:    `import UI from "./ui.js";`,
+			component:  "UI.Counter",
+			specifier:  "./ui.js",
+			exportName: "default.Counter",
+		},
+		{
+			name:       "aliased import member",
+			imports:    `import { UI as Kit } from "./ui.js";`,
+			component:  "Kit.Counter",
+			specifier:  "./ui.js",
+			exportName: "UI.Counter",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := fmt.Sprintf("---\n%s\n---\n<%s client:load />", tt.imports, tt.component)
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Error(err)
+			}
+			h := handler.NewHandler(source, "/test.astro")
+			HydrationPass(doc, TransformOptions{Filename: "<stdin>"}, h)
+
+			var component *astro.Node
+			walk(doc, func(n *astro.Node) {
+				if n.Data == tt.component {
+					component = n
+				}
+			})
+			if component == nil {
+				t.Fatalf("expected to find <%s>", tt.component)
+			}
+			want := fmt.Sprintf(`"%s" "%s"`, tt.specifier, tt.exportName)
+			got := ""
+			if path, export := GetAttr(component, "client:component-path"), GetAttr(component, "client:component-export"); path != nil && export != nil {
+				got = fmt.Sprintf("%s %s", path.Val, export.Val)
+			}
+			if want != got {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, want, got)
+			}
+		})
+	}
+}
+
+func TestAbsolutizeMetadataURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "canonical link",
+			source: `<link rel="canonical" href="./">`,
+			want:   `<link rel="canonical" href="https://example.com/blog/hello/"></link>`,
+		},
+		{
+			name:   "og:image root relative",
+			source: `<meta property="og:image" content="/og.png">`,
+			want:   `<meta property="og:image" content="https://example.com/og.png"></meta>`,
+		},
+		{
+			name:   "og:image path relative",
+			source: `<meta property="og:image" content="cover.png">`,
+			want:   `<meta property="og:image" content="https://example.com/blog/hello/cover.png"></meta>`,
+		},
+		{
+			name:   "twitter:image",
+			source: `<meta name="twitter:image" content="/card.png">`,
+			want:   `<meta name="twitter:image" content="https://example.com/card.png"></meta>`,
+		},
+		{
+			name:   "absolute url",
+			source: `<meta property="og:image" content="https://cdn.example.com/og.png">`,
+			want:   `<meta property="og:image" content="https://cdn.example.com/og.png"></meta>`,
+		},
+		{
+			name:   "protocol relative url",
+			source: `<link rel="canonical" href="//example.org/">`,
+			want:   `<link rel="canonical" href="//example.org/"></link>`,
+		},
+		{
+			name:   "expression",
+			source: `<link rel="canonical" href={canonical}>`,
+			want:   `<link rel="canonical" href={canonical}></link>`,
+		},
+		{
+			name:   "other link",
+			source: `<link rel="icon" href="/favicon.svg">`,
+			want:   `<link rel="icon" href="/favicon.svg"></link>`,
+		},
+		{
+			name:   "other meta",
+			source: `<meta name="description" content="/not-a-url">`,
+			want:   `<meta name="description" content="/not-a-url"></meta>`,
+		},
+		{
+			name:   "og:image:secure_url",
+			source: `<meta property="og:image:secure_url" content="/og.png">`,
+			want:   `<meta property="og:image:secure_url" content="https://example.com/og.png"></meta>`,
+		},
+		{
+			name:   "og:title",
+			source: `<meta property="og:title" content="Hello World">`,
+			want:   `<meta property="og:title" content="Hello World"></meta>`,
+		},
+		{
+			name:   "og:type",
+			source: `<meta property="og:type" content="article">`,
+			want:   `<meta property="og:type" content="article"></meta>`,
+		},
+		{
+			name:   "twitter:card",
+			source: `<meta name="twitter:card" content="summary_large_image">`,
+			want:   `<meta name="twitter:card" content="summary_large_image"></meta>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			source := "<html><head>" + tt.source + "</head><body></body></html>"
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Error(err)
+			}
+			h := handler.NewHandler(source, "/test.astro")
+			Transform(doc, TransformOptions{Scope: "xxxxxx", Site: "https://example.com", Pathname: "/blog/hello/"}, h)
+			astro.PrintToSource(&b, doc)
+			got := b.String()
+			want := "<html><head>" + tt.want + "</head><body></body></html>"
+			if want != got {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, want, got)
+			}
+		})
+	}
+}
+
+func TestResolveRootURLs(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		site   string
+		base   string
+		want   string
+	}{
+		{
+			name:   "link",
+			source: `<link rel="stylesheet" href="/global.css">`,
+			want:   `<link rel="stylesheet" href="https://example.com/docs/global.css"></link>`,
+		},
+		{
+			name:   "inline script",
+			source: `<script is:inline src="/analytics.js"></script>`,
+			want:   `<script is:inline src="https://example.com/docs/analytics.js"></script>`,
+		},
+		{
+			name:   "bundled script",
+			source: `<script src="/src/scripts/menu.ts"></script>`,
+			want:   `<script src="/src/scripts/menu.ts"></script>`,
+		},
+		{
+			name:   "hoisted script",
+			source: `<script hoist src="/src/scripts/menu.ts"></script>`,
+			want:   `<script hoist src="/src/scripts/menu.ts"></script>`,
+		},
+		{
+			name:   "script kept in the html",
+			source: `<script src="/analytics.js" defer></script>`,
+			want:   `<script src="https://example.com/docs/analytics.js" defer></script>`,
+		},
+		{
+			name:   "site with a trailing slash",
+			source: `<img src="/logo.png">`,
+			site:   "https://example.com/",
+			want:   `<img src="https://example.com/docs/logo.png"></img>`,
+		},
+		{
+			name:   "site with a path",
+			source: `<a href="/guide/">Guide</a>`,
+			site:   "https://example.com/en",
+			base:   "/",
+			want:   `<a href="https://example.com/en/guide/">Guide</a>`,
+		},
+		{
+			name:   "base without slashes",
+			source: `<a href="/">Home</a>`,
+			base:   "docs",
+			want:   `<a href="https://example.com/docs/">Home</a>`,
+		},
+		{
+			name:   "absolute url",
+			source: `<a href="https://astro.build/">Astro</a>`,
+			want:   `<a href="https://astro.build/">Astro</a>`,
+		},
+		{
+			name:   "protocol relative url",
+			source: `<img src="//cdn.example.com/logo.png">`,
+			want:   `<img src="//cdn.example.com/logo.png"></img>`,
+		},
+		{
+			name:   "relative url",
+			source: `<img src="logo.png">`,
+			want:   `<img src="logo.png"></img>`,
+		},
+		{
+			name:   "fragment",
+			source: `<a href="#top">Top</a>`,
+			want:   `<a href="#top">Top</a>`,
+		},
+		{
+			name:   "data and mailto",
+			source: `<img src="data:image/png;base64,AAAA"><a href="mailto:hi@example.com">Mail</a>`,
+			want:   `<img src="data:image/png;base64,AAAA"></img><a href="mailto:hi@example.com">Mail</a>`,
+		},
+		{
+			name:   "expression",
+			source: `<a href={"/about/"}>About</a>`,
+			want:   `<a href={"/about/"}>About</a>`,
+		},
+		{
+			name:   "component",
+			source: `<Link href="/about/">About</Link>`,
+			want:   `<Link href="/about/">About</Link>`,
+		},
+		{
+			name:   "other attributes",
+			source: `<div data-src="/a.png" title="/b"></div>`,
+			want:   `<div data-src="/a.png" title="/b"></div>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			opts := TransformOptions{Site: "https://example.com", Base: "/docs/", ResolveRootURLs: true}
+			if tt.site != "" {
+				opts.Site = tt.site
+			}
+			if tt.base != "" {
+				opts.Base = tt.base
+			}
+			walk(doc, func(n *astro.Node) {
+				ResolveRootURLs(n, opts)
+			})
+			var b strings.Builder
+			astro.PrintToSource(&b, doc)
+			if got, want := b.String(), tt.want; got != want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, want, got)
+			}
+		})
+	}
+	for _, resolve := range []bool{false, true} {
+		source := `<link rel="canonical" href="/about/"><a href="/about/">About</a>`
+		doc, err := astro.Parse(strings.NewReader(source))
+		if err != nil {
+			t.Error(err)
+		}
+		Transform(doc, TransformOptions{Site: "https://example.com", Base: "/docs", ResolveRootURLs: resolve}, handler.NewHandler(source, "/test.astro"))
+		var b strings.Builder
+		astro.PrintToSource(&b, doc)
+		// Canonical URLs are absolutized either way, but only root resolution knows about `Base`
+		want := `<link rel="canonical" href="https://example.com/about/"></link><a href="/about/">About</a>`
+		if resolve {
+			want = `<link rel="canonical" href="https://example.com/docs/about/"></link><a href="https://example.com/docs/about/">About</a>`
+		}
+		if got := b.String(); got != want {
+			t.Errorf("\nFAIL: Transform with ResolveRootURLs: %v\n  want: %s\n  got:  %s", resolve, want, got)
+		}
+	}
+}
+
+func TestTransformResultPageExports(t *testing.T) {
+	source := `---
+export const prerender = false;
+export async function getStaticPaths() {
+	return [];
+}
+---
+<h1>Page</h1>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	h := handler.NewHandler(source, "/test.astro")
+	result := TransformWithResult(doc, TransformOptions{Scope: "xxxxxx"}, h)
+	if !result.ContainsGetStaticPaths {
+		t.Error("expected ContainsGetStaticPaths to be true")
+	}
+	if result.Prerender == nil || *result.Prerender {
+		t.Errorf("expected Prerender to be false, got %v", result.Prerender)
+	}
+}
+
+func TestTransformScopingSVG(t *testing.T) {
+	source := `<svg viewBox="0 0 10 10"><title>Dot</title><circle class="dot" cx="5" cy="5" r="4" fill="currentColor" /><style>circle{stroke:blue}</style></svg><style>.dot{fill:red}</style>`
+	tests := []struct {
+		strategy string
+		want     string
+		css      string
+	}{
+		{
+			strategy: "where",
+			want:     `<svg viewBox="0 0 10 10" class="astro-xxxxxx"><title class="astro-xxxxxx">Dot</title><circle class="dot astro-xxxxxx" cx="5" cy="5" r="4" fill="currentColor"></circle><style>circle{stroke:blue}</style></svg>`,
+			css:      `.dot:where(.astro-xxxxxx){fill:red}`,
+		},
+		{
+			strategy: "attribute",
+			want:     `<svg viewBox="0 0 10 10" data-astro-cid-xxxxxx><title data-astro-cid-xxxxxx>Dot</title><circle class="dot" cx="5" cy="5" r="4" fill="currentColor" data-astro-cid-xxxxxx></circle><style>circle{stroke:blue}</style></svg>`,
+			css:      `.dot[data-astro-cid-xxxxxx]{fill:red}`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			b.Reset()
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Error(err)
+			}
+			transformOptions := TransformOptions{Scope: "xxxxxx", ScopedStyleStrategy: tt.strategy}
+			h := handler.NewHandler(source, "/test.astro")
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+			astro.PrintToSource(&b, doc.LastChild.FirstChild.NextSibling.FirstChild)
+			got := b.String()
+			if tt.want != got {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.strategy, tt.want, got)
+			}
+			if len(doc.Styles) != 1 || doc.Styles[0].FirstChild.Data != tt.css {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %v", tt.strategy, tt.css, doc.Styles)
+			}
+		})
+	}
+}
+
+func TestTransformFallbackScope(t *testing.T) {
+	source := `<div></div><style>div { color: red; }</style>`
+	tests := []struct {
+		name     string
+		filename string
+		want     string
+		code     loc.DiagnosticCode
+	}{
+		{
+			name:     "derived from filename",
+			filename: "/src/pages/index.astro",
+			want:     fmt.Sprintf(`<div class="astro-%s"></div>`, ScopeHash("/src/pages/index.astro", "")),
+			code:     loc.INFO,
+		},
+		{
+			name: "missing filename",
+			want: `<div></div>`,
+			code: loc.WARNING_MISSING_SCOPE,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Error(err)
+			}
+			opts := TransformOptions{Filename: tt.filename}
+			h := handler.NewHandler(source, tt.filename)
+			ExtractStyles(doc, &opts, h)
+			Transform(doc, opts, h)
+			var b strings.Builder
+			astro.PrintToSource(&b, doc.LastChild.FirstChild.NextSibling.FirstChild)
+			if got := b.String(); got != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+			diagnostics := h.Diagnostics()
+			if len(diagnostics) != 1 || diagnostics[0].Code != int(tt.code) {
+				t.Errorf("expected a single %d diagnostic, got %v", tt.code, diagnostics)
+			}
+			if tt.filename == "" && strings.Contains(doc.Styles[0].FirstChild.Data, "astro-") {
+				t.Errorf("expected styles to be left unscoped, got %s", doc.Styles[0].FirstChild.Data)
+			}
+		})
+	}
+}
+
+func TestMultipleDefineVars(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "two blocks",
+			source: `<div></div><style define:vars={{ color }}>div { color: var(--color); }</style><style define:vars={{ color: "red" }}>div { background: var(--color); }</style>`,
+			want:   []string{`2 <style> blocks use ` + "`define:vars`" + ` ({{ color }}, {{ color: "red" }}). Their variables are merged, so a variable defined more than once takes the value of the last block. 1:86`},
+		},
+		{
+			name:   "single block",
+			source: `<div></div><style define:vars={{ color }}>div { color: var(--color); }</style><style>div { margin: 0; }</style>`,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			opts := TransformOptions{Scope: "xxxxxx"}
+			ExtractStyles(doc, &opts, h)
+			Transform(doc, opts, h)
+			got := []string{}
+			for _, d := range h.Diagnostics() {
+				if d.Code == int(loc.INFO) {
+					got = append(got, fmt.Sprintf("%s %d:%d", d.Text, d.Location.Line, d.Location.Column))
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExtractCSSImports(t *testing.T) {
+	source := `---
+import './card.css';
+import styles from './card.module.css';
+import Card from './Card.astro';
+const theme = await import('./theme.css');
+const file = "./not-an-import.css";
+import type { Stylesheet } from './types.css';
+---
+<Card class={styles.card} />`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	want := []CSSImport{
+		{Specifier: "./card.css", SideEffect: true, Loc: loc.Loc{Start: strings.Index(source, "import './card.css'")}},
+		{Specifier: "./card.module.css", SideEffect: false, Loc: loc.Loc{Start: strings.Index(source, "import styles")}},
+	}
+	got := ExtractCSSImports(doc)
+	if fmt.Sprint(want) != fmt.Sprint(got) {
+		t.Errorf("\nFAIL: ExtractCSSImports\n  want: %v\n  got:  %v", want, got)
+	}
+}
+
+func TestTransformExpression(t *testing.T) {
+	source := `---
+import Counter from "../components/Counter.jsx";
+---
+<Counter client:load title={x} /><a href={url} class="link">Link</a><p class={classes} /><style>p { color: red; }</style>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	h := handler.NewHandler(source, "/test.astro")
+	// Generated attributes and values, like the scope added to `class`, are left alone
+	opts := TransformOptions{Filename: "<stdin>", Scope: "xxxxxx", TransformExpression: func(raw string) string {
+		return fmt.Sprintf("wrap(%s)", raw)
+	}}
+	ExtractStyles(doc, &opts, h)
+	Transform(doc, opts, h)
+
+	got := make([]string, 0)
+	walk(doc, func(n *astro.Node) {
+		for _, attr := range n.Attr {
+			if attr.Type == astro.ExpressionAttribute {
+				got = append(got, fmt.Sprintf("%s={%s}", attr.Key, attr.Val))
+			}
+		}
+	})
+	want := []string{
+		`title={wrap(x)}`,
+		`client:component-path={"../components/Counter.jsx"}`,
+		`client:component-export={"default"}`,
+		`href={wrap(url)}`,
+		`class={((wrap(classes)) ?? "") + " astro-xxxxxx"}`,
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("\nFAIL: TransformExpression\n  want: %s\n  got:  %s", strings.Join(want, " "), strings.Join(got, " "))
+	}
+}
+
+func TestTransformExpressionDenylist(t *testing.T) {
+	source := `<div title={title} style={styles} class={classes} />`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	h := handler.NewHandler(source, "/test.astro")
+	Transform(doc, TransformOptions{
+		Filename:               "<stdin>",
+		ExpressionAttrDenylist: []string{"style", "class"},
+		TransformExpression: func(raw string) string {
+			return fmt.Sprintf("wrap(%s)", raw)
+		},
+	}, h)
+
+	got := make([]string, 0)
+	walk(doc, func(n *astro.Node) {
+		for _, attr := range n.Attr {
+			if attr.Type == astro.ExpressionAttribute {
+				got = append(got, fmt.Sprintf("%s={%s}", attr.Key, attr.Val))
+			}
+		}
+	})
+	want := []string{
+		`title={wrap(title)}`,
+		`style={styles}`,
+		`class={classes}`,
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("\nFAIL: ExpressionAttrDenylist\n  want: %s\n  got:  %s", strings.Join(want, " "), strings.Join(got, " "))
+	}
+}
+
+func TestHeadAndBodyContent(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		head   bool
+		body   bool
+	}{
+		{
+			name:   "head only",
+			source: `<head><meta charset="utf-8"><title>Page</title>{extra}</head>`,
+			head:   true,
+		},
+		{
+			name: "metadata fragment",
+			source: `---
+const { title } = Astro.props;
+---
+<title>{title}</title>
+<meta name="description" content="Description">
+<link rel="icon" href="/favicon.svg">`,
+			head: true,
+		},
+		{
+			name:   "mixed",
+			source: `<html><head><title>Page</title></head><body><h1>Hello</h1></body></html>`,
+			head:   true,
+			body:   true,
+		},
+		{
+			name:   "empty body",
+			source: `<html><head><title>Page</title></head><body>  </body></html>`,
+			head:   true,
+		},
+		{
+			name:   "body fragment",
+			source: `<Card />text`,
+			body:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			h := handler.NewHandler(tt.source, "/test.astro")
+			Transform(doc, TransformOptions{Scope: "xxxxxx"}, h)
+			if doc.HasHeadContent != tt.head || doc.HasBodyContent != tt.body {
+				t.Errorf("\nFAIL: %s\n  want: head=%v body=%v\n  got:  head=%v body=%v", tt.name, tt.head, tt.body, doc.HasHeadContent, doc.HasBodyContent)
+			}
+		})
+	}
+}
+
+func TestInlineStyle(t *testing.T) {
+	source := `<section><style is:inline>p{color:red}</style><p /></section><style>div{color:blue}</style><div />`
+	want := `<section class="astro-xxxxxx"><style is:inline>p{color:red}</style><p class="astro-xxxxxx"></p></section><div class="astro-xxxxxx"></div>`
+	var b strings.Builder
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	transformOptions := TransformOptions{Scope: "xxxxxx"}
+	h := handler.NewHandler(source, "/test.astro")
+	ExtractStyles(doc, &transformOptions, h)
+	Transform(doc, transformOptions, h)
+	for c := doc.LastChild.FirstChild.NextSibling.FirstChild; c != nil; c = c.NextSibling {
+		astro.PrintToSource(&b, c)
+	}
+	got := b.String()
+	if want != got {
+		t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", "is:inline style", want, got)
+	}
+	if len(doc.Styles) != 1 || doc.Styles[0].FirstChild.Data != `div:where(.astro-xxxxxx){color:blue}` {
+		t.Errorf("\nFAIL: expected only the sibling style to be extracted and scoped\n  got:  %v", doc.Styles)
+	}
+
+	// Scoping an explicitly passed inline style leaves it untouched
+	inline := doc.LastChild.FirstChild.NextSibling.FirstChild.FirstChild
+	if ScopeStyle([]*astro.Node{inline}, transformOptions, h) || inline.FirstChild.Data != `p{color:red}` {
+		t.Errorf("\nFAIL: expected is:inline style not to be scoped\n  got:  %s", inline.FirstChild.Data)
+	}
+}
+
+func TestAppendStyle(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		css    string
+		scoped bool
+		want   string
+		styles []string
+	}{
+		{
+			name:   "scoped",
+			source: `<div />`,
+			css:    `div{margin:0}`,
+			scoped: true,
+			want:   `<div class="astro-xxxxxx"></div>`,
+			styles: []string{`div:where(.astro-xxxxxx){margin:0}`},
+		},
+		{
+			name:   "global",
+			source: `<div />`,
+			css:    `div{margin:0}`,
+			want:   `<div></div>`,
+			styles: []string{`div{margin:0}`},
+		},
+		{
+			name:   "after extracted styles",
+			source: `<style>p{color:red}</style><style>a{color:blue}</style><div />`,
+			css:    `div{margin:0}`,
+			scoped: true,
+			want:   `<div class="astro-xxxxxx"></div>`,
+			styles: []string{`p:where(.astro-xxxxxx){color:red}`, `a:where(.astro-xxxxxx){color:blue}`, `div:where(.astro-xxxxxx){margin:0}`},
+		},
+		{
+			name:   "global after extracted styles",
+			source: `<style>p{color:red}</style><div />`,
+			css:    `div{margin:0}`,
+			want:   `<div class="astro-xxxxxx"></div>`,
+			styles: []string{`p:where(.astro-xxxxxx){color:red}`, `div{margin:0}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			transformOptions := TransformOptions{Scope: "xxxxxx"}
+			h := handler.NewHandler(tt.source, "/test.astro")
+			ExtractStyles(doc, &transformOptions, h)
+			style := AppendStyle(doc, tt.css, tt.scoped)
+			Transform(doc, transformOptions, h)
+			var b strings.Builder
+			astro.PrintToSource(&b, doc.LastChild.FirstChild.NextSibling.FirstChild)
+			if got := b.String(); got != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+			styles := []string{}
+			for _, n := range doc.Styles {
+				styles = append(styles, n.FirstChild.Data)
+			}
+			if strings.Join(styles, "\n") != strings.Join(tt.styles, "\n") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.styles, styles)
+			}
+			if doc.Styles[len(doc.Styles)-1] != style {
+				t.Errorf("\nFAIL: %s\n  expected the appended style to be the last one", tt.name)
+			}
+		})
+	}
+}
+
+func TestHoistedOrder(t *testing.T) {
+	source := `---
+import Layout from '../layouts/Layout.astro';
+---
+<html>
+	<head>
+		<style>a{}</style>
+		<script>one()</script>
+	</head>
+	<body>
+		<Layout>
+			<style>b{}</style>
+			<script>two()</script>
+			<div><style>c{}</style></div>
+		</Layout>
+		{show && <style>d{}</style>}
+		<style>e{}</style>
+		<script>three()</script>
+	</body>
+</html>`
+	wantStyles := []string{"a", "b", "c", "d", "e"}
+	wantScripts := []string{"one()", "two()", "three()"}
+	for _, experimentalScriptOrder := range []bool{false, true} {
+		doc, err := astro.Parse(strings.NewReader(source))
+		if err != nil {
+			t.Error(err)
+		}
+		opts := TransformOptions{Scope: "xxxxxx", ExperimentalScriptOrder: experimentalScriptOrder}
+		h := handler.NewHandler(source, "/test.astro")
+		ExtractStyles(doc, &opts, h)
+		Transform(doc, opts, h)
+		styles := []string{}
+		for _, n := range doc.Styles {
+			styles = append(styles, strings.SplitN(n.FirstChild.Data, ":", 2)[0])
+		}
+		scripts := []string{}
+		for _, n := range doc.Scripts {
+			scripts = append(scripts, n.FirstChild.Data)
+		}
+		if !slices.Equal(styles, wantStyles) || !slices.Equal(scripts, wantScripts) {
+			t.Errorf("\nFAIL: ExperimentalScriptOrder: %v\n  want: %v %v\n  got:  %v %v", experimentalScriptOrder, wantStyles, wantScripts, styles, scripts)
+		}
+	}
+}
+
+func TestExtractClonedNodes(t *testing.T) {
+	source := `<nav><style>nav{color:red}</style><script>a()</script></nav>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	body := doc.LastChild.FirstChild.NextSibling
+	body.AppendChild(body.FirstChild.Clone(true))
+	transformOptions := TransformOptions{Scope: "xxxxxx"}
+	h := handler.NewHandler(source, "/test.astro")
+	ExtractStyles(doc, &transformOptions, h)
+	Transform(doc, transformOptions, h)
+	var b strings.Builder
+	astro.PrintToSource(&b, doc)
+	if want := `<nav class="astro-xxxxxx"></nav><nav class="astro-xxxxxx"></nav>`; b.String() != want {
+		t.Errorf("\nFAIL: cloned nodes\n  want: %s\n  got:  %s", want, b.String())
+	}
+	if len(doc.Styles) != 2 || len(doc.Scripts) != 2 || doc.Styles[0] == doc.Styles[1] || doc.Scripts[0] == doc.Scripts[1] {
+		t.Errorf("\nFAIL: expected the cloned style and script to be extracted\n  got:  %v %v", doc.Styles, doc.Scripts)
+	}
+}
+
+func TestComponentUsages(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name: "hydrated component",
+			source: `---
+import Card from './Card.astro';
+---
+<Card title="x" client:load />`,
+			want: []string{"Card@42 attrs=[title] directives=[client:load] spread=false"},
+		},
+		{
+			name: "scoped and hydrated component",
+			source: `---
+import Counter from './Counter.jsx';
+---
+<Counter count={1} client:visible /><style>p { color: red; }</style>`,
+			want: []string{"Counter@46 attrs=[count] directives=[client:visible] spread=false"},
+		},
+		{
+			name:   "attribute kinds",
+			source: `<Card title="x" {description} count={1} label=` + "`a`" + ` hidden set:html={html} />`,
+			want:   []string{"Card@1 attrs=[title description count label hidden] directives=[set:html] spread=false"},
+		},
+		{
+			name:   "spread",
+			source: `<Card {...props} title="x" />`,
+			want:   []string{"Card@1 attrs=[title] directives=[] spread=true"},
+		},
+		{
+			name:   "nested and custom elements",
+			source: `<div><Layout title="a"><my-element foo="bar"></my-element><Fragment slot="b" /></Layout></div>`,
+			want: []string{
+				"Layout@6 attrs=[title] directives=[] spread=false",
+				"my-element@24 attrs=[foo] directives=[] spread=false",
+			},
+		},
+		{
+			name:   "elements",
+			source: `<div title="x"><p>Hello</p></div>`,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			opts := TransformOptions{Filename: "/test.astro"}
+			ExtractStyles(doc, &opts, h)
+			result := TransformWithResult(doc, opts, h)
+			got := []string{}
+			for _, usage := range result.ComponentUsages {
+				got = append(got, fmt.Sprintf("%s@%d attrs=%v directives=%v spread=%v", usage.Name, usage.Pos.Start, usage.Attrs, usage.Directives, usage.HasSpread))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRawHTMLNodes(t *testing.T) {
+	source := `<article set:html={post.body} /><p>{text}</p><Fragment set:html="<b>hi</b>" /><div set:text={text} />`
+	for _, audit := range []bool{false, true} {
+		doc, err := astro.Parse(strings.NewReader(source))
+		if err != nil {
+			t.Error(err)
+		}
+		h := handler.NewHandler(source, "/test.astro")
+		result := TransformWithResult(doc, TransformOptions{AuditRawHTML: audit}, h)
+		nodes := []string{}
+		for _, n := range result.RawHTMLNodes {
+			nodes = append(nodes, fmt.Sprintf("%s %d", n.Data, n.Loc[0].Start))
+		}
+		if want := "article 1, Fragment 46"; strings.Join(nodes, ", ") != want {
+			t.Errorf("\nFAIL: RawHTMLNodes\n  want: %s\n  got:  %s", want, strings.Join(nodes, ", "))
+		}
+		infos := []string{}
+		for _, d := range h.Diagnostics() {
+			if d.Code == int(loc.INFO_RAW_HTML) {
+				infos = append(infos, fmt.Sprintf("%s %d:%d", d.Text, d.Location.Line, d.Location.Column))
+			}
+		}
+		want := []string{}
+		if audit {
+			want = []string{
+				"<article> renders unescaped HTML with `set:html`. 1:10",
+				"<Fragment> renders unescaped HTML with `set:html`. 1:56",
+			}
+		}
+		if strings.Join(infos, "\n") != strings.Join(want, "\n") {
+			t.Errorf("\nFAIL: AuditRawHTML %v\n  want: %v\n  got:  %v", audit, want, infos)
+		}
+	}
+}
+
+func TestMetaTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		audit    bool
+		charset  bool
+		viewport bool
+		infos    []string
+	}{
+		{
+			name:    "charset without viewport",
+			source:  `<html><head><meta charset="utf-8"><title>Test</title></head><body></body></html>`,
+			audit:   true,
+			charset: true,
+			infos:   []string{"head 1:8"},
+		},
+		{
+			name:     "charset and viewport",
+			source:   `<html><head><meta charset="utf-8"><meta name="Viewport" content="width=device-width"></head></html>`,
+			audit:    true,
+			charset:  true,
+			viewport: true,
+			infos:    []string{},
+		},
+		{
+			name:    "http-equiv charset in a partial",
+			source:  `<meta http-equiv="Content-Type" content="text/html; charset=utf-8">`,
+			audit:   true,
+			charset: true,
+			infos:   []string{" 1:1"},
+		},
+		{
+			name:   "body content only",
+			source: `<main><p>Hello</p></main>`,
+			audit:  true,
+			infos:  []string{},
+		},
+		{
+			name:    "audit disabled",
+			source:  `<html><head><meta charset="utf-8"></head></html>`,
+			charset: true,
+			infos:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			result := TransformWithResult(doc, TransformOptions{AuditViewportMeta: tt.audit}, h)
+			if result.HasCharsetMeta != tt.charset || result.HasViewportMeta != tt.viewport {
+				t.Errorf("\nFAIL: %s\n  want: charset=%v viewport=%v\n  got:  charset=%v viewport=%v", tt.name, tt.charset, tt.viewport, result.HasCharsetMeta, result.HasViewportMeta)
+			}
+			infos := []string{}
+			for _, d := range h.Diagnostics() {
+				if d.Code == int(loc.INFO_MISSING_VIEWPORT) {
+					infos = append(infos, fmt.Sprintf("%s %d:%d", tt.source[d.Location.Column-1:d.Location.Column-1+d.Location.Length], d.Location.Line, d.Location.Column))
+				}
+			}
+			if strings.Join(infos, ",") != strings.Join(tt.infos, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.infos, infos)
+			}
+		})
+	}
+}
+
+func TestDuplicateAttributes(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		want     string
+		warnings []string
+	}{
+		{
+			name:     "class",
+			source:   `<div class="a" class="b"></div>`,
+			want:     `<div class="b"></div>`,
+			warnings: []string{"class 1:6 Duplicate attribute `class`. It is overridden by `class` at 1:16."},
+		},
+		{
+			name:     "component prop",
+			source:   `<Card title="a" title={b} />`,
+			want:     `<Card title={b}></Card>`,
+			warnings: []string{"title 1:7 Duplicate attribute `title`. It is overridden by `title` at 1:17."},
+		},
+		{
+			name:   "three times",
+			source: `<div id="a" id="b" id="c"></div>`,
+			want:   `<div id="c"></div>`,
+			warnings: []string{
+				"id 1:6 Duplicate attribute `id`. It is overridden by `id` at 1:20.",
+				"id 1:13 Duplicate attribute `id`. It is overridden by `id` at 1:20.",
+			},
+		},
+		{
+			name:   "spreads",
+			source: `<div {...a} {...a} title="x"></div>`,
+			want:   `<div {...} {...} title="x"></div>`,
+		},
+		{
+			name:     "data attributes on html elements",
+			source:   `<div data-Foo="a" data-foo="b"></div>`,
+			want:     `<div data-foo="b"></div>`,
+			warnings: []string{"data-Foo 1:6 Duplicate attribute `data-Foo`. It is overridden by `data-foo` at 1:19."},
+		},
+		{
+			name:   "data props on components",
+			source: `<Card data-Foo="a" data-foo="b" />`,
+			want:   `<Card data-Foo="a" data-foo="b"></Card>`,
+		},
+		{
+			name:   "different keys",
+			source: `<div class="a" class:list={["b"]}></div>`,
+			want:   `<div class="a" class:list={["b"]}></div>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			removeDuplicateAttributes(doc, h)
+			var b strings.Builder
+			astro.PrintToSource(&b, doc)
+			if got := b.String(); got != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+			warnings := []string{}
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_DUPLICATE_ATTRIBUTE) {
+					warnings = append(warnings, fmt.Sprintf("%s %d:%d %s", tt.source[w.Location.Column-1:w.Location.Column-1+w.Location.Length], w.Location.Line, w.Location.Column, w.Text))
+				}
+			}
+			if strings.Join(warnings, "\n") != strings.Join(tt.warnings, "\n") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestUnknownDirectives(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		strict bool
+		want   []string
+	}{
+		{
+			name:   "known directives",
+			source: `<A client:load /><B client:idle /><C client:visible /><D client:media="(max-width: 50em)" /><E client:only="react" />`,
+			want:   []string{},
+		},
+		{
+			name:   "typo",
+			source: `<Counter client:visable />`,
+			want:   []string{"2019 1:10 Unknown directive `client:visable`. Did you mean `client:visible`?"},
+		},
+		{
+			name:   "transposed letters",
+			source: `<Counter client:laod />`,
+			want:   []string{"2019 1:10 Unknown directive `client:laod`. Did you mean `client:load`?"},
+		},
+		{
+			name:   "strict transposed letters",
+			source: `<Counter client:laod />`,
+			strict: true,
+			want:   []string{"1006 1:10 Unknown directive `client:laod`. Did you mean `client:load`?"},
+		},
+		{
+			name:   "strict known directive",
+			source: `<Counter client:load />`,
+			strict: true,
+			want:   []string{},
+		},
+		{
+			name:   "onload",
+			source: `<Counter client:onload />`,
+			want:   []string{"2019 1:10 Unknown directive `client:onload`. Did you mean `client:load`?"},
+		},
+		{
+			name:   "custom directive",
+			source: `<Counter client:hover />`,
+			want:   []string{"2019 1:10 Unknown directive `client:hover`."},
+		},
+		{
+			name:   "strict",
+			source: `<Counter client:visable />`,
+			strict: true,
+			want:   []string{"1006 1:10 Unknown directive `client:visable`. Did you mean `client:visible`?"},
+		},
+		{
+			name:   "html element",
+			source: `<div client:visable />`,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			Transform(doc, TransformOptions{StrictDirectives: tt.strict}, h)
+			got := []string{}
+			for _, d := range h.Diagnostics() {
+				if d.Code == int(loc.WARNING_UNKNOWN_DIRECTIVE) || d.Code == int(loc.ERROR_UNKNOWN_DIRECTIVE) {
+					got = append(got, fmt.Sprintf("%d %d:%d %s", d.Code, d.Location.Line, d.Location.Column, d.Text))
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+			// The directive is still passed through
+			if len(tt.want) > 0 && len(doc.SortedHydrationDirectives()) != 1 {
+				t.Errorf("\nFAIL: %s\n  expected the directive to be kept, got %v", tt.name, doc.SortedHydrationDirectives())
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"load", "load", 0},
+		{"visable", "visible", 1},
+		{"onload", "load", 2},
+		{"", "idle", 4},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("\nFAIL: editDistance(%q, %q)\n  want: %d\n  got:  %d", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
+
+func TestUnknownHydratedElements(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "lowercase tag",
+			source: `<mycomponent client:load />`,
+			want:   []string{"client:load 1:14"},
+		},
+		{
+			name: "imported component",
+			source: `---
+import MyComponent from "./MyComponent.jsx";
+---
+<MyComponent client:load />`,
+			want: []string{},
+		},
+		{
+			name:   "custom element",
+			source: `<my-component client:visible />`,
+			want:   []string{},
+		},
+		{
+			name:   "known html element",
+			source: `<div client:load />`,
+			want:   []string{},
+		},
+		{
+			name:   "svg element",
+			source: `<svg><lineargradient client:idle /></svg>`,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			Transform(doc, TransformOptions{}, h)
+			got := []string{}
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_UNKNOWN_HYDRATED_ELEMENT) {
+					got = append(got, fmt.Sprintf("%s %d:%d", tt.source[w.Location.Column-1:w.Location.Column-1+w.Location.Length], w.Location.Line, w.Location.Column))
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExtractStyleImports(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		remove  bool
+		imports []string
+		css     string
+	}{
+		{
+			name:    "collects imports",
+			source:  "<style>\n@import \"./foo.css\";\n@import url('../bar.css');\ndiv { color: red; }\n</style>",
+			imports: []string{"./foo.css", "../bar.css"},
+			css:     "\n@import \"./foo.css\";\n@import url('../bar.css');\ndiv { color: red; }\n",
+		},
+		{
+			name:    "removes imports",
+			source:  "<style>\n@import \"./foo.css\";\n@import url(../bar.css);\ndiv { color: red; }\n</style>",
+			remove:  true,
+			imports: []string{"./foo.css", "../bar.css"},
+			css:     "\n\n\ndiv { color: red; }\n",
+		},
+		{
+			name:    "keeps conditional imports",
+			source:  "<style>@import \"./print.css\" print; @import \"./foo.css\";</style>",
+			remove:  true,
+			imports: []string{"./foo.css"},
+			css:     "@import \"./print.css\" print; ",
+		},
+		{
+			name:    "ignores preprocessor imports",
+			source:  "<style lang=\"scss\">@import \"./vars\";</style>",
+			remove:  true,
+			imports: []string{},
+			css:     "@import \"./vars\";",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			transformOptions := TransformOptions{RemoveStyleImports: tt.remove}
+			ExtractStyles(doc, &transformOptions, handler.NewHandler(tt.source, "/test.astro"))
+			if strings.Join(doc.StyleImports, ",") != strings.Join(tt.imports, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.imports, doc.StyleImports)
+			}
+			if got := doc.Styles[0].FirstChild.Data; got != tt.css {
+				t.Errorf("\nFAIL: %s\n  want: %q\n  got:  %q", tt.name, tt.css, got)
+			}
+		})
+	}
+}
+
+var benchmarkTransformFixtures = []string{
+	`---
+import Layout from '../layouts/Layout.astro';
+import Counter from '../components/Counter.jsx';
+import { Card, Grid } from '../components/ui';
+import * as Icons from '../components/icons';
+const { items } = Astro.props;
+---
+<Layout title="Home">
+	<main class="container">
+		<h1 class:list={["title", { active: true }]}>Welcome</h1>
+		<Counter client:load count={0} />
+		<Grid client:visible>
+			{items.map((item) => <Card client:idle title={item.title}><Icons.Star client:only="react" /></Card>)}
+		</Grid>
+		<ul>{items.map((item) => <li><a href={item.href}>{item.title}</a></li>)}</ul>
+	</main>
+</Layout>
+<style>
+	.container { max-width: 60rem; margin: 0 auto; }
+	h1 { font-size: 2rem; }
+	ul > li a:hover { text-decoration: underline; }
+</style>`,
+	`---
+interface Props { title: string }
+const { title } = Astro.props;
+---
+<html lang="en">
+	<head>
+		<meta charset="utf-8" />
+		<meta name="viewport" content="width=device-width" />
+		<title>{title}</title>
+		<link rel="canonical" href="/" />
+	</head>
+	<body>
+		<header><nav><a href="/">Home</a><a href="/blog">Blog</a></nav></header>
+		<slot />
+		<footer><p>&copy; 2024</p></footer>
+		<script>document.querySelector('nav')?.classList.add('ready');</script>
+	</body>
+</html>
+<style is:global>body { margin: 0; font-family: system-ui; }</style>
+<style define:vars={{ accent: 'red' }}>a { color: var(--accent); }</style>`,
+	`<section class="features">
+	<my-element client:load></my-element>
+	<div class="feature"><h2>Fast</h2><p>Ship less JavaScript.</p></div>
+	<div class="feature"><h2>Flexible</h2><p>Bring your own framework.</p></div>
+	<svg viewBox="0 0 10 10"><circle cx="5" cy="5" r="4" /></svg>
+	<table><tr><td>One</td><td>Two</td></tr></table>
+</section>
+<style>
+	.features { display: grid; }
+	.feature :global(h2) { margin: 0; }
+	svg circle { fill: currentColor; }
+</style>`,
+}
+
+func BenchmarkTransform(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, source := range benchmarkTransformFixtures {
+			b.StopTimer()
+			h := handler.NewHandler(source, "/src/pages/index.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+			transformOptions := TransformOptions{Scope: "xxxxxx", Filename: "/src/pages/index.astro"}
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+		}
+	}
+}
+
+// Plain static markup: no styles, scripts or hydrated components
+var benchmarkStaticFixtures = []string{
+	`---
+const { title } = Astro.props;
+---
+<article>
+	<h1>{title}</h1>
+	<p>Astro is a web framework for building <em>content-driven</em> websites.</p>
+	<ul>{[1, 2, 3].map((i) => <li>Item {i}</li>)}</ul>
+	<slot />
+</article>`,
+	`<footer class="footer">
+	<nav><a href="/">Home</a><a href="/about">About</a><a href="/blog">Blog</a></nav>
+	<p>&copy; 2024 Astro</p>
+</footer>`,
+	`---
+import Card from '../components/Card.astro';
+---
+<section>
+	<Card title="One" href="/one" />
+	<Card title="Two" href="/two" />
+	<table><thead><tr><th>Name</th><th>Value</th></tr></thead><tbody><tr><td>A</td><td>1</td></tr></tbody></table>
+</section>`,
+}
+
+// Static markup skips the optional passes, but the noscope marker must still be removed
+func TestTransformStaticNoscope(t *testing.T) {
+	source := `<section><div data-astro-noscope><span /></div></section>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transformOptions := TransformOptions{Scope: "xxxxxx"}
+	h := handler.NewHandler(source, "/test.astro")
+	ExtractStyles(doc, &transformOptions, h)
+	Transform(doc, transformOptions, h)
+	var b strings.Builder
+	astro.PrintToSource(&b, doc.LastChild.FirstChild.NextSibling.FirstChild)
+	want := `<section><div><span></span></div></section>`
+	if got := b.String(); got != want {
+		t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", source, want, got)
+	}
+}
+
+func BenchmarkTransformStatic(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, source := range benchmarkStaticFixtures {
+			b.StopTimer()
+			h := handler.NewHandler(source, "/src/pages/index.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+			transformOptions := TransformOptions{Scope: "xxxxxx", Filename: "/src/pages/index.astro"}
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+		}
+	}
+}
+
+func TestMixedContent(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		site   string
+		want   []string
+	}{
+		{
+			name:   "img over http",
+			source: `<img src="http://example.com/a.png" />`,
+			site:   "https://astro.build",
+			want:   []string{"http://example.com/a.png 1:11"},
+		},
+		{
+			name:   "stylesheet and script over http",
+			source: `<link rel="stylesheet" href="http://example.com/a.css"><script src="http://example.com/a.js"></script>`,
+			site:   "https://astro.build",
+			want:   []string{"http://example.com/a.css 1:30", "http://example.com/a.js 1:69"},
+		},
+		{
+			name:   "anchor",
+			source: `<a href="http://example.com">Example</a>`,
+			site:   "https://astro.build",
+			want:   []string{},
+		},
+		{
+			name:   "canonical link",
+			source: `<link rel="canonical" href="http://example.com/" />`,
+			site:   "https://astro.build",
+			want:   []string{},
+		},
+		{
+			name:   "https img",
+			source: `<img src="https://example.com/a.png" />`,
+			site:   "https://astro.build",
+			want:   []string{},
+		},
+		{
+			name:   "http site",
+			source: `<img src="http://example.com/a.png" />`,
+			site:   "http://localhost:4321",
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			Transform(doc, TransformOptions{Site: tt.site}, h)
+			got := []string{}
+			for _, w := range h.Warnings() {
+				if w.Code == int(loc.WARNING_MIXED_CONTENT) {
+					got = append(got, fmt.Sprintf("%s %d:%d", tt.source[w.Location.Column-1:w.Location.Column-1+w.Location.Length], w.Location.Line, w.Location.Column))
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHoistInlineStyles(t *testing.T) {
+	red := "astro-style-" + astro.HashString("color:red")
+	tests := []struct {
+		name   string
+		source string
+		want   string
+		css    string
+	}{
+		{
+			name:   "shared class",
+			source: `<p style="color:red">One</p><p style="color:red">Two</p><p style="color:red">Three</p>`,
+			want:   fmt.Sprintf(`<p class="%[1]s astro-xxxxxx">One</p><p class="%[1]s astro-xxxxxx">Two</p><p class="%[1]s astro-xxxxxx">Three</p>`, red),
+			css:    fmt.Sprintf(`.%s:where(.astro-xxxxxx){color:red}`, red),
+		},
+		{
+			name:   "existing class",
+			source: `<p class="a" style="color:red" /><p style="color:red" />`,
+			want:   fmt.Sprintf(`<p class="a %[1]s astro-xxxxxx"></p><p class="%[1]s astro-xxxxxx"></p>`, red),
+			css:    fmt.Sprintf(`.%s:where(.astro-xxxxxx){color:red}`, red),
+		},
+		{
+			name:   "unique and expression styles",
+			source: `<p style="color:red" /><p style="color:blue" /><p style={"color:red"} />`,
+			want:   `<p style="color:red"></p><p style="color:blue"></p><p style={"color:red"}></p>`,
+		},
+		{
+			name:   "comments and escapes",
+			source: `<p style="color:red/*" /><p style="color:red/*" /><p style="content:'\\'" /><p style="content:'\\'" />`,
+			want:   `<p style="color:red/*"></p><p style="color:red/*"></p><p style="content:'\\'"></p><p style="content:'\\'"></p>`,
+		},
+		{
+			name:   "spread and components",
+			source: `<p style="color:red" {...props} /><Card style="color:red" /><p style="color:red" />`,
+			want:   `<p style="color:red" {...}></p><Card style="color:red"></Card><p style="color:red"></p>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			transformOptions := TransformOptions{Scope: "xxxxxx", HoistInlineStyles: true}
+			h := handler.NewHandler(tt.source, "/test.astro")
+			ExtractStyles(doc, &transformOptions, h)
+			Transform(doc, transformOptions, h)
+			for c := doc.LastChild.FirstChild.NextSibling.FirstChild; c != nil; c = c.NextSibling {
+				astro.PrintToSource(&b, c)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+			css := ""
+			if len(doc.Styles) > 0 {
+				if len(doc.Styles) != 1 {
+					t.Fatalf("expected a single generated style, got %d", len(doc.Styles))
+				}
+				css = doc.Styles[0].FirstChild.Data
+			}
+			if css != tt.css {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.css, css)
+			}
+		})
+	}
+}
+
+func TestElementKinds(t *testing.T) {
+	source := `---
+import MyComp from './my-comp.astro';
+import * as ns from './components';
+import type { thing } from './types';
+---
+<my-element client:load /><MyComp /><ns.thing-x /><other.thing-x /><thing /><My-Comp /><ns.thing />`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Error(err)
+	}
+	Transform(doc, TransformOptions{}, handler.NewHandler(source, "/test.astro"))
+	got := []string{}
+	walk(doc, func(n *astro.Node) {
+		if n.Type != astro.ElementNode || IsImplicitNode(n) {
+			return
+		}
+		kind := "element"
+		switch {
+		case n.Component && n.CustomElement:
+			kind = "both"
+		case n.Component:
+			kind = "component"
+		case n.CustomElement:
+			kind = "custom element"
+		}
+		got = append(got, fmt.Sprintf("%s: %s", n.Data, kind))
+	})
+	want := []string{
+		"my-element: custom element",
+		"MyComp: component",
+		"ns.thing-x: component",
+		"other.thing-x: custom element",
+		"thing: element",
+		"My-Comp: custom element",
+		"ns.thing: component",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("\nFAIL: element kinds\n  want: %v\n  got:  %v", want, got)
+	}
+}
+
+func TestNormalizeBooleanAttributes(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "attribute name",
+			source: `<button disabled="disabled">Go</button>`,
+			want:   `<button disabled>Go</button>`,
+		},
+		{
+			name:   "true and empty",
+			source: `<input required="true" readonly="" multiple='Multiple'>`,
+			want:   `<input required readonly multiple></input>`,
+		},
+		{
+			name:   "false",
+			source: `<input type="checkbox" checked="false" selected="FALSE">`,
+			want:   `<input type="checkbox"></input>`,
+		},
+		{
+			name:   "expressions and other values",
+			source: "<details hidden={x} open={open} autoplay=\"muted\" disabled=`false`></details>",
+			want:   "<details hidden={x} open={open} autoplay=\"muted\" disabled=`false`></details>",
+		},
+		{
+			name:   "components and custom elements",
+			source: `<Button disabled="false" /><my-button checked="checked" />`,
+			want:   `<Button disabled="false"></Button><my-button checked="checked"></my-button>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			Transform(doc, TransformOptions{NormalizeBooleanAttributes: true}, handler.NewHandler(tt.source, "/test.astro"))
+			astro.PrintToSource(&b, doc)
+			if got := b.String(); got != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHydratedCustomElements(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		paths  []string
+		errors []string
+	}{
+		{
+			name: "side-effect import",
+			source: `---
+import '../components/my-counter.js';
+import './other-element.js';
+---
+<my-counter client:visible />`,
+			paths: []string{"../components/my-counter.js"},
+		},
+		{
+			name:   "explicit path",
+			source: `<my-counter client:load client:component-path="./counter.js" />`,
+			paths:  []string{"counter.js"},
+		},
+		{
+			name: "unresolved",
+			source: `---
+import './other-element.js';
+---
+<my-counter client:idle />`,
+			paths:  []string{},
+			errors: []string{"Unable to determine the module that defines <my-counter>, so it cannot be hydrated. 4:2"},
+		},
+		{
+			name:   "not hydrated",
+			source: `<my-counter />`,
+			paths:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "/test.astro")
+			doc, err := astro.ParseWithOptions(strings.NewReader(tt.source), astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			Transform(doc, TransformOptions{}, h)
+			paths := []string{}
+			for _, c := range doc.HydratedComponents {
+				paths = append(paths, c.ResolvedPath)
+			}
+			if strings.Join(paths, ",") != strings.Join(tt.paths, ",") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.paths, paths)
+			}
+			errors := []string{}
+			for _, e := range h.Errors() {
+				errors = append(errors, fmt.Sprintf("%s %d:%d", e.Text, e.Location.Line, e.Location.Column))
+			}
+			if strings.Join(errors, "\n") != strings.Join(tt.errors, "\n") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.errors, errors)
+			}
+		})
+	}
+}
+
+func TestHydratedCustomElementPath(t *testing.T) {
+	source := `<my-counter client:component-path="./counter.js" client:load count="1" />`
+	h := handler.NewHandler(source, "/test.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Error(err)
+	}
+	Transform(doc, TransformOptions{}, h)
+	var b strings.Builder
+	astro.PrintToSource(&b, doc)
+	// The authored path is replaced by the resolved one
+	want := `<my-counter client:load count="1" client:component-hydration="load" client:component-path={"counter.js"} client:component-export={"default"}></my-counter>`
+	if got := b.String(); got != want {
+		t.Errorf("\nFAIL: explicit path\n  want: %s\n  got:  %s", want, got)
+	}
+}
+
+func TestOnDiagnostic(t *testing.T) {
+	source := `<div class="a" class="b"></div><p id="a" id="b"></p>`
+	h := handler.NewHandler(source, "/test.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Error(err)
+	}
+	got := []string{}
+	Transform(doc, TransformOptions{OnDiagnostic: func(d loc.DiagnosticMessage) {
+		got = append(got, fmt.Sprintf("%d %d:%d", d.Code, d.Location.Line, d.Location.Column))
+	}}, h)
+	want := []string{"2020 1:6", "2020 1:35"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("\nFAIL: OnDiagnostic\n  want: %v\n  got:  %v", want, got)
+	}
+	if len(h.Diagnostics()) != len(want) {
+		t.Errorf("\nFAIL: collected diagnostics\n  want: %d\n  got:  %d", len(want), len(h.Diagnostics()))
+	}
+}
+
+func TestMaxDiagnostics(t *testing.T) {
+	source := strings.Repeat(`<p id="a" id="b"></p>`, 10)
+	h := handler.NewHandler(source, "/test.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Error(err)
+	}
+	Transform(doc, TransformOptions{MaxDiagnostics: 3}, h)
+	got := []string{}
+	for _, d := range h.Diagnostics() {
+		got = append(got, fmt.Sprint(d.Code))
+	}
+	want := []string{"2020", "2020", "2020", "2024"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("\nFAIL: MaxDiagnostics\n  want: %v\n  got:  %v", want, got)
+	}
+}
+
+func TestMaxDiagnosticsKeepsErrors(t *testing.T) {
+	source := strings.Repeat(`<p id="a" id="b"></p>`, 5)
+	h := handler.NewHandler(source, "/test.astro")
+	h.SetMaxDiagnostics(3)
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Error(err)
+	}
+	Transform(doc, TransformOptions{}, h)
+	h.AppendError(&loc.ErrorWithRange{Code: loc.ERROR, Text: "Something went wrong"})
+	got := []string{}
+	for _, d := range h.Diagnostics() {
+		got = append(got, fmt.Sprint(d.Code))
+	}
+	want := []string{"1000", "2020", "2020", "2020", "2024"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("\nFAIL: MaxDiagnostics with an error\n  want: %v\n  got:  %v", want, got)
+	}
+	if !h.HasErrors() {
+		t.Errorf("\nFAIL: MaxDiagnostics with an error\n  expected the error to be kept")
+	}
+}
+
+func TestComponentAliases(t *testing.T) {
+	source := `---
+import AstroImage from '../components/AstroImage.jsx';
+---
+<Img client:load src="a.png" /><img src="b.png" />`
+	h := handler.NewHandler(source, "/src/pages/index.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Error(err)
+	}
+	Transform(doc, TransformOptions{ComponentAliases: map[string]string{"Img": "AstroImage", "img": "picture"}}, h)
+	var b strings.Builder
+	astro.PrintToSource(&b, doc)
+	want := `<AstroImage client:load src="a.png" client:component-hydration="load" client:component-path={"../components/AstroImage.jsx"} client:component-export={"default"}></AstroImage><img src="b.png"></img>`
+	if got := b.String(); !strings.Contains(got, want) {
+		t.Errorf("\nFAIL: ComponentAliases\n  want: %s\n  got:  %s", want, got)
+	}
+	if len(doc.HydratedComponents) != 1 || doc.HydratedComponents[0].Specifier != "../components/AstroImage.jsx" {
+		t.Errorf("\nFAIL: ComponentAliases\n  expected AstroImage to be hydrated, got %v", doc.HydratedComponents)
+	}
+}
+
+func TestInvalidComponentAliases(t *testing.T) {
+	source := `<Img src="a.png" /><Icon /><Card />`
+	h := handler.NewHandler(source, "/test.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Error(err)
+	}
+	Transform(doc, TransformOptions{ComponentAliases: map[string]string{"Img": "my-img", "Icon": "ui.", "Card": "ui.Card"}}, h)
+	var b strings.Builder
+	astro.PrintToSource(&b, doc)
+	want := `<Img src="a.png"></Img><Icon></Icon><ui.Card></ui.Card>`
+	if got := b.String(); !strings.Contains(got, want) {
+		t.Errorf("\nFAIL: invalid ComponentAliases\n  want: %s\n  got:  %s", want, got)
+	}
+	got := []string{}
+	for _, d := range h.Diagnostics() {
+		if d.Code == int(loc.WARNING_INVALID_COMPONENT_ALIAS) {
+			got = append(got, fmt.Sprintf("%s %d:%d", d.Text, d.Location.Line, d.Location.Column))
+		}
+	}
+	wantWarnings := []string{
+		`The alias "my-img" of <Img> is not a valid component name, so it is ignored. 1:2`,
+		`The alias "ui." of <Icon> is not a valid component name, so it is ignored. 1:21`,
+	}
+	if strings.Join(got, "\n") != strings.Join(wantWarnings, "\n") {
+		t.Errorf("\nFAIL: invalid ComponentAliases\n  want: %v\n  got:  %v", wantWarnings, got)
+	}
+}
+
+func TestKeepStylesInPlace(t *testing.T) {
+	source := `<h1>Hello</h1><style>h1 { color: red; }</style><style is:inline>p { color: blue; }</style>{show && <style>div { color: green; }</style>}<style define:vars={{ color }}>h1 { color: var(--color); }</style>`
+	h := handler.NewHandler(source, "/test.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Error(err)
+	}
+	opts := TransformOptions{Scope: "xxxxxx", KeepStylesInPlace: true}
+	ExtractStyles(doc, &opts, h)
+	Transform(doc, opts, h)
+	got := []string{}
+	walk(doc, func(n *astro.Node) {
+		if n.DataAtom == atom.Style {
+			var b strings.Builder
+			astro.PrintToSource(&b, n)
+			got = append(got, b.String())
+		}
+	})
+	want := []string{
+		`<style data-astro-id="xxxxxx">h1:where(.astro-xxxxxx){color:red}</style>`,
+		`<style is:inline>p { color: blue; }</style>`,
+		`<style data-astro-id="xxxxxx">div:where(.astro-xxxxxx){color:green}</style>`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("\nFAIL: KeepStylesInPlace\n  want: %v\n  got:  %v", want, got)
+	}
+	// Styles with `define:vars` are still extracted
+	if len(doc.Styles) != 1 || !HasAttr(doc.Styles[0], "define:vars") {
+		t.Errorf("\nFAIL: KeepStylesInPlace\n  expected only the define:vars style to be extracted, got %d styles", len(doc.Styles))
+	}
+	for _, w := range h.Warnings() {
+		if w.Code == int(loc.WARNING_ELEMENT_IN_EXPRESSION) {
+			t.Errorf("\nFAIL: KeepStylesInPlace\n  unexpected warning: %s", w.Text)
+		}
+	}
+}
+
+func TestTransformSubtree(t *testing.T) {
+	frontmatter := `---
+import Counter from '../components/Counter.jsx';
+---
+`
+	source := frontmatter + `<div id="a"><Counter client:load /></div><div id="b"><Counter client:visible /></div><style>div { color: red; }</style>`
+	h := handler.NewHandler(source, "/src/pages/index.astro")
+	doc, err := astro.ParseWithOptions(strings.NewReader(source), astro.ParseOptionWithHandler(h))
+	if err != nil {
+		t.Error(err)
+	}
+	opts := TransformOptions{Filename: "/src/pages/index.astro", Scope: "xxxxxx"}
+	ExtractStyles(doc, &opts, h)
+	Transform(doc, opts, h)
+
+	// An editor replaces the first div with one that was never transformed
+	replacement, err := astro.Parse(strings.NewReader(frontmatter + `<div id="a"><Counter client:idle /><script>a()</script></div>`))
+	if err != nil {
+		t.Error(err)
+	}
+	div := replacement.LastChild.FirstChild.NextSibling.FirstChild
+	div.Parent.RemoveChild(div)
+	body := doc.LastChild.FirstChild.NextSibling
+	body.InsertBefore(div, body.FirstChild)
+	body.RemoveChild(div.NextSibling)
+	TransformSubtree(doc, div, opts, h)
+
+	var b strings.Builder
+	astro.PrintToSource(&b, body)
+	want := `<div id="a" class="astro-xxxxxx"><Counter client:idle client:component-hydration="idle" client:component-path={"/src/components/Counter.jsx"} client:component-export={"default"} class="astro-xxxxxx"></Counter></div><div id="b" class="astro-xxxxxx"><Counter client:visible client:component-hydration="visible" client:component-path={"/src/components/Counter.jsx"} client:component-export={"default"} class="astro-xxxxxx"></Counter></div>`
+	if got := b.String(); got != want {
+		t.Errorf("\nFAIL: TransformSubtree\n  want: %s\n  got:  %s", want, got)
+	}
+	// The component of the replaced div is forgotten
+	if got := doc.SortedHydrationDirectives(); strings.Join(got, ",") != "idle,visible" {
+		t.Errorf("\nFAIL: hydration directives\n  want: [idle visible]\n  got:  %v", got)
+	}
+	if len(doc.HydratedComponents) != 2 || len(doc.HydratedComponentNodes) != 2 || len(doc.ComponentUsages) != 2 || len(doc.Scripts) != 1 {
+		t.Errorf("\nFAIL: collected metadata\n  want: 2 hydrated components, 2 nodes, 2 usages and 1 script\n  got:  %d, %d, %d and %d", len(doc.HydratedComponents), len(doc.HydratedComponentNodes), len(doc.ComponentUsages), len(doc.Scripts))
+	}
+	// Styles are scoped when the whole document is transformed
+	if got := doc.Styles[0].FirstChild.Data; got != "div:where(.astro-xxxxxx){color:red}" {
+		t.Errorf("\nFAIL: styles were scoped again\n  got: %s", got)
+	}
+}
+
+func TestRunPlugins(t *testing.T) {
+	source := `<style>img { display: block }</style><img src="a.png"><picture><img src="b.png" loading="eager"></picture>`
+	// lazyImages adds `loading="lazy"` to every image
+	lazyImages := func(doc *astro.Node, ctx *PluginContext) error {
+		walk(doc, func(n *astro.Node) {
+			if n.DataAtom == atom.Img {
+				SetAttr(n, astro.Attribute{Key: "loading", Val: "lazy", Type: astro.QuotedAttribute})
+				ctx.Handler.AppendInfo(&loc.ErrorWithRange{Code: loc.INFO, Text: "lazy " + GetQuotedAttr(n, "class")})
+			}
+		})
+		return nil
+	}
+	failing := func(doc *astro.Node, ctx *PluginContext) error {
+		return fmt.Errorf("plugin failed")
+	}
+	unreachable := func(doc *astro.Node, ctx *PluginContext) error {
+		t.Error("plugins after a failing one should not run")
+		return nil
+	}
+
+	h := handler.NewHandler(source, "/test.astro")
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := TransformOptions{Filename: "/src/Image.astro", Plugins: []Plugin{lazyImages}}
+	ExtractStyles(doc, &opts, h)
+	Transform(doc, opts, h)
+	if err := RunPlugins(doc, opts, h); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	astro.PrintToSource(&b, doc)
+	scope := ScopeHash(opts.Filename, "")
+	want := fmt.Sprintf(`<img src="a.png" class="astro-%[1]s" loading="lazy"></img><picture class="astro-%[1]s"><img src="b.png" loading="lazy" class="astro-%[1]s"></img></picture>`, scope)
+	if got := b.String(); got != want {
+		t.Errorf("\nFAIL: lazy images\n  want: %s\n  got:  %s", want, got)
+	}
+	infos := []string{}
+	for _, d := range h.Diagnostics() {
+		if strings.HasPrefix(d.Text, "lazy") {
+			infos = append(infos, d.Text)
+		}
+	}
+	if want := "lazy astro-" + scope; len(infos) != 2 || infos[0] != want || infos[1] != want {
+		t.Errorf("\nFAIL: plugin diagnostics\n  want: %s twice\n  got:  %v", want, infos)
+	}
+
+	opts.Plugins = []Plugin{failing, unreachable}
+	if err := RunPlugins(doc, opts, h); err == nil || err.Error() != "plugin failed" {
+		t.Errorf("\nFAIL: failing plugin\n  want: plugin failed\n  got:  %v", err)
+	}
+}
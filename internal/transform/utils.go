@@ -1,20 +1,48 @@
 package transform
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+
 	astro "github.com/withastro/compiler/internal"
 	"golang.org/x/net/html/atom"
 )
 
 func hasTruthyAttr(n *astro.Node, key string) bool {
-	for _, attr := range n.Attr {
-		if attr.Key == key &&
-			(attr.Type == astro.EmptyAttribute) ||
-			(attr.Type == astro.ExpressionAttribute && attr.Val == "true") ||
-			(attr.Type == astro.QuotedAttribute && (attr.Val == "" || attr.Val == "true")) {
-			return true
+	truthy, _ := GetTruthyAttrValue(n, key)
+	return truthy
+}
+
+// GetTruthyAttrValue reports whether the attribute of n matching key is truthy, and whether
+// that is known at compile time. A missing attribute is falsy.
+//
+//	hoist                  truthy
+//	hoist="" hoist="true"  truthy
+//	hoist="false"          falsy, like any other quoted value (e.g. "1")
+//	hoist={true}           truthy
+//	hoist={false}          falsy
+//	hoist={1} hoist={""}   only known at runtime, like any other expression
+//	{hoist} hoist=`true`   only known at runtime
+func GetTruthyAttrValue(n *astro.Node, key string) (truthy bool, static bool) {
+	attr := GetAttr(n, key)
+	if attr == nil {
+		return false, true
+	}
+	switch attr.Type {
+	case astro.EmptyAttribute:
+		return true, true
+	case astro.QuotedAttribute:
+		return attr.Val == "" || attr.Val == "true", true
+	case astro.ExpressionAttribute:
+		switch strings.TrimSpace(attr.Val) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
 		}
 	}
-	return false
+	return false, false
 }
 
 func HasSetDirective(n *astro.Node) bool {
@@ -25,9 +53,20 @@ func HasInlineDirective(n *astro.Node) bool {
 	return HasAttr(n, "is:inline")
 }
 
+// attrKeyMatches reports whether the attribute key of n matches key. Props of components,
+// fragments and custom elements are case-sensitive, while the attributes of HTML elements
+// are not, like in HTML.
+func attrKeyMatches(n *astro.Node, attrKey string, key string) bool {
+	if n.Component || n.CustomElement || n.Fragment {
+		return attrKey == key
+	}
+	return strings.EqualFold(attrKey, key)
+}
+
+// AttrIndex returns the index of the first attribute of n matching key, or -1.
 func AttrIndex(n *astro.Node, key string) int {
 	for i, attr := range n.Attr {
-		if attr.Key == key {
+		if attrKeyMatches(n, attr.Key, key) {
 			return i
 		}
 	}
@@ -38,27 +77,107 @@ func HasAttr(n *astro.Node, key string) bool {
 	return AttrIndex(n, key) != -1
 }
 
+// GetAttr returns a copy of the first attribute of n matching key, or nil.
 func GetAttr(n *astro.Node, key string) *astro.Attribute {
+	if i := AttrIndex(n, key); i != -1 {
+		attr := n.Attr[i]
+		return &attr
+	}
+	return nil
+}
+
+// SetAttr replaces the first attribute of n with the same key as attr, or appends attr.
+// Unless attr has a quote of its own, a replaced attribute keeps its authored quote.
+func SetAttr(n *astro.Node, attr astro.Attribute) {
+	if i := AttrIndex(n, attr.Key); i != -1 {
+		if attr.Quote == 0 {
+			attr.Quote = n.Attr[i].Quote
+		}
+		n.Attr[i] = attr
+		return
+	}
+	n.Attr = append(n.Attr, attr)
+}
+
+// RemoveAttr removes every attribute of n matching key, and reports whether there was any.
+func RemoveAttr(n *astro.Node, key string) bool {
+	attrs := n.Attr[:0]
 	for _, attr := range n.Attr {
-		if attr.Key == key {
-			return &attr
+		if !attrKeyMatches(n, attr.Key, key) {
+			attrs = append(attrs, attr)
 		}
 	}
-	return nil
+	removed := len(attrs) != len(n.Attr)
+	n.Attr = attrs
+	return removed
 }
 
-func IsHoistable(n *astro.Node, renderScriptEnabled bool) bool {
-	parent := n.Closest(func(p *astro.Node) bool {
-		return p.DataAtom == atom.Svg || p.DataAtom == atom.Noscript || p.DataAtom == atom.Template
-	})
+// GetClassList returns the classes of the `class` attribute of n. Classes set with an
+// expression are only known at runtime and are not included.
+func GetClassList(n *astro.Node) []string {
+	attr := GetAttr(n, "class")
+	if attr == nil || attr.Type != astro.QuotedAttribute {
+		return []string{}
+	}
+	return strings.Fields(attr.Val)
+}
 
-	if renderScriptEnabled && parent != nil && parent.Expression {
-		return true
+// AddClass adds class to the `class` attribute of n, creating the attribute if needed.
+// When `class` is an expression, the class is appended to its value at runtime.
+func AddClass(n *astro.Node, class string) {
+	i := AttrIndex(n, "class")
+	if i == -1 {
+		n.Attr = append(n.Attr, astro.Attribute{
+			Key:  "class",
+			Type: astro.QuotedAttribute,
+			Val:  class,
+		})
+		return
 	}
+	attr := n.Attr[i]
+	switch attr.Type {
+	case astro.EmptyAttribute:
+		attr.Type = astro.QuotedAttribute
+		attr.Val = class
+	case astro.QuotedAttribute:
+		for _, c := range strings.Fields(attr.Val) {
+			if c == class {
+				return
+			}
+		}
+		if strings.TrimSpace(attr.Val) == "" {
+			attr.Val = class
+		} else {
+			attr.Val = fmt.Sprintf(`%s %s`, attr.Val, class)
+		}
+	case astro.TemplateLiteralAttribute:
+		attr.Val = fmt.Sprintf(`%s %s`, attr.Val, class)
+	case astro.ExpressionAttribute:
+		attr.Val = fmt.Sprintf(`((%s) ?? "") + " %s"`, attr.Val, class)
+	case astro.ShorthandAttribute:
+		// `class` is a reserved word and can't name a variable, so `{class}` refers to the prop
+		attr.Type = astro.ExpressionAttribute
+		attr.Val = fmt.Sprintf(`((Astro.props.class) ?? "") + " %s"`, class)
+	}
+	n.Attr[i] = attr
+}
 
+// IsHoistable reports whether the style or script n may be hoisted, which it can't be from
+// an <svg>, a <noscript> or a <template>. Expressions don't prevent hoisting: see ExtractStyles
+// and ExtractScript for how elements inside of them are handled.
+func IsHoistable(n *astro.Node) bool {
+	parent := n.Closest(func(p *astro.Node) bool {
+		// Expressions are parsed as <template> elements
+		return !p.Expression && (p.DataAtom == atom.Svg || p.DataAtom == atom.Noscript || p.DataAtom == atom.Template)
+	})
 	return parent == nil
 }
 
+// InExpression reports whether n is rendered by an expression, e.g. conditionally or in a loop.
+func InExpression(n *astro.Node) bool {
+	return n.Closest(func(p *astro.Node) bool { return p.Expression }) != nil
+}
+
 func IsImplicitNode(n *astro.Node) bool {
 	return HasAttr(n, astro.ImplicitNodeMarker)
 }
@@ -84,14 +203,178 @@ func IsTopLevel(n *astro.Node) bool {
 	return false
 }
 
+// GetQuotedAttr returns the value of the attribute of n matching key, or "" unless it is
+// quoted. Use LookupQuotedAttr to tell a missing attribute from an empty one.
 func GetQuotedAttr(n *astro.Node, key string) string {
-	for _, attr := range n.Attr {
-		if attr.Key == key {
-			if attr.Type == astro.QuotedAttribute {
-				return attr.Val
+	val, _ := LookupQuotedAttr(n, key)
+	return val
+}
+
+// LookupQuotedAttr returns the value of the attribute of n matching key, and whether it
+// is a quoted attribute (e.g. `href="/"`).
+func LookupQuotedAttr(n *astro.Node, key string) (string, bool) {
+	return lookupAttr(n, key, astro.QuotedAttribute)
+}
+
+// GetExpressionAttr returns the value of the attribute of n matching key, and whether it
+// is an expression attribute (e.g. `href={url}`).
+func GetExpressionAttr(n *astro.Node, key string) (string, bool) {
+	return lookupAttr(n, key, astro.ExpressionAttribute)
+}
+
+func lookupAttr(n *astro.Node, key string, kind astro.AttributeType) (string, bool) {
+	attr := GetAttr(n, key)
+	if attr == nil || attr.Type != kind {
+		return "", false
+	}
+	return attr.Val, true
+}
+
+var windowsPathExp = regexp.MustCompile(`^[A-Za-z]:[\\/]|\\`)
+var styleModuleSpecExp = regexp.MustCompile(`(\.css|\.pcss|\.postcss|\.sass|\.scss|\.styl|\.stylus|\.less)$`)
+
+// IsStyleModuleSpecifier reports whether an import specifier points to a stylesheet.
+func IsStyleModuleSpecifier(specifier string) bool {
+	return styleModuleSpecExp.MatchString(specifier)
+}
+
+// ScopeHash returns the scope of a component, derived from its path relative to projectRoot.
+// Paths are compared with posix separators, and case-insensitively for Windows paths, so the same
+// component produces the same scope on every machine. The hash is always 8 lowercase characters.
+func ScopeHash(filename string, projectRoot string) string {
+	return astro.HashString(relativePath(filename, projectRoot))
+}
+
+// NormalizePath converts the separators of a Windows path to forward slashes and lowercases
+// its drive letter, so that a file is named the same way on every platform. Posix paths are
+// returned unchanged.
+func NormalizePath(p string) string {
+	if !windowsPathExp.MatchString(p) {
+		return p
+	}
+	p = strings.ReplaceAll(p, "\\", "/")
+	if len(p) > 1 && p[1] == ':' {
+		p = strings.ToLower(p[:1]) + p[1:]
+	}
+	return p
+}
+
+// NormalizePaths normalizes `Filename`, `NormalizedFilename` and `ProjectRoot` with NormalizePath,
+// and makes `NormalizedFilename` relative to `ProjectRoot` when it is inside it. It should be
+// called once on the options of a compile, before they are used to transform or print it.
+func NormalizePaths(opts *TransformOptions) {
+	opts.NormalizedFilename = relativePath(opts.NormalizedFilename, opts.ProjectRoot)
+	opts.Filename = NormalizePath(opts.Filename)
+	opts.ProjectRoot = NormalizePath(opts.ProjectRoot)
+}
+
+// relativePath returns filename relative to root when root is one of its parent directories,
+// and filename otherwise, both normalized with NormalizePath. Windows paths are compared
+// case-insensitively.
+func relativePath(filename string, root string) string {
+	isWindows := windowsPathExp.MatchString(filename)
+	filename = NormalizePath(filename)
+	root = strings.TrimSuffix(NormalizePath(root), "/")
+	if root == "" || len(filename) <= len(root) || filename[len(root)] != '/' {
+		return filename
+	}
+	if prefix := filename[:len(root)]; prefix == root || (isWindows && strings.EqualFold(prefix, root)) {
+		return filename[len(root)+1:]
+	}
+	return filename
+}
+
+// NormalizeLineEndings converts the CRLF and lone CR line endings of source to LF, so that
+// a component saved with Windows line endings compiles to the same module. Lines and columns
+// are unchanged, only offsets past a removed CR move. It should be called once on the source
+// of a compile, before it is parsed.
+func NormalizeLineEndings(source string) string {
+	if !strings.Contains(source, "\r") {
+		return source
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(source, "\r\n", "\n"), "\r", "\n")
+}
+
+// SanitizeScope replaces every character of scope that isn't safe in a class name, an attribute
+// name and a CSS selector alike with a `-`, and reports whether any character was replaced.
+func SanitizeScope(scope string) (string, bool) {
+	valid := func(r rune) bool {
+		return r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+	}
+	if strings.IndexFunc(scope, func(r rune) bool { return !valid(r) }) == -1 {
+		return scope, false
+	}
+	return strings.Map(func(r rune) rune {
+		if valid(r) {
+			return r
+		}
+		return '-'
+	}, scope), true
+}
+
+// closestMatch returns the candidate with the smallest edit distance to s, as long as it is
+// close enough to be a likely typo, or "" otherwise.
+func closestMatch(s string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(s, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
 			}
-			return ""
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
 		}
+		prev, curr = curr, prev
 	}
-	return ""
+	return prev[len(b)]
+}
+
+// HTML attributes whose presence alone enables them. `hidden` is deliberately missing,
+// since it also accepts the `until-found` value.
+var booleanAttributes = map[string]bool{
+	"allowfullscreen": true,
+	"async":           true,
+	"autofocus":       true,
+	"autoplay":        true,
+	"checked":         true,
+	"controls":        true,
+	"default":         true,
+	"defer":           true,
+	"disabled":        true,
+	"formnovalidate":  true,
+	"inert":           true,
+	"ismap":           true,
+	"itemscope":       true,
+	"loop":            true,
+	"multiple":        true,
+	"muted":           true,
+	"nomodule":        true,
+	"novalidate":      true,
+	"open":            true,
+	"playsinline":     true,
+	"readonly":        true,
+	"required":        true,
+	"reversed":        true,
+	"selected":        true,
+}
+
+// IsBooleanAttribute reports whether key is an HTML boolean attribute, ignoring case.
+func IsBooleanAttribute(key string) bool {
+	return booleanAttributes[strings.ToLower(key)]
 }
@@ -0,0 +1,333 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/handler"
+	"golang.org/x/net/html/atom"
+)
+
+func TestAddClass(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		class  string
+		want   string
+	}{
+		{
+			name:   "no class",
+			source: `<div></div>`,
+			class:  "foo",
+			want:   `<div class="foo"></div>`,
+		},
+		{
+			name:   "empty class",
+			source: `<div class></div>`,
+			class:  "foo",
+			want:   `<div class="foo"></div>`,
+		},
+		{
+			name:   "quoted class",
+			source: `<div class="a b"></div>`,
+			class:  "foo",
+			want:   `<div class="a b foo"></div>`,
+		},
+		{
+			name:   "quoted class already present",
+			source: `<div class="a foo"></div>`,
+			class:  "foo",
+			want:   `<div class="a foo"></div>`,
+		},
+		{
+			name:   "template literal class",
+			source: "<div class=`a ${b}`></div>",
+			class:  "foo",
+			want:   "<div class=`a ${b} foo`></div>",
+		},
+		{
+			name:   "expression class",
+			source: `<div class={cls}></div>`,
+			class:  "foo",
+			want:   `<div class={((cls) ?? "") + " foo"}></div>`,
+		},
+		{
+			name:   "shorthand class",
+			source: `<div {class}></div>`,
+			class:  "foo",
+			want:   `<div class={((Astro.props.class) ?? "") + " foo"}></div>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			h := handler.NewHandler(tt.source, "TestUtils.astro")
+			nodes, err := astro.ParseFragmentWithOptions(strings.NewReader(tt.source), &astro.Node{Type: astro.ElementNode, DataAtom: atom.Body, Data: atom.Body.String()}, astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			AddClass(nodes[0], tt.class)
+			astro.PrintToSource(&b, nodes[0])
+			got := b.String()
+			if tt.want != got {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetClassList(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "no class",
+			source: `<div></div>`,
+			want:   []string{},
+		},
+		{
+			name:   "quoted class",
+			source: `<div class=" a  b "></div>`,
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "expression class",
+			source: `<div class={cls}></div>`,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "TestUtils.astro")
+			nodes, err := astro.ParseFragmentWithOptions(strings.NewReader(tt.source), &astro.Node{Type: astro.ElementNode, DataAtom: atom.Body, Data: atom.Body.String()}, astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			got := GetClassList(nodes[0])
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") || len(got) != len(tt.want) {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetAttr(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		key        string
+		has        bool
+		quoted     string
+		isQuoted   bool
+		expression string
+		isExpr     bool
+	}{
+		{
+			name:     "quoted",
+			source:   `<a href="/"></a>`,
+			key:      "href",
+			has:      true,
+			quoted:   "/",
+			isQuoted: true,
+		},
+		{
+			name:     "empty quoted",
+			source:   `<a href=""></a>`,
+			key:      "href",
+			has:      true,
+			isQuoted: true,
+		},
+		{
+			name:       "expression",
+			source:     `<a href={url}></a>`,
+			key:        "href",
+			has:        true,
+			expression: "url",
+			isExpr:     true,
+		},
+		{
+			name:   "missing",
+			source: `<a></a>`,
+			key:    "href",
+		},
+		{
+			name:     "html attributes are case-insensitive",
+			source:   `<a HREF="/"></a>`,
+			key:      "href",
+			has:      true,
+			quoted:   "/",
+			isQuoted: true,
+		},
+		{
+			name:   "component props are case-sensitive",
+			source: `<Link HREF="/"></Link>`,
+			key:    "href",
+		},
+		{
+			name:     "component props",
+			source:   `<Link HREF="/"></Link>`,
+			key:      "HREF",
+			has:      true,
+			quoted:   "/",
+			isQuoted: true,
+		},
+		{
+			name:   "custom element attributes are case-sensitive",
+			source: `<my-link HREF="/"></my-link>`,
+			key:    "href",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "TestUtils.astro")
+			nodes, err := astro.ParseFragmentWithOptions(strings.NewReader(tt.source), &astro.Node{Type: astro.ElementNode, DataAtom: atom.Body, Data: atom.Body.String()}, astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			n := nodes[0]
+			if got := HasAttr(n, tt.key); got != tt.has || (GetAttr(n, tt.key) != nil) != tt.has {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.has, got)
+			}
+			if val, ok := LookupQuotedAttr(n, tt.key); val != tt.quoted || ok != tt.isQuoted {
+				t.Errorf("\nFAIL: %s LookupQuotedAttr\n  want: %q %v\n  got:  %q %v", tt.name, tt.quoted, tt.isQuoted, val, ok)
+			}
+			if val, ok := GetExpressionAttr(n, tt.key); val != tt.expression || ok != tt.isExpr {
+				t.Errorf("\nFAIL: %s GetExpressionAttr\n  want: %q %v\n  got:  %q %v", tt.name, tt.expression, tt.isExpr, val, ok)
+			}
+		})
+	}
+}
+
+func TestSetAndRemoveAttr(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		set     *astro.Attribute
+		remove  string
+		removed bool
+		want    string
+	}{
+		{
+			name:   "set replaces in place",
+			source: `<a class="a" href="/old" id="b"></a>`,
+			set:    &astro.Attribute{Key: "href", Val: "url", Type: astro.ExpressionAttribute},
+			want:   `<a class="a" href={url} id="b"></a>`,
+		},
+		{
+			name:   "set keeps the authored quote",
+			source: `<a href='/old'></a>`,
+			set:    &astro.Attribute{Key: "href", Val: "/", Type: astro.QuotedAttribute},
+			want:   `<a href='/'></a>`,
+		},
+		{
+			name:   "set appends",
+			source: `<a class="a"></a>`,
+			set:    &astro.Attribute{Key: "href", Val: "/", Type: astro.QuotedAttribute},
+			want:   `<a class="a" href="/"></a>`,
+		},
+		{
+			name:   "set matches html attributes case-insensitively",
+			source: `<a HREF="/old"></a>`,
+			set:    &astro.Attribute{Key: "href", Val: "/", Type: astro.QuotedAttribute},
+			want:   `<a href="/"></a>`,
+		},
+		{
+			name:   "set matches component props exactly",
+			source: `<Link HREF="/old"></Link>`,
+			set:    &astro.Attribute{Key: "href", Val: "/", Type: astro.QuotedAttribute},
+			want:   `<Link HREF="/old" href="/"></Link>`,
+		},
+		{
+			name:    "remove",
+			source:  `<a class="a" href="/" id="b"></a>`,
+			remove:  "href",
+			removed: true,
+			want:    `<a class="a" id="b"></a>`,
+		},
+		{
+			name:    "remove html attributes case-insensitively",
+			source:  `<a Href="/" id="b"></a>`,
+			remove:  "href",
+			removed: true,
+			want:    `<a id="b"></a>`,
+		},
+		{
+			name:   "remove missing",
+			source: `<a id="b"></a>`,
+			remove: "href",
+			want:   `<a id="b"></a>`,
+		},
+		{
+			name:   "remove matches component props exactly",
+			source: `<Link Href="/"></Link>`,
+			remove: "href",
+			want:   `<Link Href="/"></Link>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			h := handler.NewHandler(tt.source, "TestUtils.astro")
+			nodes, err := astro.ParseFragmentWithOptions(strings.NewReader(tt.source), &astro.Node{Type: astro.ElementNode, DataAtom: atom.Body, Data: atom.Body.String()}, astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			if tt.set != nil {
+				SetAttr(nodes[0], *tt.set)
+			} else if got := RemoveAttr(nodes[0], tt.remove); got != tt.removed {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.removed, got)
+			}
+			astro.PrintToSource(&b, nodes[0])
+			if got := b.String(); got != tt.want {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetTruthyAttrValue(t *testing.T) {
+	tests := []struct {
+		source string
+		truthy bool
+		static bool
+	}{
+		{source: `<script></script>`, truthy: false, static: true},
+		{source: `<script hoist></script>`, truthy: true, static: true},
+		{source: `<script HOIST></script>`, truthy: true, static: true},
+		{source: `<script hoist=""></script>`, truthy: true, static: true},
+		{source: `<script hoist="true"></script>`, truthy: true, static: true},
+		{source: `<script hoist="false"></script>`, truthy: false, static: true},
+		{source: `<script hoist="1"></script>`, truthy: false, static: true},
+		{source: `<script hoist={true}></script>`, truthy: true, static: true},
+		{source: `<script hoist={ true }></script>`, truthy: true, static: true},
+		{source: `<script hoist={false}></script>`, truthy: false, static: true},
+		{source: `<script hoist={1}></script>`, truthy: false, static: false},
+		{source: `<script hoist={""}></script>`, truthy: false, static: false},
+		{source: `<script hoist={enabled}></script>`, truthy: false, static: false},
+		{source: `<script {hoist}></script>`, truthy: false, static: false},
+		{source: "<script hoist=`true`></script>", truthy: false, static: false},
+		// Other attributes never make `hoist` truthy
+		{source: `<script async foo="" bar="true" baz={true}></script>`, truthy: false, static: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			h := handler.NewHandler(tt.source, "TestUtils.astro")
+			nodes, err := astro.ParseFragmentWithOptions(strings.NewReader(tt.source), &astro.Node{Type: astro.ElementNode, DataAtom: atom.Body, Data: atom.Body.String()}, astro.ParseOptionWithHandler(h))
+			if err != nil {
+				t.Error(err)
+			}
+			truthy, static := GetTruthyAttrValue(nodes[0], "hoist")
+			if truthy != tt.truthy || static != tt.static {
+				t.Errorf("\nFAIL: %s\n  want: %v %v\n  got:  %v %v", tt.source, tt.truthy, tt.static, truthy, static)
+			}
+			if hasTruthyAttr(nodes[0], "hoist") != tt.truthy {
+				t.Errorf("\nFAIL: %s\n  expected hasTruthyAttr to be %v", tt.source, tt.truthy)
+			}
+		})
+	}
+}
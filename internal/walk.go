@@ -0,0 +1,97 @@
+package astro
+
+// A WalkAction tells Walk how to continue after a node has been entered.
+type WalkAction uint8
+
+const (
+	// WalkContinue visits the children of the node.
+	WalkContinue WalkAction = iota
+	// WalkSkipChildren skips the children of the node. Exit is still called for it.
+	WalkSkipChildren
+	// WalkStop ends the traversal. Exit is not called for the node nor for any of
+	// its ancestors.
+	WalkStop
+)
+
+// A Visitor is called by Walk for every node it visits.
+type Visitor interface {
+	// Enter is called before the children of n are visited.
+	Enter(n *Node) WalkAction
+	// Exit is called once the children of n have been visited, or skipped.
+	Exit(n *Node)
+}
+
+// VisitorFuncs adapts a pair of functions to a Visitor. Either may be nil.
+type VisitorFuncs struct {
+	OnEnter func(n *Node) WalkAction
+	OnExit  func(n *Node)
+}
+
+func (v VisitorFuncs) Enter(n *Node) WalkAction {
+	if v.OnEnter == nil {
+		return WalkContinue
+	}
+	return v.OnEnter(n)
+}
+
+func (v VisitorFuncs) Exit(n *Node) {
+	if v.OnExit != nil {
+		v.OnExit(n)
+	}
+}
+
+// walkFrame is a node that has been entered but not exited yet.
+type walkFrame struct {
+	node   *Node
+	parent *Node
+	// The sibling that followed node when it was entered
+	next *Node
+}
+
+// following returns the node to visit after f.node. If f.node has been removed from
+// its parent, this is the sibling that followed it when it was entered.
+func (f walkFrame) following() *Node {
+	if f.node.Parent == f.parent {
+		return f.node.NextSibling
+	}
+	return f.next
+}
+
+// Walk visits root and every node below it in document order, calling v.Enter
+// before and v.Exit after the children of each node.
+//
+// The tree may be modified while it is walked:
+//   - the children of a node are read after Enter returns for it, so children
+//     added or removed by Enter are taken into account;
+//   - the next sibling of a node is read after Exit returns for it, so siblings
+//     inserted after the current node are visited;
+//   - a node may remove itself from its parent in Enter or Exit. Its children are
+//     still visited unless Enter skips them, and the walk continues with the
+//     sibling that followed it when it was entered.
+//
+// The siblings of root are never visited.
+func Walk(root *Node, v Visitor) {
+	var stack []walkFrame
+	n := root
+	for {
+		if n != nil {
+			frame := walkFrame{node: n, parent: n.Parent, next: n.NextSibling}
+			action := v.Enter(n)
+			if action == WalkStop {
+				return
+			}
+			stack = append(stack, frame)
+			if action == WalkContinue {
+				n = n.FirstChild
+				continue
+			}
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		v.Exit(top.node)
+		if len(stack) == 0 {
+			return
+		}
+		n = top.following()
+	}
+}
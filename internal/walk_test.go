@@ -0,0 +1,162 @@
+package astro
+
+import (
+	"strings"
+	"testing"
+)
+
+// walkLabel names elements by tag and other nodes by their data.
+func walkLabel(n *Node) string {
+	if n.Type == ElementNode {
+		return n.Data
+	}
+	return n.Type.String() + " " + strings.TrimSpace(n.Data)
+}
+
+func TestWalk(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		enter  func(n *Node) WalkAction
+		exit   func(n *Node)
+		want   []string
+	}{
+		{
+			name:   "order",
+			source: `<div><p>a</p><span /></div>`,
+			want: []string{
+				"> body", "> div", "> p", "> text a", "< text a", "< p", "> span", "< span", "< div", "< body",
+			},
+		},
+		{
+			name:   "skip children",
+			source: `<div><p>a</p></div><span />`,
+			enter: func(n *Node) WalkAction {
+				if n.Data == "div" {
+					return WalkSkipChildren
+				}
+				return WalkContinue
+			},
+			want: []string{"> body", "> div", "< div", "> span", "< span", "< body"},
+		},
+		{
+			name:   "stop",
+			source: `<div><p>a</p><span /></div><section />`,
+			enter: func(n *Node) WalkAction {
+				if n.Data == "p" {
+					return WalkStop
+				}
+				return WalkContinue
+			},
+			want: []string{"> body", "> div", "> p"},
+		},
+		{
+			name:   "remove current node on enter",
+			source: `<div><p>a</p><span /></div>`,
+			enter: func(n *Node) WalkAction {
+				if n.Data == "p" {
+					n.Parent.RemoveChild(n)
+				}
+				return WalkContinue
+			},
+			want: []string{
+				"> body", "> div", "> p", "> text a", "< text a", "< p", "> span", "< span", "< div", "< body",
+			},
+		},
+		{
+			name:   "remove current node on exit",
+			source: `<div><p>a</p><span /></div>`,
+			exit: func(n *Node) {
+				if n.Data == "p" {
+					n.Parent.RemoveChild(n)
+				}
+			},
+			want: []string{
+				"> body", "> div", "> p", "> text a", "< text a", "< p", "> span", "< span", "< div", "< body",
+			},
+		},
+		{
+			name:   "remove next sibling",
+			source: `<div><p>a</p><span /><b /></div>`,
+			enter: func(n *Node) WalkAction {
+				if n.Data == "p" {
+					n.Parent.RemoveChild(n.NextSibling)
+				}
+				return WalkContinue
+			},
+			want: []string{
+				"> body", "> div", "> p", "> text a", "< text a", "< p", "> b", "< b", "< div", "< body",
+			},
+		},
+		{
+			name:   "append sibling",
+			source: `<div><p>a</p></div>`,
+			enter: func(n *Node) WalkAction {
+				if n.Data == "p" {
+					n.Parent.AppendChild(&Node{Type: ElementNode, Data: "em"})
+				}
+				return WalkContinue
+			},
+			want: []string{
+				"> body", "> div", "> p", "> text a", "< text a", "< p", "> em", "< em", "< div", "< body",
+			},
+		},
+		{
+			name:   "append child",
+			source: `<div><p>a</p></div>`,
+			enter: func(n *Node) WalkAction {
+				if n.Data == "p" {
+					n.AppendChild(&Node{Type: ElementNode, Data: "em"})
+				}
+				return WalkContinue
+			},
+			want: []string{
+				"> body", "> div", "> p", "> text a", "< text a", "> em", "< em", "< p", "< div", "< body",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := doc.LastChild.FirstChild.NextSibling
+			got := []string{}
+			Walk(body, VisitorFuncs{
+				OnEnter: func(n *Node) WalkAction {
+					got = append(got, "> "+walkLabel(n))
+					if tt.enter != nil {
+						return tt.enter(n)
+					}
+					return WalkContinue
+				},
+				OnExit: func(n *Node) {
+					got = append(got, "< "+walkLabel(n))
+					if tt.exit != nil {
+						tt.exit(n)
+					}
+				},
+			})
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("\nFAIL: %s\n  want: %v\n  got:  %v", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWalkRootSiblings(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<div><p /></div><span />`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	div := doc.LastChild.FirstChild.NextSibling.FirstChild
+	got := []string{}
+	Walk(div, VisitorFuncs{OnEnter: func(n *Node) WalkAction {
+		got = append(got, walkLabel(n))
+		return WalkContinue
+	}})
+	if want := "div, p"; strings.Join(got, ", ") != want {
+		t.Errorf("\nFAIL: root siblings\n  want: %s\n  got:  %s", want, strings.Join(got, ", "))
+	}
+}
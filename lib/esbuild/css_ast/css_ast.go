@@ -655,6 +655,7 @@ func (ss *SSClass) Hash() uint32 {
 type SSAttribute struct {
 	MatcherOp       string // Either "" or one of: "=" "~=" "|=" "^=" "$=" "*="
 	MatcherValue    string
+	MatcherRaw      string // The matcher value as written in the source, including any quotes
 	NamespacedName  NamespacedName
 	MatcherModifier byte // Either 0 or one of: 'i' 'I' 's' 'S'
 }
@@ -690,3 +691,69 @@ func (ss *SSPseudoClass) Hash() uint32 {
 	hash = HashTokens(hash, ss.Args)
 	return hash
 }
+
+type PseudoClassKind uint8
+
+const (
+	PseudoClassHas PseudoClassKind = iota
+	PseudoClassIs
+	PseudoClassNot
+	PseudoClassWhere
+)
+
+func (kind PseudoClassKind) String() string {
+	switch kind {
+	case PseudoClassHas:
+		return "has"
+	case PseudoClassIs:
+		return "is"
+	case PseudoClassNot:
+		return "not"
+	case PseudoClassWhere:
+		return "where"
+	default:
+		panic("Internal error")
+	}
+}
+
+// This is used for pseudo-classes that take a selector list as an argument
+// (e.g. ":is(a, b)") so that the selectors inside can be scoped individually
+type SSPseudoClassWithSelectorList struct {
+	Selectors []ComplexSelector
+	Kind      PseudoClassKind
+}
+
+func (a *SSPseudoClassWithSelectorList) Equal(ss SS) bool {
+	b, ok := ss.(*SSPseudoClassWithSelectorList)
+	if !ok || a.Kind != b.Kind || len(a.Selectors) != len(b.Selectors) {
+		return false
+	}
+	for i, ai := range a.Selectors {
+		if !ai.Equal(b.Selectors[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ss *SSPseudoClassWithSelectorList) Hash() uint32 {
+	hash := uint32(5)
+	hash = helpers.HashCombine(hash, uint32(ss.Kind))
+	hash = helpers.HashCombine(hash, uint32(len(ss.Selectors)))
+	for _, complex := range ss.Selectors {
+		hash = helpers.HashCombine(hash, uint32(len(complex.Selectors)))
+		for _, sel := range complex.Selectors {
+			if sel.TypeSelector != nil {
+				hash = helpers.HashCombineString(hash, sel.TypeSelector.Name.Text)
+			} else {
+				hash = helpers.HashCombine(hash, 0)
+			}
+			hash = helpers.HashCombine(hash, uint32(len(sel.SubclassSelectors)))
+			for _, sub := range sel.SubclassSelectors {
+				hash = helpers.HashCombine(hash, sub.Hash())
+			}
+			hash = helpers.HashCombineString(hash, sel.Combinator)
+		}
+	}
+	return hash
+}
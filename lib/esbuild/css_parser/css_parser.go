@@ -308,11 +308,55 @@ func (p *parser) parseListOfDeclarations() (list []css_ast.Rule) {
 			list = append(list, p.parseSelectorRuleFrom(p.index, parseSelectorOpts{allowNesting: true}))
 
 		default:
-			list = append(list, p.parseDeclaration())
+			// Nested style rules may also omit the leading "&" (e.g. "> p {}")
+			if p.isNestedStyleRule() {
+				list = append(list, p.parseSelectorRuleFrom(p.index, parseSelectorOpts{allowNesting: true, isRelative: true}))
+			} else {
+				list = append(list, p.parseDeclaration())
+			}
 		}
 	}
 }
 
+// Reports whether the tokens at the current position form a nested style rule
+// instead of a declaration. Declarations end at a ";" or "}" while style rules
+// reach a "{" first.
+func (p *parser) isNestedStyleRule() bool {
+	switch t := p.current(); t.Kind {
+	case css_lexer.TIdent:
+		if strings.HasPrefix(t.DecodedText(p.source.Contents), "--") {
+			return false
+		}
+
+		// "a:hover {}" is a rule but "a: {}" is a declaration
+		if p.next().Kind == css_lexer.TColon {
+			if k := p.at(p.index + 2).Kind; k == css_lexer.TWhitespace || k == css_lexer.TOpenBrace {
+				return false
+			}
+		}
+	case css_lexer.TDelimDot, css_lexer.THash, css_lexer.TColon, css_lexer.TOpenBracket, css_lexer.TDelimAsterisk,
+		css_lexer.TDelimGreaterThan, css_lexer.TDelimPlus, css_lexer.TDelimTilde:
+	default:
+		return false
+	}
+	depth := 0
+	for i := p.index; i < p.end; i++ {
+		switch p.tokens[i].Kind {
+		case css_lexer.TOpenParen, css_lexer.TFunction, css_lexer.TOpenBracket:
+			depth++
+		case css_lexer.TCloseParen, css_lexer.TCloseBracket:
+			depth--
+		case css_lexer.TOpenBrace:
+			return depth == 0
+		case css_lexer.TSemicolon, css_lexer.TCloseBrace:
+			if depth == 0 {
+				return false
+			}
+		}
+	}
+	return false
+}
+
 func mangleRules(rules []css_ast.Rule) []css_ast.Rule {
 	type hashEntry struct {
 		indices []uint32
@@ -560,6 +604,10 @@ func isSafeSelectors(complexSelectors []css_ast.ComplexSelector) bool {
 						return false
 					}
 
+				case *css_ast.SSPseudoClassWithSelectorList:
+					// Bail since none of these pseudo classes work in IE 7
+					return false
+
 				case *css_ast.SSPseudoClass:
 					// Bail if this pseudo class doesn't match a hard-coded list that's
 					// known to work everywhere. For example, ":focus" doesn't work in IE 7.
@@ -627,6 +675,16 @@ var specialAtRules = map[string]atRuleKind{
 	"font-face": atRuleDeclarations,
 	"page":      atRuleDeclarations,
 
+	// These only contain descriptors, so their contents must never be scoped
+	//
+	//   Documentation: https://developer.mozilla.org/en-US/docs/Web/CSS/@property
+	//   Documentation: https://developer.mozilla.org/en-US/docs/Web/CSS/@counter-style
+	//   Documentation: https://developer.mozilla.org/en-US/docs/Web/CSS/@font-palette-values
+	//
+	"property":            atRuleDeclarations,
+	"counter-style":       atRuleDeclarations,
+	"font-palette-values": atRuleDeclarations,
+
 	// These go inside "@page": https://www.w3.org/TR/css-page-3/#syntax-page-selector
 	"bottom-center":       atRuleDeclarations,
 	"bottom-left-corner":  atRuleDeclarations,
@@ -2,6 +2,7 @@ package css_parser
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/withastro/compiler/lib/esbuild/compat"
 	"github.com/withastro/compiler/lib/esbuild/css_ast"
@@ -25,6 +26,12 @@ func (p *parser) parseSelectorList(opts parseSelectorOpts) (list []css_ast.Compl
 			break
 		}
 		p.eat(css_lexer.TWhitespace)
+
+		// Selector lists inside pseudo-classes are forgiving of a trailing comma
+		if opts.stopOnCloseParen && p.peek(css_lexer.TCloseParen) {
+			break
+		}
+
 		loc := p.current().Range.Loc
 		sel, good, hasNestPrefix := p.parseComplexSelector(opts)
 		if !good {
@@ -49,17 +56,30 @@ func (p *parser) parseSelectorList(opts parseSelectorOpts) (list []css_ast.Compl
 }
 
 type parseSelectorOpts struct {
-	atNestRange  logger.Range
-	allowNesting bool
+	atNestRange      logger.Range
+	allowNesting     bool
+	isRelative       bool
+	stopOnCloseParen bool
 }
 
 func (p *parser) parseComplexSelector(opts parseSelectorOpts) (result css_ast.ComplexSelector, ok bool, hasNestPrefix bool) {
 	// Parent
 	loc := p.current().Range.Loc
+
+	// Relative selectors (e.g. ":has(> img)") may start with a combinator
+	var leadingCombinator string
+	if opts.isRelative {
+		leadingCombinator = p.parseCombinator()
+		if leadingCombinator != "" {
+			p.eat(css_lexer.TWhitespace)
+		}
+	}
+
 	sel, good := p.parseCompoundSelector(opts)
 	if !good {
 		return
 	}
+	sel.Combinator = leadingCombinator
 	hasNestPrefix = sel.NestingSelector == css_ast.NestingSelectorPrefix
 	isNestContaining := sel.NestingSelector != css_ast.NestingSelectorNone
 	result.Selectors = append(result.Selectors, sel)
@@ -69,6 +89,9 @@ func (p *parser) parseComplexSelector(opts parseSelectorOpts) (result css_ast.Co
 		if p.peek(css_lexer.TEndOfFile) || p.peek(css_lexer.TComma) || p.peek(css_lexer.TOpenBrace) {
 			break
 		}
+		if opts.stopOnCloseParen && p.peek(css_lexer.TCloseParen) {
+			break
+		}
 
 		// Optional combinator
 		combinator := p.parseCombinator()
@@ -202,6 +225,10 @@ subclassSelectors:
 				}
 				break subclassSelectors
 			}
+			if pseudo, ok := p.parsePseudoClassWithSelectorList(opts); ok {
+				sel.SubclassSelectors = append(sel.SubclassSelectors, pseudo)
+				break
+			}
 			pseudo := p.parsePseudoClassSelector()
 			sel.SubclassSelectors = append(sel.SubclassSelectors, &pseudo)
 
@@ -301,6 +328,7 @@ func (p *parser) parseAttributeSelector() (attr css_ast.SSAttribute, ok bool) {
 			p.unexpected()
 		}
 		attr.MatcherValue = p.decoded()
+		attr.MatcherRaw = p.raw()
 		p.advance()
 		p.eat(css_lexer.TWhitespace)
 		if p.peek(css_lexer.TIdent) {
@@ -318,6 +346,45 @@ func (p *parser) parseAttributeSelector() (attr css_ast.SSAttribute, ok bool) {
 	return
 }
 
+// Attempts to parse ":is()", ":where()", ":not()", and ":has()" as a list
+// of selectors. If the arguments are not a valid selector list, the parser
+// is rewound so the pseudo-class can be parsed as an opaque token list.
+func (p *parser) parsePseudoClassWithSelectorList(opts parseSelectorOpts) (*css_ast.SSPseudoClassWithSelectorList, bool) {
+	if p.next().Kind != css_lexer.TFunction {
+		return nil, false
+	}
+
+	var kind css_ast.PseudoClassKind
+	switch strings.ToLower(p.next().DecodedText(p.source.Contents)) {
+	case "is":
+		kind = css_ast.PseudoClassIs
+	case "where":
+		kind = css_ast.PseudoClassWhere
+	case "not":
+		kind = css_ast.PseudoClassNot
+	case "has":
+		kind = css_ast.PseudoClassHas
+	default:
+		return nil, false
+	}
+
+	start := p.index
+	p.advance()
+	p.advance()
+	p.eat(css_lexer.TWhitespace)
+	list, ok := p.parseSelectorList(parseSelectorOpts{
+		allowNesting:     opts.allowNesting,
+		isRelative:       kind == css_ast.PseudoClassHas,
+		stopOnCloseParen: true,
+	})
+	p.eat(css_lexer.TWhitespace)
+	if !ok || !p.eat(css_lexer.TCloseParen) {
+		p.index = start
+		return nil, false
+	}
+	return &css_ast.SSPseudoClassWithSelectorList{Kind: kind, Selectors: list}, true
+}
+
 func (p *parser) parsePseudoClassSelector() css_ast.SSPseudoClass {
 	p.advance()
 
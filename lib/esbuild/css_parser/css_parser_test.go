@@ -566,7 +566,7 @@ func TestDeclaration(t *testing.T) {
 	expectPrinted(t, ".decl { a: b; }", ".decl {\n  a: b;\n}\n")
 	expectPrinted(t, ".decl { a: b; c: d }", ".decl {\n  a: b;\n  c: d;\n}\n")
 	expectPrinted(t, ".decl { a: b; c: d; }", ".decl {\n  a: b;\n  c: d;\n}\n")
-	expectParseError(t, ".decl { a { b: c; } }", "<stdin>: WARNING: Expected \":\"\n")
+	expectParseError(t, ".decl { a { b: c; } }", "")
 	expectPrinted(t, ".decl { & a { b: c; } }", ".decl {\n  & a {\n    b: c;\n  }\n}\n")
 
 	// See http://browserhacks.com/
@@ -712,7 +712,7 @@ func TestNestedSelector(t *testing.T) {
 			"<stdin>: NOTE: This is a nested style rule because of the \"&\" here:\n")
 	expectParseError(t, "a { & b, & c {} }", "")
 
-	expectParseError(t, "a { b & {} }", "<stdin>: WARNING: Expected \":\"\n")
+	expectParseError(t, "a { b & {} }", "")
 	expectParseError(t, "a { @nest b & {} }", "")
 	expectParseError(t, "a { @nest & b, c {} }",
 		"<stdin>: WARNING: Every selector in a nested style rule must contain \"&\"\n"+
@@ -748,7 +748,7 @@ func TestNestedSelector(t *testing.T) {
 func TestBadQualifiedRules(t *testing.T) {
 	expectParseError(t, "$bad: rule;", "<stdin>: WARNING: Unexpected \"$\"\n")
 	expectParseError(t, "$bad { color: red }", "<stdin>: WARNING: Unexpected \"$\"\n")
-	expectParseError(t, "a { div.major { color: blue } color: red }", "<stdin>: WARNING: Expected \":\" but found \".\"\n")
+	expectParseError(t, "a { div.major { color: blue } color: red }", "")
 	expectParseError(t, "a { div:hover { color: blue } color: red }", "")
 	expectParseError(t, "a { div:hover { color: blue }; color: red }", "")
 	expectParseError(t, "a { div:hover { color: blue } ; color: red }", "")
@@ -2,12 +2,30 @@ package css_printer
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/withastro/compiler/lib/esbuild/css_ast"
 	"github.com/withastro/compiler/lib/esbuild/css_lexer"
+	"github.com/withastro/compiler/lib/esbuild/logger"
 )
 
+// A selector that can never be scoped (e.g. "html"), since the scope is only
+// applied to elements authored in the component
+type UnscopedSelector struct {
+	Loc      logger.Loc
+	Selector string
+}
+
 func (p *printer) printScopedSelector() bool {
+	// The compound around ":not()" carries the scope instead
+	if p.insideNot {
+		return true
+	}
+	// Nested selectors are already scoped by their parent rule
+	if p.insideScopedRule {
+		p.didPrintScope = true
+		return true
+	}
 	var str string
 	if p.options.ScopeStrategy == ScopeStrategyWhere {
 		str = fmt.Sprintf(":where(.astro-%s)", p.options.Scope)
@@ -17,6 +35,31 @@ func (p *printer) printScopedSelector() bool {
 		str = fmt.Sprintf(".astro-%s", p.options.Scope)
 	}
 	p.print(str)
+	p.didPrintScope = true
+	return true
+}
+
+// Reports whether a compound selector only consists of pseudo-classes or
+// pseudo-elements (e.g. ":hover"). These are treated like an implied universal
+// selector, so the scope is prepended.
+func isBarePseudoClassSelector(sel css_ast.CompoundSelector) bool {
+	if sel.TypeSelector != nil || sel.NestingSelector != css_ast.NestingSelectorNone || len(sel.SubclassSelectors) == 0 {
+		return false
+	}
+	for _, sub := range sel.SubclassSelectors {
+		switch s := sub.(type) {
+		case *css_ast.SSPseudoClass:
+			if s.Name == "global" || s.Name == "root" {
+				return false
+			}
+		case *css_ast.SSPseudoClassWithSelectorList:
+			if hasGlobalSelector(*s) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
 	return true
 }
 
@@ -44,6 +87,10 @@ func (p *printer) printCompoundSelector(sel css_ast.CompoundSelector, isFirst bo
 		}
 	}
 
+	if isBarePseudoClassSelector(sel) {
+		scoped = p.printScopedSelector()
+	}
+
 	if sel.TypeSelector != nil {
 		whitespace := mayNeedWhitespaceAfter
 		if len(sel.SubclassSelectors) > 0 {
@@ -59,8 +106,12 @@ func (p *printer) printCompoundSelector(sel css_ast.CompoundSelector, isFirst bo
 		switch sel.TypeSelector.Name.Text {
 		case "body", "html":
 			scoped = true
+			if !p.insideNot {
+				p.unscopableSelector = sel.TypeSelector.Name.Text
+			}
 		default:
-			if !scoped {
+			// Attribute selectors print the scope after their closing bracket
+			if !scoped && !startsWithAttributeSelector(sel) {
 				scoped = p.printScopedSelector()
 			}
 		}
@@ -93,30 +144,15 @@ func (p *printer) printCompoundSelector(sel css_ast.CompoundSelector, isFirst bo
 			}
 
 		case *css_ast.SSAttribute:
-			if !scoped {
-				scoped = p.printScopedSelector()
-			}
 			p.print("[")
 			p.printNamespacedName(s.NamespacedName, canDiscardWhitespaceAfter)
 			if s.MatcherOp != "" {
 				p.print(s.MatcherOp)
-				printAsIdent := false
-
-				// Print the value as an identifier if it's possible
-				if css_lexer.WouldStartIdentifierWithoutEscapes(s.MatcherValue) {
-					printAsIdent = true
-					for _, c := range s.MatcherValue {
-						if !css_lexer.IsNameContinue(c) {
-							printAsIdent = false
-							break
-						}
-					}
-				}
-
-				if printAsIdent {
-					p.printIdent(s.MatcherValue, identNormal, canDiscardWhitespaceAfter)
+				if s.MatcherRaw != "" {
+					// Preserve the original quoting and escapes so the value round-trips
+					p.print(s.MatcherRaw)
 				} else {
-					p.printQuoted(s.MatcherValue)
+					p.printAttributeMatcherValue(s.MatcherValue)
 				}
 			}
 			if s.MatcherModifier != 0 {
@@ -124,12 +160,30 @@ func (p *printer) printCompoundSelector(sel css_ast.CompoundSelector, isFirst bo
 				p.print(string(rune(s.MatcherModifier)))
 			}
 			p.print("]")
+			if !scoped {
+				scoped = p.printScopedSelector()
+			}
+
+		case *css_ast.SSPseudoClassWithSelectorList:
+			p.printPseudoClassWithSelectorList(*s)
+			// An alternative of `:is()` or `:where()` may opt out of the scope with `:global()`
+			if hasGlobalSelector(*s) {
+				scoped = true
+			}
 
 		case *css_ast.SSPseudoClass:
+			// The scope must come before any pseudo-element, which has to be last in the compound.
+			// This includes `::part()`, which selects into a shadow tree that is never scoped.
+			if !scoped && isPseudoElement(*s) {
+				scoped = p.printScopedSelector()
+			}
 			p.printPseudoClassSelector(*s, whitespace)
 			if s.Name == "global" || s.Name == "root" {
 				scoped = true
 			}
+			if s.Name == "root" && !p.insideNot {
+				p.unscopableSelector = ":root"
+			}
 		}
 	}
 
@@ -144,6 +198,88 @@ func (p *printer) printCompoundSelector(sel css_ast.CompoundSelector, isFirst bo
 	}
 }
 
+func (p *printer) printAttributeMatcherValue(value string) {
+	printAsIdent := false
+
+	// Print the value as an identifier if it's possible
+	if css_lexer.WouldStartIdentifierWithoutEscapes(value) {
+		printAsIdent = true
+		for _, c := range value {
+			if !css_lexer.IsNameContinue(c) {
+				printAsIdent = false
+				break
+			}
+		}
+	}
+
+	if printAsIdent {
+		p.printIdent(value, identNormal, canDiscardWhitespaceAfter)
+	} else {
+		p.printQuoted(value)
+	}
+}
+
+// Reports whether one of the selectors of an `:is()` or `:where()` is a `:global()`
+// selector, which matches elements outside of the component.
+func hasGlobalSelector(pseudo css_ast.SSPseudoClassWithSelectorList) bool {
+	if pseudo.Kind != css_ast.PseudoClassIs && pseudo.Kind != css_ast.PseudoClassWhere {
+		return false
+	}
+	for _, complex := range pseudo.Selectors {
+		for _, compound := range complex.Selectors {
+			for _, sub := range compound.SubclassSelectors {
+				if s, ok := sub.(*css_ast.SSPseudoClass); ok && s.Name == "global" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func startsWithAttributeSelector(sel css_ast.CompoundSelector) bool {
+	if len(sel.SubclassSelectors) == 0 {
+		return false
+	}
+	_, ok := sel.SubclassSelectors[0].(*css_ast.SSAttribute)
+	return ok
+}
+
+// Reports whether this is a pseudo-element, including the legacy single-colon forms
+// (e.g. ":before") that are allowed for backwards compatibility.
+func isPseudoElement(pseudo css_ast.SSPseudoClass) bool {
+	if pseudo.IsElement {
+		return true
+	}
+	switch pseudo.Name {
+	case "before", "after", "first-line", "first-letter":
+		return true
+	}
+	return false
+}
+
+func (p *printer) printPseudoClassWithSelectorList(pseudo css_ast.SSPseudoClassWithSelectorList) {
+	p.print(":")
+	p.print(pseudo.Kind.String())
+	p.print("(")
+	wasInsideNot := p.insideNot
+	p.insideNot = wasInsideNot || pseudo.Kind == css_ast.PseudoClassNot
+	for i, complex := range pseudo.Selectors {
+		if i > 0 {
+			if p.options.MinifyWhitespace {
+				p.print(",")
+			} else {
+				p.print(", ")
+			}
+		}
+		for j, compound := range complex.Selectors {
+			p.printCompoundSelector(compound, j == 0, j+1 == len(complex.Selectors))
+		}
+	}
+	p.insideNot = wasInsideNot
+	p.print(")")
+}
+
 func (p *printer) printPseudoClassSelector(pseudo css_ast.SSPseudoClass, whitespace trailingWhitespace) {
 	if pseudo.Name == "global" {
 		if len(pseudo.Args) > 0 {
@@ -167,3 +303,67 @@ func (p *printer) printPseudoClassSelector(pseudo css_ast.SSPseudoClass, whitesp
 		}
 	}
 }
+
+// Collects the names of every "@keyframes" rule in the stylesheet, including
+// those nested inside of other at-rules. A stylesheet is a single "<style>", so
+// keyframes of other styles, components or global stylesheets are never included
+func collectKeyframeNames(rules []css_ast.Rule, names map[string]bool) {
+	for _, rule := range rules {
+		switch r := rule.Data.(type) {
+		case *css_ast.RAtKeyframes:
+			if r.Name != "" {
+				names[r.Name] = true
+			}
+		case *css_ast.RKnownAt:
+			collectKeyframeNames(r.Rules, names)
+		case *css_ast.RAtLayer:
+			collectKeyframeNames(r.Rules, names)
+		case *css_ast.RSelector:
+			collectKeyframeNames(r.Rules, names)
+		}
+	}
+}
+
+// Keyframes defined in a scoped stylesheet are renamed so that they cannot
+// collide with keyframes of the same name in other components
+func (p *printer) scopedKeyframeName(name string) string {
+	if !p.keyframes[name] {
+		return name
+	}
+	return fmt.Sprintf("%s-astro-%s", name, p.options.Scope)
+}
+
+func isAnimationDeclaration(r *css_ast.RDeclaration) bool {
+	if r.Key == css_ast.DAnimation || r.Key == css_ast.DAnimationName {
+		return true
+	}
+	switch strings.ToLower(r.KeyText) {
+	case "-webkit-animation", "-webkit-animation-name":
+		return true
+	}
+	return false
+}
+
+// Rewrites references to locally defined keyframes in an "animation" or
+// "animation-name" value. Names that are not defined locally are left alone, as
+// they refer to keyframes declared by another style.
+func (p *printer) scopeAnimationNames(tokens []css_ast.Token) []css_ast.Token {
+	if len(p.keyframes) == 0 {
+		return tokens
+	}
+	var scoped []css_ast.Token
+	for i, t := range tokens {
+		if t.Kind != css_lexer.TIdent || !p.keyframes[t.Text] {
+			continue
+		}
+		if scoped == nil {
+			scoped = make([]css_ast.Token, len(tokens))
+			copy(scoped, tokens)
+		}
+		scoped[i].Text = p.scopedKeyframeName(t.Text)
+	}
+	if scoped == nil {
+		return tokens
+	}
+	return scoped
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/withastro/compiler/lib/esbuild/css_ast"
 	"github.com/withastro/compiler/lib/esbuild/css_lexer"
 	"github.com/withastro/compiler/lib/esbuild/helpers"
+	"github.com/withastro/compiler/lib/esbuild/logger"
 	"github.com/withastro/compiler/lib/esbuild/sourcemap"
 )
 
@@ -21,6 +22,24 @@ type printer struct {
 	css                    []byte
 	extractedLegalComments map[string]bool
 	builder                sourcemap.ChunkBuilder
+
+	// Used to detect complex selectors that could not be scoped
+	ruleLoc            logger.Loc
+	didPrintScope      bool
+	unscopableSelector string
+	unscopedSelectors  []UnscopedSelector
+
+	// Keyframes defined in the stylesheet being printed, which are renamed to avoid
+	// collisions. References to any other keyframes are left alone.
+	keyframes map[string]bool
+
+	// Set while printing the contents of a rule whose selectors are all scoped.
+	// Nested selectors are relative to that rule, so they don't need a scope.
+	insideScopedRule bool
+
+	// Set while printing the arguments of ":not()", which are never scoped: a
+	// scope there would make the selector match almost every element.
+	insideNot bool
 }
 
 type ScopeStrategy uint8
@@ -52,6 +71,7 @@ type PrintResult struct {
 	CSS                    []byte
 	ExtractedLegalComments map[string]bool
 	SourceMapChunk         sourcemap.Chunk
+	UnscopedSelectors      []UnscopedSelector
 }
 
 func Print(tree css_ast.AST, options Options) PrintResult {
@@ -60,6 +80,10 @@ func Print(tree css_ast.AST, options Options) PrintResult {
 		importRecords: tree.ImportRecords,
 		builder:       sourcemap.MakeChunkBuilder(options.InputSourceMap, options.LineOffsetTables),
 	}
+	if options.Scope != "" {
+		p.keyframes = make(map[string]bool)
+		collectKeyframeNames(tree.Rules, p.keyframes)
+	}
 	for _, rule := range tree.Rules {
 		p.printRule(rule, 0, false)
 	}
@@ -67,6 +91,7 @@ func Print(tree css_ast.AST, options Options) PrintResult {
 		CSS:                    p.css,
 		ExtractedLegalComments: p.extractedLegalComments,
 		SourceMapChunk:         p.builder.GenerateChunk(p.css),
+		UnscopedSelectors:      p.unscopedSelectors,
 	}
 }
 
@@ -121,7 +146,7 @@ func (p *printer) printRule(rule css_ast.Rule, indent int32, omitTrailingSemicol
 		if r.Name == "" {
 			p.print("\"\"")
 		} else {
-			p.printIdent(r.Name, identNormal, canDiscardWhitespaceAfter)
+			p.printIdent(p.scopedKeyframeName(r.Name), identNormal, canDiscardWhitespaceAfter)
 		}
 		if !p.options.MinifyWhitespace {
 			p.print(" ")
@@ -201,14 +226,18 @@ func (p *printer) printRule(rule css_ast.Rule, indent int32, omitTrailingSemicol
 		}
 
 	case *css_ast.RSelector:
+		p.ruleLoc = rule.Loc
 		if r.HasAtNest {
 			p.print("@nest")
 		}
-		p.printComplexSelectors(r.Selectors, indent, r.HasAtNest)
+		wasInsideScopedRule := p.insideScopedRule
+		allScoped := p.printComplexSelectors(r.Selectors, indent, r.HasAtNest)
 		if !p.options.MinifyWhitespace {
 			p.print(" ")
 		}
+		p.insideScopedRule = wasInsideScopedRule || allScoped
 		p.printRuleBlock(r.Rules, indent)
+		p.insideScopedRule = wasInsideScopedRule
 
 	case *css_ast.RQualified:
 		hasWhitespaceAfter := p.printTokens(r.Prelude, printTokensOpts{})
@@ -220,7 +249,11 @@ func (p *printer) printRule(rule css_ast.Rule, indent int32, omitTrailingSemicol
 	case *css_ast.RDeclaration:
 		p.printIdent(r.KeyText, identNormal, canDiscardWhitespaceAfter)
 		p.print(":")
-		hasWhitespaceAfter := p.printTokens(r.Value, printTokensOpts{
+		value := r.Value
+		if isAnimationDeclaration(r) {
+			value = p.scopeAnimationNames(value)
+		}
+		hasWhitespaceAfter := p.printTokens(value, printTokensOpts{
 			indent:        indent,
 			isDeclaration: true,
 		})
@@ -310,7 +343,8 @@ func (p *printer) printRuleBlock(rules []css_ast.Rule, indent int32) {
 	p.print("}")
 }
 
-func (p *printer) printComplexSelectors(selectors []css_ast.ComplexSelector, indent int32, hasAtNest bool) {
+func (p *printer) printComplexSelectors(selectors []css_ast.ComplexSelector, indent int32, hasAtNest bool) bool {
+	allScoped := true
 	for i, complex := range selectors {
 		if i > 0 {
 			if p.options.MinifyWhitespace {
@@ -321,10 +355,19 @@ func (p *printer) printComplexSelectors(selectors []css_ast.ComplexSelector, ind
 			}
 		}
 
+		p.didPrintScope = false
+		p.unscopableSelector = ""
 		for j, compound := range complex.Selectors {
 			p.printCompoundSelector(compound, (!hasAtNest || i != 0) && j == 0, j+1 == len(complex.Selectors))
 		}
+		if !p.didPrintScope {
+			allScoped = false
+			if p.options.Scope != "" && p.unscopableSelector != "" {
+				p.unscopedSelectors = append(p.unscopedSelectors, UnscopedSelector{Loc: p.ruleLoc, Selector: p.unscopableSelector})
+			}
+		}
 	}
+	return allScoped
 }
 
 func (p *printer) printNamespacedName(nsName css_ast.NamespacedName, whitespace trailingWhitespace) {
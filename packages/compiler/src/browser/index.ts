@@ -5,6 +5,16 @@ export const transform: typeof types.transform = (input, options) => {
 	return ensureServiceIsRunning().transform(input, options);
 };
 
+export const transformAll: typeof types.transformAll = (files, options) => {
+	return ensureServiceIsRunning().transformAll(files, options);
+};
+
+export const cancel: typeof types.cancel = (id) => {
+	const service: any = (globalThis as any)['@astrojs/compiler'];
+	// Nothing can be running before the WASM instance was started
+	return service ? service.cancel(id) : false;
+};
+
 export const parse: typeof types.parse = (input, options) => {
 	return ensureServiceIsRunning().parse(input, options);
 };
@@ -15,6 +25,7 @@ export const convertToTSX: typeof types.convertToTSX = (input, options) => {
 
 interface Service {
 	transform: typeof types.transform;
+	transformAll: typeof types.transformAll;
 	parse: typeof types.parse;
 	convertToTSX: typeof types.convertToTSX;
 }
@@ -81,6 +92,8 @@ const startRunningService = async (wasmURL: string): Promise<Service> => {
 	return {
 		transform: (input, options) =>
 			new Promise((resolve) => resolve(service.transform(input, options || {}))),
+		transformAll: (files, options) =>
+			new Promise((resolve) => resolve(service.transformAll(files, options || {}))),
 		convertToTSX: (input, options) =>
 			new Promise((resolve) => resolve(service.convertToTSX(input, options || {}))).then(
 				(result: any) => ({
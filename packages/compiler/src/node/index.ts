@@ -3,16 +3,34 @@ export type {
 	ParseOptions,
 	ParseResult,
 	PreprocessorResult,
+	TransformAllFile,
 	TransformOptions,
 	TransformResult,
 } from '../shared/types.js';
 import { promises as fs } from 'node:fs';
 import { fileURLToPath } from 'node:url';
+import { createPendingCancellations } from '../shared/cancel.js';
 import type * as types from '../shared/types.js';
 import Go from './wasm_exec.js';
 
+const pendingCancellations = createPendingCancellations();
+
 export const transform: typeof types.transform = async (input, options) => {
-	return getService().then((service) => service.transform(input, options));
+	pendingCancellations.add(options?.id);
+	return getService().then((service) => {
+		pendingCancellations.start(options?.id);
+		return service.transform(input, options);
+	});
+};
+
+export const transformAll: typeof types.transformAll = async (files, options) => {
+	return getService().then((service) => service.transformAll(files, options));
+};
+
+export const cancel: typeof types.cancel = (id) => {
+	if (pendingCancellations.cancel(id)) return true;
+	const service: any = (globalThis as any)['@astrojs/compiler'];
+	return service ? service.cancel(id) : false;
 };
 
 export const parse: typeof types.parse = async (input, options) => {
@@ -32,6 +50,7 @@ export const compile = async (template: string): Promise<string> => {
 
 interface Service {
 	transform: typeof types.transform;
+	transformAll: typeof types.transformAll;
 	parse: typeof types.parse;
 	convertToTSX: typeof types.convertToTSX;
 }
@@ -89,6 +108,16 @@ const startRunningService = async (): Promise<Service> => {
 					throw err;
 				}
 			}),
+		transformAll: (files, options) =>
+			new Promise((resolve) => {
+				try {
+					resolve(_service.transformAll(files, options || {}));
+				} catch (err) {
+					// Recreate the service next time on panic
+					longLivedService = void 0;
+					throw err;
+				}
+			}),
 		parse: (input, options) =>
 			new Promise((resolve) => resolve(_service.parse(input, options || {})))
 				.catch((error) => {
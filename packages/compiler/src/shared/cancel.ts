@@ -0,0 +1,30 @@
+/**
+ * Tracks the ids of transforms that were called but haven't reached the WASM service yet, e.g.
+ * while it is still starting. `cancel` can then be called synchronously right after `transform`:
+ * the transform rejects with an "AbortError" as soon as it starts, like one cancelled while running.
+ */
+export function createPendingCancellations() {
+	// Whether `cancel` was called for each pending id
+	const pending = new Map<string, boolean>();
+	return {
+		add(id: string | undefined) {
+			if (id) pending.set(id, false);
+		},
+		cancel(id: string): boolean {
+			if (!pending.has(id)) return false;
+			pending.set(id, true);
+			return true;
+		},
+		/** Removes `id` from the pending transforms, and throws if it was cancelled in the meantime. */
+		start(id: string | undefined) {
+			if (!id) return;
+			const cancelled = pending.get(id);
+			pending.delete(id);
+			if (cancelled) {
+				const err = new Error(`Transform "${id}" was cancelled`);
+				err.name = 'AbortError';
+				throw err;
+			}
+		},
+	};
+}
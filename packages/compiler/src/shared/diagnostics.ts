@@ -4,6 +4,8 @@ export enum DiagnosticCode {
 	ERROR_FRAGMENT_SHORTHAND_ATTRS = 1002,
 	ERROR_UNMATCHED_IMPORT = 1003,
 	ERROR_UNSUPPORTED_SLOT_ATTRIBUTE = 1004,
+	ERROR_UNKNOWN_DIRECTIVE = 1006,
+	ERROR_UNRESOLVED_CUSTOM_ELEMENT = 1007,
 	WARNING = 2000,
 	WARNING_UNTERMINATED_HTML_COMMENT = 2001,
 	WARNING_UNCLOSED_HTML_TAG = 2002,
@@ -11,6 +13,28 @@ export enum DiagnosticCode {
 	WARNING_IGNORED_DIRECTIVE = 2004,
 	WARNING_UNSUPPORTED_EXPRESSION = 2005,
 	WARNING_SET_WITH_CHILDREN = 2006,
+	WARNING_CANNOT_DEFINE_VARS = 2007,
+	WARNING_INVALID_SPREAD = 2008,
+	WARNING_UNEXPECTED_CHARACTER = 2009,
+	WARNING_CANNOT_RERUN = 2010,
+	WARNING_DETACHED_NODE = 2011,
+	WARNING_UNSCOPED_SELECTOR = 2012,
+	WARNING_IMPLICITLY_CLOSED_ELEMENT = 2013,
+	WARNING_MISSING_SCOPE = 2014,
+	WARNING_UNKNOWN_HYDRATED_ELEMENT = 2015,
+	WARNING_MIXED_CONTENT = 2016,
+	WARNING_HYDRATION_IN_NOSCRIPT = 2017,
+	WARNING_INVALID_SCOPE = 2018,
+	WARNING_UNKNOWN_DIRECTIVE = 2019,
+	WARNING_DUPLICATE_ATTRIBUTE = 2020,
+	WARNING_INLINE_SCRIPT_ATTRIBUTE = 2021,
+	WARNING_CONFLICTING_DECLARATION = 2022,
+	WARNING_ELEMENT_IN_EXPRESSION = 2023,
+	WARNING_DIAGNOSTICS_TRUNCATED = 2024,
+	WARNING_SELF_CLOSING_ELEMENT = 2025,
+	WARNING_INVALID_COMPONENT_ALIAS = 2026,
 	INFO = 3000,
+	INFO_RAW_HTML = 3001,
+	INFO_MISSING_VIEWPORT = 3002,
 	HINT = 4000,
 }
@@ -1,4 +1,4 @@
-import type { RootNode } from './ast.js';
+import type { Point, RootNode } from './ast.js';
 import type { DiagnosticCode } from './diagnostics.js';
 export type * from './ast.js';
 
@@ -41,13 +41,60 @@ export interface DiagnosticLocation {
 
 export interface TransformOptions {
 	internalURL?: string;
+	/**
+	 * Windows paths are normalized to forward slashes with a lowercase drive letter before they are used, e.g. in source maps and component paths.
+	 */
 	filename?: string;
+	/**
+	 * Normalized like `filename`, and made relative to `projectRoot` when it is inside of it.
+	 */
 	normalizedFilename?: string;
+	/**
+	 * When set, the scope is derived from `filename` relative to this directory, so it is stable across machines.
+	 */
+	projectRoot?: string;
+	/**
+	 * When set, relative URLs in `<link rel="canonical">` and URL-valued Open Graph / Twitter `<meta>` tags (e.g. `og:image`, `twitter:image`) are resolved against `site` and `pathname`.
+	 */
+	site?: string;
+	pathname?: string;
+	/**
+	 * The path the site is deployed under, e.g. `/docs/`, used by `resolveRootURLs`.
+	 */
+	base?: string;
 	sourcemap?: boolean | 'inline' | 'external' | 'both';
 	astroGlobalArgs?: string;
 	compact?: boolean;
+	/**
+	 * Collapse runs of whitespace in text to a single space, and trim whitespace at element boundaries.
+	 * Content inside `<pre>`, `<textarea>`, `<script>`, `<style>`, and expressions is preserved.
+	 */
+	minify?: boolean;
+	/**
+	 * Lowercase HTML tag names and attribute keys. Components, custom elements, directives and expression attributes are left as-is.
+	 */
+	normalize?: boolean;
+	/**
+	 * Only add the scope class to elements that can be matched by the component's scoped styles.
+	 */
+	optimizedScopes?: boolean;
 	resultScopedSlot?: boolean;
+	/**
+	 * How elements are matched by scoped styles. `where` (default) and `class` add an `astro-<scope>`
+	 * class, targeted by `:where(.astro-<scope>)` and `.astro-<scope>` respectively. `attribute` adds a
+	 * `data-astro-cid-<scope>` attribute instead, targeted by `[data-astro-cid-<scope>]`, and leaves
+	 * class lists untouched.
+	 *
+	 * Rules nested in another style rule, such as `& .title` in `.card { & .title {} }`, are not
+	 * scoped: `.title` matches any descendant of a scoped `.card`, including the elements of
+	 * child components.
+	 */
 	scopedStyleStrategy?: 'where' | 'class' | 'attribute';
+	/**
+	 * How attributes are spaced in the output. `preserve` (default) keeps the whitespace written
+	 * between attributes, `normalized` separates them with a single space.
+	 */
+	format?: 'preserve' | 'normalized';
 	/**
 	 * @deprecated "as" has been removed and no longer has any effect!
 	 */
@@ -58,6 +105,11 @@ export interface TransformOptions {
 		content: string,
 		attrs: Record<string, string>
 	) => null | Promise<PreprocessorResult | PreprocessorError>;
+	/**
+	 * Adds `data-astro-source-file` and `data-astro-source-loc` (`line:column`) attributes to the
+	 * elements of the component, so that dev tools can map them back to their source. Components,
+	 * metadata, SVG elements and the contents of `is:raw` elements are not annotated.
+	 */
 	annotateSourceFile?: boolean;
 	/**
 	 * Render script tags to be processed (e.g. script tags that have no attributes or only a `src` attribute)
@@ -65,7 +117,92 @@ export interface TransformOptions {
 	 * @experimental
 	 */
 	renderScript?: boolean;
+	/**
+	 * @deprecated Styles and scripts are always hoisted in document order, so this has no effect.
+	 */
 	experimentalScriptOrder?: boolean;
+	/**
+	 * Remove the `@import` rules of extracted styles, which are returned in `styleImports`,
+	 * so that the bundler can handle them.
+	 */
+	removeStyleImports?: boolean;
+	/**
+	 * Additional tag names whose `client:` directives are handled like those of components, even
+	 * though they do not follow the component naming convention: hydration props and metadata are
+	 * added for them. They are still printed as HTML elements, not rendered as components.
+	 */
+	extraComponentTags?: string[];
+	/**
+	 * Renders components under another name, e.g. `{ Img: 'AstroImage' }` renders `<Img>` as
+	 * `<AstroImage>`. The aliased name is the one resolved against the imports, for hydration too.
+	 * Aliases that are not an identifier or a member expression (e.g. `my-img`) are reported with
+	 * a `WARNING_INVALID_COMPONENT_ALIAS` diagnostic and ignored.
+	 */
+	componentAliases?: Record<string, string>;
+	/**
+	 * Replace `style` attributes repeated verbatim on several elements with a shared scoped class.
+	 * Unlike inline styles, the generated rule does not take precedence over more specific selectors.
+	 */
+	hoistInlineStyles?: boolean;
+	/**
+	 * Report `client:` directives that are not built into Astro (e.g. the typo `client:visable`)
+	 * as errors instead of warnings.
+	 */
+	strictDirectives?: boolean;
+	/**
+	 * Reports every `set:html` directive with an informational diagnostic, to help audit where
+	 * unescaped HTML is rendered. The output is unchanged.
+	 */
+	auditRawHTML?: boolean;
+	/**
+	 * Reports a component that renders head content without a `<meta name="viewport">` with an
+	 * informational diagnostic. The output is unchanged.
+	 */
+	auditViewportMeta?: boolean;
+	/**
+	 * Prints boolean attributes of HTML elements such as `disabled="disabled"` or `checked="true"`
+	 * in their bare form, and removes those set to `"false"`. Expression values are left untouched.
+	 */
+	normalizeBooleanAttributes?: boolean;
+	/**
+	 * Resolves quoted `href` and `src` attributes that start with `/` against `site` and `base`.
+	 * Absolute, `data:` and `mailto:` URLs, fragments, expressions and the `src` of hoisted
+	 * scripts are left untouched.
+	 */
+	resolveRootURLs?: boolean;
+	/**
+	 * Renders the `<style>` elements of a component where they are authored, with their CSS scoped,
+	 * instead of extracting them to `css`. `is:inline` styles are unchanged, and styles with
+	 * `define:vars` are still extracted.
+	 */
+	keepStylesInPlace?: boolean;
+	/**
+	 * Combines the scoped styles of a component that are authored next to each other into a single
+	 * entry of `css`, in authored order. Global and inline styles, styles with `define:vars` and
+	 * styles with `@charset` or `@import` rules are kept separate.
+	 */
+	mergeScopedStyles?: boolean;
+	/**
+	 * The number of warnings, infos and hints reported before the others are dropped, to bound
+	 * memory on very broken input. Errors are always reported. A single
+	 * `WARNING_DIAGNOSTICS_TRUNCATED` diagnostic is added when the list is truncated. Defaults to
+	 * `0`, which reports all of them.
+	 */
+	maxDiagnostics?: number;
+	/**
+	 * Passes run in order on the AST of the component once it has been transformed, so that they
+	 * see scope classes and hydration attributes, and before it is printed. A plugin may return a
+	 * patched copy of the AST, in which only attributes and the values of text, comment, doctype and
+	 * frontmatter nodes can change. A plugin that throws or rejects fails the transform.
+	 *
+	 * Plugins can't add diagnostics to the result: only the AST they return is read.
+	 */
+	plugins?: ((ast: RootNode) => RootNode | void | Promise<RootNode | void>)[];
+	/**
+	 * Makes the transform cancellable: calling `cancel(id)` before it settles, including right after
+	 * calling `transform`, rejects the returned promise with an `AbortError`.
+	 */
+	id?: string;
 }
 
 export type ConvertToTSXOptions = Pick<
@@ -101,9 +238,30 @@ export interface HydratedComponent {
 	resolvedPath: string;
 }
 
+export interface CSSImport {
+	specifier: string;
+	/** Whether this is a bare `import './file.css'` without any bindings */
+	sideEffect: boolean;
+	/** Offset of the import statement in the source */
+	start: number;
+}
+
+export interface ComponentUsage {
+	name: string;
+	/** Keys of the attributes passed as props, in authored order */
+	attrs: string[];
+	/** Directives used on the component (e.g. `client:load`), which aren't passed as props */
+	directives: string[];
+	/** Spread attributes have no known keys, so they are only flagged */
+	hasSpread: boolean;
+	/** Position of the tag name */
+	position: Point;
+}
+
 export interface TransformResult {
 	code: string;
 	map: string;
+	/** Scope of the component's scoped styles, after it is sanitized or derived from `filename`. Empty if there is none */
 	scope: string;
 	styleError: string[];
 	diagnostics: DiagnosticMessage[];
@@ -112,8 +270,36 @@ export interface TransformResult {
 	hydratedComponents: HydratedComponent[];
 	clientOnlyComponents: HydratedComponent[];
 	serverComponents: HydratedComponent[];
+	/** Every component and custom element used in the template, in document order */
+	componentUsages: ComponentUsage[];
+	cssImports: CSSImport[];
+	/** Specifiers of the CSS `@import` rules found in extracted styles */
+	styleImports: string[];
 	containsHead: boolean;
 	propagation: boolean;
+	containsGetStaticPaths: boolean;
+	/** The value of the `prerender` export when it is a boolean literal, `null` otherwise */
+	prerender: boolean | null;
+	/**
+	 * The arguments of the `$$createMetadata` call in `code`, or `null` when `resolvePath` is set
+	 * and no metadata is printed.
+	 */
+	metadata: ComponentMetadata | null;
+}
+
+export interface ComponentMetadata {
+	/** Imports of the frontmatter, other than type and style imports */
+	modules: { specifier: string; assert: string }[];
+	/** Names of the hydrated components and custom elements */
+	hydratedComponents: string[];
+	/** Specifiers of the imports of `client:only` components */
+	clientOnlyComponents: string[];
+	scripts: (
+		| { type: 'inline'; value: string }
+		| { type: 'external'; src: string }
+		| { type: 'define:vars'; value: string; keys: string }
+	)[];
+	hydrationDirectives: string[];
 }
 
 export interface SourceMap {
@@ -167,9 +353,19 @@ export interface TSXResult {
 		body: TSXLocation;
 		scripts?: TSXExtractedScript[];
 		styles?: TSXExtractedStyle[];
+		props: TSXProps | null;
 	};
 }
 
+export interface TSXProps {
+	kind: 'interface' | 'type' | 'import';
+	exported: boolean;
+	generic: boolean;
+	/** The module `Props` is imported from, only set when `kind` is `import` */
+	specifier: string;
+	range: TSXLocation;
+}
+
 export interface ParseResult {
 	ast: RootNode;
 	diagnostics: DiagnosticMessage[];
@@ -187,6 +383,30 @@ export declare function transform(
 	options?: TransformOptions
 ): Promise<TransformResult>;
 
+export interface TransformAllFile {
+	filename: string;
+	source: string;
+	/** Overrides the options shared by the whole batch for this file */
+	options?: TransformOptions;
+}
+
+/**
+ * Transforms many files in a single call, which avoids paying the overhead of calling into
+ * the compiler once per file. Results are returned in the order of `files`. A file that fails
+ * to compile does not reject the whole batch: its result has an empty `code` and carries the
+ * error in `diagnostics`.
+ */
+export declare function transformAll(
+	files: TransformAllFile[],
+	options?: TransformOptions
+): Promise<TransformResult[]>;
+
+/**
+ * Cancels the running `transform` that was started with the given `id`. Its promise rejects
+ * with an error named `AbortError`. Returns whether such a transform was found.
+ */
+export declare function cancel(id: string): boolean;
+
 export declare function parse(input: string, options?: ParseOptions): Promise<ParseResult>;
 
 export declare function convertToTSX(
@@ -0,0 +1,75 @@
+import { cancel, teardown, transform } from '@astrojs/compiler';
+import { test } from 'uvu';
+import * as assert from 'uvu/assert';
+
+const FIXTURE = `
+<div>Hello world!</div>
+
+<style lang="scss">
+$color: red;
+div {
+  color: $color;
+}
+</style>
+`;
+
+test('rejects when cancelled during a slow preprocessStyle', async () => {
+	let preprocessing: () => void;
+	const started = new Promise<void>((resolve) => {
+		preprocessing = resolve;
+	});
+	const result = transform(FIXTURE, {
+		id: 'slow',
+		preprocessStyle: () => {
+			preprocessing();
+			return new Promise((resolve) => {
+				setTimeout(() => resolve({ code: 'div{color:red}' }), 1000);
+			});
+		},
+	});
+	await started;
+	assert.is(cancel('slow'), true);
+	try {
+		await result;
+		assert.unreachable('Expected the transform to be cancelled');
+	} catch (err: any) {
+		assert.instance(err, Error);
+		assert.is(err.name, 'AbortError');
+	}
+});
+
+test('rejects when cancelled before the next phase', async () => {
+	const result = transform(FIXTURE, { id: 'early' });
+	assert.is(cancel('early'), true);
+	try {
+		await result;
+		assert.unreachable('Expected the transform to be cancelled');
+	} catch (err: any) {
+		assert.is(err.name, 'AbortError');
+	}
+});
+
+test('rejects when cancelled synchronously while the service starts', async () => {
+	teardown();
+	const result = transform(FIXTURE, { id: 'sync' });
+	assert.is(cancel('sync'), true);
+	try {
+		await result;
+		assert.unreachable('Expected the transform to be cancelled');
+	} catch (err: any) {
+		assert.instance(err, Error);
+		assert.is(err.name, 'AbortError');
+	}
+	// The cancellation only applies to that call
+	const next = await transform(FIXTURE, { id: 'sync' });
+	assert.match(next.code, 'Hello world!');
+});
+
+test('settled transforms can no longer be cancelled', async () => {
+	const result = await transform(FIXTURE, { id: 'done' });
+	assert.match(result.code, 'Hello world!');
+	assert.is(cancel('done'), false);
+	assert.is(cancel('unknown'), false);
+});
+
+test.run();
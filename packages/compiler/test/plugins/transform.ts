@@ -0,0 +1,60 @@
+import { transform } from '@astrojs/compiler';
+import { test } from 'uvu';
+import * as assert from 'uvu/assert';
+import type { ElementNode, RootNode } from '../../types.js';
+
+const FIXTURE = `<div class="card">Hello world!</div>`;
+
+test('applies the AST returned by a plugin', async () => {
+	const result = await transform(FIXTURE, {
+		plugins: [
+			(ast: RootNode) => {
+				const div = ast.children.find((n) => n.type === 'element') as ElementNode;
+				div.attributes.push({
+					type: 'attribute',
+					kind: 'quoted',
+					name: 'data-plugin',
+					value: 'card',
+				});
+				return ast;
+			},
+		],
+	});
+	assert.match(result.code, 'data-plugin="card"');
+});
+
+test('runs plugins in order and awaits them', async () => {
+	const seen: string[] = [];
+	const result = await transform(FIXTURE, {
+		plugins: [
+			async () => {
+				seen.push('first');
+			},
+			(ast: RootNode) => {
+				seen.push('second');
+				const div = ast.children.find((n) => n.type === 'element') as ElementNode;
+				div.children = [{ ...div.children[0], value: 'Patched' } as any];
+				return ast;
+			},
+		],
+	});
+	assert.equal(seen, ['first', 'second']);
+	assert.match(result.code, 'Patched');
+});
+
+test('rejects when a plugin throws', async () => {
+	try {
+		await transform(FIXTURE, {
+			plugins: [
+				() => {
+					throw new Error('Boom');
+				},
+			],
+		});
+		assert.unreachable('Expected the transform to fail');
+	} catch (err: any) {
+		assert.match(err.message, 'Boom');
+	}
+});
+
+test.run();
@@ -0,0 +1,31 @@
+import { type TransformResult, transform } from '@astrojs/compiler';
+import { test } from 'uvu';
+import * as assert from 'uvu/assert';
+
+const FIXTURE = `---
+import Card from './Card.astro';
+---
+<Card title="x" {...props} client:load />
+<div><my-element foo="bar"></my-element></div>`;
+
+let result: TransformResult;
+test.before(async () => {
+	result = await transform(FIXTURE);
+});
+
+test('returns the components used in the template', () => {
+	assert.equal(
+		result.componentUsages.map((usage) => usage.name),
+		['Card', 'my-element']
+	);
+});
+
+test('returns the attributes of a component', () => {
+	const [card] = result.componentUsages;
+	assert.equal(card.attrs, ['title']);
+	assert.equal(card.directives, ['client:load']);
+	assert.equal(card.hasSpread, true);
+	assert.equal(card.position, { line: 4, column: 2, offset: 42 });
+});
+
+test.run();
@@ -0,0 +1,34 @@
+import { transform, transformAll } from '@astrojs/compiler';
+
+// Compares compiling many small components one call at a time with a single `transformAll` call.
+const COMPONENTS = 1000;
+const ROUNDS = 5;
+
+const files = Array.from({ length: COMPONENTS }, (_, i) => ({
+	filename: `/src/components/Card${i}.astro`,
+	source: `---
+const { title } = Astro.props;
+---
+<div class="card"><h2>{title}</h2><p>Item ${i}</p></div>
+<style>.card { color: red; }</style>`,
+}));
+
+async function run() {
+	for (let round = 0; round < ROUNDS; round++) {
+		let start = performance.now();
+		for (const file of files) {
+			await transform(file.source, { filename: file.filename });
+		}
+		const single = performance.now() - start;
+
+		start = performance.now();
+		await transformAll(files);
+		const batch = performance.now() - start;
+
+		console.log(
+			`Round ${round}: transform ${single.toFixed(0)}ms, transformAll ${batch.toFixed(0)}ms`
+		);
+	}
+}
+
+run();
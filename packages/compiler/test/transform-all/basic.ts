@@ -0,0 +1,56 @@
+import { transformAll } from '@astrojs/compiler';
+import { test } from 'uvu';
+import * as assert from 'uvu/assert';
+
+const HYDRATED = `---
+import Counter from './Counter.jsx';
+---
+<Counter client:load />`;
+
+test('returns the results in input order', async () => {
+	const results = await transformAll([
+		{ filename: '/src/A.astro', source: '<h1>A</h1>' },
+		{ filename: '/src/B.astro', source: '<h1>B</h1>' },
+		{ filename: '/src/C.astro', source: '<h1>C</h1>' },
+	]);
+	assert.is(results.length, 3);
+	assert.match(results[0].code, '<h1>A</h1>');
+	assert.match(results[1].code, '<h1>B</h1>');
+	assert.match(results[2].code, '<h1>C</h1>');
+});
+
+test('file options override batch options', async () => {
+	const [batch, file] = await transformAll(
+		[
+			{ filename: '/src/A.astro', source: '<h1>A</h1>' },
+			{ filename: '/src/B.astro', source: '<h1>B</h1>', options: { internalURL: 'file-url' } },
+		],
+		{ internalURL: 'batch-url' }
+	);
+	assert.match(batch.code, '"batch-url"');
+	assert.match(file.code, '"file-url"');
+	assert.not.match(file.code, '"batch-url"');
+});
+
+test('a failing file does not abort the batch', async () => {
+	const results = await transformAll([
+		{ filename: '/src/A.astro', source: '<h1>A</h1>' },
+		{
+			filename: '/src/B.astro',
+			source: HYDRATED,
+			options: {
+				resolvePath: () => {
+					throw new Error('Unable to resolve');
+				},
+			},
+		},
+		{ filename: '/src/C.astro', source: '<h1>C</h1>' },
+	]);
+	assert.match(results[0].code, '<h1>A</h1>');
+	assert.is(results[1].code, '');
+	assert.is(results[1].diagnostics.length, 1);
+	assert.match(results[1].diagnostics[0].text, 'Unable to resolve');
+	assert.match(results[2].code, '<h1>C</h1>');
+});
+
+test.run();
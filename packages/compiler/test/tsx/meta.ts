@@ -17,6 +17,7 @@ test('return ranges', async () => {
 		},
 		scripts: null,
 		styles: null,
+		props: null,
 	});
 });
 
@@ -35,6 +36,7 @@ test('return ranges - no frontmatter', async () => {
 		},
 		scripts: null,
 		styles: null,
+		props: null,
 	});
 });
 
@@ -53,6 +55,23 @@ test('return proper ranges with multibyte characters', async () => {
 		},
 		scripts: null,
 		styles: null,
+		props: null,
+	});
+});
+
+test('return props declaration', async () => {
+	const input = `---\nexport interface Props { title: string }\n---\n\n<div></div>`;
+	const { metaRanges } = await convertToTSX(input, { sourcemap: 'external' });
+
+	assert.equal(metaRanges.props, {
+		kind: 'interface',
+		exported: true,
+		generic: false,
+		specifier: '',
+		range: {
+			start: 4,
+			end: 44,
+		},
 	});
 });
 